@@ -185,6 +185,9 @@ const (
 	AUDIT_ACTIONS
 	AUDIT_ACTIONS_FAILED
 
+	RATE_LIMITED_REQUESTS
+	CONCURRENCY_LIMITED_REQUESTS
+
 	// unknown is always the last and does not have a corresponding name or metric
 	UNKNOWN
 )
@@ -231,6 +234,9 @@ const (
 	_AUDIT_ACTIONS           = "audit_actions"
 	_AUDIT_ACTIONS_FAILED    = "audit_actions_failed"
 
+	_RATE_LIMITED_REQUESTS        = "rate_limited_requests"
+	_CONCURRENCY_LIMITED_REQUESTS = "concurrency_limited_requests"
+
 	REQUEST_RATE  = "request_rate"
 	REQUEST_TIMER = "request_timer"
 )
@@ -276,6 +282,9 @@ var metricNames = []string{
 	_AUDIT_REQUESTS_FILTERED,
 	_AUDIT_ACTIONS,
 	_AUDIT_ACTIONS_FAILED,
+
+	_RATE_LIMITED_REQUESTS,
+	_CONCURRENCY_LIMITED_REQUESTS,
 }
 
 const (
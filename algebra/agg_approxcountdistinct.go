@@ -0,0 +1,141 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the Aggregate function APPROX_COUNT_DISTINCT(expr).
+It returns the estimated number of distinct values of expr other than
+NULL and MISSING, like COUNT(DISTINCT expr), but keeping a HyperLogLog
+sketch of constant size rather than all the distinct values. The
+standard error of the estimate is about 0.8%.
+*/
+
+type ApproxCountDistinct struct {
+	AggregateBase
+}
+
+/*
+The function NewApproxCountDistinct calls NewAggregateBase to
+create an aggregate function named ApproxCountDistinct with
+one expression as input.
+*/
+func NewApproxCountDistinct(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &ApproxCountDistinct{
+		*NewAggregateBase("approx_count_distinct", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *ApproxCountDistinct) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+It returns a value of type NUMBER.
+*/
+func (this *ApproxCountDistinct) Type() value.Type { return value.NUMBER }
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *ApproxCountDistinct) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewApproxCountDistinct with the input operand
+cast to a Function as the FunctionConstructor.
+*/
+func (this *ApproxCountDistinct) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewApproxCountDistinct(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *ApproxCountDistinct) Copy() expression.Expression {
+	rv := &ApproxCountDistinct{
+		*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+If no input to the ApproxCountDistinct function, then the default value
+returned is a zero value.
+*/
+func (this *ApproxCountDistinct) Default(item value.Value, context Context) (value.Value, error) {
+	return value.ZERO_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating operands. For missing and
+null values return the input value itself. Add the other values
+to the HyperLogLog sketch of the intermediate aggregate value.
+*/
+func (this *ApproxCountDistinct) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	item, e := this.Operands()[0].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	if item.Type() <= value.NULL {
+		return cumulative, nil
+	}
+
+	return hllAdd(this.Name(), item, cumulative)
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *ApproxCountDistinct) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return cumulateHyperLogLogs(this.Name(), part, cumulative)
+}
+
+/*
+Compute the Final. Return the estimate of the HyperLogLog sketch,
+or zero if there is none.
+*/
+func (this *ApproxCountDistinct) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	if !hasSketch(cumulative, _HLL_ATTACHMENT) {
+		return value.ZERO_VALUE, nil
+	}
+
+	hll, e := getHyperLogLog(this.Name(), cumulative)
+	if e != nil {
+		return nil, e
+	}
+	return value.NewValue(int64(hll.Count())), nil
+}
@@ -0,0 +1,170 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the ordered set aggregate function
+APPROX_PERCENTILE(fraction) WITHIN GROUP (ORDER BY expr [ASC|DESC]),
+also written APPROX_PERCENTILE(expr, fraction). It returns the estimated
+value at the given fraction of the ordered number values in the group,
+like PERCENTILE_CONT, but keeping a t-digest sketch of bounded size
+rather than all the values. Fraction is a number between 0 and 1, or
+an array of them, in which case the result is the array of the
+corresponding percentiles. The first operand is the ORDER BY
+expression, the second the fraction.
+*/
+
+type ApproxPercentile struct {
+	AggregateBase
+}
+
+/*
+The function NewApproxPercentile calls NewAggregateBase to
+create an aggregate function named ApproxPercentile with
+the ORDER BY expression and the fraction as input.
+*/
+func NewApproxPercentile(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &ApproxPercentile{
+		*NewAggregateBase("approx_percentile", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *ApproxPercentile) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+It returns a number, or an array of numbers.
+*/
+func (this *ApproxPercentile) Type() value.Type { return value.JSON }
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *ApproxPercentile) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+func (this *ApproxPercentile) MinArgs() int { return 2 }
+
+func (this *ApproxPercentile) MaxArgs() int { return 2 }
+
+/*
+The constructor returns a NewApproxPercentile with the input operand
+cast to a Function as the FunctionConstructor.
+*/
+func (this *ApproxPercentile) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewApproxPercentile(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *ApproxPercentile) Copy() expression.Expression {
+	rv := &ApproxPercentile{
+		*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+If no input to the ApproxPercentile function, then the default value
+returned is a null.
+*/
+func (this *ApproxPercentile) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating operands. Add the values of
+type NUMBER to the t-digest sketch of the intermediate aggregate value.
+*/
+func (this *ApproxPercentile) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	item, e := this.Operands()[0].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	if item.Type() <= value.NULL {
+		return cumulative, nil
+	}
+
+	return tdigestAdd(this.Name(), item, cumulative)
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *ApproxPercentile) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return cumulateTDigests(this.Name(), part, cumulative)
+}
+
+/*
+Compute the Final. Return the estimate of the t-digest sketch at each
+fraction, counted from the end for DESC. Return NULL if no values of
+type NUMBER exist.
+*/
+func (this *ApproxPercentile) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	fractions, array, e := percentileFractions(this.Name(), this.Operands()[1], context)
+	if e != nil {
+		return nil, e
+	}
+
+	rv := make([]interface{}, len(fractions))
+	for i := range rv {
+		rv[i] = value.NULL_VALUE
+	}
+
+	if hasSketch(cumulative, _TDIGEST_ATTACHMENT) {
+		td, e := getTDigest(this.Name(), cumulative)
+		if e != nil {
+			return nil, e
+		}
+
+		for i, f := range fractions {
+			if this.HasFlags(AGGREGATE_DESCENDING) {
+				f = 1.0 - f
+			}
+			if td.Count() > 0.0 {
+				rv[i] = value.NewValue(td.Quantile(f))
+			}
+		}
+	}
+
+	if array {
+		return value.NewValue(rv), nil
+	}
+	return rv[0].(value.Value), nil
+}
@@ -0,0 +1,181 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"fmt"
+
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the Aggregate function BIT_XOR_AGG(expr). It returns
+the bitwise XOR of all the integer values in the group, or of the
+distinct ones with DISTINCT. Other values are skipped, and the result
+is NULL if there are none. Type BitXorAgg is a struct that inherits
+from AggregateBase.
+*/
+type BitXorAgg struct {
+	AggregateBase
+}
+
+/*
+The function NewBitXorAgg calls NewAggregateBase to
+create an aggregate function named BIT_XOR_AGG with
+one expression as input.
+*/
+func NewBitXorAgg(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &BitXorAgg{
+		*NewAggregateBase("bit_xor_agg", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *BitXorAgg) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+It returns a value of type NUMBER.
+*/
+func (this *BitXorAgg) Type() value.Type { return value.NUMBER }
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *BitXorAgg) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewBitXorAgg with the input operand
+cast to a Function as the FunctionConstructor.
+*/
+func (this *BitXorAgg) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewBitXorAgg(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *BitXorAgg) Copy() expression.Expression {
+	rv := &BitXorAgg{
+		*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+If no input to the BIT_XOR_AGG function, then the default value
+returned is a null.
+*/
+func (this *BitXorAgg) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating operands. Values other than
+integers are skipped. For DISTINCT, collect the values in a set.
+*/
+func (this *BitXorAgg) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	item, e := this.Operands()[0].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	if _, ok := bitwiseInt(item); !ok {
+		return cumulative, nil
+	}
+
+	if this.Distinct() {
+		return setAdd(item, cumulative, true), nil
+	} else {
+		return this.cumulatePart(item, cumulative, context)
+	}
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *BitXorAgg) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	if this.Distinct() {
+		return cumulateSets(part, cumulative)
+	} else {
+		return this.cumulatePart(part, cumulative, context)
+	}
+}
+
+/*
+Returns input cumulative value as the Final result. For DISTINCT,
+XOR the values of the set.
+*/
+func (this *BitXorAgg) ComputeFinal(cumulative value.Value, context Context) (c value.Value, e error) {
+	if !this.Distinct() || cumulative == value.NULL_VALUE {
+		return cumulative, nil
+	}
+
+	set, e := getSet(cumulative)
+	if e != nil {
+		return nil, e
+	}
+
+	c = value.NULL_VALUE
+	for _, v := range set.Values() {
+		c, e = this.cumulatePart(v, c, context)
+		if e != nil {
+			return nil, e
+		}
+	}
+	return c, nil
+}
+
+/*
+Aggregate input partial values into cumulative result number value.
+If either is null, return the other one.
+*/
+func (this *BitXorAgg) cumulatePart(part, cumulative value.Value, context Context) (value.Value, error) {
+	if part == value.NULL_VALUE {
+		return cumulative, nil
+	} else if cumulative == value.NULL_VALUE {
+		return part, nil
+	}
+
+	p, ok := bitwiseInt(part)
+	if !ok {
+		return nil, fmt.Errorf("Invalid partial BIT_XOR_AGG %v of type %T.", part.Actual(), part.Actual())
+	}
+
+	c, ok := bitwiseInt(cumulative)
+	if !ok {
+		return nil, fmt.Errorf("Invalid BIT_XOR_AGG %v of type %T.", cumulative.Actual(), cumulative.Actual())
+	}
+
+	return value.NewValue(c ^ p), nil
+}
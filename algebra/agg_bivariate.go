@@ -0,0 +1,166 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"fmt"
+
+	"github.com/couchbase/query/value"
+)
+
+/*
+The bivariate aggregates CORR(y, x), COVAR_POP(y, x), COVAR_SAMP(y, x)
+and REGR_*(y, x) all derive from the count, the means and the sums of
+squared and cross deviations from the means of the pairs in the group
+where both y and x are numbers. These are cumulated in an object with
+fields n, mx, my, sxx, syy and sxy, using Welford's update for each
+pair and Chan's formulas to combine intermediate results, which avoid
+the loss of precision of the naive sums of squares.
+*/
+type bivariate struct {
+	n, mx, my, sxx, syy, sxy float64
+}
+
+var _BIVARIATE_FIELDS = []string{"n", "mx", "my", "sxx", "syy", "sxy"}
+
+func getBivariate(aggname string, cumulative value.Value) (*bivariate, error) {
+	var fields [6]float64
+	for i, name := range _BIVARIATE_FIELDS {
+		f, _ := cumulative.Field(name)
+		if f.Type() != value.NUMBER {
+			return nil, fmt.Errorf("Missing or invalid %s in %s: %v.", name, aggname, cumulative.Actual())
+		}
+		fields[i] = value.AsNumberValue(f).Float64()
+	}
+	return &bivariate{fields[0], fields[1], fields[2], fields[3], fields[4], fields[5]}, nil
+}
+
+/*
+The empty state is the default of the aggregate: NULL, or zero for
+REGR_COUNT, which like COUNT returns zero when there are no inputs.
+*/
+func emptyBivariate(cumulative value.Value) bool {
+	return cumulative.Type() != value.OBJECT
+}
+
+func (this *bivariate) value() value.Value {
+	return value.NewValue(map[string]interface{}{
+		"n":   this.n,
+		"mx":  this.mx,
+		"my":  this.my,
+		"sxx": this.sxx,
+		"syy": this.syy,
+		"sxy": this.sxy,
+	})
+}
+
+func (this *bivariate) add(y, x float64) {
+	this.n++
+	dx := x - this.mx
+	dy := y - this.my
+	this.mx += dx / this.n
+	this.my += dy / this.n
+	this.sxx += dx * (x - this.mx)
+	this.syy += dy * (y - this.my)
+	this.sxy += dx * (y - this.my)
+}
+
+func (this *bivariate) merge(other *bivariate) {
+	n := this.n + other.n
+	if n == 0 {
+		return
+	}
+	dx := other.mx - this.mx
+	dy := other.my - this.my
+	f := this.n * other.n / n
+	this.sxx += other.sxx + dx*dx*f
+	this.syy += other.syy + dy*dy*f
+	this.sxy += other.sxy + dx*dy*f
+	this.mx += dx * other.n / n
+	this.my += dy * other.n / n
+	this.n = n
+}
+
+/*
+Aggregates input data by evaluating both operands, skipping the pairs
+where either is not a number.
+*/
+func (this *AggregateBase) cumulateBivariate(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	y, e := this.Operands()[0].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	x, e := this.Operands()[1].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	if y.Type() != value.NUMBER || x.Type() != value.NUMBER {
+		return cumulative, nil
+	}
+
+	b := &bivariate{}
+	if !emptyBivariate(cumulative) {
+		b, e = getBivariate(this.Name(), cumulative)
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	b.add(value.AsNumberValue(y).Float64(), value.AsNumberValue(x).Float64())
+	return b.value(), nil
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *AggregateBase) cumulateBivariates(part, cumulative value.Value) (value.Value, error) {
+	if emptyBivariate(part) {
+		return cumulative, nil
+	} else if emptyBivariate(cumulative) {
+		return part, nil
+	}
+
+	p, e := getBivariate(this.Name(), part)
+	if e != nil {
+		return nil, e
+	}
+
+	c, e := getBivariate(this.Name(), cumulative)
+	if e != nil {
+		return nil, e
+	}
+
+	c.merge(p)
+	return c.value(), nil
+}
+
+/*
+Compute the Final with the given function, which returns NULL if there
+are not enough pairs, or the statistic is undefined.
+*/
+func (this *AggregateBase) computeBivariate(cumulative value.Value, final func(b *bivariate) value.Value) (
+	value.Value, error) {
+	b := &bivariate{}
+	if !emptyBivariate(cumulative) {
+		var e error
+		b, e = getBivariate(this.Name(), cumulative)
+		if e != nil {
+			return nil, e
+		}
+	}
+	return final(b), nil
+}
@@ -0,0 +1,155 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"fmt"
+
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the Aggregate function BOOL_AND(expr). It returns
+true if all the boolean values in the group are true. Other values are
+skipped, and the result is NULL if there are none. Type BoolAnd is a
+struct that inherits from AggregateBase.
+*/
+type BoolAnd struct {
+	AggregateBase
+}
+
+/*
+The function NewBoolAnd calls NewAggregateBase to
+create an aggregate function named BOOL_AND with
+one expression as input.
+*/
+func NewBoolAnd(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &BoolAnd{
+		*NewAggregateBase("bool_and", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *BoolAnd) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+It returns a value of type BOOLEAN.
+*/
+func (this *BoolAnd) Type() value.Type { return value.BOOLEAN }
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *BoolAnd) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewBoolAnd with the input operand
+cast to a Function as the FunctionConstructor.
+*/
+func (this *BoolAnd) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewBoolAnd(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *BoolAnd) Copy() expression.Expression {
+	rv := &BoolAnd{
+		*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+If no input to the BOOL_AND function, then the default value
+returned is a null.
+*/
+func (this *BoolAnd) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating operands. Values other than
+booleans are skipped. DISTINCT does not change the result.
+*/
+func (this *BoolAnd) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	item, e := this.Operands()[0].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	if item.Type() != value.BOOLEAN {
+		return cumulative, nil
+	}
+
+	return this.cumulatePart(item, cumulative, context)
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *BoolAnd) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return this.cumulatePart(part, cumulative, context)
+}
+
+/*
+Returns input cumulative value as the Final result.
+*/
+func (this *BoolAnd) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	return cumulative, nil
+}
+
+/*
+Aggregate input partial values into cumulative result boolean value.
+If either is null, return the other one.
+*/
+func (this *BoolAnd) cumulatePart(part, cumulative value.Value, context Context) (value.Value, error) {
+	if part == value.NULL_VALUE {
+		return cumulative, nil
+	} else if cumulative == value.NULL_VALUE {
+		return part, nil
+	}
+
+	p, ok := part.Actual().(bool)
+	if !ok {
+		return nil, fmt.Errorf("Invalid partial BOOL_AND %v of type %T.", part.Actual(), part.Actual())
+	}
+
+	c, ok := cumulative.Actual().(bool)
+	if !ok {
+		return nil, fmt.Errorf("Invalid BOOL_AND %v of type %T.", cumulative.Actual(), cumulative.Actual())
+	}
+
+	return value.NewValue(c && p), nil
+}
@@ -0,0 +1,123 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the Aggregate function COVAR_POP(y, x). It returns the
+population covariance of the pairs of numbers y and x in the group.
+*/
+
+type CovarPop struct {
+	AggregateBase
+}
+
+/*
+The function NewCovarPop calls NewAggregateBase to
+create an aggregate function named CovarPop with
+two expressions as input.
+*/
+func NewCovarPop(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &CovarPop{
+		*NewAggregateBase("covar_pop", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *CovarPop) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+It returns a value of type NUMBER.
+*/
+func (this *CovarPop) Type() value.Type { return value.NUMBER }
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *CovarPop) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+func (this *CovarPop) MinArgs() int { return 2 }
+
+func (this *CovarPop) MaxArgs() int { return 2 }
+
+/*
+The constructor returns a NewCovarPop with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *CovarPop) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewCovarPop(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *CovarPop) Copy() expression.Expression {
+	rv := &CovarPop{
+		*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+If no input to the CovarPop function, then the default value
+returned is a null.
+*/
+func (this *CovarPop) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data, the pairs where both operands are numbers.
+*/
+func (this *CovarPop) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	return this.cumulateBivariate(item, cumulative, context)
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *CovarPop) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return this.cumulateBivariates(part, cumulative)
+}
+
+/*
+Compute the population covariance as sxy / n. Return NULL if there
+are no pairs.
+*/
+func (this *CovarPop) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	return this.computeBivariate(cumulative, func(b *bivariate) value.Value {
+		if b.n == 0 {
+			return value.NULL_VALUE
+		}
+		return value.NewValue(b.sxy / b.n)
+	})
+}
@@ -0,0 +1,123 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the Aggregate function COVAR_SAMP(y, x). It returns the
+sample covariance of the pairs of numbers y and x in the group.
+*/
+
+type CovarSamp struct {
+	AggregateBase
+}
+
+/*
+The function NewCovarSamp calls NewAggregateBase to
+create an aggregate function named CovarSamp with
+two expressions as input.
+*/
+func NewCovarSamp(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &CovarSamp{
+		*NewAggregateBase("covar_samp", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *CovarSamp) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+It returns a value of type NUMBER.
+*/
+func (this *CovarSamp) Type() value.Type { return value.NUMBER }
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *CovarSamp) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+func (this *CovarSamp) MinArgs() int { return 2 }
+
+func (this *CovarSamp) MaxArgs() int { return 2 }
+
+/*
+The constructor returns a NewCovarSamp with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *CovarSamp) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewCovarSamp(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *CovarSamp) Copy() expression.Expression {
+	rv := &CovarSamp{
+		*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+If no input to the CovarSamp function, then the default value
+returned is a null.
+*/
+func (this *CovarSamp) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data, the pairs where both operands are numbers.
+*/
+func (this *CovarSamp) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	return this.cumulateBivariate(item, cumulative, context)
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *CovarSamp) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return this.cumulateBivariates(part, cumulative)
+}
+
+/*
+Compute the sample covariance as sxy / (n - 1). Return NULL if there
+are fewer than two pairs.
+*/
+func (this *CovarSamp) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	return this.computeBivariate(cumulative, func(b *bivariate) value.Value {
+		if b.n < 2 {
+			return value.NULL_VALUE
+		}
+		return value.NewValue(b.sxy / (b.n - 1))
+	})
+}
@@ -0,0 +1,141 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the Aggregate function HLL_SKETCH(expr). It returns
+the HyperLogLog sketch of the distinct values of expr other than NULL
+and MISSING, serialized as a base64 string. Sketches can be stored,
+combined with HLL_MERGE() and HLL_UNION(), and estimated with
+HLL_ESTIMATE(), so that distinct counts can be rolled up.
+*/
+
+type HLLSketch struct {
+	AggregateBase
+}
+
+/*
+The function NewHLLSketch calls NewAggregateBase to
+create an aggregate function named HLLSketch with
+one expression as input.
+*/
+func NewHLLSketch(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &HLLSketch{
+		*NewAggregateBase("hll_sketch", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *HLLSketch) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+It returns a value of type STRING.
+*/
+func (this *HLLSketch) Type() value.Type { return value.STRING }
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *HLLSketch) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewHLLSketch with the input operand
+cast to a Function as the FunctionConstructor.
+*/
+func (this *HLLSketch) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewHLLSketch(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *HLLSketch) Copy() expression.Expression {
+	rv := &HLLSketch{
+		*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+If no input to the HLLSketch function, then the default value
+returned is a null.
+*/
+func (this *HLLSketch) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating operands. For missing and
+null values return the input value itself. Add the other values
+to the HyperLogLog sketch of the intermediate aggregate value.
+*/
+func (this *HLLSketch) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	item, e := this.Operands()[0].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	if item.Type() <= value.NULL {
+		return cumulative, nil
+	}
+
+	return hllAdd(this.Name(), item, cumulative)
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *HLLSketch) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return cumulateHyperLogLogs(this.Name(), part, cumulative)
+}
+
+/*
+Compute the Final. Return the serialized HyperLogLog sketch, or
+NULL if there is none.
+*/
+func (this *HLLSketch) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	if !hasSketch(cumulative, _HLL_ATTACHMENT) {
+		return value.NULL_VALUE, nil
+	}
+
+	hll, e := getHyperLogLog(this.Name(), cumulative)
+	if e != nil {
+		return nil, e
+	}
+	return value.NewValue(hll.String()), nil
+}
@@ -0,0 +1,154 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the Aggregate function LISTAGG([DISTINCT] expr [, separator])
+[WITHIN GROUP (ORDER BY ...)], which also accepts the ORDER BY and ON OVERFLOW
+clauses within its arguments. It is the same as STRING_AGG, except that the
+separator is optional and defaults to the empty string.
+*/
+
+type ListAgg struct {
+	AggregateBase
+	overflow *Overflow
+}
+
+/*
+The function NewListAgg calls NewAggregateBase to
+create an aggregate function named ListAgg with
+the expression and the optional separator as input.
+*/
+func NewListAgg(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &ListAgg{
+		AggregateBase: *NewAggregateBase("listagg", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *ListAgg) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+It returns a value of type STRING.
+*/
+func (this *ListAgg) Type() value.Type { return value.STRING }
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *ListAgg) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+func (this *ListAgg) MinArgs() int { return 1 }
+
+func (this *ListAgg) MaxArgs() int { return 2 }
+
+/*
+The constructor returns a NewListAgg with the input operand
+cast to a Function as the FunctionConstructor.
+*/
+func (this *ListAgg) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewListAgg(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *ListAgg) Copy() expression.Expression {
+	rv := &ListAgg{
+		AggregateBase: *NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+		overflow: this.overflow,
+	}
+
+	if len(this.AggregateOrder()) > 0 {
+		rv.SetAggregateOrder(this.AggregateOrder().Copy())
+	}
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+Returns the ON OVERFLOW clause, nil for the default of ERROR.
+*/
+func (this *ListAgg) Overflow() *Overflow {
+	return this.overflow
+}
+
+func (this *ListAgg) SetOverflow(overflow *Overflow) {
+	this.overflow = overflow
+}
+
+/*
+Returns string representation of aggregate, including the ON OVERFLOW clause.
+*/
+func (this *ListAgg) String() string {
+	return this.toString(this.overflow.String())
+}
+
+func (this *ListAgg) EquivalentTo(other expression.Expression) bool {
+	otherAggregate, ok := other.(Aggregate)
+	return ok && this.String() == otherAggregate.String()
+}
+
+/*
+If no input to the ListAgg function, then the default value
+returned is a null.
+*/
+func (this *ListAgg) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating operands. Collect the values
+converted to strings, along with their ORDER BY keys, as the
+intermediate aggregate value.
+*/
+func (this *ListAgg) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	return stringAggAdd(&this.AggregateBase, item, cumulative, context)
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *ListAgg) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return stringAggCumulate(part, cumulative)
+}
+
+/*
+Compute the Final. Sort the values, remove duplicates for DISTINCT,
+and concatenate them with the separator.
+*/
+func (this *ListAgg) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	var separator expression.Expression
+	if len(this.Operands()) > 1 {
+		separator = this.Operands()[1]
+	}
+	return stringAggFinal(&this.AggregateBase, separator, this.overflow, cumulative, context)
+}
@@ -0,0 +1,172 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"math"
+
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the ordered set aggregate function
+PERCENTILE_CONT(fraction) WITHIN GROUP (ORDER BY expr [ASC|DESC]).
+It returns the value at the given fraction of the ordered number
+values in the group, interpolating linearly between adjacent values.
+Fraction is a number between 0 and 1, or an array of them, in which
+case the result is the array of the corresponding percentiles.
+The first operand is the ORDER BY expression, the second the fraction.
+*/
+
+type PercentileCont struct {
+	AggregateBase
+}
+
+/*
+The function NewPercentileCont calls NewAggregateBase to
+create an aggregate function named PercentileCont with
+the ORDER BY expression and the fraction as input.
+*/
+func NewPercentileCont(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &PercentileCont{
+		*NewAggregateBase("percentile_cont", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *PercentileCont) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+It returns a number, or an array of numbers.
+*/
+func (this *PercentileCont) Type() value.Type { return value.JSON }
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *PercentileCont) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+func (this *PercentileCont) MinArgs() int { return 2 }
+
+func (this *PercentileCont) MaxArgs() int { return 2 }
+
+/*
+The constructor returns a NewPercentileCont with the input operand
+cast to a Function as the FunctionConstructor.
+*/
+func (this *PercentileCont) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewPercentileCont(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *PercentileCont) Copy() expression.Expression {
+	rv := &PercentileCont{
+		*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+If no input to the PercentileCont function, then the default value
+returned is a null.
+*/
+func (this *PercentileCont) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating operands. Collect all the
+values of type NUMBER as the intermediate aggregate value.
+*/
+func (this *PercentileCont) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	item, e := this.Operands()[0].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	if item.Type() != value.NUMBER {
+		return cumulative, nil
+	}
+
+	return listAdd(item, cumulative), nil
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *PercentileCont) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return cumulateOrderedLists(part, cumulative)
+}
+
+/*
+Compute the Final. Sort the values, and interpolate between the values
+at either side of position fraction * (count - 1). Return NULL if no
+values of type NUMBER exist.
+*/
+func (this *PercentileCont) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	fractions, array, e := percentileFractions(this.Name(), this.Operands()[1], context)
+	if e != nil {
+		return nil, e
+	}
+
+	var vals value.Values
+	if cumulative != value.NULL_VALUE {
+		vals, e = orderedValues(cumulative, this.HasFlags(AGGREGATE_DESCENDING))
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	rv := make([]interface{}, len(fractions))
+	for i, f := range fractions {
+		if len(vals) == 0 {
+			rv[i] = value.NULL_VALUE
+			continue
+		}
+
+		pos := f * float64(len(vals)-1)
+		lo := math.Floor(pos)
+		hi := math.Ceil(pos)
+		lov := vals[int(lo)].(value.NumberValue).Float64()
+		hiv := vals[int(hi)].(value.NumberValue).Float64()
+		rv[i] = value.NewValue(lov + (pos-lo)*(hiv-lov))
+	}
+
+	if array {
+		return value.NewValue(rv), nil
+	}
+	return rv[0].(value.Value), nil
+}
@@ -0,0 +1,171 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"math"
+
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the ordered set aggregate function
+PERCENTILE_DISC(fraction) WITHIN GROUP (ORDER BY expr [ASC|DESC]).
+It returns the first of the ordered values in the group whose
+cumulative distribution is at least the given fraction, so that the
+result is always one of the values, of any type.
+Fraction is a number between 0 and 1, or an array of them, in which
+case the result is the array of the corresponding percentiles.
+The first operand is the ORDER BY expression, the second the fraction.
+*/
+
+type PercentileDisc struct {
+	AggregateBase
+}
+
+/*
+The function NewPercentileDisc calls NewAggregateBase to
+create an aggregate function named PercentileDisc with
+the ORDER BY expression and the fraction as input.
+*/
+func NewPercentileDisc(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &PercentileDisc{
+		*NewAggregateBase("percentile_disc", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *PercentileDisc) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+It returns a value, or an array of values.
+*/
+func (this *PercentileDisc) Type() value.Type { return value.JSON }
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *PercentileDisc) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+func (this *PercentileDisc) MinArgs() int { return 2 }
+
+func (this *PercentileDisc) MaxArgs() int { return 2 }
+
+/*
+The constructor returns a NewPercentileDisc with the input operand
+cast to a Function as the FunctionConstructor.
+*/
+func (this *PercentileDisc) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewPercentileDisc(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *PercentileDisc) Copy() expression.Expression {
+	rv := &PercentileDisc{
+		*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+If no input to the PercentileDisc function, then the default value
+returned is a null.
+*/
+func (this *PercentileDisc) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating operands. Collect all the
+values other than NULL and MISSING as the intermediate aggregate value.
+*/
+func (this *PercentileDisc) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	item, e := this.Operands()[0].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	if item.Type() <= value.NULL {
+		return cumulative, nil
+	}
+
+	return listAdd(item, cumulative), nil
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *PercentileDisc) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return cumulateOrderedLists(part, cumulative)
+}
+
+/*
+Compute the Final. Sort the values, and return the one at position
+ceil(fraction * count) - 1. Return NULL if there are no values.
+*/
+func (this *PercentileDisc) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	fractions, array, e := percentileFractions(this.Name(), this.Operands()[1], context)
+	if e != nil {
+		return nil, e
+	}
+
+	var vals value.Values
+	if cumulative != value.NULL_VALUE {
+		vals, e = orderedValues(cumulative, this.HasFlags(AGGREGATE_DESCENDING))
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	rv := make([]interface{}, len(fractions))
+	for i, f := range fractions {
+		if len(vals) == 0 {
+			rv[i] = value.NULL_VALUE
+			continue
+		}
+
+		pos := int(math.Ceil(f*float64(len(vals)))) - 1
+		if pos < 0 {
+			pos = 0
+		}
+		rv[i] = vals[pos]
+	}
+
+	if array {
+		return value.NewValue(rv), nil
+	}
+	return rv[0].(value.Value), nil
+}
@@ -45,6 +45,7 @@ const (
 	AGGREGATE_IGNORENULLS
 	AGGREGATE_FROMFIRST
 	AGGREGATE_FROMLAST
+	AGGREGATE_DESCENDING
 )
 
 /*
@@ -67,6 +68,8 @@ const (
 	AGGREGATE_WINDOW_FROMLAST
 	AGGREGATE_WINDOW_2ND_POSINT
 	AGGREGATE_WINDOW_2ND_DYNAMIC
+	AGGREGATE_ALLOWS_WITHIN_GROUP
+	AGGREGATE_ALLOWS_ORDER_BY
 )
 
 /*
@@ -80,6 +83,10 @@ const (
 	AGGREGATE_ALLOWS_FL              = AGGREGATE_ALLOWS_WINDOW | AGGREGATE_ALLOWS_WINDOW_FRAME | AGGREGATE_WINDOW_RESPECTNULLS | AGGREGATE_WINDOW_IGNORENULLS
 	AGGREGATE_ALLOWS_NTH             = AGGREGATE_ALLOWS_FL | AGGREGATE_WINDOW_FROMFIRST | AGGREGATE_WINDOW_FROMLAST | AGGREGATE_WINDOW_2ND_POSINT | AGGREGATE_WINDOW_2ND_DYNAMIC
 	AGGREGATE_ALLOWS_LAGLEAD         = AGGREGATE_ALLOWS_WINDOW | AGGREGATE_WINDOW_ORDER | AGGREGATE_WINDOW_RESPECTNULLS | AGGREGATE_WINDOW_IGNORENULLS | AGGREGATE_WINDOW_2ND_POSINT
+	AGGREGATE_ALLOWS_ORDERED_SET     = AGGREGATE_ALLOWS_REGULAR | AGGREGATE_ALLOWS_WINDOW | AGGREGATE_ALLOWS_WINDOW_FRAME | AGGREGATE_ALLOWS_FILTER | AGGREGATE_ALLOWS_WITHIN_GROUP
+	AGGREGATE_ALLOWS_BIVARIATE       = AGGREGATE_ALLOWS_REGULAR | AGGREGATE_ALLOWS_WINDOW | AGGREGATE_ALLOWS_WINDOW_FRAME | AGGREGATE_ALLOWS_FILTER
+	AGGREGATE_ALLOWS_APPROXIMATE     = AGGREGATE_ALLOWS_REGULAR | AGGREGATE_ALLOWS_WINDOW | AGGREGATE_ALLOWS_WINDOW_FRAME | AGGREGATE_ALLOWS_FILTER
+	AGGREGATE_ALLOWS_STRING_AGG      = AGGREGATE_ALLOWS_ALL | AGGREGATE_ALLOWS_ORDER_BY
 )
 
 /*
@@ -146,4 +153,38 @@ var _AGGREGATES = map[string]*AggregateRegistry{
 	"nth_value":       &AggregateRegistry{property: AGGREGATE_ALLOWS_NTH, agg: &NthValue{}},
 	"lag":             &AggregateRegistry{property: AGGREGATE_ALLOWS_LAGLEAD, agg: &Lag{}},
 	"lead":            &AggregateRegistry{property: AGGREGATE_ALLOWS_LAGLEAD, agg: &Lead{}},
+	"percentile_cont": &AggregateRegistry{property: AGGREGATE_ALLOWS_ORDERED_SET, agg: &PercentileCont{}},
+	"percentile_disc": &AggregateRegistry{property: AGGREGATE_ALLOWS_ORDERED_SET, agg: &PercentileDisc{}},
+	"mode":            &AggregateRegistry{property: AGGREGATE_ALLOWS_ORDERED_SET, agg: &Mode{}},
+	"corr":            &AggregateRegistry{property: AGGREGATE_ALLOWS_BIVARIATE, agg: &Corr{}},
+	"covar_pop":       &AggregateRegistry{property: AGGREGATE_ALLOWS_BIVARIATE, agg: &CovarPop{}},
+	"covar_samp":      &AggregateRegistry{property: AGGREGATE_ALLOWS_BIVARIATE, agg: &CovarSamp{}},
+	"regr_avgx":       &AggregateRegistry{property: AGGREGATE_ALLOWS_BIVARIATE, agg: &RegrAvgx{}},
+	"regr_avgy":       &AggregateRegistry{property: AGGREGATE_ALLOWS_BIVARIATE, agg: &RegrAvgy{}},
+	"regr_count":      &AggregateRegistry{property: AGGREGATE_ALLOWS_BIVARIATE, agg: &RegrCount{}},
+	"regr_intercept":  &AggregateRegistry{property: AGGREGATE_ALLOWS_BIVARIATE, agg: &RegrIntercept{}},
+	"regr_r2":         &AggregateRegistry{property: AGGREGATE_ALLOWS_BIVARIATE, agg: &RegrR2{}},
+	"regr_slope":      &AggregateRegistry{property: AGGREGATE_ALLOWS_BIVARIATE, agg: &RegrSlope{}},
+	"regr_sxx":        &AggregateRegistry{property: AGGREGATE_ALLOWS_BIVARIATE, agg: &RegrSxx{}},
+	"regr_sxy":        &AggregateRegistry{property: AGGREGATE_ALLOWS_BIVARIATE, agg: &RegrSxy{}},
+	"regr_syy":        &AggregateRegistry{property: AGGREGATE_ALLOWS_BIVARIATE, agg: &RegrSyy{}},
+
+	// approximate aggregates, and the sketches they are computed from
+	"approx_count_distinct": &AggregateRegistry{property: AGGREGATE_ALLOWS_APPROXIMATE, agg: &ApproxCountDistinct{}},
+	"approx_percentile":     &AggregateRegistry{property: AGGREGATE_ALLOWS_ORDERED_SET, agg: &ApproxPercentile{}},
+	"hll_sketch":            &AggregateRegistry{property: AGGREGATE_ALLOWS_APPROXIMATE, agg: &HLLSketch{}},
+	"hll_merge":             &AggregateRegistry{property: AGGREGATE_ALLOWS_APPROXIMATE, agg: &HLLMerge{}},
+	"tdigest_sketch":        &AggregateRegistry{property: AGGREGATE_ALLOWS_APPROXIMATE, agg: &TDigestSketch{}},
+	"tdigest_merge":         &AggregateRegistry{property: AGGREGATE_ALLOWS_APPROXIMATE, agg: &TDigestMerge{}},
+
+	// bitwise and boolean aggregates
+	"bit_and_agg": &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL, agg: &BitAndAgg{}},
+	"bit_or_agg":  &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL, agg: &BitOrAgg{}},
+	"bit_xor_agg": &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL, agg: &BitXorAgg{}},
+	"bool_and":    &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL, agg: &BoolAnd{}},
+	"bool_or":     &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL, agg: &BoolOr{}},
+
+	// string aggregates, which order their input
+	"listagg":    &AggregateRegistry{property: AGGREGATE_ALLOWS_STRING_AGG, agg: &ListAgg{}},
+	"string_agg": &AggregateRegistry{property: AGGREGATE_ALLOWS_STRING_AGG, agg: &StringAgg{}},
 }
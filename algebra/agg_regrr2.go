@@ -0,0 +1,126 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the Aggregate function REGR_R2(y, x). It returns the
+coefficient of determination of the least squares line fitted to the
+pairs of numbers y and x in the group.
+*/
+
+type RegrR2 struct {
+	AggregateBase
+}
+
+/*
+The function NewRegrR2 calls NewAggregateBase to
+create an aggregate function named RegrR2 with
+two expressions as input.
+*/
+func NewRegrR2(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &RegrR2{
+		*NewAggregateBase("regr_r2", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *RegrR2) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+It returns a value of type NUMBER.
+*/
+func (this *RegrR2) Type() value.Type { return value.NUMBER }
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *RegrR2) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+func (this *RegrR2) MinArgs() int { return 2 }
+
+func (this *RegrR2) MaxArgs() int { return 2 }
+
+/*
+The constructor returns a NewRegrR2 with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *RegrR2) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewRegrR2(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *RegrR2) Copy() expression.Expression {
+	rv := &RegrR2{
+		*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+If no input to the RegrR2 function, then the default value
+returned is a null.
+*/
+func (this *RegrR2) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data, the pairs where both operands are numbers.
+*/
+func (this *RegrR2) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	return this.cumulateBivariate(item, cumulative, context)
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *RegrR2) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return this.cumulateBivariates(part, cumulative)
+}
+
+/*
+Compute the coefficient of determination as sxy^2 / (sxx * syy), which
+is 1 if y is constant. Return NULL if there are no pairs, or x is constant.
+*/
+func (this *RegrR2) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	return this.computeBivariate(cumulative, func(b *bivariate) value.Value {
+		if b.n == 0 || b.sxx == 0 {
+			return value.NULL_VALUE
+		} else if b.syy == 0 {
+			return value.ONE_VALUE
+		}
+		return value.NewValue(b.sxy * b.sxy / (b.sxx * b.syy))
+	})
+}
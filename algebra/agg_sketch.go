@@ -0,0 +1,249 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"fmt"
+
+	"github.com/couchbase/query/util"
+	"github.com/couchbase/query/value"
+)
+
+/*
+The approximate aggregates keep a HyperLogLog or t-digest sketch as an
+attachment of their cumulative value, starting from their default value
+with no sketch. The sketches are mergeable, so that intermediate results
+can be combined in any order. Sketches marshal to their serialized form,
+so that a cumulative value which has been spilled to disk comes back with
+the sketch as a string, which is parsed again when next used.
+*/
+const (
+	_HLL_ATTACHMENT     = "hll"
+	_TDIGEST_ATTACHMENT = "tdigest"
+)
+
+/*
+Returns true if the cumulative value holds a sketch.
+*/
+func hasSketch(cumulative value.Value, name string) bool {
+	av, ok := cumulative.(value.AnnotatedValue)
+	return ok && av.GetAttachment(name) != nil
+}
+
+/*
+Retrieve the HyperLogLog sketch of the cumulative value.
+*/
+func getHyperLogLog(aggname string, cumulative value.Value) (*util.HyperLogLog, error) {
+	av, ok := cumulative.(value.AnnotatedValue)
+	if !ok {
+		return nil, fmt.Errorf("Invalid %s %v of type %T.", aggname, cumulative, cumulative)
+	}
+
+	switch hll := av.GetAttachment(_HLL_ATTACHMENT).(type) {
+	case *util.HyperLogLog:
+		return hll, nil
+	case string:
+		rv, e := util.ParseHyperLogLog(hll)
+		if e != nil {
+			return nil, fmt.Errorf("Invalid %s sketch: %v", aggname, e)
+		}
+		av.SetAttachment(_HLL_ATTACHMENT, rv)
+		return rv, nil
+	default:
+		return nil, fmt.Errorf("Invalid %s sketch %v of type %T.", aggname, hll, hll)
+	}
+}
+
+/*
+Retrieve the HyperLogLog sketch of the cumulative value, adding an
+empty one if there is none yet.
+*/
+func newHyperLogLog(aggname string, cumulative value.Value) (value.AnnotatedValue, *util.HyperLogLog, error) {
+	if hasSketch(cumulative, _HLL_ATTACHMENT) {
+		hll, e := getHyperLogLog(aggname, cumulative)
+		return cumulative.(value.AnnotatedValue), hll, e
+	}
+
+	hll, e := util.NewHyperLogLog(util.HLL_DEFAULT_PRECISION)
+	if e != nil {
+		return nil, nil, e
+	}
+	av := value.NewAnnotatedValue(cumulative)
+	av.SetAttachment(_HLL_ATTACHMENT, hll)
+	return av, hll, nil
+}
+
+/*
+Add the hash of the item to the HyperLogLog sketch of the cumulative
+value. Equal values have the same JSON representation.
+*/
+func hllAdd(aggname string, item, cumulative value.Value) (value.Value, error) {
+	bytes, e := item.MarshalJSON()
+	if e != nil {
+		return nil, e
+	}
+
+	av, hll, e := newHyperLogLog(aggname, cumulative)
+	if e != nil {
+		return nil, e
+	}
+	hll.AddBytes(bytes)
+	return av, nil
+}
+
+/*
+Merge a serialized HyperLogLog sketch into the cumulative value.
+Values other than strings are skipped.
+*/
+func hllMerge(aggname string, item, cumulative value.Value) (value.Value, error) {
+	if item.Type() != value.STRING {
+		return cumulative, nil
+	}
+
+	other, e := util.ParseHyperLogLog(item.Actual().(string))
+	if e != nil {
+		return nil, fmt.Errorf("Invalid %s sketch: %v", aggname, e)
+	}
+
+	av, hll, e := newHyperLogLog(aggname, cumulative)
+	if e != nil {
+		return nil, e
+	}
+	hll.Merge(other)
+	return av, nil
+}
+
+/*
+Aggregate intermediate HyperLogLog sketches.
+*/
+func cumulateHyperLogLogs(aggname string, part, cumulative value.Value) (value.Value, error) {
+	if !hasSketch(part, _HLL_ATTACHMENT) {
+		return cumulative, nil
+	} else if !hasSketch(cumulative, _HLL_ATTACHMENT) {
+		return part, nil
+	}
+
+	p, e := getHyperLogLog(aggname, part)
+	if e != nil {
+		return nil, e
+	}
+
+	c, e := getHyperLogLog(aggname, cumulative)
+	if e != nil {
+		return nil, e
+	}
+	c.Merge(p)
+	return cumulative, nil
+}
+
+/*
+Retrieve the t-digest sketch of the cumulative value.
+*/
+func getTDigest(aggname string, cumulative value.Value) (*util.TDigest, error) {
+	av, ok := cumulative.(value.AnnotatedValue)
+	if !ok {
+		return nil, fmt.Errorf("Invalid %s %v of type %T.", aggname, cumulative, cumulative)
+	}
+
+	switch td := av.GetAttachment(_TDIGEST_ATTACHMENT).(type) {
+	case *util.TDigest:
+		return td, nil
+	case string:
+		rv, e := util.ParseTDigest(td)
+		if e != nil {
+			return nil, fmt.Errorf("Invalid %s sketch: %v", aggname, e)
+		}
+		av.SetAttachment(_TDIGEST_ATTACHMENT, rv)
+		return rv, nil
+	default:
+		return nil, fmt.Errorf("Invalid %s sketch %v of type %T.", aggname, td, td)
+	}
+}
+
+/*
+Retrieve the t-digest sketch of the cumulative value, adding an empty
+one if there is none yet.
+*/
+func newTDigest(aggname string, cumulative value.Value) (value.AnnotatedValue, *util.TDigest, error) {
+	if hasSketch(cumulative, _TDIGEST_ATTACHMENT) {
+		td, e := getTDigest(aggname, cumulative)
+		return cumulative.(value.AnnotatedValue), td, e
+	}
+
+	td, e := util.NewTDigest(util.TDIGEST_DEFAULT_COMPRESSION)
+	if e != nil {
+		return nil, nil, e
+	}
+	av := value.NewAnnotatedValue(cumulative)
+	av.SetAttachment(_TDIGEST_ATTACHMENT, td)
+	return av, td, nil
+}
+
+/*
+Add the item to the t-digest sketch of the cumulative value. Values
+other than numbers are skipped.
+*/
+func tdigestAdd(aggname string, item, cumulative value.Value) (value.Value, error) {
+	if item.Type() != value.NUMBER {
+		return cumulative, nil
+	}
+
+	av, td, e := newTDigest(aggname, cumulative)
+	if e != nil {
+		return nil, e
+	}
+	td.Add(value.AsNumberValue(item).Float64())
+	return av, nil
+}
+
+/*
+Merge a serialized t-digest sketch into the cumulative value. Values
+other than strings are skipped.
+*/
+func tdigestMerge(aggname string, item, cumulative value.Value) (value.Value, error) {
+	if item.Type() != value.STRING {
+		return cumulative, nil
+	}
+
+	other, e := util.ParseTDigest(item.Actual().(string))
+	if e != nil {
+		return nil, fmt.Errorf("Invalid %s sketch: %v", aggname, e)
+	}
+
+	av, td, e := newTDigest(aggname, cumulative)
+	if e != nil {
+		return nil, e
+	}
+	td.Merge(other)
+	return av, nil
+}
+
+/*
+Aggregate intermediate t-digest sketches.
+*/
+func cumulateTDigests(aggname string, part, cumulative value.Value) (value.Value, error) {
+	if !hasSketch(part, _TDIGEST_ATTACHMENT) {
+		return cumulative, nil
+	} else if !hasSketch(cumulative, _TDIGEST_ATTACHMENT) {
+		return part, nil
+	}
+
+	p, e := getTDigest(aggname, part)
+	if e != nil {
+		return nil, e
+	}
+
+	c, e := getTDigest(aggname, cumulative)
+	if e != nil {
+		return nil, e
+	}
+	c.Merge(p)
+	return cumulative, nil
+}
@@ -0,0 +1,471 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the Aggregate function STRING_AGG([DISTINCT] expr, separator
+[ORDER BY ...] [ON OVERFLOW ...]). It returns the concatenation of the values
+of expr, separated by separator, in the given order. Strings are taken as
+they are, numbers and booleans are converted to strings, and other values,
+including NULL and MISSING, are skipped. The result is NULL if there are no
+values.
+*/
+
+type StringAgg struct {
+	AggregateBase
+	overflow *Overflow
+}
+
+/*
+The function NewStringAgg calls NewAggregateBase to
+create an aggregate function named StringAgg with
+the expression and the separator as input.
+*/
+func NewStringAgg(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &StringAgg{
+		AggregateBase: *NewAggregateBase("string_agg", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *StringAgg) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+It returns a value of type STRING.
+*/
+func (this *StringAgg) Type() value.Type { return value.STRING }
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *StringAgg) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+func (this *StringAgg) MinArgs() int { return 2 }
+
+func (this *StringAgg) MaxArgs() int { return 2 }
+
+/*
+The constructor returns a NewStringAgg with the input operand
+cast to a Function as the FunctionConstructor.
+*/
+func (this *StringAgg) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewStringAgg(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *StringAgg) Copy() expression.Expression {
+	rv := &StringAgg{
+		AggregateBase: *NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+		overflow: this.overflow,
+	}
+
+	if len(this.AggregateOrder()) > 0 {
+		rv.SetAggregateOrder(this.AggregateOrder().Copy())
+	}
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+Returns the ON OVERFLOW clause, nil for the default of ERROR.
+*/
+func (this *StringAgg) Overflow() *Overflow {
+	return this.overflow
+}
+
+func (this *StringAgg) SetOverflow(overflow *Overflow) {
+	this.overflow = overflow
+}
+
+/*
+Returns string representation of aggregate, including the ON OVERFLOW clause.
+*/
+func (this *StringAgg) String() string {
+	return this.toString(this.overflow.String())
+}
+
+func (this *StringAgg) EquivalentTo(other expression.Expression) bool {
+	otherAggregate, ok := other.(Aggregate)
+	return ok && this.String() == otherAggregate.String()
+}
+
+/*
+If no input to the StringAgg function, then the default value
+returned is a null.
+*/
+func (this *StringAgg) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating operands. Collect the values
+converted to strings, along with their ORDER BY keys, as the
+intermediate aggregate value.
+*/
+func (this *StringAgg) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	return stringAggAdd(&this.AggregateBase, item, cumulative, context)
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *StringAgg) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return stringAggCumulate(part, cumulative)
+}
+
+/*
+Compute the Final. Sort the values, remove duplicates for DISTINCT,
+and concatenate them with the separator.
+*/
+func (this *StringAgg) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	return stringAggFinal(&this.AggregateBase, this.Operands()[1], this.overflow, cumulative, context)
+}
+
+/*
+The ON OVERFLOW clause of STRING_AGG and LISTAGG, which determines what
+happens when the result would be longer than the maximum length: either
+an error, which is the default, or the result is truncated after the
+last value that fits, and followed by the separator, the filler and,
+WITH COUNT, the number of values left out in parentheses.
+*/
+type Overflow struct {
+	truncate  bool
+	filler    string
+	withCount bool
+}
+
+func NewOverflow(truncate bool, filler string, withCount bool) *Overflow {
+	return &Overflow{
+		truncate:  truncate,
+		filler:    filler,
+		withCount: withCount,
+	}
+}
+
+/*
+The default filler of ON OVERFLOW TRUNCATE.
+*/
+const OVERFLOW_FILLER = "..."
+
+func (this *Overflow) Truncate() bool {
+	return this != nil && this.truncate
+}
+
+func (this *Overflow) Filler() string {
+	return this.filler
+}
+
+func (this *Overflow) WithCount() bool {
+	return this.withCount
+}
+
+/*
+Representation as a N1QL string. The default is empty.
+*/
+func (this *Overflow) String() string {
+	if this == nil {
+		return ""
+	} else if !this.truncate {
+		return " ON OVERFLOW ERROR"
+	}
+
+	s := " ON OVERFLOW TRUNCATE " + expression.NewStringer().Visit(expression.NewConstant(this.filler))
+	if this.withCount {
+		return s + " WITH COUNT"
+	}
+	return s + " WITHOUT COUNT"
+}
+
+/*
+Maximum length in bytes of the result of STRING_AGG and LISTAGG,
+the maximum size of a document.
+*/
+var _STRING_AGG_MAX_LENGTH = 20 * 1024 * 1024
+
+/*
+Attachment with the size of the intermediate value that is accounted
+against the request memory quota.
+*/
+const _QUOTA_ATTACHMENT = "quota"
+
+/*
+Strings are taken as they are, numbers and booleans are converted as
+by TOSTRING(), and other values are skipped.
+*/
+func aggString(val value.Value) (string, bool) {
+	switch val.Type() {
+	case value.STRING:
+		return val.Actual().(string), true
+	case value.BOOLEAN:
+		return fmt.Sprint(val.Actual()), true
+	case value.NUMBER:
+		switch actual := val.ActualForIndex().(type) {
+		case float64:
+			return strconv.FormatFloat(actual, 'f', -1, 64), true
+		case int64:
+			return strconv.FormatInt(actual, 10), true
+		}
+	}
+	return "", false
+}
+
+/*
+Add the string of the item, with its ORDER BY keys if any, to the list of
+the cumulative value, and account for it against the memory quota.
+*/
+func stringAggAdd(agg *AggregateBase, item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := agg.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	val, e := agg.Operands()[0].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	s, ok := aggString(val)
+	if !ok {
+		return cumulative, nil
+	}
+
+	entry := value.NewValue(s)
+	if order := agg.AggregateOrder(); len(order) > 0 {
+		keys := make([]interface{}, 0, len(order)+1)
+		keys = append(keys, s)
+		for _, term := range order {
+			key, e := term.Expression().Evaluate(item, context)
+			if e != nil {
+				return nil, e
+			}
+			if key.Type() == value.MISSING {
+				key = value.NULL_VALUE
+			}
+			keys = append(keys, key)
+		}
+		entry = value.NewValue(keys)
+	}
+
+	av := listAdd(entry, cumulative)
+	return av, trackAggregateSize(av, entry.Size(), context)
+}
+
+/*
+Aggregate intermediate lists, which start from NULL if there is no input,
+along with the size accounted for them.
+*/
+func stringAggCumulate(part, cumulative value.Value) (value.Value, error) {
+	if part == value.NULL_VALUE {
+		return cumulative, nil
+	} else if cumulative == value.NULL_VALUE {
+		return part, nil
+	}
+
+	rv, e := cumulateLists(part, cumulative)
+	if e != nil {
+		return nil, e
+	}
+
+	pv := part.(value.AnnotatedValue)
+	size, _ := pv.GetAttachment(_QUOTA_ATTACHMENT).(int)
+	if size > 0 {
+		cSize, _ := rv.GetAttachment(_QUOTA_ATTACHMENT).(int)
+		rv.SetAttachment(_QUOTA_ATTACHMENT, cSize+size)
+		pv.SetAttachment(_QUOTA_ATTACHMENT, 0)
+	}
+	return rv, nil
+}
+
+/*
+Sort the strings of the cumulative value in the ORDER BY order, remove
+duplicates for DISTINCT, and join them with the separator, applying
+the ON OVERFLOW clause. The size of the list is no longer accounted
+once the result is computed.
+*/
+func stringAggFinal(agg *AggregateBase, separator expression.Expression, overflow *Overflow,
+	cumulative value.Value, context Context) (value.Value, error) {
+	if cumulative == value.NULL_VALUE {
+		return cumulative, nil
+	}
+
+	sep := ""
+	if separator != nil {
+		sv, e := separator.Evaluate(nil, context)
+		if e != nil {
+			return nil, e
+		}
+		if sv.Type() > value.NULL {
+			s, ok := sv.Actual().(string)
+			if !ok {
+				return nil, fmt.Errorf("Invalid %s separator %v, it must be a string.", strings.ToUpper(agg.Name()), sv)
+			}
+			sep = s
+		}
+	}
+
+	list, e := getList(cumulative)
+	if e != nil {
+		return nil, e
+	}
+	releaseAggregateSize(cumulative.(value.AnnotatedValue), context)
+
+	vals := list.Values()
+	order := agg.AggregateOrder()
+	if len(order) > 0 {
+		sort.SliceStable(vals, func(i, j int) bool {
+			for k, term := range order {
+				ki, _ := vals[i].Index(k + 1)
+				kj, _ := vals[j].Index(k + 1)
+				if c := term.Compare(ki, kj); c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+	}
+
+	strs := make([]string, 0, len(vals))
+	var seen map[string]bool
+	if agg.Distinct() {
+		seen = make(map[string]bool, len(vals))
+	}
+	for _, v := range vals {
+		if len(order) > 0 {
+			v, _ = v.Index(0)
+		}
+		s := v.Actual().(string)
+		if seen != nil {
+			if seen[s] {
+				continue
+			}
+			seen[s] = true
+		}
+		strs = append(strs, s)
+	}
+
+	if len(strs) == 0 {
+		return value.NULL_VALUE, nil
+	}
+	rv, e := joinStrings(agg.Name(), strs, sep, overflow)
+	if e != nil {
+		return nil, e
+	}
+	return value.NewValue(rv), nil
+}
+
+/*
+Join the strings with the separator, applying the ON OVERFLOW clause
+if the result exceeds the maximum length.
+*/
+func joinStrings(aggname string, strs []string, sep string, overflow *Overflow) (string, error) {
+	length := (len(strs) - 1) * len(sep)
+	for _, s := range strs {
+		length += len(s)
+	}
+	if length <= _STRING_AGG_MAX_LENGTH {
+		return strings.Join(strs, sep), nil
+	} else if !overflow.Truncate() {
+		return "", fmt.Errorf("%s result exceeds the maximum length of %d.", strings.ToUpper(aggname),
+			_STRING_AGG_MAX_LENGTH)
+	}
+
+	// leave room for the separator, the filler and the count
+	room := _STRING_AGG_MAX_LENGTH - len(sep) - len(overflow.Filler())
+	if overflow.WithCount() {
+		room -= len(strconv.Itoa(len(strs))) + 2
+	}
+
+	var buf strings.Builder
+	n := 0
+	for ; n < len(strs); n++ {
+		l := len(strs[n])
+		if n > 0 {
+			l += len(sep)
+		}
+		if buf.Len()+l > room {
+			break
+		}
+		if n > 0 {
+			buf.WriteString(sep)
+		}
+		buf.WriteString(strs[n])
+	}
+
+	if n > 0 {
+		buf.WriteString(sep)
+	}
+	buf.WriteString(overflow.Filler())
+	if overflow.WithCount() {
+		buf.WriteString("(" + strconv.Itoa(len(strs)-n) + ")")
+	}
+	return buf.String(), nil
+}
+
+/*
+Account for the size added to the intermediate value against the request
+memory quota, if any.
+*/
+func trackAggregateSize(av value.AnnotatedValue, size uint64, context Context) error {
+	qc, ok := context.(QuotaContext)
+	if !ok || !qc.UseRequestQuota() {
+		return nil
+	}
+
+	tracked, _ := av.GetAttachment(_QUOTA_ATTACHMENT).(int)
+	av.SetAttachment(_QUOTA_ATTACHMENT, tracked+int(size))
+	if qc.TrackValueSize(size) {
+		return errors.NewMemoryQuotaExceededError()
+	}
+	return nil
+}
+
+/*
+Release the size accounted for the intermediate value.
+*/
+func releaseAggregateSize(av value.AnnotatedValue, context Context) {
+	tracked, _ := av.GetAttachment(_QUOTA_ATTACHMENT).(int)
+	if tracked <= 0 {
+		return
+	}
+
+	if qc, ok := context.(QuotaContext); ok {
+		qc.ReleaseValueSize(uint64(tracked))
+	}
+	av.SetAttachment(_QUOTA_ATTACHMENT, 0)
+}
@@ -0,0 +1,140 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the Aggregate function TDIGEST_MERGE(sketch). It
+returns the t-digest sketch of all the numbers the sketches in the
+group were built from, as returned by TDIGEST_SKETCH(), serialized as
+a base64 string. Values other than strings are skipped.
+*/
+
+type TDigestMerge struct {
+	AggregateBase
+}
+
+/*
+The function NewTDigestMerge calls NewAggregateBase to
+create an aggregate function named TDigestMerge with
+one expression as input.
+*/
+func NewTDigestMerge(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &TDigestMerge{
+		*NewAggregateBase("tdigest_merge", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *TDigestMerge) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+It returns a value of type STRING.
+*/
+func (this *TDigestMerge) Type() value.Type { return value.STRING }
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *TDigestMerge) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewTDigestMerge with the input operand
+cast to a Function as the FunctionConstructor.
+*/
+func (this *TDigestMerge) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewTDigestMerge(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *TDigestMerge) Copy() expression.Expression {
+	rv := &TDigestMerge{
+		*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+If no input to the TDigestMerge function, then the default value
+returned is a null.
+*/
+func (this *TDigestMerge) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating operands. For missing and
+null values return the input value itself. Merge the sketches into
+the t-digest sketch of the intermediate aggregate value.
+*/
+func (this *TDigestMerge) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	item, e := this.Operands()[0].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	if item.Type() <= value.NULL {
+		return cumulative, nil
+	}
+
+	return tdigestMerge(this.Name(), item, cumulative)
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *TDigestMerge) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return cumulateTDigests(this.Name(), part, cumulative)
+}
+
+/*
+Compute the Final. Return the serialized t-digest sketch, or NULL
+if there is none.
+*/
+func (this *TDigestMerge) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	if !hasSketch(cumulative, _TDIGEST_ATTACHMENT) {
+		return value.NULL_VALUE, nil
+	}
+
+	td, e := getTDigest(this.Name(), cumulative)
+	if e != nil {
+		return nil, e
+	}
+	return value.NewValue(td.String()), nil
+}
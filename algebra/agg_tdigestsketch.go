@@ -0,0 +1,140 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the Aggregate function TDIGEST_SKETCH(expr). It returns
+the t-digest sketch of the number values of expr, serialized as a
+base64 string. Sketches can be stored, combined with TDIGEST_MERGE()
+and TDIGEST_UNION(), and queried with TDIGEST_PERCENTILE(), so that
+percentiles can be rolled up.
+*/
+
+type TDigestSketch struct {
+	AggregateBase
+}
+
+/*
+The function NewTDigestSketch calls NewAggregateBase to
+create an aggregate function named TDigestSketch with
+one expression as input.
+*/
+func NewTDigestSketch(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &TDigestSketch{
+		*NewAggregateBase("tdigest_sketch", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *TDigestSketch) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+It returns a value of type STRING.
+*/
+func (this *TDigestSketch) Type() value.Type { return value.STRING }
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *TDigestSketch) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewTDigestSketch with the input operand
+cast to a Function as the FunctionConstructor.
+*/
+func (this *TDigestSketch) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewTDigestSketch(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *TDigestSketch) Copy() expression.Expression {
+	rv := &TDigestSketch{
+		*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+If no input to the TDigestSketch function, then the default value
+returned is a null.
+*/
+func (this *TDigestSketch) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating operands. Add the values of
+type NUMBER to the t-digest sketch of the intermediate aggregate value.
+*/
+func (this *TDigestSketch) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	item, e := this.Operands()[0].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	if item.Type() <= value.NULL {
+		return cumulative, nil
+	}
+
+	return tdigestAdd(this.Name(), item, cumulative)
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *TDigestSketch) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return cumulateTDigests(this.Name(), part, cumulative)
+}
+
+/*
+Compute the Final. Return the serialized t-digest sketch, or NULL
+if there is none.
+*/
+func (this *TDigestSketch) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	if !hasSketch(cumulative, _TDIGEST_ATTACHMENT) {
+		return value.NULL_VALUE, nil
+	}
+
+	td, e := getTDigest(this.Name(), cumulative)
+	if e != nil {
+		return nil, e
+	}
+	return value.NewValue(td.String()), nil
+}
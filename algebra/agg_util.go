@@ -480,3 +480,88 @@ func compute_nth_value(item, cumpart value.Value, expr expression.Expression, ni
 	}
 	return nil
 }
+
+/*
+Aggregate intermediate lists of the ordered set aggregates,
+which start from NULL if there is no input.
+*/
+func cumulateOrderedLists(part, cumulative value.Value) (value.Value, error) {
+	if part == value.NULL_VALUE {
+		return cumulative, nil
+	} else if cumulative == value.NULL_VALUE {
+		return part, nil
+	}
+	return cumulateLists(part, cumulative)
+}
+
+/*
+Return the values collected by an ordered set aggregate, sorted in
+the order of its WITHIN GROUP clause.
+*/
+func orderedValues(cumulative value.Value, descending bool) (value.Values, error) {
+	list, e := getList(cumulative)
+	if e != nil {
+		return nil, e
+	}
+
+	vals := list.Values()
+	sort.SliceStable(vals, func(i, j int) bool {
+		if descending {
+			return vals[i].Collate(vals[j]) > 0
+		}
+		return vals[i].Collate(vals[j]) < 0
+	})
+	return vals, nil
+}
+
+/*
+Evaluate the fraction argument of PERCENTILE_CONT, PERCENTILE_DISC and
+APPROX_PERCENTILE, a number or an array of numbers between 0 and 1. The
+argument must not depend on the input, and is checked by the semantic
+checker.
+*/
+func percentileFractions(aggname string, expr expression.Expression, context Context) ([]float64, bool, error) {
+	val, e := expr.Evaluate(nil, context)
+	if e != nil {
+		return nil, false, e
+	}
+
+	vals := []interface{}{val}
+	array := val.Type() == value.ARRAY
+	if array {
+		vals = val.Actual().([]interface{})
+	}
+
+	fractions := make([]float64, len(vals))
+	for i, v := range vals {
+		f := value.NewValue(v)
+		if f.Type() != value.NUMBER {
+			return nil, false, fmt.Errorf("Invalid %s fraction %v, it must be a number between 0 and 1.", aggname, f)
+		}
+		fractions[i] = f.(value.NumberValue).Float64()
+		if fractions[i] < 0.0 || fractions[i] > 1.0 {
+			return nil, false, fmt.Errorf("Invalid %s fraction %v, it must be a number between 0 and 1.", aggname, f)
+		}
+	}
+	return fractions, array, nil
+}
+
+/*
+Return the value as an int64 if it is an integer, for the bitwise
+aggregates.
+*/
+func bitwiseInt(item value.Value) (int64, bool) {
+	if item.Type() != value.NUMBER {
+		return 0, false
+	}
+
+	switch actual := item.ActualForIndex().(type) {
+	case float64:
+		if value.IsInt(actual) {
+			return int64(actual), true
+		}
+	case int64:
+		return actual, true
+	}
+	return 0, false
+}
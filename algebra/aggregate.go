@@ -110,6 +110,16 @@ type Aggregate interface {
 	   Check if aggregate is done, if any.
 	*/
 	IsCumulateDone(cumulative value.Value, context Context) (bool, error)
+
+	/*
+	   Return the ORDER BY terms of the aggregate input, if any.
+	*/
+	AggregateOrder() SortTerms
+
+	/*
+	   Set the ORDER BY terms of the aggregate input.
+	*/
+	SetAggregateOrder(order SortTerms)
 }
 
 /*
@@ -117,9 +127,12 @@ Base class for Aggregate functions.
 It inherits from expressions FunctionBase, and has
      text           which represents the function name.
      flags          which represents the modifers/flags
-                         DISTINCT, INCREMENTAL, RESPECT|IGNORE NULLS, FROM FIRST|LAST
+                         DISTINCT, INCREMENTAL, RESPECT|IGNORE NULLS, FROM FIRST|LAST,
+                         WITHIN GROUP (ORDER BY ... DESC)
      filter         include those objects that filter condition is true in aggregation
      windowTerm     which represents the Window information
+     order          which represents the ORDER BY of the aggregate input, as in
+                         STRING_AGG(expr, sep ORDER BY ...)
 */
 
 type AggregateBase struct {
@@ -128,6 +141,7 @@ type AggregateBase struct {
 	flags      uint32
 	filter     expression.Expression
 	windowTerm *WindowTerm
+	order      SortTerms
 }
 
 /*
@@ -190,6 +204,14 @@ func (this *AggregateBase) Flags() uint32                 { return this.flags }
 func (this *AggregateBase) MinArgs() int                  { return 1 }
 func (this *AggregateBase) MaxArgs() int                  { return 1 }
 func (this *AggregateBase) Filter() expression.Expression { return this.filter }
+func (this *AggregateBase) AggregateOrder() SortTerms     { return this.order }
+
+/*
+Sets the ORDER BY terms of the aggregate input
+*/
+func (this *AggregateBase) SetAggregateOrder(order SortTerms) {
+	this.order = order
+}
 
 /*
 If Incremental aggregation is possible or not
@@ -220,6 +242,14 @@ func (this *AggregateBase) IsCumulateDone(cumulative value.Value, context Contex
  Returns string representation of aggregate
 */
 func (this *AggregateBase) String() string {
+	return this.toString("")
+}
+
+/*
+Returns string representation of aggregate, with the given clause
+after the arguments and ORDER BY.
+*/
+func (this *AggregateBase) toString(clause string) string {
 	var buf bytes.Buffer
 	stringer := expression.NewStringer()
 
@@ -230,7 +260,14 @@ func (this *AggregateBase) String() string {
 		buf.WriteString("DISTINCT ")
 	}
 
-	for i, op := range this.Operands() {
+	// ordered set aggregates have the ORDER BY expression as first operand
+	operands := this.Operands()
+	withinGroup := len(operands) > 0 && AggregateHasProperty(this.Name(), AGGREGATE_ALLOWS_WITHIN_GROUP)
+	if withinGroup {
+		operands = operands[1:]
+	}
+
+	for i, op := range operands {
 		if i > 0 {
 			buf.WriteString(", ")
 		}
@@ -243,8 +280,23 @@ func (this *AggregateBase) String() string {
 		}
 	}
 
+	if len(this.order) > 0 {
+		buf.WriteString(" ORDER BY ")
+		buf.WriteString(this.order.String())
+	}
+
+	buf.WriteString(clause)
 	buf.WriteString(")")
 
+	if withinGroup {
+		buf.WriteString(" WITHIN GROUP (ORDER BY ")
+		buf.WriteString(stringer.Visit(this.Operands()[0]))
+		if this.HasFlags(AGGREGATE_DESCENDING) {
+			buf.WriteString(" DESC")
+		}
+		buf.WriteString(")")
+	}
+
 	if this.Filter() != nil {
 		buf.WriteString(" FILTER (WHERE ")
 		buf.WriteString(stringer.Visit(this.Filter()))
@@ -347,6 +399,7 @@ func EqualAggregateModifiers(agg1, agg2 Aggregate) bool {
 	return agg1.Flags() == agg2.Flags() &&
 		expression.Equivalent(agg1.Filter(), agg2.Filter()) &&
 		expression.Equivalents(agg1.Operands(), agg2.Operands()) &&
+		agg1.AggregateOrder().String() == agg2.AggregateOrder().String() &&
 		((wTerm1 == wTerm2) || (wTerm1 != nil && wTerm2 != nil && wTerm1.String() == wTerm2.String()))
 }
 
@@ -373,6 +426,10 @@ func (this *AggregateBase) Children() expression.Expressions {
 		rv = append(rv, this.Filter())
 	}
 
+	if len(this.order) > 0 {
+		rv = append(rv, this.order.Expressions()...)
+	}
+
 	wTerm := this.WindowTerm()
 	if wTerm != nil {
 		exprs := wTerm.Expressions()
@@ -412,6 +469,13 @@ func (this *AggregateBase) MapChildren(mapper expression.Mapper) error {
 		this.filter = expr
 	}
 
+	if len(this.order) > 0 {
+		err := this.order.MapExpressions(mapper)
+		if err != nil {
+			return err
+		}
+	}
+
 	wTerm := this.WindowTerm()
 	if wTerm != nil {
 		return wTerm.MapExpressions(mapper)
@@ -22,3 +22,14 @@ type Context interface {
 	PositionalArg(position int) (value.Value, bool)
 	EvaluateSubquery(query *Select, parent value.Value) (value.Value, error)
 }
+
+/*
+Contexts that account for the memory used by a request against its
+memory quota, such as the execution context. Aggregates that keep
+their input, such as STRING_AGG, use it to account for their state.
+*/
+type QuotaContext interface {
+	UseRequestQuota() bool
+	TrackValueSize(size uint64) bool
+	ReleaseValueSize(size uint64)
+}
@@ -11,6 +11,7 @@ package algebra
 
 import (
 	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
 )
 
 /*
@@ -161,6 +162,31 @@ func (this *SortTerm) NullsPos() bool {
 	return this.nullsPos
 }
 
+/*
+Compare the values of the term for two items. Return a negative
+number if the first sorts before the second, honoring the sort
+order and the position of NULL and MISSING.
+*/
+func (this *SortTerm) Compare(ev1, ev2 value.Value) int {
+	var c int
+
+	if !this.NullsPos() || ((ev1.Type() <= value.NULL && ev2.Type() <= value.NULL) ||
+		(ev1.Type() > value.NULL && ev2.Type() > value.NULL)) {
+		c = ev1.Collate(ev2)
+	} else {
+		if ev1.Type() <= value.NULL && ev2.Type() > value.NULL {
+			c = 1
+		} else {
+			c = -1
+		}
+	}
+
+	if this.Descending() {
+		return -c
+	}
+	return c
+}
+
 /*
 Map Expressions for all sort terms in the receiver.
 */
@@ -25,6 +25,7 @@ and select clause respectively.
 */
 type Subselect struct {
 	with       expression.Bindings   `json:"with"`
+	recursive  RecursiveWiths        `json:"recursive"`
 	from       FromTerm              `json:"from"`
 	let        expression.Bindings   `json:"let"`
 	where      expression.Expression `json:"where"`
@@ -37,12 +38,11 @@ type Subselect struct {
 /*
 Constructor.
 */
-func NewSubselect(with expression.Bindings, from FromTerm, let expression.Bindings,
+func NewSubselect(with *WithClause, from FromTerm, let expression.Bindings,
 	where expression.Expression, group *Group, window WindowTerms,
 	projection *Projection) *Subselect {
 
-	return &Subselect{
-		with:       with,
+	rv := &Subselect{
 		from:       from,
 		let:        let,
 		where:      where,
@@ -50,6 +50,12 @@ func NewSubselect(with expression.Bindings, from FromTerm, let expression.Bindin
 		projection: projection,
 		window:     window,
 	}
+
+	if with != nil {
+		rv.with = with.Bindings()
+		rv.recursive = with.Recursive()
+	}
+	return rv
 }
 
 /*
@@ -77,11 +83,21 @@ expressions and calls PushBindings for the let clause.
 func (this *Subselect) Formalize(parent *expression.Formalizer) (f *expression.Formalizer, err error) {
 	if this.with != nil {
 		f = expression.NewFormalizer("", parent)
+
+		// recursive subselects read their own alias in FROM
+		if this.recursive != nil {
+			f.SetPermanentWiths(this.recursive.Bindings())
+		}
 		err = f.PushBindings(this.with, false)
 		if err != nil {
 			return nil, err
 		}
 		f.SetWiths(this.with)
+
+		this.recursive, err = this.recursive.formalize()
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if this.from != nil {
@@ -287,7 +303,7 @@ func (this *Subselect) String() string {
 	var s string
 
 	if len(this.with) > 0 {
-		s += withBindings(this.with)
+		s += withBindings(this.with, this.recursive)
 	}
 
 	s += "select " + this.projection.String()
@@ -330,6 +346,13 @@ func (this *Subselect) With() expression.Bindings {
 	return this.with
 }
 
+/*
+Returns the terms of the With clause that recurse.
+*/
+func (this *Subselect) RecursiveWiths() RecursiveWiths {
+	return this.recursive
+}
+
 /*
 Returns a FromTerm that represents the From clause
 in the subselect statement.
@@ -406,8 +429,11 @@ func stringBindings(bindings expression.Bindings) string {
    Representation as a N1QL WITH clause string.
 */
 
-func withBindings(bindings expression.Bindings) string {
+func withBindings(bindings expression.Bindings, recursive RecursiveWiths) string {
 	s := " WITH "
+	if len(recursive) > 0 {
+		s += "RECURSIVE "
+	}
 
 	for i, b := range bindings {
 		if i > 0 {
@@ -417,6 +443,9 @@ func withBindings(bindings expression.Bindings) string {
 		s += "`" + b.Variable() + "` AS ( "
 		s += b.Expression().String()
 		s += " ) "
+		if term := recursive.Get(b.Variable()); term != nil {
+			s += term.String() + " "
+		}
 	}
 
 	return s
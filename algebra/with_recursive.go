@@ -0,0 +1,326 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+The WITH clause of a subselect. WITH RECURSIVE additionally records the
+terms that may reference their own alias.
+*/
+type WithClause struct {
+	bindings  expression.Bindings
+	recursive RecursiveWiths
+}
+
+func NewWithClause(bindings expression.Bindings) *WithClause {
+	return &WithClause{
+		bindings: bindings,
+	}
+}
+
+func NewRecursiveWithClause(terms RecursiveWiths) *WithClause {
+	rv := &WithClause{
+		bindings:  make(expression.Bindings, len(terms)),
+		recursive: make(RecursiveWiths, 0, len(terms)),
+	}
+
+	for i, term := range terms {
+		rv.bindings[i] = term.binding
+		rv.recursive = append(rv.recursive, term)
+	}
+	return rv
+}
+
+func (this *WithClause) Bindings() expression.Bindings {
+	return this.bindings
+}
+
+func (this *WithClause) Recursive() RecursiveWiths {
+	return this.recursive
+}
+
+/*
+A term of WITH RECURSIVE:
+
+	alias AS (anchor UNION [ALL] recursive) [CYCLE exprs RESTRICT] [OPTIONS {...}]
+
+The anchor is evaluated once. The recursive subselect, which reads the
+alias, is then evaluated over and over, with the alias bound to the items
+produced by the previous iteration only, until an iteration produces no
+new items. The alias is finally bound to all the items produced.
+
+UNION drops items already produced, CYCLE ... RESTRICT drops items for
+which the cycle expressions evaluate to values already seen on the path
+leading to them from an anchor item, and the "levels" and "documents"
+options cap the number of iterations and the number of items produced. Without them, the server limits apply, and
+exceeding them is an error rather than a truncated result.
+
+Terms that are not a UNION, or whose second subselect does not read the
+alias, are plain WITH terms.
+*/
+type RecursiveWith struct {
+	binding   *expression.Binding
+	cycle     expression.Expressions
+	options   value.Value
+	union     bool
+	anchor    *Select
+	recursive *Select
+}
+
+const (
+	_LEVELS    = "levels"
+	_DOCUMENTS = "documents"
+)
+
+func NewRecursiveWith(binding *expression.Binding, cycle expression.Expressions, options value.Value) *RecursiveWith {
+	rv := &RecursiveWith{
+		binding: binding,
+		cycle:   cycle,
+		options: options,
+	}
+
+	subq, ok := binding.Expression().(*Subquery)
+	if !ok || subq.Select().Order() != nil || subq.Select().Limit() != nil || subq.Select().Offset() != nil {
+		return rv
+	}
+
+	var setop *setOp
+	switch subresult := subq.Select().Subresult().(type) {
+	case *Union:
+		setop = &subresult.setOp
+		rv.union = true
+	case *UnionAll:
+		setop = &subresult.setOp
+	default:
+		return rv
+	}
+
+	rv.anchor = NewSelect(setop.first, nil, nil, nil)
+	rv.recursive = NewSelect(setop.second, nil, nil, nil)
+
+	// the alias changes from one iteration to the next
+	rv.recursive.SetCorrelated()
+	return rv
+}
+
+func (this *RecursiveWith) Alias() string {
+	return this.binding.Variable()
+}
+
+func (this *RecursiveWith) Binding() *expression.Binding {
+	return this.binding
+}
+
+func (this *RecursiveWith) Cycle() expression.Expressions {
+	return this.cycle
+}
+
+/*
+The cycle expressions are evaluated on each item bound to the alias, so
+they are qualified by it: unqualified identifiers are fields of the item.
+*/
+func (this *RecursiveWith) FormalizeCycle() error {
+	f := expression.NewFormalizer(this.Alias(), nil)
+	for i, expr := range this.cycle {
+		expr, err := f.Map(expr)
+		if err != nil {
+			return err
+		}
+		this.cycle[i] = expr
+	}
+	return nil
+}
+
+func (this *RecursiveWith) Options() value.Value {
+	return this.options
+}
+
+/*
+UNION, as opposed to UNION ALL.
+*/
+func (this *RecursiveWith) Union() bool {
+	return this.union
+}
+
+func (this *RecursiveWith) Anchor() *Select {
+	return this.anchor
+}
+
+func (this *RecursiveWith) Recursive() *Select {
+	return this.recursive
+}
+
+/*
+Maximum number of iterations after the anchor, -1 if unset, in which
+case the server limit applies.
+*/
+func (this *RecursiveWith) Levels() int64 {
+	return this.option(_LEVELS)
+}
+
+/*
+Maximum number of items produced, -1 if unset, in which case the
+server limit applies.
+*/
+func (this *RecursiveWith) Documents() int64 {
+	return this.option(_DOCUMENTS)
+}
+
+func (this *RecursiveWith) option(name string) int64 {
+	if this.options != nil {
+		if v, ok := this.options.Field(name); ok && v.Type() == value.NUMBER {
+			return value.AsNumberValue(v).Int64()
+		}
+	}
+	return -1
+}
+
+/*
+Whether the term recurses at all: its second subselect must read the alias.
+*/
+func (this *RecursiveWith) recurses() bool {
+	return this.recursive != nil &&
+		referencesAlias(this.recursive.Subresult().Expressions(), this.Alias())
+}
+
+/*
+Semantic checks on the term.
+*/
+func (this *RecursiveWith) Validate() errors.Error {
+	alias := this.Alias()
+	if referencesAlias(this.anchor.Subresult().Expressions(), alias) {
+		return errors.NewRecursiveWithSemanticError(alias, "the anchor cannot reference the recursive alias")
+	}
+
+	if this.options == nil {
+		return nil
+	}
+	fields, ok := this.options.Actual().(map[string]interface{})
+	if !ok {
+		return errors.NewRecursiveWithSemanticError(alias, "OPTIONS must be an object")
+	}
+	for name, _ := range fields {
+		if name != _LEVELS && name != _DOCUMENTS {
+			return errors.NewRecursiveWithSemanticError(alias, "unknown option "+name)
+		}
+		v, _ := this.options.Field(name)
+		if v.Type() != value.NUMBER {
+			return errors.NewRecursiveWithSemanticError(alias, "option "+name+" must be a number")
+		}
+		f := value.AsNumberValue(v).Float64()
+		if f < 0 || f != math.Trunc(f) {
+			return errors.NewRecursiveWithSemanticError(alias, "option "+name+" must be a non-negative integer")
+		}
+	}
+	return nil
+}
+
+/*
+Representation as a N1QL string, following the term.
+*/
+func (this *RecursiveWith) String() string {
+	var s string
+
+	if len(this.cycle) > 0 {
+		s += " CYCLE "
+		for i, expr := range this.cycle {
+			if i > 0 {
+				s += ", "
+			}
+			s += expr.String()
+		}
+		s += " RESTRICT"
+	}
+
+	if this.options != nil {
+		s += " OPTIONS " + this.options.String()
+	}
+
+	return s
+}
+
+func (this *RecursiveWith) MarshalJSON() ([]byte, error) {
+	r := map[string]interface{}{"alias": this.Alias()}
+	if len(this.cycle) > 0 {
+		cycle := make([]string, len(this.cycle))
+		for i, expr := range this.cycle {
+			cycle[i] = expr.String()
+		}
+		r["cycle"] = cycle
+	}
+	if this.options != nil {
+		r["options"] = this.options
+	}
+	return json.Marshal(r)
+}
+
+type RecursiveWiths []*RecursiveWith
+
+func (this RecursiveWiths) Bindings() expression.Bindings {
+	rv := make(expression.Bindings, len(this))
+	for i, term := range this {
+		rv[i] = term.binding
+	}
+	return rv
+}
+
+/*
+The term for alias, if it recurses.
+*/
+func (this RecursiveWiths) Get(alias string) *RecursiveWith {
+	for _, term := range this {
+		if term.Alias() == alias {
+			return term
+		}
+	}
+	return nil
+}
+
+/*
+Once identifiers are formalized, keep the terms that actually recurse;
+the others can have neither CYCLE nor OPTIONS.
+*/
+func (this RecursiveWiths) formalize() (RecursiveWiths, error) {
+	var rv RecursiveWiths
+
+	for _, term := range this {
+		if term.recurses() {
+			err := term.FormalizeCycle()
+			if err != nil {
+				return nil, err
+			}
+			rv = append(rv, term)
+		} else if len(term.cycle) > 0 || term.options != nil {
+			return nil, errors.NewRecursiveWithSemanticError(term.Alias(),
+				"CYCLE and OPTIONS require a UNION whose second subselect references the alias")
+		}
+	}
+	return rv, nil
+}
+
+func referencesAlias(exprs expression.Expressions, alias string) bool {
+	for _, expr := range exprs {
+		if ident, ok := expr.(*expression.Identifier); ok && ident.Identifier() == alias {
+			return true
+		}
+		if referencesAlias(expr.Children(), alias) {
+			return true
+		}
+	}
+	return false
+}
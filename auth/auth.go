@@ -42,6 +42,7 @@ const (
 	PRIV_QUERY_STATS                            Privilege = 26 // Ability to read query stats
 	PRIV_QUERY_TRANSACTION_STMT                 Privilege = 27 // Ability to run Transaction statements.
 	PRIV_UPSERT                                 Privilege = 28 // Ability to run docs UPSERT
+	PRIV_CLUSTER_SETTINGS_WRITE                 Privilege = 29 // Ability to change settings, such as workload groups
 )
 
 type PrivilegePair struct {
@@ -80,6 +80,8 @@ func privilegeString(namespace, target, obj string, requested auth.Privilege) (s
 		permission = join3Strings("cluster.bucket[", target, "]!manage")
 	case auth.PRIV_QUERY_STATS:
 		permission = "cluster.admin.internal.stats!read"
+	case auth.PRIV_CLUSTER_SETTINGS_WRITE:
+		permission = "cluster.settings!write"
 	default:
 		return "", fmt.Errorf("Invalid Privileges")
 	}
@@ -22,8 +22,10 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/couchbase/query/auth"
 	"github.com/couchbase/query/datastore"
@@ -32,6 +34,7 @@ import (
 	"github.com/couchbase/query/expression"
 	"github.com/couchbase/query/logging"
 	"github.com/couchbase/query/timestamp"
+	"github.com/couchbase/query/transactions"
 	"github.com/couchbase/query/util"
 	"github.com/couchbase/query/value"
 )
@@ -42,6 +45,7 @@ type store struct {
 	namespaces     map[string]*namespace
 	namespaceNames []string
 	inferencer     datastore.Inferencer // what we use to infer schemas
+	txLock         sync.Mutex           // serializes transaction commits
 
 	users map[string]*datastore.User
 }
@@ -174,24 +178,117 @@ func (s *store) HasSystemCBOStats() (bool, errors.Error) {
 	return false, nil
 }
 
-func (s *store) StartTransaction(stmtAtomicity bool, context datastore.QueryContext) (map[string]bool, errors.Error) {
-	return nil, errors.NewTranDatastoreNotSupportedError("file")
+func (s *store) StartTransaction(stmtAtomicity bool, context datastore.QueryContext) (dks map[string]bool, err errors.Error) {
+	txContext, _ := context.GetTxContext().(*transactions.TranContext)
+	if txContext == nil {
+		return
+	}
+
+	if txContext.TxExpired() {
+		return nil, errors.NewTransactionExpired()
+	}
+
+	if stmtAtomicity {
+		// statement level atomicity
+		dks = make(map[string]bool, 8)
+		if txm, _ := txContext.TxMutations().(*txMutations); txm != nil {
+			txm.startStatement(dks)
+		}
+		return
+	}
+
+	id, er := util.UUIDV3()
+	if er != nil {
+		return nil, errors.NewStartTransactionError(er, nil)
+	}
+	txContext.SetTxMutations(newTxMutations(txContext.TxImplicit()))
+	txContext.SetTxId(id, time.Now().Add(txContext.TxTimeout()))
+	return
 }
 
 func (s *store) CommitTransaction(stmtAtomicity bool, context datastore.QueryContext) errors.Error {
-	return errors.NewTranDatastoreNotSupportedError("file")
+	txContext, _ := context.GetTxContext().(*transactions.TranContext)
+	if txContext == nil {
+		return nil
+	}
+
+	if txContext.TxExpired() {
+		return errors.NewTransactionExpired()
+	}
+
+	txm, _ := txContext.TxMutations().(*txMutations)
+	if txm == nil {
+		return nil
+	}
+
+	if stmtAtomicity {
+		txm.commitStatement()
+		return nil
+	}
+
+	err := txm.commit(s, txContext.TxId())
+	txContext.SetTxMutations(nil)
+	return err
 }
 
 func (s *store) RollbackTransaction(stmtAtomicity bool, context datastore.QueryContext, sname string) errors.Error {
-	return errors.NewTranDatastoreNotSupportedError("file")
+	txContext, _ := context.GetTxContext().(*transactions.TranContext)
+	if txContext == nil {
+		return nil
+	}
+
+	if txContext.TxExpired() {
+		return errors.NewTransactionExpired()
+	}
+
+	txm, _ := txContext.TxMutations().(*txMutations)
+	if txm == nil {
+		return nil
+	}
+
+	if !txm.implicit && (stmtAtomicity || sname != "") {
+		// statement level atomicity or savepoint rollback
+		return txm.rollback(sname)
+	}
+
+	// nothing has been written yet: just drop the staged mutations
+	txContext.SetTxMutations(nil)
+	return nil
 }
 
 func (s *store) SetSavepoint(stmtAtomicity bool, context datastore.QueryContext, sname string) errors.Error {
-	return errors.NewTranDatastoreNotSupportedError("file")
+	if sname == "" {
+		return nil
+	}
+
+	txm, err := getTxMutations(context)
+	if txm != nil {
+		txm.setSavepoint(sname)
+	}
+	return err
 }
 
 func (s *store) TransactionDeltaKeyScan(keyspace string, conn *datastore.IndexConnection) {
 	defer conn.Sender().Close()
+
+	txm, err := getTxMutations(conn.QueryContext())
+	if err != nil {
+		conn.Fatal(err)
+		return
+	}
+	if txm == nil {
+		return
+	}
+
+	for k, deleted := range txm.deltaKeys(keyspace) {
+		entry := &datastore.IndexEntry{PrimaryKey: k}
+		if deleted {
+			entry.MetaData = value.NULL_VALUE
+		}
+		if !conn.Sender().SendEntry(entry) {
+			return
+		}
+	}
 }
 
 // NewStore creates a new file-based store for the given filepath.
@@ -208,6 +305,11 @@ func NewDatastore(path string) (s datastore.Datastore, e errors.Error) {
 		return
 	}
 
+	e = fs.replayJournals()
+	if e != nil {
+		return
+	}
+
 	// get the schema inferencer
 	var err errors.Error
 	fs.inferencer, err = GetDefaultInferencer(fs)
@@ -376,8 +478,50 @@ func (p *namespace) BucketByName(name string) (datastore.Bucket, errors.Error) {
 type keyspace struct {
 	namespace *namespace
 	name      string
-	fi        datastore.Indexer
-	fileLock  sync.Mutex
+	fi        *fileIndexer
+	locks     keyLocks
+	casLock   sync.Mutex
+	cas       uint64 // last CAS handed out
+}
+
+// keyLocks serializes the mutations of each document, so that DML on
+// different documents of a keyspace can proceed concurrently
+type keyLocks struct {
+	sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+func (kl *keyLocks) lock(key string) {
+	kl.Lock()
+	if kl.locks == nil {
+		kl.locks = make(map[string]*keyLock)
+	}
+	l, ok := kl.locks[key]
+	if !ok {
+		l = &keyLock{}
+		kl.locks[key] = l
+	}
+	l.refs++
+	kl.Unlock()
+
+	l.Lock()
+}
+
+func (kl *keyLocks) unlock(key string) {
+	kl.Lock()
+	l := kl.locks[key]
+	l.refs--
+	if l.refs == 0 {
+		delete(kl.locks, key)
+	}
+	kl.Unlock()
+
+	l.Unlock()
 }
 
 func (b *keyspace) NamespaceId() string {
@@ -425,7 +569,13 @@ func (b *keyspace) Count(context datastore.QueryContext) (int64, errors.Error) {
 	if er != nil {
 		return 0, errors.NewFileDatastoreError(er, "")
 	}
-	return int64(len(dirEntries)), nil
+	var count int64
+	for _, ent := range dirEntries {
+		if isDocument(ent) {
+			count++
+		}
+	}
+	return count, nil
 }
 
 func (b *keyspace) Size(context datastore.QueryContext) (int64, errors.Error) {
@@ -435,7 +585,9 @@ func (b *keyspace) Size(context datastore.QueryContext) (int64, errors.Error) {
 	}
 	var size int64
 	for _, ent := range dirEntries {
-		size += ent.Size()
+		if isDocument(ent) {
+			size += ent.Size()
+		}
 	}
 	return size, nil
 }
@@ -452,8 +604,20 @@ func (b *keyspace) Fetch(keys []string, keysMap map[string]value.AnnotatedValue,
 	context datastore.QueryContext, subPaths []string) []errors.Error {
 	var errs []errors.Error
 
+	txm, err := getTxMutations(context)
+	if err != nil {
+		return []errors.Error{err}
+	}
+	if txm != nil {
+		keys = txm.fetch(b, keys, keysMap)
+	}
+
 	for _, k := range keys {
+
+		// the document and its CAS are read together
+		b.locks.lock(k)
 		item, e := b.fetchOne(k)
+		b.locks.unlock(k)
 
 		if e != nil {
 			if os.IsNotExist(e.GetICause()) {
@@ -478,19 +642,44 @@ func (b *keyspace) Fetch(keys []string, keysMap map[string]value.AnnotatedValue,
 }
 
 func (b *keyspace) fetchOne(key string) (value.AnnotatedValue, errors.Error) {
-	path := filepath.Join(b.path(), key+".json")
-	item, e := fetch(path)
+	item, e := fetch(b.documentPath(key))
 	if e != nil {
-		item = nil
+		return nil, e
 	}
 
-	return item, e
+	cas, er := b.documentCas(key)
+	if er != nil {
+		return nil, errors.NewFileDatastoreError(er, "")
+	}
+	item.GetMeta()["cas"] = cas
+	return item, nil
+}
+
+func (b *keyspace) documentPath(key string) string {
+	return filepath.Join(b.path(), key+".json")
+}
+
+const _CAS_DIR = ".cas"
+
+// the CAS of each document is kept in a file of its own
+func (b *keyspace) casPath(key string) string {
+	return filepath.Join(b.path(), _CAS_DIR, key)
+}
+
+// the state of a document on disk, nil if it does not exist
+func (b *keyspace) stat(key string) (os.FileInfo, error) {
+	fi, err := os.Stat(b.documentPath(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return fi, err
 }
 
 const (
 	INSERT = 0x01
 	UPDATE = 0x02
 	UPSERT = 0x04
+	DELETE = 0x08
 )
 
 func opToString(op int) string {
@@ -502,6 +691,8 @@ func opToString(op int) string {
 		return "update"
 	case UPSERT:
 		return "upsert"
+	case DELETE:
+		return "delete"
 	}
 
 	return "unknown operation"
@@ -513,63 +704,80 @@ func (b *keyspace) performOp(op int, kvPairs []value.Pair, context datastore.Que
 		return nil, errors.NewFileNoKeysInsertError(nil, "keyspace "+b.Name())
 	}
 
+	txm, err := getTxMutations(context)
+	if err != nil {
+		return nil, err
+	}
+	if txm != nil {
+		return txm.stage(b, op, kvPairs)
+	}
+
 	insertedKeys := make([]value.Pair, 0)
 	var returnErr errors.Error
 
-	// this lock can be mode more granular FIXME
-	b.fileLock.Lock()
-	defer b.fileLock.Unlock()
-
 	for _, kv := range kvPairs {
-		var file *os.File
-		var err error
-
-		key := kv.Name
-		value, _ := json.Marshal(kv.Value.Actual())
-		filename := filepath.Join(b.path(), key+".json")
-
-		switch op {
-
-		case INSERT:
-			// add the key only if it doesn't exist
-			if _, err = os.Stat(filename); err == nil {
-				err = errors.NewFileKeyExists(nil, "Key (File) "+filename)
-			} else {
-				// create and write the file
-				if file, err = os.Create(filename); err == nil {
-					_, err = file.Write(value)
-					file.Close()
-				}
-			}
-		case UPDATE:
-			// add the key only if it doesn't exist
-			if _, err = os.Stat(filename); err == nil {
-				// open and write the file
-				if file, err = os.OpenFile(filename, os.O_TRUNC|os.O_RDWR, 0666); err == nil {
-					_, err = file.Write(value)
-					file.Close()
-				}
-			}
-
-		case UPSERT:
-			// open the file for writing, if doesn't exist then create
-			if file, err = os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0666); err == nil {
-				_, err = file.Write(value)
-				file.Close()
-			}
-		}
-
+		cas, err := b.writeOne(op, kv.Name, kv.Value)
 		if err != nil {
 			returnErr = errors.NewFileDMLError(returnErr, opToString(op)+" Failed "+err.Error())
 		} else {
+			setMetaCas(kv.Value, cas)
 			insertedKeys = append(insertedKeys, kv)
 		}
 	}
 
+	if err := b.fi.persistIndexes(); err != nil && returnErr == nil {
+		returnErr = err
+	}
+
 	return insertedKeys, returnErr
 
 }
 
+// write a single document, returning its new CAS
+func (b *keyspace) writeOne(op int, key string, val value.Value) (uint64, error) {
+	bytes, err := json.Marshal(val.Actual())
+	if err != nil {
+		return 0, err
+	}
+
+	b.locks.lock(key)
+	defer b.locks.unlock(key)
+
+	filename := b.documentPath(key)
+	fi, err := b.stat(key)
+	if err != nil {
+		return 0, err
+	}
+
+	switch op {
+	case INSERT:
+		// add the key only if it doesn't exist
+		if fi != nil {
+			return 0, errors.NewFileKeyExists(nil, "Key (File) "+filename)
+		}
+	case UPDATE:
+		// update the key only if it exists, and has not changed since it was read
+		if fi == nil {
+			return 0, errors.NewFileDatastoreError(nil, "Key (File) "+filename+" not found")
+		}
+		if cas, ok := getMetaCas(val); ok {
+			prev, err := b.documentCas(key)
+			if err != nil {
+				return 0, err
+			}
+			if cas != prev {
+				return 0, errors.NewFileCasMismatchError(nil, "Key "+key)
+			}
+		}
+	}
+
+	cas, err := b.writeDocument(key, bytes)
+	if err == nil {
+		b.fi.indexDocument(key, val)
+	}
+	return cas, err
+}
+
 func (b *keyspace) Insert(inserts []value.Pair, context datastore.QueryContext) ([]value.Pair, errors.Error) {
 	return b.performOp(INSERT, inserts, context)
 }
@@ -583,13 +791,25 @@ func (b *keyspace) Upsert(upserts []value.Pair, context datastore.QueryContext)
 }
 
 func (b *keyspace) Delete(deletes []value.Pair, context datastore.QueryContext) ([]value.Pair, errors.Error) {
+	txm, err := getTxMutations(context)
+	if err != nil {
+		return nil, err
+	}
+	if txm != nil {
+		return txm.stage(b, DELETE, deletes)
+	}
 
 	var fileError []string
 	var deleted []value.Pair
 	for _, pair := range deletes {
 		key := pair.Name
-		filename := filepath.Join(b.path(), key+".json")
-		if err := os.Remove(filename); err != nil {
+		b.locks.lock(key)
+		err := b.removeDocument(key)
+		if err == nil {
+			b.fi.indexDocument(key, nil)
+		}
+		b.locks.unlock(key)
+		if err != nil {
 			if !os.IsNotExist(err) {
 				fileError = append(fileError, err.Error())
 			}
@@ -598,12 +818,14 @@ func (b *keyspace) Delete(deletes []value.Pair, context datastore.QueryContext)
 		}
 	}
 
+	err = b.fi.persistIndexes()
+
 	if len(fileError) > 0 {
 		errLine := fmt.Sprintf("Delete failed on some keys %v", fileError)
 		return deleted, errors.NewFileDatastoreError(nil, errLine)
 	}
 
-	return deleted, nil
+	return deleted, err
 }
 
 func (b *keyspace) Release(close bool) {
@@ -644,19 +866,26 @@ func newKeyspace(p *namespace, dir string) (b *keyspace, e errors.Error) {
 		return nil, errors.NewFileKeyspaceNotDirError(nil, "Keyspace path "+dir)
 	}
 
+	// CAS values keep increasing across restarts, so that a document removed and written
+	// again never gets back a CAS it had before
+	b.cas = uint64(time.Now().UnixNano())
+
 	b.fi = newFileIndexer(b)
 	b.fi.CreatePrimaryIndex("", "#primary", nil)
+	e = b.fi.loadIndexes()
 
 	return
 }
 
 type fileIndexer struct {
+	sync.RWMutex
 	keyspace *keyspace
 	indexes  map[string]datastore.Index
 	primary  datastore.PrimaryIndex
+	version  uint64
 }
 
-func newFileIndexer(keyspace *keyspace) datastore.Indexer {
+func newFileIndexer(keyspace *keyspace) *fileIndexer {
 
 	return &fileIndexer{
 		keyspace: keyspace,
@@ -681,6 +910,9 @@ func (fi *fileIndexer) Name() datastore.IndexType {
 }
 
 func (fi *fileIndexer) IndexIds() ([]string, errors.Error) {
+	fi.RLock()
+	defer fi.RUnlock()
+
 	rv := make([]string, 0, len(fi.indexes))
 	for name, _ := range fi.indexes {
 		rv = append(rv, name)
@@ -689,6 +921,9 @@ func (fi *fileIndexer) IndexIds() ([]string, errors.Error) {
 }
 
 func (fi *fileIndexer) IndexNames() ([]string, errors.Error) {
+	fi.RLock()
+	defer fi.RUnlock()
+
 	rv := make([]string, 0, len(fi.indexes))
 	for name, _ := range fi.indexes {
 		rv = append(rv, name)
@@ -701,6 +936,9 @@ func (fi *fileIndexer) IndexById(id string) (datastore.Index, errors.Error) {
 }
 
 func (fi *fileIndexer) IndexByName(name string) (datastore.Index, errors.Error) {
+	fi.RLock()
+	defer fi.RUnlock()
+
 	index, ok := fi.indexes[name]
 	if !ok {
 		return nil, errors.NewFileIdxNotFound(nil, name)
@@ -713,11 +951,21 @@ func (fi *fileIndexer) PrimaryIndexes() ([]datastore.PrimaryIndex, errors.Error)
 }
 
 func (fi *fileIndexer) Indexes() ([]datastore.Index, errors.Error) {
-	return []datastore.Index{fi.primary}, nil
+	fi.RLock()
+	defer fi.RUnlock()
+
+	rv := make([]datastore.Index, 0, len(fi.indexes))
+	for _, index := range fi.indexes {
+		rv = append(rv, index)
+	}
+	return rv, nil
 }
 
 func (fi *fileIndexer) CreatePrimaryIndex(requestId, name string, with value.Value) (
 	datastore.PrimaryIndex, errors.Error) {
+	fi.Lock()
+	defer fi.Unlock()
+
 	if fi.primary == nil {
 		pi := new(primaryIndex)
 		fi.primary = pi
@@ -732,11 +980,32 @@ func (fi *fileIndexer) CreatePrimaryIndex(requestId, name string, with value.Val
 
 func (b *fileIndexer) CreateIndex(requestId, name string, seekKey, rangeKey expression.Expressions,
 	where expression.Expression, with value.Value) (datastore.Index, errors.Error) {
-	return nil, errors.NewFileNotSupported(nil, "CREATE INDEX is not supported for file-based datastore.")
+
+	// non-leading keys always include missing values
+	keys := make(datastore.IndexKeys, len(rangeKey))
+	for i, expr := range rangeKey {
+		attrs := datastore.IK_NONE
+		if i > 0 {
+			attrs = datastore.IK_MISSING
+		}
+		keys[i] = &datastore.IndexKey{Expr: expr, Attributes: attrs}
+	}
+	return b.createIndex(name, seekKey, keys, where, with)
 }
 
+func (b *fileIndexer) CreateIndex2(requestId, name string, seekKey expression.Expressions,
+	rangeKey datastore.IndexKeys, where expression.Expression, with value.Value) (datastore.Index, errors.Error) {
+	return b.createIndex(name, seekKey, rangeKey, where, with)
+}
+
+// indexes are built on creation: there is nothing to do for existing indexes
 func (b *fileIndexer) BuildIndexes(requestId string, names ...string) errors.Error {
-	return errors.NewFileNotSupported(nil, "BUILD INDEXES is not supported for file-based datastore.")
+	for _, name := range names {
+		if _, err := b.IndexByName(name); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (b *fileIndexer) Refresh() errors.Error {
@@ -744,7 +1013,9 @@ func (b *fileIndexer) Refresh() errors.Error {
 }
 
 func (b *fileIndexer) MetadataVersion() uint64 {
-	return 0
+	b.RLock()
+	defer b.RUnlock()
+	return b.version
 }
 
 func (b *fileIndexer) SetLogLevel(level logging.Level) {
@@ -866,6 +1137,10 @@ func (pi *primaryIndex) Scan(requestId string, span *datastore.Span, distinct bo
 			break
 		}
 
+		if !isDocument(dirEntry) {
+			continue
+		}
+
 		id := documentPathToId(dirEntry.Name())
 
 		if low != "" &&
@@ -882,11 +1157,9 @@ func (pi *primaryIndex) Scan(requestId string, span *datastore.Span, distinct bo
 			break
 		}
 
-		if !dirEntry.IsDir() {
-			entry := datastore.IndexEntry{PrimaryKey: id}
-			conn.Sender().SendEntry(&entry)
-			n++
-		}
+		entry := datastore.IndexEntry{PrimaryKey: id}
+		conn.Sender().SendEntry(&entry)
+		n++
 	}
 }
 
@@ -904,7 +1177,7 @@ func (pi *primaryIndex) ScanEntries(requestId string, limit int64, cons datastor
 		if limit > 0 && int64(i) > limit {
 			break
 		}
-		if !dirEntry.IsDir() {
+		if isDocument(dirEntry) {
 			entry := datastore.IndexEntry{PrimaryKey: documentPathToId(dirEntry.Name())}
 			conn.Sender().SendEntry(&entry)
 		}
@@ -924,6 +1197,84 @@ func fetch(path string) (item value.AnnotatedValue, e errors.Error) {
 	return
 }
 
+// the CAS of an existing document, 1 for documents that were not written by the store.
+// The caller holds the document lock
+func (b *keyspace) documentCas(key string) (uint64, error) {
+	bytes, er := ioutil.ReadFile(b.casPath(key))
+	if os.IsNotExist(er) {
+		return 1, nil
+	} else if er != nil {
+		return 0, er
+	}
+	return strconv.ParseUint(string(bytes), 10, 64)
+}
+
+// a CAS larger than any handed out, and than the current one of the document
+func (b *keyspace) nextCas(prev uint64) uint64 {
+	b.casLock.Lock()
+	defer b.casLock.Unlock()
+
+	b.cas++
+	if b.cas <= prev {
+		b.cas = prev + 1
+	}
+	return b.cas
+}
+
+// atomically replace a document, returning its new CAS.
+// The CAS changes first: should the document not follow, it is still safe to
+// reject updates based on the old CAS. The caller holds the document lock
+func (b *keyspace) writeDocument(key string, bytes []byte) (uint64, error) {
+	prev, err := b.documentCas(key)
+	if err != nil {
+		return 0, err
+	}
+	cas := b.nextCas(prev)
+	err = writeFileAtomic(b.casPath(key), []byte(strconv.FormatUint(cas, 10)))
+	if err == nil {
+		err = writeFileAtomic(b.documentPath(key), bytes)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return cas, nil
+}
+
+// remove a document and its CAS. The caller holds the document lock
+func (b *keyspace) removeDocument(key string) error {
+	err := os.Remove(b.documentPath(key))
+	if err == nil {
+		os.Remove(b.casPath(key))
+	}
+	return err
+}
+
+func getMetaCas(val value.Value) (uint64, bool) {
+	av, ok := val.(value.AnnotatedValue)
+	if !ok || av == nil {
+		return 0, false
+	}
+	switch cas := av.GetMeta()["cas"].(type) {
+	case uint64:
+		return cas, true
+	case int64:
+		return uint64(cas), true
+	case float64:
+		return uint64(cas), true
+	}
+	return 0, false
+}
+
+func setMetaCas(val value.Value, cas uint64) {
+	if av, ok := val.(value.AnnotatedValue); ok && av != nil {
+		meta := av.GetMeta()
+		if meta == nil {
+			meta = av.NewMeta()
+		}
+		meta["cas"] = cas
+	}
+}
+
 func documentPathToId(p string) string {
 	_, file := filepath.Split(p)
 	ext := filepath.Ext(file)
@@ -0,0 +1,1138 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package file
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/expression/parser"
+	"github.com/couchbase/query/logging"
+	"github.com/couchbase/query/timestamp"
+	"github.com/couchbase/query/value"
+)
+
+// Secondary indexes are kept in memory as a sorted list of entries, and
+// persisted, definition and entries, in a file per index in the keyspace
+// index directory.
+// Changes are appended to a log next to the index file, which is only
+// rewritten once the log outgrows it.
+// On load, the entries are rebuilt from the documents if any document is more
+// recent than the index file and its log.
+
+const _INDEX_DIR = ".indexes"
+const _INDEX_EXT = ".index"
+const _INDEX_LOG_EXT = ".log"
+
+// the log is folded into the index file past this many changes, or the number of entries
+const _INDEX_LOG_MIN = 1024
+
+type indexEntry struct {
+	keys value.Values
+	pk   string
+}
+
+// secondaryIndex is a sorted, persisted secondary index.
+type secondaryIndex struct {
+	sync.RWMutex
+	name     string
+	keyspace *keyspace
+	indexer  *fileIndexer
+	seekKey  expression.Expressions
+	rangeKey datastore.IndexKeys
+	where    expression.Expression
+	with     value.Value
+	alias    string                   // keyspace alias used by the index expressions
+	arrayKey int                      // position of the array key, if any, or -1
+	entries  []*indexEntry            // sorted entries, replaced, never modified, on mutations
+	docs     map[string][]*indexEntry // entries by document key
+	changed  map[string]bool          // documents whose entries are not persisted
+	full     bool                     // all entries need persisting, not just the changed ones
+	seq      uint64                   // last change persisted
+	logged   int                      // changes in the log
+	persists sync.Mutex               // serializes writes of the index file and log
+}
+
+func newSecondaryIndex(indexer *fileIndexer, name string, seekKey expression.Expressions,
+	rangeKey datastore.IndexKeys, where expression.Expression, with value.Value) (*secondaryIndex, errors.Error) {
+
+	si := &secondaryIndex{
+		name:     name,
+		keyspace: indexer.keyspace,
+		indexer:  indexer,
+		seekKey:  seekKey,
+		rangeKey: rangeKey,
+		where:    where,
+		with:     with,
+		arrayKey: -1,
+		docs:     make(map[string][]*indexEntry),
+		changed:  make(map[string]bool),
+	}
+
+	for i, key := range rangeKey {
+		if isArray, _ := key.Expr.IsArrayIndexKey(); isArray {
+			if si.arrayKey >= 0 {
+				return nil, errors.NewFileNotSupported(nil, "Multiple array keys in index "+name)
+			}
+			si.arrayKey = i
+		}
+	}
+
+	si.alias = si.keyspace.name
+	exprs := make(expression.Expressions, 0, len(rangeKey)+1)
+	for _, key := range rangeKey {
+		exprs = append(exprs, key.Expr)
+	}
+	if where != nil {
+		exprs = append(exprs, where)
+	}
+	if alias := keyspaceAlias(si.keyspace.name, exprs); alias != "" {
+		si.alias = alias
+	}
+	return si, nil
+}
+
+// keyspace names are not case sensitive: find which spelling the index uses
+func keyspaceAlias(name string, exprs expression.Expressions) string {
+	for _, expr := range exprs {
+		if ident, ok := expr.(*expression.Identifier); ok && strings.EqualFold(ident.Identifier(), name) {
+			return ident.Identifier()
+		}
+		if alias := keyspaceAlias(name, expr.Children()); alias != "" {
+			return alias
+		}
+	}
+	return ""
+}
+
+func (si *secondaryIndex) BucketId() string {
+	return ""
+}
+
+func (si *secondaryIndex) ScopeId() string {
+	return ""
+}
+
+func (si *secondaryIndex) KeyspaceId() string {
+	return si.keyspace.Id()
+}
+
+func (si *secondaryIndex) Id() string {
+	return si.Name()
+}
+
+func (si *secondaryIndex) Name() string {
+	return si.name
+}
+
+func (si *secondaryIndex) Type() datastore.IndexType {
+	return datastore.DEFAULT
+}
+
+func (si *secondaryIndex) Indexer() datastore.Indexer {
+	return si.indexer
+}
+
+func (si *secondaryIndex) SeekKey() expression.Expressions {
+	return si.seekKey
+}
+
+func (si *secondaryIndex) RangeKey() expression.Expressions {
+	rv := make(expression.Expressions, len(si.rangeKey))
+	for i, key := range si.rangeKey {
+		rv[i] = key.Expr
+	}
+	return rv
+}
+
+func (si *secondaryIndex) RangeKey2() datastore.IndexKeys {
+	return si.rangeKey
+}
+
+func (si *secondaryIndex) Condition() expression.Expression {
+	return si.where
+}
+
+func (si *secondaryIndex) IsPrimary() bool {
+	return false
+}
+
+func (si *secondaryIndex) State() (state datastore.IndexState, msg string, err errors.Error) {
+	return datastore.ONLINE, "", nil
+}
+
+func (si *secondaryIndex) Statistics(requestId string, span *datastore.Span) (
+	datastore.Statistics, errors.Error) {
+	return nil, nil
+}
+
+func (si *secondaryIndex) Drop(requestId string) errors.Error {
+	return si.indexer.dropIndex(si)
+}
+
+func (si *secondaryIndex) snapshot() []*indexEntry {
+	si.RLock()
+	rv := si.entries
+	si.RUnlock()
+	return rv
+}
+
+// Scan implements the original index API: spans are composite bounds on the leading keys
+func (si *secondaryIndex) Scan(requestId string, span *datastore.Span, distinct bool, limit int64,
+	cons datastore.ScanConsistency, vector timestamp.Vector, conn *datastore.IndexConnection) {
+	defer conn.Sender().Close()
+
+	var n int64
+	for _, e := range si.snapshot() {
+		if limit > 0 && n >= limit {
+			break
+		}
+		if !e.matchesSpan(span) {
+			continue
+		}
+		if !conn.Sender().SendEntry(&datastore.IndexEntry{EntryKey: e.keys, PrimaryKey: e.pk}) {
+			return
+		}
+		n++
+	}
+}
+
+// Scan2 implements the spock index API, with per key ranges and projections
+func (si *secondaryIndex) Scan2(requestId string, spans datastore.Spans2, reverse, distinctAfterProjection,
+	ordered bool, projection *datastore.IndexProjection, offset, limit int64,
+	cons datastore.ScanConsistency, vector timestamp.Vector, conn *datastore.IndexConnection) {
+	defer conn.Sender().Close()
+
+	var seen map[string]bool
+	if distinctAfterProjection {
+		seen = make(map[string]bool)
+	}
+
+	entries := si.snapshot()
+	l := len(entries)
+	var n int64
+	for i := 0; i < l; i++ {
+		if limit > 0 && n >= limit {
+			break
+		}
+		e := entries[i]
+		if reverse {
+			e = entries[l-1-i]
+		}
+		if !e.matchesSpans2(spans) {
+			continue
+		}
+
+		entry := e.project(projection)
+		if seen != nil {
+			key := distinctKey(entry, projection)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if !conn.Sender().SendEntry(entry) {
+			return
+		}
+		n++
+	}
+}
+
+func (si *secondaryIndex) Count(span *datastore.Span, cons datastore.ScanConsistency,
+	vector timestamp.Vector) (int64, errors.Error) {
+	return si.countDocuments(func(e *indexEntry) bool { return e.matchesSpan(span) }), nil
+}
+
+func (si *secondaryIndex) Count2(requestId string, spans datastore.Spans2, cons datastore.ScanConsistency,
+	vector timestamp.Vector) (int64, errors.Error) {
+	return si.countDocuments(func(e *indexEntry) bool { return e.matchesSpans2(spans) }), nil
+}
+
+// array keys generate several entries per document, which are counted once
+func (si *secondaryIndex) countDocuments(matches func(e *indexEntry) bool) int64 {
+	var n int64
+	var seen map[string]bool
+
+	if si.arrayKey >= 0 {
+		seen = make(map[string]bool)
+	}
+	for _, e := range si.snapshot() {
+		if !matches(e) {
+			continue
+		}
+		if seen != nil {
+			if seen[e.pk] {
+				continue
+			}
+			seen[e.pk] = true
+		}
+		n++
+	}
+	return n
+}
+
+func (si *secondaryIndex) CanCountDistinct() bool {
+	return true
+}
+
+// count the distinct values of the leading key
+func (si *secondaryIndex) CountDistinct(requestId string, spans datastore.Spans2, cons datastore.ScanConsistency,
+	vector timestamp.Vector) (int64, errors.Error) {
+	var n int64
+	var last value.Value
+
+	// entries are sorted, so equal leading keys are adjacent
+	for _, e := range si.snapshot() {
+		if !e.matchesSpans2(spans) {
+			continue
+		}
+		k := e.keys[0]
+		if k.Type() <= value.NULL {
+			continue
+		}
+		if last == nil || last.Collate(k) != 0 {
+			n++
+			last = k
+		}
+	}
+	return n, nil
+}
+
+func (e *indexEntry) matchesSpan(span *datastore.Span) bool {
+	if span == nil {
+		return true
+	}
+	if len(span.Seek) > 0 {
+		return compareKeys(e.keys, span.Seek) == 0
+	}
+	if len(span.Range.Low) > 0 {
+		c := compareKeys(e.keys, span.Range.Low)
+		if c < 0 || (c == 0 && span.Range.Inclusion&datastore.LOW == 0) {
+			return false
+		}
+	}
+	if len(span.Range.High) > 0 {
+		c := compareKeys(e.keys, span.Range.High)
+		if c > 0 || (c == 0 && span.Range.Inclusion&datastore.HIGH == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// compare the leading keys of an entry to composite bounds
+func compareKeys(keys, bounds value.Values) int {
+	for i, b := range bounds {
+		if i >= len(keys) {
+			return -1
+		}
+		if c := keys[i].Collate(b); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func (e *indexEntry) matchesSpans2(spans datastore.Spans2) bool {
+	if len(spans) == 0 {
+		return true
+	}
+	for _, span := range spans {
+		if e.matchesSpan2(span) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *indexEntry) matchesSpan2(span *datastore.Span2) bool {
+	if len(span.Seek) > 0 && compareKeys(e.keys, span.Seek) != 0 {
+		return false
+	}
+	for i, r := range span.Ranges {
+		if i >= len(e.keys) {
+			break
+		}
+		k := e.keys[i]
+		if r.Low != nil {
+			c := k.Collate(r.Low)
+			if c < 0 || (c == 0 && r.Inclusion&datastore.LOW == 0) {
+				return false
+			}
+		}
+		if r.High != nil {
+			c := k.Collate(r.High)
+			if c > 0 || (c == 0 && r.Inclusion&datastore.HIGH == 0) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (e *indexEntry) project(projection *datastore.IndexProjection) *datastore.IndexEntry {
+	if projection == nil {
+		return &datastore.IndexEntry{EntryKey: e.keys, PrimaryKey: e.pk}
+	}
+	rv := &datastore.IndexEntry{EntryKey: make(value.Values, 0, len(projection.EntryKeys)), PrimaryKey: e.pk}
+	for _, k := range projection.EntryKeys {
+		if k >= 0 && k < len(e.keys) {
+			rv.EntryKey = append(rv.EntryKey, e.keys[k])
+		}
+	}
+	return rv
+}
+
+func distinctKey(entry *datastore.IndexEntry, projection *datastore.IndexProjection) string {
+	var b strings.Builder
+
+	for _, k := range entry.EntryKey {
+		b.WriteString(k.String())
+		b.WriteByte(0)
+	}
+	if projection == nil || projection.PrimaryKey {
+		b.WriteString(entry.PrimaryKey)
+	}
+	return b.String()
+}
+
+func compareEntries(a, b *indexEntry, keys datastore.IndexKeys) int {
+	for i, k := range a.keys {
+		c := k.Collate(b.keys[i])
+		if c != 0 {
+			if keys[i].HasAttribute(datastore.IK_DESC) {
+				return -c
+			}
+			return c
+		}
+	}
+	return strings.Compare(a.pk, b.pk)
+}
+
+// evaluate the index keys for a document
+// array keys generate one entry per element
+func (si *secondaryIndex) documentEntries(key string, doc value.Value) ([]*indexEntry, error) {
+	context := expression.NewIndexContext()
+	av := value.NewAnnotatedValue(value.NewValue(doc.Actual()))
+	av.SetId(key)
+	item := value.NewValue(map[string]interface{}{si.alias: av})
+
+	if si.where != nil {
+		cond, err := si.where.Evaluate(item, context)
+		if err != nil {
+			return nil, err
+		}
+		if !cond.Truth() {
+			return nil, nil
+		}
+	}
+
+	keys := make(value.Values, len(si.rangeKey))
+	var elems value.Values
+	for i, k := range si.rangeKey {
+		if i == si.arrayKey {
+			val, vals, err := k.Expr.EvaluateForIndex(item, context)
+			if err != nil {
+				return nil, err
+			}
+			if vals == nil {
+				vals = value.Values{val}
+			}
+			_, distinct := k.Expr.IsArrayIndexKey()
+			elems = arrayKeyValues(vals, distinct)
+			continue
+		}
+		val, err := k.Expr.Evaluate(item, context)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = val
+	}
+
+	if si.arrayKey < 0 {
+		return si.validEntries([]*indexEntry{&indexEntry{keys: keys, pk: key}}), nil
+	}
+
+	// empty arrays are missing keys
+	if len(elems) == 0 {
+		elems = value.Values{value.MISSING_VALUE}
+	}
+	rv := make([]*indexEntry, len(elems))
+	for i, e := range elems {
+		entryKeys := make(value.Values, len(keys))
+		copy(entryKeys, keys)
+		entryKeys[si.arrayKey] = e
+		rv[i] = &indexEntry{keys: entryKeys, pk: key}
+	}
+	return si.validEntries(rv), nil
+}
+
+func arrayKeyValues(vals value.Values, distinct bool) value.Values {
+	if !distinct {
+		return vals
+	}
+	rv := make(value.Values, 0, len(vals))
+	for _, v := range vals {
+		found := false
+		for _, r := range rv {
+			if v.Collate(r) == 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			rv = append(rv, v)
+		}
+	}
+	return rv
+}
+
+// documents with a missing leading key are not indexed, unless the key includes missing
+func (si *secondaryIndex) validEntries(entries []*indexEntry) []*indexEntry {
+	if len(si.rangeKey) == 0 || si.rangeKey[0].HasAttribute(datastore.IK_MISSING) {
+		return entries
+	}
+	rv := entries[:0]
+	for _, e := range entries {
+		if e.keys[0].Type() != value.MISSING {
+			rv = append(rv, e)
+		}
+	}
+	if len(rv) == 0 {
+		return nil
+	}
+	return rv
+}
+
+// replace the entries for a document, nil documents are removed
+func (si *secondaryIndex) update(key string, doc value.Value) {
+	var newEntries []*indexEntry
+
+	if doc != nil {
+		var err error
+
+		newEntries, err = si.documentEntries(key, doc)
+		if err != nil {
+			logging.Infof("File index %v: cannot index document %v: %v", si.name, key, err)
+			newEntries = nil
+		}
+	}
+
+	si.Lock()
+	defer si.Unlock()
+
+	oldEntries := si.docs[key]
+	if len(oldEntries) == 0 && len(newEntries) == 0 {
+		return
+	}
+
+	// the old entries are found by key, and what lies between them copied as is
+	removed := make([]int, 0, len(oldEntries))
+	for _, e := range oldEntries {
+		removed = append(removed, si.position(e))
+	}
+	sort.Ints(removed)
+
+	entries := make([]*indexEntry, 0, len(si.entries)-len(oldEntries)+len(newEntries))
+	start := 0
+	for _, i := range removed {
+		entries = append(entries, si.entries[start:i]...)
+		start = i + 1
+	}
+	entries = append(entries, si.entries[start:]...)
+
+	for _, e := range newEntries {
+		i := sort.Search(len(entries), func(i int) bool {
+			return compareEntries(entries[i], e, si.rangeKey) >= 0
+		})
+		entries = append(entries, nil)
+		copy(entries[i+1:], entries[i:])
+		entries[i] = e
+	}
+	si.entries = entries
+	if len(newEntries) > 0 {
+		si.docs[key] = newEntries
+	} else {
+		delete(si.docs, key)
+	}
+	si.changed[key] = true
+}
+
+// the position of an entry, which must be in the index.
+// Entries of the same document may compare equal, so the search ends on the entry itself
+func (si *secondaryIndex) position(e *indexEntry) int {
+	i := sort.Search(len(si.entries), func(i int) bool {
+		return compareEntries(si.entries[i], e, si.rangeKey) >= 0
+	})
+	for i < len(si.entries)-1 && si.entries[i] != e {
+		i++
+	}
+	return i
+}
+
+// index all the documents in the keyspace
+func (si *secondaryIndex) build() errors.Error {
+	dirEntries, er := ioutil.ReadDir(si.keyspace.path())
+	if er != nil {
+		return errors.NewFileDatastoreError(er, "")
+	}
+
+	docs := make(map[string][]*indexEntry, len(dirEntries))
+	entries := make([]*indexEntry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if !isDocument(dirEntry) {
+			continue
+		}
+		key := documentPathToId(dirEntry.Name())
+		doc, err := si.keyspace.fetchOne(key)
+		if err != nil {
+			if os.IsNotExist(err.GetICause()) {
+				continue
+			}
+			return err
+		}
+		docEntries, er := si.documentEntries(key, doc)
+		if er != nil {
+			logging.Infof("File index %v: cannot index document %v: %v", si.name, key, er)
+			continue
+		}
+		if len(docEntries) > 0 {
+			docs[key] = docEntries
+			entries = append(entries, docEntries...)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return compareEntries(entries[i], entries[j], si.rangeKey) < 0
+	})
+
+	si.Lock()
+	si.entries = entries
+	si.docs = docs
+	si.changed = make(map[string]bool)
+	si.full = true
+	si.Unlock()
+	return nil
+}
+
+// persisted index format
+type indexFile struct {
+	Name    string           `json:"name"`
+	Keys    []indexFileKey   `json:"keys"`
+	Where   string           `json:"where,omitempty"`
+	With    interface{}      `json:"with,omitempty"`
+	Seq     uint64           `json:"seq,omitempty"`
+	Entries []indexFileEntry `json:"entries"`
+}
+
+type indexFileKey struct {
+	Expr     string `json:"expr"`
+	Array    bool   `json:"array,omitempty"`
+	Distinct bool   `json:"distinct,omitempty"`
+	Desc     bool   `json:"desc,omitempty"`
+	Missing  bool   `json:"missing,omitempty"`
+}
+
+// MISSING cannot be represented in JSON: missing keys are listed by position
+type indexFileEntry struct {
+	PK      string        `json:"pk"`
+	Keys    []interface{} `json:"keys"`
+	Missing []int         `json:"missing,omitempty"`
+}
+
+// a line of the log: the entries of a document after a change, none if it was removed.
+// Changes up to the sequence number of the index file are already in it
+type indexLogRecord struct {
+	Seq     uint64           `json:"seq"`
+	PK      string           `json:"pk"`
+	Entries []indexFileEntry `json:"entries,omitempty"`
+}
+
+func (e *indexEntry) encode() indexFileEntry {
+	rv := indexFileEntry{PK: e.pk, Keys: make([]interface{}, len(e.keys))}
+	for j, k := range e.keys {
+		if k.Type() == value.MISSING {
+			rv.Missing = append(rv.Missing, j)
+		} else {
+			rv.Keys[j] = k
+		}
+	}
+	return rv
+}
+
+func (fe *indexFileEntry) decode() *indexEntry {
+	keys := make(value.Values, len(fe.Keys))
+	for j, k := range fe.Keys {
+		keys[j] = value.NewValue(k)
+	}
+	for _, m := range fe.Missing {
+		if m >= 0 && m < len(keys) {
+			keys[m] = value.MISSING_VALUE
+		}
+	}
+	return &indexEntry{keys: keys, pk: fe.PK}
+}
+
+func (si *secondaryIndex) path() string {
+	return filepath.Join(si.keyspace.path(), _INDEX_DIR, si.name+_INDEX_EXT)
+}
+
+func (si *secondaryIndex) logPath() string {
+	return si.path() + _INDEX_LOG_EXT
+}
+
+// append the changed documents to the log, or write the whole index once the log is too long
+func (si *secondaryIndex) persist() errors.Error {
+	si.persists.Lock()
+	defer si.persists.Unlock()
+
+	si.Lock()
+	if !si.full && len(si.changed) == 0 {
+		si.Unlock()
+		return nil
+	}
+	entries := si.entries
+	full := si.full || si.logged+len(si.changed) > _INDEX_LOG_MIN && si.logged+len(si.changed) > len(entries)
+	var records []indexLogRecord
+	if !full {
+		records = make([]indexLogRecord, 0, len(si.changed))
+		for key := range si.changed {
+			si.seq++
+			record := indexLogRecord{Seq: si.seq, PK: key}
+			for _, e := range si.docs[key] {
+				record.Entries = append(record.Entries, e.encode())
+			}
+			records = append(records, record)
+		}
+	}
+	si.changed = make(map[string]bool)
+	si.full = false
+	seq := si.seq
+	si.Unlock()
+
+	var er error
+	if full {
+		er = si.write(entries, seq)
+	} else {
+		er = si.appendLog(records)
+	}
+	if er != nil {
+		si.Lock()
+		si.full = true
+		si.Unlock()
+		return errors.NewFileDatastoreError(er, "index "+si.name)
+	}
+	return nil
+}
+
+// append changes to the log. The caller holds the persists lock
+func (si *secondaryIndex) appendLog(records []indexLogRecord) error {
+	var buf []byte
+
+	for i := range records {
+		bytes, er := json.Marshal(&records[i])
+		if er != nil {
+			return er
+		}
+		buf = append(buf, bytes...)
+		buf = append(buf, '\n')
+	}
+
+	created := si.logged == 0
+	f, er := os.OpenFile(si.logPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if er != nil {
+		return er
+	}
+	_, er = f.Write(buf)
+	if er == nil {
+		er = f.Sync()
+	}
+	if cer := f.Close(); er == nil {
+		er = cer
+	}
+	if er == nil && created {
+		er = syncDir(filepath.Dir(si.logPath()))
+	}
+	if er == nil {
+		si.logged += len(records)
+	}
+	return er
+}
+
+// write the index to a temporary file and rename it, so that a crash never leaves a partial index,
+// then drop the log. The caller holds the persists lock
+func (si *secondaryIndex) write(entries []*indexEntry, seq uint64) error {
+	f := indexFile{
+		Name:    si.name,
+		Keys:    make([]indexFileKey, len(si.rangeKey)),
+		Seq:     seq,
+		Entries: make([]indexFileEntry, len(entries)),
+	}
+	for i, k := range si.rangeKey {
+		expr := k.Expr
+		all, isArray := expr.(*expression.All)
+		if isArray {
+			expr = all.Array()
+			f.Keys[i].Array = true
+			f.Keys[i].Distinct = all.Distinct()
+		}
+		f.Keys[i].Expr = expr.String()
+		f.Keys[i].Desc = k.HasAttribute(datastore.IK_DESC)
+		f.Keys[i].Missing = k.HasAttribute(datastore.IK_MISSING)
+	}
+	if si.where != nil {
+		f.Where = si.where.String()
+	}
+	if si.with != nil {
+		f.With = si.with.Actual()
+	}
+	for i, e := range entries {
+		f.Entries[i] = e.encode()
+	}
+
+	bytes, er := json.Marshal(&f)
+	if er != nil {
+		return er
+	}
+	er = writeFileAtomic(si.path(), bytes)
+	if er != nil {
+		return er
+	}
+
+	// changes in the log are in the index file, even if removing it fails
+	si.logged = 0
+	er = os.Remove(si.logPath())
+	if os.IsNotExist(er) {
+		er = nil
+	}
+	return er
+}
+
+func syncDir(dir string) error {
+	d, er := os.Open(dir)
+	if er != nil {
+		return er
+	}
+	er = d.Sync()
+	if cer := d.Close(); er == nil {
+		er = cer
+	}
+	return er
+}
+
+func writeFileAtomic(path string, bytes []byte) error {
+	dir, file := filepath.Split(path)
+	er := os.MkdirAll(dir, 0755)
+	if er != nil {
+		return er
+	}
+	tmp, er := ioutil.TempFile(dir, "."+file+".")
+	if er != nil {
+		return er
+	}
+	er = tmp.Chmod(0644)
+	if er == nil {
+		_, er = tmp.Write(bytes)
+	}
+	if er == nil {
+		er = tmp.Sync()
+	}
+	if cer := tmp.Close(); er == nil {
+		er = cer
+	}
+	if er == nil {
+		er = os.Rename(tmp.Name(), path)
+	}
+	if er != nil {
+		os.Remove(tmp.Name())
+		return er
+	}
+
+	// the rename is only durable once the directory is
+	return syncDir(dir)
+}
+
+// load a persisted index, rebuilding its entries if documents have changed since
+func loadSecondaryIndex(indexer *fileIndexer, path string) (*secondaryIndex, errors.Error) {
+	bytes, er := ioutil.ReadFile(path)
+	if er != nil {
+		return nil, errors.NewFileDatastoreError(er, "")
+	}
+	var f indexFile
+	er = json.Unmarshal(bytes, &f)
+	if er != nil {
+		return nil, errors.NewFileDatastoreError(er, "index "+path)
+	}
+
+	rangeKey := make(datastore.IndexKeys, len(f.Keys))
+	for i, k := range f.Keys {
+		expr, er := parser.Parse(k.Expr)
+		if er != nil {
+			return nil, errors.NewFileDatastoreError(er, "index "+f.Name)
+		}
+		if k.Array {
+			expr = expression.NewAll(expr, k.Distinct)
+		}
+		attrs := datastore.IK_NONE
+		if k.Desc {
+			attrs |= datastore.IK_DESC
+		}
+		if k.Missing {
+			attrs |= datastore.IK_MISSING
+		}
+		rangeKey[i] = &datastore.IndexKey{Expr: expr, Attributes: attrs}
+	}
+	var where expression.Expression
+	if f.Where != "" {
+		where, er = parser.Parse(f.Where)
+		if er != nil {
+			return nil, errors.NewFileDatastoreError(er, "index "+f.Name)
+		}
+	}
+	var with value.Value
+	if f.With != nil {
+		with = value.NewValue(f.With)
+	}
+
+	si, err := newSecondaryIndex(indexer, f.Name, nil, rangeKey, where, with)
+	if err != nil {
+		return nil, err
+	}
+
+	if indexStale(si.keyspace.path(), path, si.logPath()) {
+		logging.Infof("File index %v is out of date: rebuilding", f.Name)
+		return si, si.build()
+	}
+
+	for i := range f.Entries {
+		fe := &f.Entries[i]
+		if len(fe.Keys) != len(rangeKey) {
+			logging.Infof("File index %v is corrupted: rebuilding", f.Name)
+			return si, si.build()
+		}
+		si.docs[fe.PK] = append(si.docs[fe.PK], fe.decode())
+	}
+	si.seq = f.Seq
+
+	// replay the changes logged since the index file was written
+	logged, er := si.replayLog(len(rangeKey))
+	if er != nil {
+		logging.Infof("File index %v log is corrupted: rebuilding", f.Name)
+		return si, si.build()
+	}
+
+	entries := make([]*indexEntry, 0, len(f.Entries))
+	for _, docEntries := range si.docs {
+		entries = append(entries, docEntries...)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return compareEntries(entries[i], entries[j], si.rangeKey) < 0
+	})
+	si.entries = entries
+
+	// fold the log into the index file
+	si.full = logged > 0
+	return si, nil
+}
+
+func (si *secondaryIndex) replayLog(nKeys int) (int, error) {
+	bytes, er := ioutil.ReadFile(si.logPath())
+	if os.IsNotExist(er) {
+		return 0, nil
+	} else if er != nil {
+		return 0, er
+	}
+
+	n := 0
+	for _, line := range strings.Split(string(bytes), "\n") {
+		var record indexLogRecord
+
+		if line == "" {
+			continue
+		}
+		er = json.Unmarshal([]byte(line), &record)
+		if er != nil {
+			return n, er
+		}
+		if record.Seq <= si.seq {
+			continue
+		}
+		docEntries := make([]*indexEntry, 0, len(record.Entries))
+		for i := range record.Entries {
+			if len(record.Entries[i].Keys) != nKeys {
+				return n, errors.NewFileDatastoreError(nil, "index log entry "+record.PK)
+			}
+			docEntries = append(docEntries, record.Entries[i].decode())
+		}
+		if len(docEntries) > 0 {
+			si.docs[record.PK] = docEntries
+		} else {
+			delete(si.docs, record.PK)
+		}
+		si.seq = record.Seq
+		n++
+	}
+	return n, nil
+}
+
+// an index is stale if documents have been added, removed or modified after it, or its log, was written
+func indexStale(dir, path, logPath string) bool {
+	fi, er := os.Stat(path)
+	if er != nil {
+		return true
+	}
+	modTime := fi.ModTime()
+	if li, er := os.Stat(logPath); er == nil && li.ModTime().After(modTime) {
+		modTime = li.ModTime()
+	}
+	di, er := os.Stat(dir)
+	if er != nil || di.ModTime().After(modTime) {
+		return true
+	}
+	dirEntries, er := ioutil.ReadDir(dir)
+	if er != nil {
+		return true
+	}
+	for _, dirEntry := range dirEntries {
+		if isDocument(dirEntry) && dirEntry.ModTime().After(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// documents are regular files. Temporary files have a leading dot and a random suffix,
+// which sets them apart from documents with a key that starts with a dot
+func isDocument(fi os.FileInfo) bool {
+	return !fi.IsDir() && (!strings.HasPrefix(fi.Name(), ".") || filepath.Ext(fi.Name()) == ".json")
+}
+
+func (fi *fileIndexer) loadIndexes() errors.Error {
+	dir := filepath.Join(fi.keyspace.path(), _INDEX_DIR)
+	dirEntries, er := ioutil.ReadDir(dir)
+	if er != nil {
+		if os.IsNotExist(er) {
+			return nil
+		}
+		return errors.NewFileDatastoreError(er, "")
+	}
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || filepath.Ext(dirEntry.Name()) != _INDEX_EXT || strings.HasPrefix(dirEntry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, dirEntry.Name())
+		si, err := loadSecondaryIndex(fi, path)
+		if err == nil {
+			err = si.persist()
+		}
+		if err != nil {
+			logging.Errorf("File index %v cannot be loaded: %v", path, err)
+			continue
+		}
+		fi.indexes[si.name] = si
+	}
+	return nil
+}
+
+func (fi *fileIndexer) createIndex(name string, seekKey expression.Expressions, rangeKey datastore.IndexKeys,
+	where expression.Expression, with value.Value) (datastore.Index, errors.Error) {
+
+	fi.Lock()
+	defer fi.Unlock()
+
+	if _, ok := fi.indexes[name]; ok {
+		return nil, errors.NewIndexAlreadyExistsError(name)
+	}
+	si, err := newSecondaryIndex(fi, name, seekKey, rangeKey, where, with)
+	if err == nil {
+		err = si.build()
+	}
+	if err == nil {
+		err = si.persist()
+	}
+	if err != nil {
+		return nil, err
+	}
+	fi.indexes[name] = si
+	fi.version++
+	return si, nil
+}
+
+func (fi *fileIndexer) dropIndex(si *secondaryIndex) errors.Error {
+	fi.Lock()
+	defer fi.Unlock()
+
+	if fi.indexes[si.name] != si {
+		return errors.NewFileIdxNotFound(nil, si.name)
+	}
+	er := os.Remove(si.path())
+	if er != nil && !os.IsNotExist(er) {
+		return errors.NewFileDatastoreError(er, "index "+si.name)
+	}
+	os.Remove(si.logPath())
+	delete(fi.indexes, si.name)
+	fi.version++
+	return nil
+}
+
+func (fi *fileIndexer) secondaryIndexes() []*secondaryIndex {
+	fi.RLock()
+	defer fi.RUnlock()
+
+	rv := make([]*secondaryIndex, 0, len(fi.indexes))
+	for _, index := range fi.indexes {
+		if si, ok := index.(*secondaryIndex); ok {
+			rv = append(rv, si)
+		}
+	}
+	return rv
+}
+
+// apply document changes to all secondary indexes, nil values denote deletions
+func (fi *fileIndexer) updateIndexes(pairs []value.Pair, deleted bool) errors.Error {
+	for _, pair := range pairs {
+		if deleted {
+			fi.indexDocument(pair.Name, nil)
+		} else {
+			fi.indexDocument(pair.Name, pair.Value)
+		}
+	}
+	return fi.persistIndexes()
+}
+
+// update the entries of a document, nil if deleted, in the secondary indexes.
+// Callers must hold the document lock, and persist the indexes when done
+func (fi *fileIndexer) indexDocument(key string, doc value.Value) {
+	for _, si := range fi.secondaryIndexes() {
+		si.update(key, doc)
+	}
+}
+
+func (fi *fileIndexer) persistIndexes() errors.Error {
+	var rv errors.Error
+
+	for _, si := range fi.secondaryIndexes() {
+		if err := si.persist(); err != nil {
+			rv = err
+		}
+	}
+	return rv
+}
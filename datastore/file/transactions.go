@@ -0,0 +1,506 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package file
+
+/*
+
+Transactions in the file datastore.
+
+Mutations made inside a transaction are staged in memory, per keyspace, and are
+visible to the following statements of the same transaction through Fetch and
+TransactionDeltaKeyScan. Statement level atomicity and savepoints are implemented
+through an undo log of the staged values.
+
+On commit, the staged mutations are written to a journal in the store directory,
+which is synced and renamed into place before any document is touched. The documents
+are then written and the journal removed. A journal left behind by a crash is replayed
+when the store is next opened.
+
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/logging"
+	"github.com/couchbase/query/transactions"
+	"github.com/couchbase/query/value"
+)
+
+const (
+	_JOURNAL_PREFIX = ".txn-"
+	_JOURNAL_EXT    = ".journal"
+)
+
+// a staged document. A nil val denotes a deleted document, and cas is the
+// CAS of the document on disk when it was first staged, if it existed
+type txValue struct {
+	op  int
+	val []byte
+	cas uint64
+}
+
+// the staged documents of a keyspace
+type txDelta struct {
+	keyspace *keyspace
+	values   map[string]*txValue
+}
+
+// the staged state of a key before it was mutated
+type txUndo struct {
+	keyspace string
+	key      string
+	prev     *txValue
+}
+
+type txMutations struct {
+	sync.RWMutex
+	implicit   bool
+	keyspaces  map[string]*txDelta
+	undo       []*txUndo
+	stmtStart  int
+	savepoints map[string]int
+}
+
+func newTxMutations(implicit bool) *txMutations {
+	return &txMutations{
+		implicit:   implicit,
+		keyspaces:  make(map[string]*txDelta, 4),
+		savepoints: make(map[string]int, 4),
+	}
+}
+
+// the mutations of the transaction the request is running in, if any
+func getTxMutations(context datastore.QueryContext) (*txMutations, errors.Error) {
+	if context == nil {
+		return nil, nil
+	}
+	txContext, _ := context.GetTxContext().(*transactions.TranContext)
+	if txContext == nil {
+		return nil, nil
+	}
+	if txContext.TxExpired() {
+		return nil, errors.NewTransactionExpired()
+	}
+	txm, _ := txContext.TxMutations().(*txMutations)
+	return txm, nil
+}
+
+// stage a DML operation, with the same semantics as keyspace.performOp and keyspace.Delete
+func (txm *txMutations) stage(b *keyspace, op int, kvPairs []value.Pair) ([]value.Pair, errors.Error) {
+	txm.Lock()
+	defer txm.Unlock()
+
+	name := b.QualifiedName()
+	dk, ok := txm.keyspaces[name]
+	if !ok {
+		dk = &txDelta{keyspace: b, values: make(map[string]*txValue, len(kvPairs))}
+		txm.keyspaces[name] = dk
+	}
+
+	staged := make([]value.Pair, 0, len(kvPairs))
+	var returnErr errors.Error
+
+	for _, kv := range kvPairs {
+		var err error
+		var next *txValue
+		var fi os.FileInfo
+
+		key := kv.Name
+		prev, ok := dk.values[key]
+		if !ok {
+			fi, err = b.stat(key)
+			if err != nil {
+				returnErr = errors.NewFileDMLError(returnErr, opToString(op)+" Failed "+err.Error())
+				continue
+			}
+		}
+		exists := ok && prev.val != nil || fi != nil
+
+		switch op {
+		case INSERT:
+			if exists {
+				err = errors.NewFileKeyExists(nil, "Key "+key)
+			} else if ok {
+				// deleted earlier in the transaction, still present on disk
+				next = &txValue{op: UPSERT}
+			} else {
+				next = &txValue{op: INSERT}
+			}
+		case UPDATE:
+			if !exists {
+				err = fmt.Errorf("Key %v not found", key)
+			} else if ok {
+				next = &txValue{op: prev.op}
+			} else if cas, ok := getMetaCas(kv.Value); ok {
+				var prevCas uint64
+
+				prevCas, err = b.documentCas(key)
+				if err == nil && cas != prevCas {
+					err = errors.NewFileCasMismatchError(nil, "Key "+key)
+				} else if err == nil {
+					next = &txValue{op: UPDATE}
+				}
+			} else {
+				next = &txValue{op: UPDATE}
+			}
+		case UPSERT:
+			if ok && prev.op == INSERT {
+				next = &txValue{op: INSERT}
+			} else {
+				next = &txValue{op: UPSERT}
+			}
+		case DELETE:
+			if !exists {
+
+				// nothing to delete
+				continue
+			}
+			if !ok || prev.op != INSERT {
+				next = &txValue{op: DELETE}
+			}
+		}
+
+		if next != nil {
+			if ok {
+				next.cas = prev.cas
+			} else if fi != nil {
+				next.cas, err = b.documentCas(key)
+			}
+		}
+		if err == nil && next != nil && op != DELETE {
+			next.val, err = json.Marshal(kv.Value.Actual())
+		}
+		if err != nil {
+			returnErr = errors.NewFileDMLError(returnErr, opToString(op)+" Failed "+err.Error())
+			continue
+		}
+
+		if !txm.implicit {
+			txm.undo = append(txm.undo, &txUndo{keyspace: name, key: key, prev: prev})
+		}
+		if next == nil {
+			delete(dk.values, key)
+		} else {
+			dk.values[key] = next
+		}
+		staged = append(staged, kv)
+	}
+
+	return staged, returnErr
+}
+
+// read your own writes: return the keys that are not staged in the transaction
+func (txm *txMutations) fetch(b *keyspace, keys []string, keysMap map[string]value.AnnotatedValue) []string {
+
+	// implicit transactions are single statements and do not see their own mutations
+	if txm.implicit {
+		return keys
+	}
+
+	txm.RLock()
+	defer txm.RUnlock()
+
+	dk, ok := txm.keyspaces[b.QualifiedName()]
+	if !ok || len(dk.values) == 0 {
+		return keys
+	}
+
+	rkeys := make([]string, 0, len(keys))
+	for _, k := range keys {
+		tv, ok := dk.values[k]
+		if !ok {
+			rkeys = append(rkeys, k)
+		} else if tv.val != nil {
+			item := value.NewAnnotatedValue(value.NewValue(tv.val))
+			item.SetId(k)
+			keysMap[k] = item
+		}
+	}
+	return rkeys
+}
+
+// the staged keys of a keyspace, set to true if deleted
+func (txm *txMutations) deltaKeys(keyspace string) map[string]bool {
+	if txm.implicit {
+		return nil
+	}
+
+	txm.RLock()
+	defer txm.RUnlock()
+
+	dk, ok := txm.keyspaces[keyspace]
+	if !ok {
+		return nil
+	}
+	keys := make(map[string]bool, len(dk.values))
+	for k, tv := range dk.values {
+		keys[k] = tv.val == nil
+	}
+	return keys
+}
+
+// mark the start of a statement, and return the keyspaces with staged documents
+func (txm *txMutations) startStatement(dks map[string]bool) {
+	if txm.implicit {
+		return
+	}
+
+	txm.Lock()
+	defer txm.Unlock()
+
+	txm.stmtStart = len(txm.undo)
+	for k, dk := range txm.keyspaces {
+		if len(dk.values) > 0 {
+			dks[k] = true
+		}
+	}
+}
+
+func (txm *txMutations) commitStatement() {
+	txm.Lock()
+	defer txm.Unlock()
+
+	// the undo log is only needed for savepoints once the statement is done
+	if len(txm.savepoints) == 0 {
+		txm.undo = txm.undo[:0]
+	}
+	txm.stmtStart = len(txm.undo)
+}
+
+func (txm *txMutations) setSavepoint(sname string) {
+	if txm.implicit {
+		return
+	}
+
+	txm.Lock()
+	defer txm.Unlock()
+
+	txm.savepoints[sname] = len(txm.undo)
+}
+
+// undo the staged mutations up to the named savepoint, or the start of the statement
+func (txm *txMutations) rollback(sname string) errors.Error {
+	txm.Lock()
+	defer txm.Unlock()
+
+	pos := txm.stmtStart
+	if sname != "" {
+		var ok bool
+		pos, ok = txm.savepoints[sname]
+		if !ok {
+			return errors.NewNoSavepointError(sname)
+		}
+	}
+
+	for i := len(txm.undo) - 1; i >= pos; i-- {
+		u := txm.undo[i]
+		dk := txm.keyspaces[u.keyspace]
+		if u.prev == nil {
+			delete(dk.values, u.key)
+		} else {
+			dk.values[u.key] = u.prev
+		}
+		txm.undo[i] = nil
+	}
+	txm.undo = txm.undo[:pos]
+	txm.stmtStart = pos
+	for s, p := range txm.savepoints {
+		if p > pos {
+			delete(txm.savepoints, s)
+		}
+	}
+	return nil
+}
+
+type journalEntry struct {
+	Namespace string          `json:"namespace"`
+	Keyspace  string          `json:"keyspace"`
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value,omitempty"`
+}
+
+type journal struct {
+	Id      string          `json:"id"`
+	Entries []*journalEntry `json:"entries"`
+}
+
+// write the staged mutations to the store
+func (txm *txMutations) commit(s *store, id string) errors.Error {
+	txm.Lock()
+	defer txm.Unlock()
+
+	s.txLock.Lock()
+	defer s.txLock.Unlock()
+
+	names := make([]string, 0, len(txm.keyspaces))
+	for name := range txm.keyspaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// lock all the staged documents, in order, until they have been written
+	j := &journal{Id: id}
+	for _, name := range names {
+		dk := txm.keyspaces[name]
+		keys := make([]string, 0, len(dk.values))
+		for k := range dk.values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			dk.keyspace.locks.lock(k)
+			defer dk.keyspace.locks.unlock(k)
+
+			// documents may have changed on disk since they were staged
+			tv := dk.values[k]
+			fi, er := dk.keyspace.stat(k)
+			var cas uint64
+			if er == nil && fi != nil {
+				cas, er = dk.keyspace.documentCas(k)
+			}
+			if er != nil {
+				return errors.NewCommitTransactionError(er, nil)
+			}
+			if tv.cas != 0 && (fi == nil || cas != tv.cas) ||
+				tv.cas == 0 && tv.op == INSERT && fi != nil {
+				return errors.NewCommitTransactionError(errors.NewFileCasMismatchError(nil, "Key "+k), nil)
+			}
+			j.Entries = append(j.Entries, &journalEntry{Namespace: dk.keyspace.namespace.name,
+				Keyspace: dk.keyspace.name, Key: k, Value: tv.val})
+		}
+	}
+
+	txm.keyspaces = make(map[string]*txDelta, 4)
+	txm.undo = nil
+	txm.savepoints = make(map[string]int, 4)
+	txm.stmtStart = 0
+
+	if len(j.Entries) == 0 {
+		return nil
+	}
+
+	bytes, er := json.Marshal(j)
+	if er == nil {
+		er = writeFileAtomic(s.journalPath(id), bytes)
+	}
+	if er != nil {
+		return errors.NewCommitTransactionError(er, nil)
+	}
+
+	// the transaction is committed once the journal is in place: if it cannot be applied now,
+	// the journal is left for the store to apply when next loaded
+	err := s.applyJournal(j)
+	if err != nil {
+		logging.Errorf("File store transaction %v committed but not applied, the journal is kept for recovery: %v",
+			id, err)
+		return nil
+	}
+	os.Remove(s.journalPath(id))
+	return nil
+}
+
+func (s *store) journalPath(id string) string {
+	return filepath.Join(s.path, _JOURNAL_PREFIX+id+_JOURNAL_EXT)
+}
+
+// apply a committed journal. Applying a journal more than once yields the same documents.
+// The caller is responsible for locking the documents
+func (s *store) applyJournal(j *journal) errors.Error {
+	type indexUpdates struct {
+		written []value.Pair
+		deleted []value.Pair
+	}
+
+	var order []*keyspace
+	updates := make(map[*keyspace]*indexUpdates, 4)
+
+	for _, e := range j.Entries {
+		p, ok := s.namespaces[strings.ToUpper(e.Namespace)]
+		if !ok {
+			return errors.NewFileNamespaceNotFoundError(nil, e.Namespace)
+		}
+		b, ok := p.keyspaces[strings.ToUpper(e.Keyspace)]
+		if !ok {
+			return errors.NewFileKeyspaceNotFoundError(nil, e.Keyspace)
+		}
+		u, ok := updates[b]
+		if !ok {
+			u = &indexUpdates{}
+			updates[b] = u
+			order = append(order, b)
+		}
+
+		var er error
+		if e.Value != nil {
+			_, er = b.writeDocument(e.Key, e.Value)
+			u.written = append(u.written, value.Pair{Name: e.Key, Value: value.NewValue([]byte(e.Value))})
+		} else {
+			er = b.removeDocument(e.Key)
+			if os.IsNotExist(er) {
+				er = nil
+			}
+			u.deleted = append(u.deleted, value.Pair{Name: e.Key})
+		}
+		if er != nil {
+			return errors.NewFileDatastoreError(er, "transaction "+j.Id)
+		}
+	}
+
+	for _, b := range order {
+		u := updates[b]
+		if err := b.fi.updateIndexes(u.written, false); err != nil {
+			return err
+		}
+		if err := b.fi.updateIndexes(u.deleted, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// complete the transactions that were committed but not applied when the store was last used
+func (s *store) replayJournals() errors.Error {
+	paths, er := filepath.Glob(filepath.Join(s.path, _JOURNAL_PREFIX+"*"+_JOURNAL_EXT))
+	if er != nil {
+		return errors.NewFileDatastoreError(er, "")
+	}
+
+	for _, path := range paths {
+		bytes, er := ioutil.ReadFile(path)
+		if er != nil {
+			return errors.NewFileDatastoreError(er, "")
+		}
+
+		var j journal
+		er = json.Unmarshal(bytes, &j)
+		if er != nil {
+			logging.Errorf("File transaction journal %v cannot be read: %v", path, er)
+			continue
+		}
+
+		logging.Infof("File transaction %v was not completed: replaying", j.Id)
+		err := s.applyJournal(&j)
+		if err != nil {
+			return err
+		}
+		os.Remove(path)
+	}
+	return nil
+}
@@ -47,6 +47,7 @@ const KEYSPACE_NAME_NODES = "nodes"
 const KEYSPACE_NAME_APPLICABLE_ROLES = "applicable_roles"
 const KEYSPACE_NAME_TASKS_CACHE = "tasks_cache"
 const KEYSPACE_NAME_TRANSACTIONS = "transactions"
+const KEYSPACE_NAME_WORKLOAD_GROUPS = "workload_groups"
 
 // TODO, sync with fetch timeout
 const scanTimeout = 30 * time.Second
@@ -123,6 +123,10 @@ func (b *preparedsKeyspace) Fetch(keys []string, keysMap map[string]value.Annota
 					itemMap["txPrepards"] = txPrepards
 				}
 
+				if baseline, _ := prepareds.BaselineInfo(localKey, false); baseline != nil {
+					itemMap["baseline"] = baseline
+				}
+
 				if node != "" {
 					itemMap["node"] = node
 				}
@@ -122,6 +122,7 @@ func (b *tasksCacheKeyspace) Fetch(keys []string, keysMap map[string]value.Annot
 				if node != "" {
 					itemMap["node"] = node
 				}
+				entry.AddRecurringInfo(itemMap)
 
 				item := value.NewAnnotatedValue(itemMap)
 				item.NewMeta()["keyspace"] = b.fullName
@@ -0,0 +1,231 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package system
+
+import (
+	"github.com/couchbase/query/auth"
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/server"
+	"github.com/couchbase/query/timestamp"
+	"github.com/couchbase/query/value"
+)
+
+// workload groups are a node setting, like the ones in /admin/settings:
+// this keyspace only shows and changes the groups of the local node
+type workloadGroupsKeyspace struct {
+	keyspaceBase
+	indexer datastore.Indexer
+}
+
+func (b *workloadGroupsKeyspace) Release(close bool) {
+}
+
+func (b *workloadGroupsKeyspace) NamespaceId() string {
+	return b.namespace.Id()
+}
+
+func (b *workloadGroupsKeyspace) Id() string {
+	return b.Name()
+}
+
+func (b *workloadGroupsKeyspace) Name() string {
+	return b.name
+}
+
+func (b *workloadGroupsKeyspace) Count(context datastore.QueryContext) (int64, errors.Error) {
+	return int64(server.CountWorkloadGroups()), nil
+}
+
+func (b *workloadGroupsKeyspace) Size(context datastore.QueryContext) (int64, errors.Error) {
+	return -1, nil
+}
+
+func (b *workloadGroupsKeyspace) Indexer(name datastore.IndexType) (datastore.Indexer, errors.Error) {
+	return b.indexer, nil
+}
+
+func (b *workloadGroupsKeyspace) Indexers() ([]datastore.Indexer, errors.Error) {
+	return []datastore.Indexer{b.indexer}, nil
+}
+
+func (b *workloadGroupsKeyspace) Fetch(keys []string, keysMap map[string]value.AnnotatedValue,
+	context datastore.QueryContext, subPaths []string) (errs []errors.Error) {
+
+	for _, key := range keys {
+		server.WorkloadGroupDo(key, func(group *server.WorkloadGroup) {
+			itemMap := group.Object()
+			itemMap["active"] = group.Active()
+			itemMap["queued"] = group.Queued()
+
+			item := value.NewAnnotatedValue(itemMap)
+			item.NewMeta()["keyspace"] = b.fullName
+			item.SetId(key)
+			keysMap[key] = item
+		})
+	}
+	return
+}
+
+func (b *workloadGroupsKeyspace) Insert(inserts []value.Pair, context datastore.QueryContext) ([]value.Pair, errors.Error) {
+	return b.store(inserts, false, false, context)
+}
+
+func (b *workloadGroupsKeyspace) Update(updates []value.Pair, context datastore.QueryContext) ([]value.Pair, errors.Error) {
+	return b.store(updates, true, true, context)
+}
+
+func (b *workloadGroupsKeyspace) Upsert(upserts []value.Pair, context datastore.QueryContext) ([]value.Pair, errors.Error) {
+	return b.store(upserts, true, false, context)
+}
+
+func (b *workloadGroupsKeyspace) Delete(deletes []value.Pair, context datastore.QueryContext) ([]value.Pair, errors.Error) {
+	if err := canChangeWorkloadGroups(context); err != nil {
+		return nil, err
+	}
+	for _, pair := range deletes {
+		server.DropWorkloadGroup(pair.Name)
+	}
+	return deletes, nil
+}
+
+func (b *workloadGroupsKeyspace) store(pairs []value.Pair, replace bool, mustExist bool,
+	context datastore.QueryContext) ([]value.Pair, errors.Error) {
+
+	if err := canChangeWorkloadGroups(context); err != nil {
+		return nil, err
+	}
+	for i, pair := range pairs {
+		group, err := newWorkloadGroup(pair)
+		if err == nil && mustExist && !server.WorkloadGroupDo(pair.Name, func(*server.WorkloadGroup) {}) {
+			err = errors.NewSystemDatastoreError(nil, "Workload group "+pair.Name+" does not exist")
+		}
+		if err == nil {
+			err = server.AddWorkloadGroup(group, replace)
+		}
+		if err != nil {
+			return pairs[0:i], err
+		}
+	}
+	return pairs, nil
+}
+
+// the document key is the group name, and the counters added by Fetch are ignored,
+// so that documents can be updated in place
+func newWorkloadGroup(pair value.Pair) (*server.WorkloadGroup, errors.Error) {
+	doc, ok := pair.Value.Actual().(map[string]interface{})
+	if !ok {
+		return nil, errors.NewAdminWorkloadGroupInvalid(pair.Name, "definition", pair.Value)
+	}
+	def := make(map[string]interface{}, len(doc)+1)
+	for n, v := range doc {
+		if n != "active" && n != "queued" {
+			def[n] = v
+		}
+	}
+	if name, ok := def["name"]; ok && name != pair.Name {
+		return nil, errors.NewAdminWorkloadGroupInvalid(pair.Name, "name", name)
+	}
+	def["name"] = pair.Name
+	return server.NewWorkloadGroup(def)
+}
+
+func canChangeWorkloadGroups(context datastore.QueryContext) errors.Error {
+	privs := auth.NewPrivileges()
+	privs.Add("", auth.PRIV_CLUSTER_SETTINGS_WRITE, auth.PRIV_PROPS_NONE)
+	_, err := datastore.GetDatastore().Authorize(privs, context.Credentials())
+	return err
+}
+
+func newWorkloadGroupsKeyspace(p *namespace) (*workloadGroupsKeyspace, errors.Error) {
+	b := new(workloadGroupsKeyspace)
+	setKeyspaceBase(&b.keyspaceBase, p, KEYSPACE_NAME_WORKLOAD_GROUPS)
+
+	primary := &workloadGroupsIndex{
+		name:     "#primary",
+		keyspace: b,
+	}
+	b.indexer = newSystemIndexer(b, primary)
+	setIndexBase(&primary.indexBase, b.indexer)
+
+	return b, nil
+}
+
+type workloadGroupsIndex struct {
+	indexBase
+	name     string
+	keyspace *workloadGroupsKeyspace
+}
+
+func (pi *workloadGroupsIndex) KeyspaceId() string {
+	return pi.keyspace.Id()
+}
+
+func (pi *workloadGroupsIndex) Id() string {
+	return pi.Name()
+}
+
+func (pi *workloadGroupsIndex) Name() string {
+	return pi.name
+}
+
+func (pi *workloadGroupsIndex) Type() datastore.IndexType {
+	return datastore.SYSTEM
+}
+
+func (pi *workloadGroupsIndex) SeekKey() expression.Expressions {
+	return nil
+}
+
+func (pi *workloadGroupsIndex) RangeKey() expression.Expressions {
+	return nil
+}
+
+func (pi *workloadGroupsIndex) Condition() expression.Expression {
+	return nil
+}
+
+func (pi *workloadGroupsIndex) IsPrimary() bool {
+	return true
+}
+
+func (pi *workloadGroupsIndex) State() (state datastore.IndexState, msg string, err errors.Error) {
+	return datastore.ONLINE, "", nil
+}
+
+func (pi *workloadGroupsIndex) Statistics(requestId string, span *datastore.Span) (
+	datastore.Statistics, errors.Error) {
+	return nil, nil
+}
+
+func (pi *workloadGroupsIndex) Drop(requestId string) errors.Error {
+	return errors.NewSystemIdxNoDropError(nil, "")
+}
+
+func (pi *workloadGroupsIndex) Scan(requestId string, span *datastore.Span, distinct bool, limit int64,
+	cons datastore.ScanConsistency, vector timestamp.Vector, conn *datastore.IndexConnection) {
+	pi.ScanEntries(requestId, limit, cons, vector, conn)
+}
+
+func (pi *workloadGroupsIndex) ScanEntries(requestId string, limit int64, cons datastore.ScanConsistency,
+	vector timestamp.Vector, conn *datastore.IndexConnection) {
+	defer conn.Sender().Close()
+
+	for i, name := range server.WorkloadGroupNames() {
+		if limit > 0 && int64(i) >= limit {
+			break
+		}
+		entry := datastore.IndexEntry{PrimaryKey: name}
+		if !sendSystemKey(conn, &entry) {
+			return
+		}
+	}
+}
@@ -193,6 +193,12 @@ func (p *namespace) loadKeyspaces() (e errors.Error) {
 
 	p.keyspaces[tasksCache.Name()] = tasksCache
 
+	workloadGroups, e := newWorkloadGroupsKeyspace(p)
+	if e != nil {
+		return e
+	}
+	p.keyspaces[workloadGroups.Name()] = workloadGroups
+
 	reqs, e := newRequestsKeyspace(p)
 	if e != nil {
 		return e
@@ -162,3 +162,14 @@ func NewAdminBodyError(e error) Error {
 	return &err{level: EXCEPTION, ICode: 2220, IKey: "admin.accounting.bad_body", ICause: e,
 		InternalMsg: "Error getting request body", InternalCaller: CallerN(1)}
 }
+
+func NewAdminWorkloadGroupExists(name string) Error {
+	return &err{level: EXCEPTION, ICode: 2230, IKey: "admin.workload.group.already_exists",
+		InternalMsg: "Workload group " + name + " already exists", InternalCaller: CallerN(1)}
+}
+
+func NewAdminWorkloadGroupInvalid(name string, field string, value interface{}) Error {
+	return &err{level: EXCEPTION, ICode: 2240, IKey: "admin.workload.group.invalid",
+		InternalMsg:    fmt.Sprintf("Incorrect value %v for %s of workload group %s", value, field, name),
+		InternalCaller: CallerN(1)}
+}
@@ -296,3 +296,17 @@ func NewMemoryQuotaExceededError() Error {
 		InternalMsg:    "Request has exceeded memory quota",
 		InternalCaller: CallerN(1)}
 }
+
+func NewSpillError(e error, op string) Error {
+	return &err{level: EXCEPTION, ICode: 5510, IKey: "execution.spill.error", ICause: e,
+		InternalMsg:    fmt.Sprintf("Error spilling %s to disk", op),
+		InternalCaller: CallerN(1)}
+}
+
+const RECURSION_LIMIT = 5520
+
+func NewRecursionLimitError(alias string, limit int64, what string) Error {
+	return &err{level: EXCEPTION, ICode: RECURSION_LIMIT, IKey: "execution.with.recursion_limit",
+		InternalMsg:    fmt.Sprintf("Recursive WITH %s exceeded the limit of %d %s: use OPTIONS to set a limit", alias, limit, what),
+		InternalCaller: CallerN(1)}
+}
@@ -98,6 +98,20 @@ func NewPredefinedPreparedNameError(msg string) Error {
 		InternalMsg: fmt.Sprintf("Prepared name %s is predefined (reserved). ", msg), InternalCaller: CallerN(1)}
 }
 
+const NO_SUCH_BASELINE = 4093
+
+func NewNoSuchBaselineError(name string) Error {
+	return &err{level: EXCEPTION, ICode: NO_SUCH_BASELINE, IKey: "plan.baseline.no_such_baseline",
+		InternalMsg: fmt.Sprintf("No plan baseline for prepared statement: %s", name), InternalCaller: CallerN(1)}
+}
+
+const NO_SUCH_PLAN_CANDIDATE = 4094
+
+func NewNoSuchPlanCandidateError(name string, candidate string) Error {
+	return &err{level: EXCEPTION, ICode: NO_SUCH_PLAN_CANDIDATE, IKey: "plan.baseline.no_such_candidate",
+		InternalMsg: fmt.Sprintf("No candidate plan %s for prepared statement: %s", candidate, name), InternalCaller: CallerN(1)}
+}
+
 const NO_INDEX_JOIN = 4100
 
 func NewNoIndexJoinError(alias, op string) Error {
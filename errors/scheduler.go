@@ -36,3 +36,15 @@ func NewTaskNotFoundError(t string) Error {
 		InternalMsg:    fmt.Sprintf("the task %v was not found", t),
 		InternalCaller: CallerN(1)}
 }
+
+func NewInvalidScheduleError(s string, e error) Error {
+	return &err{level: EXCEPTION, ICode: 6005, IKey: "scheduler.schedule.error", ICause: e,
+		InternalMsg:    fmt.Sprintf("Invalid schedule %v", s),
+		InternalCaller: CallerN(1)}
+}
+
+func NewTaskNotRecurringError(t string) Error {
+	return &err{level: EXCEPTION, ICode: 6006, IKey: "scheduler.recurring.error", ICause: fmt.Errorf("%v", t),
+		InternalMsg:    fmt.Sprintf("Task %v is not a recurring task and cannot be paused or resumed", t),
+		InternalCaller: CallerN(1)}
+}
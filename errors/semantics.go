@@ -169,6 +169,13 @@ func NewUpdateStatInvalidIndexTypeError() Error {
 		InternalMsg: "UPDATE STATISTICS (ANALYZE) supports GSI indexes only for INDEX option.", InternalCaller: CallerN(1)}
 }
 
+const RECURSIVE_WITH_SEMANTICS = 3280
+
+func NewRecursiveWithSemanticError(alias string, msg string) Error {
+	return &err{level: EXCEPTION, ICode: RECURSIVE_WITH_SEMANTICS, IKey: "semantics.with.recursive",
+		InternalMsg: fmt.Sprintf("Recursive WITH %s: %s", alias, msg), InternalCaller: CallerN(1)}
+}
+
 /* ---- BEGIN MOVED error numbers ----
    The following error numbers (in the 4000 range) originally reside in plan.go (before the introduction of the semantics package)
    although they are semantic errors. They are moved from plan.go to semantics.go but their original error numbers are kept.
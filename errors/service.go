@@ -111,3 +111,29 @@ func NewServiceErrorHttpMethod(method string) Error {
 	return &err{level: EXCEPTION, ICode: 1170, IKey: "service.io.request.method",
 		InternalMsg: fmt.Sprintf("Unsupported method %s", method), InternalCaller: CallerN(1)}
 }
+
+const SERVICE_RATE_LIMITED = 1180
+const SERVICE_CONCURRENCY_LIMITED = 1190
+
+func NewServiceErrorRateLimited(user string, retryAfter time.Duration) Error {
+	return &err{level: EXCEPTION, ICode: SERVICE_RATE_LIMITED, IKey: "service.limits.rate",
+		InternalMsg: fmt.Sprintf("Request rate limit exceeded for user %s", user), retry: true,
+		cause:          map[string]interface{}{"user": user, "retry_after": retryAfterSeconds(retryAfter)},
+		InternalCaller: CallerN(1)}
+}
+
+func NewServiceErrorConcurrencyLimited(user string, limit int, retryAfter time.Duration) Error {
+	return &err{level: EXCEPTION, ICode: SERVICE_CONCURRENCY_LIMITED, IKey: "service.limits.concurrency",
+		InternalMsg: fmt.Sprintf("Limit of %d concurrent requests exceeded for user %s", limit, user), retry: true,
+		cause:          map[string]interface{}{"user": user, "retry_after": retryAfterSeconds(retryAfter)},
+		InternalCaller: CallerN(1)}
+}
+
+// Retry-After is expressed in whole seconds
+func retryAfterSeconds(d time.Duration) int {
+	s := int((d + time.Second - 1) / time.Second)
+	if s < 1 {
+		s = 1
+	}
+	return s
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/couchbase/query/datastore"
 	"github.com/couchbase/query/errors"
 	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/tracing"
 	"github.com/couchbase/query/util"
 	"github.com/couchbase/query/value"
 )
@@ -138,6 +139,8 @@ type base struct {
 	inDocs         int64
 	outDocs        int64
 	phaseSwitches  int64
+	spills         int64
+	spillBytes     int64
 	stopped        bool
 	isRoot         bool
 	bit            uint8
@@ -146,6 +149,7 @@ type base struct {
 	activeCond     sync.Cond
 	activeLock     sync.Mutex
 	opState        opState
+	span           *tracing.Span
 }
 
 const _ITEM_CAP = 512
@@ -257,6 +261,7 @@ func (this *base) reopen(context *Context) bool {
 
 func (this *base) close(context *Context) {
 	this.valueExchange.close()
+	this.endSpan()
 
 	if this.output != nil {
 
@@ -1299,6 +1304,9 @@ func (this *base) switchPhase(p timePhases) {
 func (this *base) setExecPhase(phase Phases, context *Context) {
 	context.AddPhaseOperator(phase)
 	this.addExecPhase(phase, context)
+	if this.span == nil {
+		this.span = context.span.Child(phase.String())
+	}
 }
 
 // accrues phase times (useful where we don't want to count operators)
@@ -1327,6 +1335,21 @@ func (this *base) addOutDocs(d int64) {
 	go_atomic.AddInt64((*int64)(&this.outDocs), d)
 }
 
+// operators with a phase are traced from the time they start to when they close
+func (this *base) endSpan() {
+	span := this.span
+	if span == nil {
+		return
+	}
+	this.span = nil
+	span.SetAttribute("#itemsIn", go_atomic.LoadInt64(&this.inDocs))
+	span.SetAttribute("#itemsOut", go_atomic.LoadInt64(&this.outDocs))
+	span.SetAttribute("execTime", time.Duration(go_atomic.LoadInt64((*int64)(&this.execTime))))
+	span.SetAttribute("servTime", time.Duration(go_atomic.LoadInt64((*int64)(&this.servTime))))
+	span.SetAttribute("kernTime", time.Duration(go_atomic.LoadInt64((*int64)(&this.chanTime))))
+	span.End()
+}
+
 // profile marshaller
 func (this *base) marshalTimes(r map[string]interface{}) {
 	var d time.Duration
@@ -1341,6 +1364,10 @@ func (this *base) marshalTimes(r map[string]interface{}) {
 	if this.phaseSwitches != 0 {
 		stats["#phaseSwitches"] = this.phaseSwitches
 	}
+	if this.spills != 0 {
+		stats["#spills"] = this.spills
+		stats["#spillBytes"] = this.spillBytes
+	}
 
 	execTime := this.execTime
 	chanTime := this.chanTime
@@ -1390,6 +1417,8 @@ func (this *base) accrueTime(copy *base) {
 	this.inDocs += copy.inDocs
 	this.outDocs += copy.outDocs
 	this.phaseSwitches += copy.phaseSwitches
+	this.spills += copy.spills
+	this.spillBytes += copy.spillBytes
 	this.execTime += copy.execTime
 	this.chanTime += copy.chanTime
 	this.servTime += copy.servTime
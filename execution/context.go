@@ -30,6 +30,7 @@ import (
 	"github.com/couchbase/query/plan"
 	"github.com/couchbase/query/planner"
 	"github.com/couchbase/query/timestamp"
+	"github.com/couchbase/query/tracing"
 	"github.com/couchbase/query/transactions"
 	"github.com/couchbase/query/value"
 )
@@ -62,6 +63,8 @@ const (
 	INFER
 	FTS_SEARCH
 	UPDATE_STAT
+	SPILL
+	SPILL_BYTES
 
 	// Expression layer
 	ADVISOR
@@ -104,6 +107,8 @@ var _PHASE_NAMES = []string{
 	INFER:        "inferKeySpace",
 	FTS_SEARCH:   "ftsSearch",
 	UPDATE_STAT:  "updateStatistics",
+	SPILL:        "spills",
+	SPILL_BYTES:  "spillBytes",
 
 	ADVISOR: "advisor",
 
@@ -192,6 +197,7 @@ type Context struct {
 	numAtrs             int
 	kvTimeout           time.Duration
 	flags               uint32
+	span                *tracing.Span
 }
 
 func NewContext(requestId string, datastore datastore.Datastore, systemstore datastore.Systemstore,
@@ -272,6 +278,7 @@ func (this *Context) Copy() *Context {
 		atrCollection:       this.atrCollection,
 		numAtrs:             this.numAtrs,
 		flags:               this.flags,
+		span:                this.span,
 	}
 
 	rv.SetDurability(this.DurabilityLevel(), this.DurabilityTimeout())
@@ -313,6 +320,14 @@ func (this *Context) SetPrepared(prepared *plan.Prepared) {
 	this.prepared = prepared
 }
 
+func (this *Context) SetSpan(span *tracing.Span) {
+	this.span = span
+}
+
+func (this *Context) Span() *tracing.Span {
+	return this.span
+}
+
 func (this *Context) SetWhitelist(val map[string]interface{}) {
 	this.whitelist = val
 }
@@ -12,16 +12,26 @@ package execution
 import (
 	"encoding/json"
 
+	"github.com/couchbase/query/errors"
 	"github.com/couchbase/query/plan"
 	"github.com/couchbase/query/value"
 )
 
 // Distincting of input data.
+//
+// Once the set of values seen outgrows the spill threshold, or half of
+// the request memory quota, it stops growing: values already in it are
+// still discarded, while unseen ones are partitioned to disk on their hash.
+// Each partition is then distincted on its own once the input is exhausted.
+// Collecting distincts feed their set to other operators, and never spill.
 type Distinct struct {
 	base
-	set     *value.Set
-	plan    *plan.Distinct
-	collect bool
+	set        *value.Set
+	plan       *plan.Distinct
+	collect    bool
+	size       uint64
+	parent     value.Value
+	partitions []*spillFile
 }
 
 func NewDistinct(plan *plan.Distinct, context *Context, collect bool) *Distinct {
@@ -58,17 +68,35 @@ func (this *Distinct) PlanOp() plan.Operator {
 }
 
 func (this *Distinct) RunOnce(context *Context, parent value.Value) {
+	defer this.releasePartitions()
 	this.runConsumer(this, context, parent)
 }
 
-func (this *Distinct) processItem(item value.AnnotatedValue, context *Context) bool {
-	p := item.GetAttachment("projection")
-	if p == nil {
-		p = item
-	}
+func (this *Distinct) beforeItems(context *Context, parent value.Value) bool {
+	this.parent = parent
+	return true
+}
 
-	if !this.set.Has(p.(value.Value)) {
-		this.set.Put(p.(value.Value), item)
+func (this *Distinct) processItem(item value.AnnotatedValue, context *Context) bool {
+	p := distinctValue(item)
+
+	if !this.set.Has(p) {
+		if this.partitions != nil {
+			return this.spillItem(p, item, context)
+		}
+		this.set.Put(p, item)
+		if !this.collect && canSpill(context) {
+			this.size += p.Size()
+			if shouldSpill(context, this.size) {
+				var err error
+
+				this.partitions, err = newSpillPartitions("distinct", _GROUP_PARTITIONS)
+				if err != nil {
+					context.Error(errors.NewSpillError(err, "distinct"))
+					return false
+				}
+			}
+		}
 		return this.collect || this.sendItem(item)
 	} else {
 		item.Recycle()
@@ -77,11 +105,83 @@ func (this *Distinct) processItem(item value.AnnotatedValue, context *Context) b
 }
 
 func (this *Distinct) afterItems(context *Context) {
+	if this.partitions != nil {
+		this.set = nil
+		this.sendPartitions(context)
+	}
 	if !this.collect {
 		this.set = nil
 	}
 }
 
+func distinctValue(item value.AnnotatedValue) value.Value {
+	p := item.GetAttachment("projection")
+	if p == nil {
+		return item
+	}
+	return p.(value.Value)
+}
+
+func (this *Distinct) spillItem(p value.Value, item value.AnnotatedValue, context *Context) bool {
+	bytes, err := value.MarshalValue(p)
+	if err == nil {
+		err = this.partitions[spillPartition(bytes, len(this.partitions), 0)].writeItem(item, this.parent)
+	}
+	if err != nil {
+		context.Error(errors.NewSpillError(err, "distinct"))
+		return false
+	}
+	if context.UseRequestQuota() {
+		context.ReleaseValueSize(item.Size())
+	}
+	return true
+}
+
+// distinct each partition with a set of its own
+func (this *Distinct) sendPartitions(context *Context) bool {
+	defer this.releasePartitions()
+
+	err := rewindSpillPartitions(&this.base, this.partitions, context)
+	if err != nil {
+		context.Error(errors.NewSpillError(err, "distinct"))
+		return false
+	}
+
+	useQuota := context.UseRequestQuota()
+	for _, partition := range this.partitions {
+		set := value.NewSet(int(context.GetPipelineCap()), false, false)
+		for {
+			item, err := partition.readItem(this.parent)
+			if err != nil {
+				context.Error(errors.NewSpillError(err, "distinct"))
+				return false
+			} else if item == nil {
+				break
+			}
+
+			p := distinctValue(item)
+			if set.Has(p) {
+				continue
+			}
+			set.Put(p, nil)
+			if useQuota && context.TrackValueSize(item.Size()) {
+				context.Error(errors.NewMemoryQuotaExceededError())
+				return false
+			}
+			if !this.sendItem(item) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (this *Distinct) releasePartitions() {
+	releaseSpillPartitions(this.partitions)
+	this.partitions = nil
+	this.size = 0
+}
+
 func (this *Distinct) Set() *value.Set {
 	return this.set
 }
@@ -96,5 +196,6 @@ func (this *Distinct) MarshalJSON() ([]byte, error) {
 func (this *Distinct) reopen(context *Context) bool {
 	rv := this.baseReopen(context)
 	this.set = value.NewSet(int(context.GetPipelineCap()), false, false)
+	this.releasePartitions()
 	return rv
 }
@@ -22,6 +22,7 @@ type FinalGroup struct {
 	base
 	plan   *plan.FinalGroup
 	groups map[string]value.AnnotatedValue
+	spill  groupSpill
 }
 
 func NewFinalGroup(plan *plan.FinalGroup, context *Context) *FinalGroup {
@@ -53,9 +54,15 @@ func (this *FinalGroup) PlanOp() plan.Operator {
 }
 
 func (this *FinalGroup) RunOnce(context *Context, parent value.Value) {
+	defer this.spill.release()
 	this.runConsumer(this, context, parent)
 }
 
+func (this *FinalGroup) beforeItems(context *Context, parent value.Value) bool {
+	this.spill.init("final group", parent)
+	return true
+}
+
 func (this *FinalGroup) processItem(item value.AnnotatedValue, context *Context) bool {
 	// Generate the group key
 	var gk string
@@ -96,7 +103,7 @@ func (this *FinalGroup) processItem(item value.AnnotatedValue, context *Context)
 			aggregates[agg.String()] = v
 		}
 
-		return true
+		return this.spill.add(gv, &this.groups, context)
 	default:
 		context.Fatal(errors.NewInvalidValueError(fmt.Sprintf(
 			"Invalid or missing aggregates of type %T.", aggregates)))
@@ -106,6 +113,17 @@ func (this *FinalGroup) processItem(item value.AnnotatedValue, context *Context)
 }
 
 func (this *FinalGroup) afterItems(context *Context) {
+
+	// spilled groups can only be told apart once read back
+	if this.spill.spilled() {
+		this.spill.send(&this.base, this.plan.Keys(), &this.groups,
+			func(gv, item value.AnnotatedValue) bool {
+				context.Fatal(errors.NewDuplicateFinalGroupError())
+				return false
+			}, context)
+		return
+	}
+
 	for _, av := range this.groups {
 		if !this.sendItem(av) {
 			return
@@ -140,5 +158,6 @@ func (this *FinalGroup) MarshalJSON() ([]byte, error) {
 func (this *FinalGroup) reopen(context *Context) bool {
 	rv := this.baseReopen(context)
 	this.groups = make(map[string]value.AnnotatedValue)
+	this.spill.release()
 	return rv
 }
@@ -23,6 +23,7 @@ type InitialGroup struct {
 	base
 	plan   *plan.InitialGroup
 	groups map[string]value.AnnotatedValue
+	spill  groupSpill
 }
 
 func NewInitialGroup(plan *plan.InitialGroup, context *Context) *InitialGroup {
@@ -54,9 +55,15 @@ func (this *InitialGroup) PlanOp() plan.Operator {
 }
 
 func (this *InitialGroup) RunOnce(context *Context, parent value.Value) {
+	defer this.spill.release()
 	this.runConsumer(this, context, parent)
 }
 
+func (this *InitialGroup) beforeItems(context *Context, parent value.Value) bool {
+	this.spill.init("initial group", parent)
+	return true
+}
+
 func (this *InitialGroup) processItem(item value.AnnotatedValue, context *Context) bool {
 	// Generate the group key
 	var gk string
@@ -73,7 +80,8 @@ func (this *InitialGroup) processItem(item value.AnnotatedValue, context *Contex
 	// Get or seed the group value
 	gv := this.groups[gk]
 	handleQuota := false
-	if gv == nil {
+	newGroup := gv == nil
+	if newGroup {
 		gv = item
 		this.groups[gk] = gv
 
@@ -110,10 +118,21 @@ func (this *InitialGroup) processItem(item value.AnnotatedValue, context *Contex
 	}
 	// TODO Recycle
 
+	if newGroup {
+		return this.spill.add(gv, &this.groups, context)
+	}
 	return true
 }
 
 func (this *InitialGroup) afterItems(context *Context) {
+	if this.spill.spilled() {
+		this.spill.send(&this.base, this.plan.Keys(), &this.groups,
+			func(gv, item value.AnnotatedValue) bool {
+				return cumulateGroups(gv, item, this.plan.Aggregates(), context)
+			}, context)
+		return
+	}
+
 	for _, av := range this.groups {
 		if !this.sendItem(av) {
 			return
@@ -131,5 +150,6 @@ func (this *InitialGroup) MarshalJSON() ([]byte, error) {
 func (this *InitialGroup) reopen(context *Context) bool {
 	rv := this.baseReopen(context)
 	this.groups = make(map[string]value.AnnotatedValue)
+	this.spill.release()
 	return rv
 }
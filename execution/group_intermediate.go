@@ -23,6 +23,7 @@ type IntermediateGroup struct {
 	base
 	plan   *plan.IntermediateGroup
 	groups map[string]value.AnnotatedValue
+	spill  groupSpill
 }
 
 func NewIntermediateGroup(plan *plan.IntermediateGroup, context *Context) *IntermediateGroup {
@@ -54,9 +55,15 @@ func (this *IntermediateGroup) PlanOp() plan.Operator {
 }
 
 func (this *IntermediateGroup) RunOnce(context *Context, parent value.Value) {
+	defer this.spill.release()
 	this.runConsumer(this, context, parent)
 }
 
+func (this *IntermediateGroup) beforeItems(context *Context, parent value.Value) bool {
+	this.spill.init("intermediate group", parent)
+	return true
+}
+
 func (this *IntermediateGroup) processItem(item value.AnnotatedValue, context *Context) bool {
 	// Generate the group key
 	var gk string
@@ -75,7 +82,7 @@ func (this *IntermediateGroup) processItem(item value.AnnotatedValue, context *C
 	if gv == nil {
 		gv = item
 		this.groups[gk] = gv
-		return true
+		return this.spill.add(gv, &this.groups, context)
 	}
 
 	// Cumulate aggregates
@@ -115,6 +122,14 @@ func (this *IntermediateGroup) processItem(item value.AnnotatedValue, context *C
 }
 
 func (this *IntermediateGroup) afterItems(context *Context) {
+	if this.spill.spilled() {
+		this.spill.send(&this.base, this.plan.Keys(), &this.groups,
+			func(gv, item value.AnnotatedValue) bool {
+				return cumulateGroups(gv, item, this.plan.Aggregates(), context)
+			}, context)
+		return
+	}
+
 	for _, av := range this.groups {
 		if !this.sendItem(av) {
 			return
@@ -132,5 +147,6 @@ func (this *IntermediateGroup) MarshalJSON() ([]byte, error) {
 func (this *IntermediateGroup) reopen(context *Context) bool {
 	rv := this.baseReopen(context)
 	this.groups = make(map[string]value.AnnotatedValue)
+	this.spill.release()
 	return rv
 }
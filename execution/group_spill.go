@@ -0,0 +1,179 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package execution
+
+// Once the groups held by a group operator outgrow the spill threshold,
+// or half of the request memory quota, they are moved to disk, together
+// with their partial aggregates, partitioned on the hash of the group key.
+// Further input starts new groups in memory, which are moved to the same
+// partitions in turn. Once the input is exhausted, each partition is read
+// back on its own, and the groups of the same key combined, so that only
+// one partition of groups is in memory at any one time.
+
+import (
+	"fmt"
+
+	"github.com/couchbase/query/algebra"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+const _GROUP_PARTITIONS = 32
+
+type groupSpill struct {
+	op         string
+	parent     value.Value
+	size       uint64
+	partitions []*spillFile
+}
+
+func (this *groupSpill) init(op string, parent value.Value) {
+	this.release()
+	this.op = op
+	this.parent = parent
+}
+
+func (this *groupSpill) spilled() bool {
+	return this.partitions != nil
+}
+
+// account for a new group, moving all groups to disk if they grow too large
+func (this *groupSpill) add(item value.AnnotatedValue, groups *map[string]value.AnnotatedValue, context *Context) bool {
+	if !canSpill(context) {
+		return true
+	}
+	this.size += item.Size()
+	if !shouldSpill(context, this.size) {
+		return true
+	}
+	return this.flush(groups, context)
+}
+
+func (this *groupSpill) flush(groups *map[string]value.AnnotatedValue, context *Context) bool {
+	var err error
+
+	if this.partitions == nil {
+		this.partitions, err = newSpillPartitions("group", _GROUP_PARTITIONS)
+		if err != nil {
+			context.Error(errors.NewSpillError(err, this.op))
+			return false
+		}
+	}
+
+	useQuota := context.UseRequestQuota()
+	for gk, gv := range *groups {
+		err = this.partitions[spillPartition([]byte(gk), _GROUP_PARTITIONS, 0)].writeItem(gv, this.parent)
+		if err != nil {
+			context.Error(errors.NewSpillError(err, this.op))
+			return false
+		}
+		if useQuota {
+			context.ReleaseValueSize(gv.Size())
+		}
+	}
+	*groups = make(map[string]value.AnnotatedValue)
+	this.size = 0
+	return true
+}
+
+// send the groups of each partition, combining those with the same key
+func (this *groupSpill) send(op *base, keys expression.Expressions, groups *map[string]value.AnnotatedValue,
+	combine func(gv, item value.AnnotatedValue) bool, context *Context) bool {
+
+	defer this.release()
+
+	if !this.flush(groups, context) {
+		return false
+	}
+	err := rewindSpillPartitions(op, this.partitions, context)
+	if err != nil {
+		context.Error(errors.NewSpillError(err, this.op))
+		return false
+	}
+
+	useQuota := context.UseRequestQuota()
+	for _, partition := range this.partitions {
+		merged := make(map[string]value.AnnotatedValue)
+		for {
+			item, err := partition.readItem(this.parent)
+			if err != nil {
+				context.Error(errors.NewSpillError(err, this.op))
+				return false
+			} else if item == nil {
+				break
+			}
+
+			var gk string
+			if len(keys) > 0 {
+				gk, err = groupKey(item, keys, context)
+				if err != nil {
+					context.Fatal(errors.NewEvaluationError(err, "GROUP key"))
+					return false
+				}
+			}
+
+			gv := merged[gk]
+			if gv == nil {
+				if useQuota && context.TrackValueSize(item.Size()) {
+					context.Error(errors.NewMemoryQuotaExceededError())
+					return false
+				}
+				merged[gk] = item
+			} else if !combine(gv, item) {
+				return false
+			}
+		}
+
+		for _, gv := range merged {
+			if !op.sendItem(gv) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (this *groupSpill) release() {
+	releaseSpillPartitions(this.partitions)
+	this.partitions = nil
+	this.parent = nil
+	this.size = 0
+}
+
+// fold the partial aggregates of item into those of group gv
+func cumulateGroups(gv, item value.AnnotatedValue, aggs algebra.Aggregates, context *Context) bool {
+	part, ok := item.GetAttachment("aggregates").(map[string]value.Value)
+	if !ok {
+		context.Fatal(errors.NewInvalidValueError(
+			fmt.Sprintf("Invalid partial aggregates %v of type %T", part, part)))
+		return false
+	}
+
+	cumulative, ok := gv.GetAttachment("aggregates").(map[string]value.Value)
+	if !ok {
+		context.Fatal(errors.NewInvalidValueError(
+			fmt.Sprintf("Invalid cumulative aggregates %v of type %T", cumulative, cumulative)))
+		return false
+	}
+
+	for _, agg := range aggs {
+		a := agg.String()
+		v, e := agg.CumulateIntermediate(part[a], cumulative[a], context)
+		if e != nil {
+			context.Fatal(errors.NewGroupUpdateError(
+				e, "Error updating intermediate GROUP value."))
+			return false
+		}
+
+		cumulative[a] = v
+	}
+	return true
+}
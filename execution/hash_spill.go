@@ -0,0 +1,513 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package execution
+
+// Grace hash join: once the build side of a hash join or nest outgrows
+// the spill threshold, or half of the request memory quota, both the
+// build and the probe side are partitioned to disk on the hash of their
+// join keys, so that matching items always land in the same partition.
+// Once the probe side is exhausted, each partition pair is joined in turn,
+// with only one partition of the build side in memory at any one time.
+//
+// With a skewed key distribution, a build partition can still be too large
+// for memory: such partition pairs are partitioned again, on a different
+// hash. When that does not help, because most of the partition shares the
+// same join key, or the maximum depth has been reached, the probe partition
+// is joined with a block nested loop: the build partition is loaded one part
+// that fits in memory at a time, and the whole probe partition is matched
+// against each part in turn. Until the last part, the probe items are written
+// out again, together with what they have matched so far, which is needed for
+// outer joins and for nests.
+
+import (
+	"io"
+
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/util"
+	"github.com/couchbase/query/value"
+)
+
+// oversized partitions are partitioned again at most this many times
+const _MAX_SPILL_DEPTH = 3
+
+// an item is probed against each part of the build side in turn: unless the
+// build side has outgrown memory, there is only one part, and matches is nil
+type hashProbe func(item value.AnnotatedValue, matches *hashMatches, last bool) bool
+
+// what a probe item has matched in the parts of the build side seen so far
+type hashMatches struct {
+	matched bool
+	items   value.AnnotatedValues
+}
+
+type hashMatchesHeader struct {
+	Matched bool `json:"m"`
+	Items   int  `json:"n"`
+}
+
+func (this *hashMatches) write(file *spillFile, parent value.Value) error {
+	err := file.write(&hashMatchesHeader{Matched: this.matched, Items: len(this.items)})
+	for _, item := range this.items {
+		if err != nil {
+			break
+		}
+		err = file.writeItem(item, parent)
+	}
+	return err
+}
+
+func (this *hashMatches) read(file *spillFile, parent value.Value) error {
+	var header hashMatchesHeader
+
+	ok, err := file.read(&header)
+	if err == nil && !ok {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	this.matched = header.Matched
+	this.items = make(value.AnnotatedValues, 0, header.Items)
+	for i := 0; i < header.Items; i++ {
+		item, err := file.readItem(parent)
+		if err == nil && item == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		this.items = append(this.items, item)
+	}
+	return nil
+}
+
+func (this *hashMatches) size() uint64 {
+	var size uint64
+	for _, item := range this.items {
+		size += item.Size()
+	}
+	return size
+}
+
+// the hash value of an item, on either side of the join
+type hashValue func(item value.AnnotatedValue, exprs expression.Expressions,
+	vals value.Values, context *Context) value.Value
+
+type hashSpill struct {
+	op         string
+	parent     value.Value
+	partitions int
+	build      []*spillFile
+	probe      []*spillFile
+}
+
+func (this *hashSpill) init(op string, parent value.Value, partitions int) {
+	this.release()
+	this.op = op
+	this.parent = parent
+	this.partitions = partitions
+}
+
+func (this *hashSpill) spilled() bool {
+	return this.build != nil
+}
+
+// move the hash table to disk, further build and probe items will follow
+func (this *hashSpill) start(hashTab *util.HashTable, buildExprs expression.Expressions,
+	buildVals value.Values, context *Context) bool {
+
+	var err error
+
+	this.build, err = newSpillPartitions("hash", this.partitions)
+	if err == nil {
+		this.probe, err = newSpillPartitions("hash", this.partitions)
+	}
+	if err != nil {
+		context.Error(errors.NewSpillError(err, this.op))
+		return false
+	}
+
+	for item := hashTab.Iterate(); item != nil; item = hashTab.Iterate() {
+		av, ok := item.(value.AnnotatedValue)
+		if !ok {
+			context.Error(errors.NewExecutionInternalError("Hash Table Iterate produced non-Annotated value"))
+			return false
+		}
+		buildVal := getBuildVal(av, buildExprs, buildVals, context)
+		if buildVal == nil || !this.write(this.build, buildVal, av, 0, context) {
+			return false
+		}
+	}
+
+	if context.UseRequestQuota() {
+		context.ReleaseValueSize(hashTab.Size())
+	}
+	hashTab.Drop()
+	return true
+}
+
+func (this *hashSpill) addBuild(item value.AnnotatedValue, buildVal value.Value, context *Context) bool {
+	if !this.write(this.build, buildVal, item, 0, context) {
+		return false
+	}
+	if context.UseRequestQuota() {
+		context.ReleaseValueSize(item.Size())
+	}
+	return true
+}
+
+func (this *hashSpill) addProbe(item value.AnnotatedValue, probeExprs expression.Expressions,
+	probeVals value.Values, context *Context) bool {
+
+	probeVal := getProbeVal(item, probeExprs, probeVals, context)
+	if probeVal == nil || !this.write(this.probe, probeVal, item, 0, context) {
+		return false
+	}
+	if context.UseRequestQuota() {
+		context.ReleaseValueSize(item.Size())
+	}
+	return true
+}
+
+func (this *hashSpill) write(partitions []*spillFile, hashVal value.Value, item value.AnnotatedValue,
+	depth int, context *Context) bool {
+
+	bytes, err := value.MarshalValue(hashVal)
+	if err == nil {
+		err = partitions[spillPartition(bytes, len(partitions), depth)].writeItem(item, this.parent)
+	}
+	if err != nil {
+		context.Error(errors.NewSpillError(err, this.op))
+		return false
+	}
+	return true
+}
+
+// the state of the join of the spilled partitions
+type hashSpillJoin struct {
+	*hashSpill
+	op         *base
+	hashTab    *util.HashTable
+	buildExprs expression.Expressions
+	buildVals  value.Values
+	probeExprs expression.Expressions
+	probeVals  value.Values
+	probe      hashProbe
+	context    *Context
+}
+
+// join each build partition with the matching probe partition
+func (this *hashSpill) join(op *base, hashTab *util.HashTable, buildExprs expression.Expressions,
+	buildVals value.Values, probeExprs expression.Expressions, probeVals value.Values,
+	probe hashProbe, context *Context) bool {
+
+	defer this.release()
+
+	for _, partitions := range [][]*spillFile{this.build, this.probe} {
+		err := rewindSpillPartitions(op, partitions, context)
+		if err != nil {
+			context.Error(errors.NewSpillError(err, this.op))
+			return false
+		}
+	}
+
+	join := &hashSpillJoin{
+		hashSpill:  this,
+		op:         op,
+		hashTab:    hashTab,
+		buildExprs: buildExprs,
+		buildVals:  buildVals,
+		probeExprs: probeExprs,
+		probeVals:  probeVals,
+		probe:      probe,
+		context:    context,
+	}
+	return join.join(this.build, this.probe, 0)
+}
+
+func (this *hashSpillJoin) join(build, probe []*spillFile, depth int) bool {
+	for p := range build {
+		full, ok := this.load(build[p])
+		if !ok {
+			return false
+		}
+		if full {
+			if !this.split(build[p], probe[p], depth+1) {
+				return false
+			}
+			continue
+		}
+		if !this.read(probe[p]) {
+			return false
+		}
+		this.dropHashTable()
+	}
+	return true
+}
+
+// join a partition pair whose build side does not fit in memory
+func (this *hashSpillJoin) split(build, probe *spillFile, depth int) bool {
+	if depth > _MAX_SPILL_DEPTH {
+		this.dropHashTable()
+		return this.nestedLoop(build, probe)
+	}
+
+	subBuild, err := newSpillPartitions("hash", this.partitions)
+	if err != nil {
+		this.context.Error(errors.NewSpillError(err, this.hashSpill.op))
+		return false
+	}
+	defer releaseSpillPartitions(subBuild)
+
+	// what has been loaded so far, then the rest of the partition
+	for item := this.hashTab.Iterate(); item != nil; item = this.hashTab.Iterate() {
+		av, ok := item.(value.AnnotatedValue)
+		if !ok {
+			this.context.Error(errors.NewExecutionInternalError("Hash Table Iterate produced non-Annotated value"))
+			return false
+		}
+		if !this.copyItem(av, subBuild, getBuildVal, this.buildExprs, this.buildVals, depth) {
+			return false
+		}
+	}
+	this.dropHashTable()
+	if !this.copy(build, subBuild, getBuildVal, this.buildExprs, this.buildVals, depth) {
+		return false
+	}
+
+	// all items share a hash, and would land in the same partition again
+	for _, file := range subBuild {
+		if file.count == build.count {
+			return this.nestedLoop(build, probe)
+		}
+	}
+
+	subProbe, err := newSpillPartitions("hash", this.partitions)
+	if err != nil {
+		this.context.Error(errors.NewSpillError(err, this.hashSpill.op))
+		return false
+	}
+	defer releaseSpillPartitions(subProbe)
+
+	if !this.copy(probe, subProbe, getProbeVal, this.probeExprs, this.probeVals, depth) {
+		return false
+	}
+	for _, partitions := range [][]*spillFile{subBuild, subProbe} {
+		err = rewindSpillPartitions(this.op, partitions, this.context)
+		if err != nil {
+			this.context.Error(errors.NewSpillError(err, this.hashSpill.op))
+			return false
+		}
+	}
+	return this.join(subBuild, subProbe, depth)
+}
+
+// join a build partition, one part that fits in memory at a time,
+// with the whole probe partition
+func (this *hashSpillJoin) nestedLoop(build, probe *spillFile) bool {
+	context := this.context
+	err := build.reread()
+	if err != nil {
+		context.Error(errors.NewSpillError(err, this.hashSpill.op))
+		return false
+	}
+
+	// the probe items, and after the first part what they have matched
+	in := probe
+	defer func() {
+		if in != probe {
+			in.close()
+		}
+	}()
+
+	for {
+		full, ok := this.load(build)
+		if !ok {
+			return false
+		}
+
+		var out *spillFile
+		if full {
+			out, err = newSpillFile("hash")
+			if err != nil {
+				context.Error(errors.NewSpillError(err, this.hashSpill.op))
+				return false
+			}
+		}
+		ok = this.loop(in, out, in != probe)
+		this.dropHashTable()
+		if !ok || !full {
+			if out != nil {
+				out.close()
+			}
+			return ok
+		}
+
+		size, err := out.rewind()
+		if err != nil {
+			out.close()
+			context.Error(errors.NewSpillError(err, this.hashSpill.op))
+			return false
+		}
+		this.op.addSpill(context, size)
+		if in != probe {
+			in.close()
+		}
+		in = out
+	}
+}
+
+// match the probe items against one part of the build partition,
+// and unless it is the last, write them out again for the next part
+func (this *hashSpillJoin) loop(in, out *spillFile, hasMatches bool) bool {
+	context := this.context
+	for {
+		item, err := in.readItem(this.parent)
+		if err != nil {
+			context.Error(errors.NewSpillError(err, this.hashSpill.op))
+			return false
+		} else if item == nil {
+			return true
+		}
+
+		matches := &hashMatches{}
+		if hasMatches {
+			err = matches.read(in, this.parent)
+			if err != nil {
+				context.Error(errors.NewSpillError(err, this.hashSpill.op))
+				return false
+			}
+		}
+
+		// the matches from earlier parts are no longer in the hash table
+		size := item.Size() + matches.size()
+		if context.UseRequestQuota() && context.TrackValueSize(size) {
+			context.Error(errors.NewMemoryQuotaExceededError())
+			return false
+		}
+		if !this.probe(item, matches, out == nil) {
+			return false
+		}
+
+		if out == nil {
+			if context.UseRequestQuota() {
+				context.ReleaseValueSize(size - item.Size())
+			}
+			continue
+		}
+		err = out.writeItem(item, this.parent)
+		if err == nil {
+			err = matches.write(out, this.parent)
+		}
+		if err != nil {
+			context.Error(errors.NewSpillError(err, this.hashSpill.op))
+			return false
+		}
+		if context.UseRequestQuota() {
+			context.ReleaseValueSize(size)
+		}
+	}
+}
+
+// bring a build partition back into the hash table,
+// returns true if it had to stop before the end, for lack of memory
+func (this *hashSpillJoin) load(partition *spillFile) (bool, bool) {
+	context := this.context
+	for {
+		item, err := partition.readItem(this.parent)
+		if err != nil {
+			context.Error(errors.NewSpillError(err, this.hashSpill.op))
+			return false, false
+		} else if item == nil {
+			return false, true
+		}
+
+		buildVal := getBuildVal(item, this.buildExprs, this.buildVals, context)
+		if buildVal == nil {
+			return false, false
+		}
+		size := item.Size()
+		if context.UseRequestQuota() && context.TrackValueSize(size) {
+			context.Error(errors.NewMemoryQuotaExceededError())
+			return false, false
+		}
+		err = this.hashTab.Put(buildVal, item, value.MarshalValue, value.EqualValue, size)
+		if err != nil {
+			context.Error(errors.NewHashTablePutError(err))
+			return false, false
+		}
+		if shouldSpill(context, this.hashTab.Size()) {
+			return true, true
+		}
+	}
+}
+
+// feed a probe partition to the operator
+func (this *hashSpillJoin) read(partition *spillFile) bool {
+	context := this.context
+	for {
+		item, err := partition.readItem(this.parent)
+		if err != nil {
+			context.Error(errors.NewSpillError(err, this.hashSpill.op))
+			return false
+		} else if item == nil {
+			return true
+		}
+
+		if context.UseRequestQuota() && context.TrackValueSize(item.Size()) {
+			context.Error(errors.NewMemoryQuotaExceededError())
+			return false
+		}
+		if !this.probe(item, nil, true) {
+			return false
+		}
+	}
+}
+
+// partition the rest of a spill file again
+func (this *hashSpillJoin) copy(from *spillFile, to []*spillFile, hashVal hashValue,
+	exprs expression.Expressions, vals value.Values, depth int) bool {
+
+	for {
+		item, err := from.readItem(this.parent)
+		if err != nil {
+			this.context.Error(errors.NewSpillError(err, this.hashSpill.op))
+			return false
+		} else if item == nil {
+			return true
+		}
+		if !this.copyItem(item, to, hashVal, exprs, vals, depth) {
+			return false
+		}
+	}
+}
+
+func (this *hashSpillJoin) copyItem(item value.AnnotatedValue, to []*spillFile, hashVal hashValue,
+	exprs expression.Expressions, vals value.Values, depth int) bool {
+
+	val := hashVal(item, exprs, vals, this.context)
+	return val != nil && this.write(to, val, item, depth, this.context)
+}
+
+func (this *hashSpillJoin) dropHashTable() {
+	if this.context.UseRequestQuota() {
+		this.context.ReleaseValueSize(this.hashTab.Size())
+	}
+	this.hashTab.Drop()
+}
+
+func (this *hashSpill) release() {
+	releaseSpillPartitions(this.build)
+	releaseSpillPartitions(this.probe)
+	this.build = nil
+	this.probe = nil
+	this.parent = nil
+}
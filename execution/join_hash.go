@@ -28,6 +28,7 @@ type HashJoin struct {
 	hashTab   *util.HashTable
 	buildVals value.Values
 	probeVals value.Values
+	spill     hashSpill
 }
 
 func NewHashJoin(plan *plan.HashJoin, context *Context, child Operator, aliasMap map[string]string) *HashJoin {
@@ -63,6 +64,7 @@ func (this *HashJoin) PlanOp() plan.Operator {
 }
 
 func (this *HashJoin) RunOnce(context *Context, parent value.Value) {
+	defer this.spill.release()
 	this.runConsumer(this, context, parent)
 }
 
@@ -92,6 +94,7 @@ func (this *HashJoin) beforeItems(context *Context, parent value.Value) bool {
 
 	this.buildVals = make(value.Values, len(this.plan.BuildExprs()))
 	this.probeVals = make(value.Values, len(this.plan.ProbeExprs()))
+	this.spill.init("hash join", parent, this.plan.SpillPartitions())
 
 	this.child.SetOutput(this.child)
 	this.child.SetInput(nil)
@@ -101,14 +104,14 @@ func (this *HashJoin) beforeItems(context *Context, parent value.Value) bool {
 	this.fork(this.child, context, parent)
 
 	ok := buildHashTab(&(this.base), this.child, this.hashTab,
-		this.plan.BuildExprs(), this.buildVals, context)
+		this.plan.BuildExprs(), this.buildVals, &this.spill, context)
 	if !ok {
 		return false
 	}
 
 	// if the build side is empty and this is not an outer join,
 	// no need to activate the probe side.
-	if this.hashTab.Count() == 0 && !this.plan.Outer() {
+	if this.hashTab.Count() == 0 && !this.plan.Outer() && !this.spill.spilled() {
 		return false
 	}
 
@@ -116,32 +119,32 @@ func (this *HashJoin) beforeItems(context *Context, parent value.Value) bool {
 }
 
 func buildHashTab(base *base, buildOp Operator, hashTab *util.HashTable,
-	buildExprs expression.Expressions, buildVals value.Values, context *Context) bool {
+	buildExprs expression.Expressions, buildVals value.Values, spill *hashSpill, context *Context) bool {
 	var err error
 	stopped := false
 	n := 1
+	sized := canSpill(context)
 
 loop:
 	for {
 		build_item, child, cont := base.getItemChildrenOp(buildOp)
 		if cont {
 			if build_item != nil {
-				for i, be := range buildExprs {
-					buildVals[i], err = be.Evaluate(build_item, context)
-					if err != nil {
-						context.Error(errors.NewEvaluationError(err, "Hash Table Build Expression"))
+				buildVal := getBuildVal(build_item, buildExprs, buildVals, context)
+				if buildVal == nil {
+					return false
+				}
+
+				if spill.spilled() {
+					if !spill.addBuild(build_item, buildVal, context) {
 						return false
 					}
+					continue
 				}
-				var buildVal value.Value
+
 				var size uint64
 
-				if len(buildVals) == 1 {
-					buildVal = buildVals[0]
-				} else {
-					buildVal = value.NewValue(buildVals)
-				}
-				if context.UseRequestQuota() {
+				if sized {
 					size = build_item.Size()
 				}
 
@@ -150,6 +153,11 @@ loop:
 					context.Error(errors.NewHashTablePutError(err))
 					return false
 				}
+
+				if sized && shouldSpill(context, hashTab.Size()) &&
+					!spill.start(hashTab, buildExprs, buildVals, context) {
+					return false
+				}
 			} else if child >= 0 {
 				n--
 			} else {
@@ -173,6 +181,25 @@ loop:
 	return true
 }
 
+func getBuildVal(item value.AnnotatedValue, buildExprs expression.Expressions,
+	buildVals value.Values, context *Context) value.Value {
+
+	var err error
+	for i, be := range buildExprs {
+		buildVals[i], err = be.Evaluate(item, context)
+		if err != nil {
+			context.Error(errors.NewEvaluationError(err, "Hash Table Build Expression"))
+			return nil
+		}
+	}
+
+	if len(buildVals) == 1 {
+		return buildVals[0]
+	} else {
+		return value.NewValue(buildVals)
+	}
+}
+
 func getProbeVal(item value.AnnotatedValue, probeExprs expression.Expressions,
 	probeVals value.Values, context *Context) value.Value {
 
@@ -193,12 +220,20 @@ func getProbeVal(item value.AnnotatedValue, probeExprs expression.Expressions,
 }
 
 func (this *HashJoin) processItem(item value.AnnotatedValue, context *Context) bool {
+	if this.spill.spilled() {
+		return this.spill.addProbe(item, this.plan.ProbeExprs(), this.probeVals, context)
+	}
+	return this.probe(item, nil, true, context)
+}
+
+func (this *HashJoin) probe(item value.AnnotatedValue, matches *hashMatches, last bool, context *Context) bool {
 	defer this.switchPhase(_EXECTIME)
 
 	var err error
 	var outVal interface{}
 	ok := true
-	matched := false
+
+	matched := matches != nil && matches.matched
 
 	probeVal := getProbeVal(item, this.plan.ProbeExprs(), this.probeVals, context)
 	if probeVal == nil {
@@ -235,6 +270,11 @@ func (this *HashJoin) processItem(item value.AnnotatedValue, context *Context) b
 		}
 	}
 
+	if !last {
+		matches.matched = matched
+		return true
+	}
+
 	if this.plan.Outer() && !matched {
 		return this.checkSendItem(item, func() uint64 {
 			return 0
@@ -248,6 +288,13 @@ func (this *HashJoin) processItem(item value.AnnotatedValue, context *Context) b
 }
 
 func (this *HashJoin) afterItems(context *Context) {
+	if this.spill.spilled() && !this.stopped {
+		this.spill.join(&this.base, this.hashTab, this.plan.BuildExprs(), this.buildVals,
+			this.plan.ProbeExprs(), this.probeVals,
+			func(item value.AnnotatedValue, matches *hashMatches, last bool) bool {
+				return this.probe(item, matches, last, context)
+			}, context)
+	}
 	this.dropHashTable(context)
 	this.plan.Onclause().ResetMemory(context)
 }
@@ -27,6 +27,7 @@ type HashNest struct {
 	hashTab   *util.HashTable
 	buildVals value.Values
 	probeVals value.Values
+	spill     hashSpill
 }
 
 func NewHashNest(plan *plan.HashNest, context *Context, child Operator, aliasMap map[string]string) *HashNest {
@@ -62,6 +63,7 @@ func (this *HashNest) PlanOp() plan.Operator {
 }
 
 func (this *HashNest) RunOnce(context *Context, parent value.Value) {
+	defer this.spill.release()
 	this.runConsumer(this, context, parent)
 }
 
@@ -91,6 +93,7 @@ func (this *HashNest) beforeItems(context *Context, parent value.Value) bool {
 
 	this.buildVals = make(value.Values, len(this.plan.BuildExprs()))
 	this.probeVals = make(value.Values, len(this.plan.ProbeExprs()))
+	this.spill.init("hash nest", parent, this.plan.SpillPartitions())
 
 	this.child.SetOutput(this.child)
 	this.child.SetInput(nil)
@@ -100,10 +103,17 @@ func (this *HashNest) beforeItems(context *Context, parent value.Value) bool {
 	this.fork(this.child, context, parent)
 
 	return buildHashTab(&(this.base), this.child, this.hashTab,
-		this.plan.BuildExprs(), this.buildVals, context)
+		this.plan.BuildExprs(), this.buildVals, &this.spill, context)
 }
 
 func (this *HashNest) processItem(item value.AnnotatedValue, context *Context) bool {
+	if this.spill.spilled() {
+		return this.spill.addProbe(item, this.plan.ProbeExprs(), this.probeVals, context)
+	}
+	return this.probe(item, nil, true, context)
+}
+
+func (this *HashNest) probe(item value.AnnotatedValue, matches *hashMatches, last bool, context *Context) bool {
 	defer this.switchPhase(_EXECTIME)
 
 	var err error
@@ -111,6 +121,10 @@ func (this *HashNest) processItem(item value.AnnotatedValue, context *Context) b
 	var right_items value.AnnotatedValues
 	ok := true
 
+	if matches != nil {
+		right_items = matches.items
+	}
+
 	probeVal := getProbeVal(item, this.plan.ProbeExprs(), this.probeVals, context)
 	if probeVal == nil {
 		return false
@@ -141,6 +155,11 @@ func (this *HashNest) processItem(item value.AnnotatedValue, context *Context) b
 		}
 	}
 
+	if !last {
+		matches.items = right_items
+		return true
+	}
+
 	var joined value.AnnotatedValue
 	joined, ok = processAnsiNest(item, right_items, this.plan.BuildAlias(), this.plan.Outer(), context)
 	if !ok {
@@ -177,6 +196,13 @@ func (this *HashNest) processItem(item value.AnnotatedValue, context *Context) b
 }
 
 func (this *HashNest) afterItems(context *Context) {
+	if this.spill.spilled() && !this.stopped {
+		this.spill.join(&this.base, this.hashTab, this.plan.BuildExprs(), this.buildVals,
+			this.plan.ProbeExprs(), this.probeVals,
+			func(item value.AnnotatedValue, matches *hashMatches, last bool) bool {
+				return this.probe(item, matches, last, context)
+			}, context)
+	}
 	this.dropHashTable(context)
 	this.plan.Onclause().ResetMemory(context)
 }
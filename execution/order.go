@@ -10,8 +10,11 @@
 package execution
 
 import (
+	"container/heap"
 	"encoding/json"
+	"io"
 
+	"github.com/couchbase/query/errors"
 	"github.com/couchbase/query/plan"
 	"github.com/couchbase/query/sort"
 	"github.com/couchbase/query/value"
@@ -22,11 +25,17 @@ type Order struct {
 	plan    *plan.Order
 	values  value.AnnotatedValues
 	context *Context
+	parent  value.Value
 	terms   []string
+	size    uint64
+	runs    []*spillFile
 }
 
 const _ORDER_CAP = 1024
 
+// maximum number of spilled runs kept before they are merged into one
+const _ORDER_MERGE_WAYS = 64
+
 var _ORDER_POOL = value.NewAnnotatedPool(_ORDER_CAP)
 
 func NewOrder(plan *plan.Order, context *Context) *Order {
@@ -60,9 +69,15 @@ func (this *Order) PlanOp() plan.Operator {
 
 func (this *Order) RunOnce(context *Context, parent value.Value) {
 	defer this.releaseValues()
+	defer this.releaseRuns()
 	this.runConsumer(this, context, parent)
 }
 
+func (this *Order) beforeItems(context *Context, parent value.Value) bool {
+	this.parent = parent
+	return true
+}
+
 func (this *Order) processItem(item value.AnnotatedValue, context *Context) bool {
 	if len(this.values) == cap(this.values) {
 		values := make(value.AnnotatedValues, len(this.values), len(this.values)<<1)
@@ -72,6 +87,65 @@ func (this *Order) processItem(item value.AnnotatedValue, context *Context) bool
 	}
 
 	this.values = append(this.values, item)
+
+	if canSpill(context) {
+		this.size += item.Size()
+		if shouldSpill(context, this.size) {
+			return this.spill(context)
+		}
+	}
+	return true
+}
+
+// sort the buffered items and move them to a new run on disk
+func (this *Order) spill(context *Context) bool {
+	if this.terms == nil {
+		this.setupTerms(context)
+	}
+	sort.Sort(this)
+
+	run, err := newSpillFile("sort")
+	if err == nil {
+		for _, av := range this.values {
+			var keys []json.RawMessage
+
+			keys, err = this.encodeKeys(av)
+			if err == nil {
+				err = this.write(run, keys, av)
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	var size int64
+	if err == nil {
+		size, err = run.rewind()
+	}
+	if err != nil {
+		if run != nil {
+			run.close()
+		}
+		context.Error(errors.NewSpillError(err, "sort"))
+		return false
+	}
+
+	this.runs = append(this.runs, run)
+	this.addSpill(context, size)
+	if len(this.runs) >= _ORDER_MERGE_WAYS && !this.compact(context) {
+		return false
+	}
+
+	useQuota := context.UseRequestQuota()
+	for i, av := range this.values {
+		if useQuota {
+			context.ReleaseValueSize(av.Size())
+		}
+		av.Recycle()
+		this.values[i] = nil
+	}
+	this.values = this.values[0:0]
+	this.size = 0
 	return true
 }
 
@@ -87,6 +161,7 @@ func (this *Order) afterItems(context *Context) {
 	defer this.releaseValues()
 	defer func() {
 		this.context = nil
+		this.parent = nil
 		this.terms = nil
 	}()
 
@@ -98,6 +173,11 @@ func (this *Order) afterItems(context *Context) {
 	this.setupTerms(context)
 	sort.Sort(this)
 
+	if len(this.runs) > 0 {
+		this.merge(context)
+		return
+	}
+
 	context.SetSortCount(uint64(this.Len()))
 	context.AddPhaseCount(SORT, uint64(this.Len()))
 
@@ -108,9 +188,90 @@ func (this *Order) afterItems(context *Context) {
 	}
 }
 
+// merge all the runs on disk into a single one, to limit open files
+func (this *Order) compact(context *Context) bool {
+	var size int64
+	var m *orderMerge
+
+	out, err := newSpillFile("sort")
+	if err == nil {
+		m, err = newOrderMerge(this, this.runs, nil)
+	}
+	for err == nil && m.Len() > 0 {
+		run := m.runs[0]
+		err = this.write(out, run.raw, run.item)
+		if err == nil {
+			err = m.advance()
+		}
+	}
+	if err == nil {
+		size, err = out.rewind()
+	}
+	if err != nil {
+		if out != nil {
+			out.close()
+		}
+		context.Error(errors.NewSpillError(err, "sort"))
+		return false
+	}
+
+	this.releaseRuns()
+	this.runs = append(this.runs, out)
+	this.addSpill(context, size)
+	return true
+}
+
+// merge the runs on disk with the sorted in memory remainder
+func (this *Order) merge(context *Context) {
+	var count uint64
+
+	defer func() {
+		context.SetSortCount(count)
+		context.AddPhaseCount(SORT, count)
+	}()
+
+	m, err := newOrderMerge(this, this.runs, this.values)
+	if err != nil {
+		context.Error(errors.NewSpillError(err, "sort"))
+		return
+	}
+
+	useQuota := context.UseRequestQuota()
+	for m.Len() > 0 {
+		run := m.runs[0]
+		item := run.item
+		if run.file != nil {
+			if useQuota && context.TrackValueSize(item.Size()) {
+				context.Error(errors.NewMemoryQuotaExceededError())
+				item.Recycle()
+				return
+			}
+		}
+
+		count++
+		if !this.sendItem(item) {
+			return
+		}
+
+		err = m.advance()
+		if err != nil {
+			context.Error(errors.NewSpillError(err, "sort"))
+			return
+		}
+	}
+}
+
 func (this *Order) releaseValues() {
 	_ORDER_POOL.Put(this.values)
 	this.values = nil
+	this.size = 0
+}
+
+func (this *Order) releaseRuns() {
+	for _, run := range this.runs {
+		run.close()
+	}
+	this.runs = nil
 }
 
 func (this *Order) Len() int {
@@ -139,22 +300,8 @@ func (this *Order) lessThan(v1 value.AnnotatedValue, v2 value.AnnotatedValue) bo
 			return false
 		}
 
-		if !term.NullsPos() || ((ev1.Type() <= value.NULL && ev2.Type() <= value.NULL) ||
-			(ev1.Type() > value.NULL && ev2.Type() > value.NULL)) {
-			c = ev1.Collate(ev2)
-		} else {
-			if ev1.Type() <= value.NULL && ev2.Type() > value.NULL {
-				c = 1
-			} else {
-				c = -1
-			}
-		}
-
-		if c == 0 {
-			continue
-		} else if term.Descending() {
-			return c > 0
-		} else {
+		c = term.Compare(ev1, ev2)
+		if c != 0 {
 			return c < 0
 		}
 	}
@@ -166,6 +313,154 @@ func (this *Order) Swap(i, j int) {
 	this.values[i], this.values[j] = this.values[j], this.values[i]
 }
 
+// a spilled item is its sort keys, followed by the item itself
+func (this *Order) encodeKeys(item value.AnnotatedValue) ([]json.RawMessage, error) {
+	rv := make([]json.RawMessage, len(this.terms))
+	for i, term := range this.plan.Terms() {
+		key, err := getOriginalCachedValue(item, term.Expression(), this.terms[i], this.context)
+		if err == nil {
+			rv[i], err = value.EncodeRaw(key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rv, nil
+}
+
+func (this *Order) write(run *spillFile, keys []json.RawMessage, item value.AnnotatedValue) error {
+	err := run.write(keys)
+	if err == nil {
+		err = run.writeItem(item, this.parent)
+	}
+	return err
+}
+
+// a sorted run, either spilled or the in memory remainder
+type orderRun struct {
+	file   *spillFile
+	values value.AnnotatedValues
+	next   int
+	keys   value.Values
+	raw    []json.RawMessage
+	item   value.AnnotatedValue
+}
+
+// move to the next item in the run, false once exhausted
+func (this *orderRun) advance(order *Order) (bool, error) {
+	if this.file == nil {
+		if this.next >= len(this.values) {
+			return false, nil
+		}
+		this.item = this.values[this.next]
+		this.next++
+		this.keys = this.keys[0:0]
+		for i, term := range order.plan.Terms() {
+			key, err := getOriginalCachedValue(this.item, term.Expression(), order.terms[i], order.context)
+			if err != nil {
+				return false, err
+			}
+			this.keys = append(this.keys, key)
+		}
+		return true, nil
+	}
+
+	this.raw = nil
+	ok, err := this.file.read(&this.raw)
+	if !ok {
+		return false, err
+	}
+	this.item, err = this.file.readItem(order.parent)
+	if err == nil && this.item == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return false, err
+	}
+	this.keys = this.keys[0:0]
+	for _, key := range this.raw {
+		this.keys = append(this.keys, value.DecodeRaw(key))
+	}
+	return true, nil
+}
+
+// min heap over the current item of each run
+type orderMerge struct {
+	order *Order
+	runs  []*orderRun
+}
+
+func newOrderMerge(order *Order, files []*spillFile, values value.AnnotatedValues) (*orderMerge, error) {
+	rv := &orderMerge{
+		order: order,
+		runs:  make([]*orderRun, 0, len(files)+1),
+	}
+
+	runs := make([]*orderRun, 0, len(files)+1)
+	for _, file := range files {
+		runs = append(runs, &orderRun{file: file})
+	}
+	if len(values) > 0 {
+		runs = append(runs, &orderRun{values: values})
+	}
+	for _, run := range runs {
+		ok, err := run.advance(order)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rv.runs = append(rv.runs, run)
+		}
+	}
+	heap.Init(rv)
+	return rv, nil
+}
+
+// move the head run forward, dropping it once exhausted
+func (this *orderMerge) advance() error {
+	ok, err := this.runs[0].advance(this.order)
+	if err != nil {
+		return err
+	}
+	if ok {
+		heap.Fix(this, 0)
+	} else {
+		heap.Pop(this)
+	}
+	return nil
+}
+
+func (this *orderMerge) Len() int {
+	return len(this.runs)
+}
+
+func (this *orderMerge) Less(i, j int) bool {
+	k1 := this.runs[i].keys
+	k2 := this.runs[j].keys
+	for t, term := range this.order.plan.Terms() {
+		c := term.Compare(k1[t], k2[t])
+		if c != 0 {
+			return c < 0
+		}
+	}
+	return false
+}
+
+func (this *orderMerge) Swap(i, j int) {
+	this.runs[i], this.runs[j] = this.runs[j], this.runs[i]
+}
+
+func (this *orderMerge) Push(item interface{}) {
+	this.runs = append(this.runs, item.(*orderRun))
+}
+
+func (this *orderMerge) Pop() interface{} {
+	index := len(this.runs) - 1
+	run := this.runs[index]
+	this.runs = this.runs[0:index]
+	return run
+}
+
 func (this *Order) MarshalJSON() ([]byte, error) {
 	r := this.plan.MarshalBase(func(r map[string]interface{}) {
 		this.marshalTimes(r)
@@ -176,5 +471,6 @@ func (this *Order) MarshalJSON() ([]byte, error) {
 func (this *Order) reopen(context *Context) bool {
 	rv := this.baseReopen(context)
 	this.values = _ORDER_POOL.Get()
+	this.releaseRuns()
 	return rv
 }
@@ -91,6 +91,7 @@ func (this *OrderLimit) PlanOp() plan.Operator {
 
 func (this *OrderLimit) RunOnce(context *Context, parent value.Value) {
 	defer this.releaseValues()
+	defer this.releaseRuns()
 	this.runConsumer(this, context, parent)
 }
 
@@ -183,7 +184,7 @@ func (this *OrderLimit) afterItems(context *Context) {
 	if this.offset != nil {
 		offset = this.offset.offset
 	}
-	if offset >= int64(len) {
+	if offset >= int64(len) && this.runs == nil {
 		this.values = this.values[0:0]
 	}
 
@@ -0,0 +1,223 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package execution
+
+// Operators that need to hold their whole input before producing any
+// output (sorts, hash tables, groups) can move part of it to temporary
+// files once it grows past the spill threshold, or past half of the
+// request memory quota, rather than failing the request.
+//
+// Spill files are sequences of newline separated JSON records, written
+// once, then rewound and read back once, and removed on close.
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	atomic "github.com/couchbase/go-couchbase/platform"
+	"github.com/couchbase/query/util"
+	"github.com/couchbase/query/value"
+)
+
+const _SPILL_BUFFER_SIZE = 64 * 1024
+
+var spillThreshold atomic.AlignedInt64
+var spillDirectory string
+var spillDirectoryLock sync.RWMutex
+
+// threshold in bytes, 0 to only spill on memory quota
+func SetSpillThreshold(threshold int64) {
+	if threshold < 0 {
+		threshold = 0
+	}
+	atomic.StoreInt64(&spillThreshold, threshold)
+}
+
+func GetSpillThreshold() int64 {
+	return atomic.LoadInt64(&spillThreshold)
+}
+
+// empty directory means the system temporary directory
+func SetSpillDirectory(dir string) {
+	spillDirectoryLock.Lock()
+	spillDirectory = dir
+	spillDirectoryLock.Unlock()
+}
+
+func GetSpillDirectory() string {
+	spillDirectoryLock.RLock()
+	dir := spillDirectory
+	spillDirectoryLock.RUnlock()
+	if dir == "" {
+		return os.TempDir()
+	}
+	return dir
+}
+
+// whether values need to be sized at all
+func canSpill(context *Context) bool {
+	return GetSpillThreshold() > 0 || context.UseRequestQuota()
+}
+
+// whether size bytes of buffered values should be moved to disk
+func shouldSpill(context *Context, size uint64) bool {
+	threshold := GetSpillThreshold()
+	if threshold > 0 && size > uint64(threshold) {
+		return true
+	}
+	return context.UseRequestQuota() && size > context.GetMemoryQuota()/2
+}
+
+// account for a spill in the operator profile and the request phase counts
+func (this *base) addSpill(context *Context, bytes int64) {
+	this.spills++
+	this.spillBytes += bytes
+	context.AddPhaseCount(SPILL, 1)
+	context.AddPhaseCount(SPILL_BYTES, uint64(bytes))
+}
+
+type spillFile struct {
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *json.Encoder
+	decoder *json.Decoder
+	count   int // records written
+}
+
+func newSpillFile(op string) (*spillFile, error) {
+	file, err := ioutil.TempFile(GetSpillDirectory(), "n1ql-"+op+"-")
+	if err != nil {
+		return nil, err
+	}
+	rv := &spillFile{
+		file:   file,
+		writer: bufio.NewWriterSize(file, _SPILL_BUFFER_SIZE),
+	}
+	rv.encoder = json.NewEncoder(rv.writer)
+	return rv, nil
+}
+
+func (this *spillFile) write(rec interface{}) error {
+	this.count++
+	return this.encoder.Encode(rec)
+}
+
+// switch from writing to reading, returns the file size
+func (this *spillFile) rewind() (int64, error) {
+	err := this.writer.Flush()
+	if err != nil {
+		return 0, err
+	}
+	size, err := this.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	this.writer = nil
+	this.encoder = nil
+	return size, this.reread()
+}
+
+// read the records again, from the first
+func (this *spillFile) reread() error {
+	_, err := this.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	this.decoder = json.NewDecoder(bufio.NewReaderSize(this.file, _SPILL_BUFFER_SIZE))
+	return nil
+}
+
+// false once all records have been read
+func (this *spillFile) read(rec interface{}) (bool, error) {
+	err := this.decoder.Decode(rec)
+	if err == io.EOF {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// annotated values keep their annotations, and are reattached to parent when read
+func (this *spillFile) writeItem(item value.AnnotatedValue, parent value.Value) error {
+	data, err := value.MarshalAnnotated(item, parent)
+	if err != nil {
+		return err
+	}
+	return this.write(json.RawMessage(data))
+}
+
+// nil once all items have been read
+func (this *spillFile) readItem(parent value.Value) (value.AnnotatedValue, error) {
+	var data json.RawMessage
+
+	ok, err := this.read(&data)
+	if !ok {
+		return nil, err
+	}
+	return value.UnmarshalAnnotated(data, parent)
+}
+
+func (this *spillFile) close() {
+	name := this.file.Name()
+	this.file.Close()
+	os.Remove(name)
+}
+
+// a set of spill files, items are assigned to one on the hash of a key
+func newSpillPartitions(op string, n int) ([]*spillFile, error) {
+	rv := make([]*spillFile, n)
+	for i := range rv {
+		file, err := newSpillFile(op)
+		if err != nil {
+			for _, file = range rv[0:i] {
+				file.close()
+			}
+			return nil, err
+		}
+		rv[i] = file
+	}
+	return rv, nil
+}
+
+// in memory hash tables use the low bits of the same hash,
+// partitions split again at a greater depth use a different hash
+func spillPartition(key []byte, n int, depth int) int {
+	h := util.SeaHashSum64(key)
+	if depth > 0 {
+		h ^= uint64(depth) * 0x9e3779b97f4a7c15
+		h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+		h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+		h ^= h >> 31
+	}
+	return int((h >> 32) % uint64(n))
+}
+
+// switch all partitions to reading, and account for them in the profile
+func rewindSpillPartitions(op *base, partitions []*spillFile, context *Context) error {
+	for _, file := range partitions {
+		size, err := file.rewind()
+		if err != nil {
+			return err
+		}
+		if size > 0 {
+			op.addSpill(context, size)
+		}
+	}
+	return nil
+}
+
+func releaseSpillPartitions(partitions []*spillFile) {
+	for _, file := range partitions {
+		file.close()
+	}
+}
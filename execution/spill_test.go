@@ -0,0 +1,261 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package execution
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/couchbase/query/algebra"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/plan"
+	"github.com/couchbase/query/util"
+	"github.com/couchbase/query/value"
+)
+
+func TestSpillFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatalf("Cannot create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	SetSpillDirectory(dir)
+	defer SetSpillDirectory("")
+
+	vals := []value.Value{
+		value.NewValue(map[string]interface{}{"a": 1, "b": []interface{}{"x", nil}}),
+		value.MISSING_VALUE,
+		value.NULL_VALUE,
+		value.NewValue("text"),
+	}
+
+	file, err := newSpillFile("test")
+	if err != nil {
+		t.Fatalf("Cannot create spill file: %v", err)
+	}
+	for _, v := range vals {
+		raw, err := value.EncodeRaw(v)
+		if err != nil {
+			t.Fatalf("Cannot encode %v: %v", v, err)
+		}
+		if err = file.write(raw); err != nil {
+			t.Fatalf("Cannot write %v: %v", v, err)
+		}
+	}
+	size, err := file.rewind()
+	if err != nil || size == 0 {
+		t.Fatalf("Unexpected rewind size %v error %v", size, err)
+	}
+
+	for _, v := range vals {
+		var raw json.RawMessage
+
+		ok, err := file.read(&raw)
+		if !ok || err != nil {
+			t.Fatalf("Expected %v, got end of file, error %v", v, err)
+		}
+		rv := value.DecodeRaw(raw)
+		if rv.Type() != v.Type() || rv.Collate(v) != 0 {
+			t.Errorf("Expected %v, got %v", v, rv)
+		}
+	}
+	if ok, _ := file.read(&json.RawMessage{}); ok {
+		t.Errorf("Expected end of file")
+	}
+
+	file.close()
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("Spill file not removed")
+	}
+}
+
+func TestHashSpillSkewed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatalf("Cannot create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	SetSpillDirectory(dir)
+	defer SetSpillDirectory("")
+	SetSpillThreshold(4096)
+	defer SetSpillThreshold(0)
+
+	// most of the build side shares one key, the rest spreads over many
+	output := &internalOutput{}
+	context := &Context{output: output}
+	exprs := expression.Expressions{expression.NewIdentifier("k")}
+	vals := make(value.Values, 1)
+	hashTab := util.NewHashTable(util.HASH_TABLE_FOR_HASH_JOIN)
+	expected := make(map[string]int)
+	build := make([]value.AnnotatedValue, 0, 3000)
+	for i := 0; i < 3000; i++ {
+		k := "hot"
+		if i%3 == 0 {
+			k = strconv.Itoa(i)
+		}
+		expected[k]++
+		build = append(build, value.NewAnnotatedValue(map[string]interface{}{"k": k, "i": i}))
+	}
+
+	var spill hashSpill
+	spill.init("test", nil, 32)
+	for _, item := range build {
+		buildVal := getBuildVal(item, exprs, vals, context)
+		if spill.spilled() {
+			if !spill.addBuild(item, buildVal, context) {
+				t.Fatalf("Cannot add build item: %v", output.err)
+			}
+			continue
+		}
+		hashTab.Put(buildVal, item, value.MarshalValue, value.EqualValue, item.Size())
+		if shouldSpill(context, hashTab.Size()) && !spill.start(hashTab, exprs, vals, context) {
+			t.Fatalf("Cannot start spill: %v", output.err)
+		}
+	}
+	for i := 0; i < 3000; i += 100 {
+		for _, k := range []string{"hot", strconv.Itoa(i), "none"} {
+			item := value.NewAnnotatedValue(map[string]interface{}{"k": k})
+			if !spill.addProbe(item, exprs, vals, context) {
+				t.Fatalf("Cannot add probe item: %v", output.err)
+			}
+		}
+	}
+
+	var maxSize uint64
+	matches := make(map[string]int)
+	probed := make(map[string]int)
+	op := &base{}
+	ok := spill.join(op, hashTab, exprs, vals, exprs, vals, func(item value.AnnotatedValue, m *hashMatches, last bool) bool {
+		k, _ := item.Field("k")
+		key := k.Actual().(string)
+		if hashTab.Size() > maxSize {
+			maxSize = hashTab.Size()
+		}
+
+		// what was matched in earlier parts of the build side is carried over
+		var found value.AnnotatedValues
+		if m != nil {
+			if m.matched != (len(m.items) > 0) {
+				t.Errorf("Probe of %v lost its matched state", key)
+			}
+			found = m.items
+		}
+		rv, _ := hashTab.Get(k, value.MarshalValue, value.EqualValue)
+		for rv != nil {
+			found = append(found, rv.(value.AnnotatedValue))
+			rv, _ = hashTab.GetNext()
+		}
+		if !last {
+			m.matched = len(found) > 0
+			m.items = found
+			return true
+		}
+		for _, match := range found {
+			mk, _ := match.Field("k")
+			if mk.Actual() != key {
+				t.Errorf("Probe of %v matched %v", key, mk)
+			}
+		}
+		matches[key] += len(found)
+		probed[key]++
+		return true
+	}, context)
+
+	if !ok || output.err != nil {
+		t.Fatalf("Unexpected join failure: %v", output.err)
+	}
+	if maxSize > 2*4096 {
+		t.Errorf("Build side not kept within the threshold, got %v bytes", maxSize)
+	}
+	for key, n := range probed {
+		if matches[key] != n*expected[key] {
+			t.Errorf("Key %v: expected %v matches, got %v", key, n*expected[key], matches[key])
+		}
+	}
+	if probed["hot"] != 30 || probed["none"] != 30 || matches["none"] != 0 {
+		t.Errorf("Unexpected probes %v", probed)
+	}
+	if op.spills == 0 {
+		t.Errorf("Expected spills in the profile")
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("Spill files not removed")
+	}
+}
+
+func TestOrderSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatalf("Cannot create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	SetSpillDirectory(dir)
+	defer SetSpillDirectory("")
+	SetSpillThreshold(4096)
+	defer SetSpillThreshold(0)
+
+	// documents that have not been projected yet, with their meta data and attachments
+	output := &internalOutput{}
+	context := &Context{output: output}
+	terms := algebra.SortTerms{algebra.NewSortTerm(expression.NewIdentifier("n"), false, false)}
+	order := NewOrder(plan.NewOrder(algebra.NewOrder(terms), nil, nil, 0, 0), context)
+
+	var sorted []value.AnnotatedValue
+	order.doSend = func(this *base, op Operator, item value.AnnotatedValue) bool {
+		sorted = append(sorted, item)
+		return true
+	}
+
+	const n = 2000
+	order.beforeItems(context, nil)
+	for i := 0; i < n; i++ {
+		k := (i * 7919) % n
+		item := value.NewAnnotatedValue(map[string]interface{}{"n": k})
+		item.SetId("doc" + strconv.Itoa(k))
+		item.NewMeta()["cas"] = k
+		item.SetAttachment("tag", k)
+		if !order.processItem(item, context) {
+			t.Fatalf("Cannot sort item: %v", output.err)
+		}
+	}
+	order.afterItems(context)
+	order.releaseRuns()
+
+	if output.err != nil {
+		t.Fatalf("Unexpected sort failure: %v", output.err)
+	}
+	if order.spills == 0 {
+		t.Errorf("Expected spills in the profile")
+	}
+	if len(sorted) != n {
+		t.Fatalf("Expected %v items, got %v", n, len(sorted))
+	}
+	for i, item := range sorted {
+		k, _ := item.Field("n")
+		if !k.EquivalentTo(value.NewValue(i)) {
+			t.Fatalf("Item %v out of order: %v", i, k)
+		}
+		id := "doc" + strconv.Itoa(i)
+		if item.GetId() != id || !value.NewValue(item.GetMeta()["cas"]).EquivalentTo(k) ||
+			!value.NewValue(item.GetAttachment("tag")).EquivalentTo(k) {
+			t.Errorf("Item %v lost its annotations: %v %v %v", i, item.GetId(),
+				item.GetMeta(), item.GetAttachment("tag"))
+		}
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("Spill files not removed")
+	}
+}
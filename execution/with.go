@@ -78,9 +78,21 @@ func (this *With) RunOnce(context *Context, parent value.Value) {
 		}
 
 		for _, b := range this.plan.Bindings() {
-			v, e := b.Expression().Evaluate(wv, context)
-			if e != nil {
-				context.Error(errors.NewEvaluationError(e, "WITH"))
+			var v value.Value
+			var err errors.Error
+
+			if term := this.plan.RecursiveWiths().Get(b.Variable()); term != nil {
+				v, err = this.evaluateRecursive(term, wv, context)
+			} else {
+				var e error
+
+				v, e = b.Expression().Evaluate(wv, context)
+				if e != nil {
+					err = errors.NewEvaluationError(e, "WITH")
+				}
+			}
+			if err != nil {
+				context.Error(err)
 				this.notify()
 
 				// MB-31605 have to start the child for the output and stop
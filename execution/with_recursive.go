@@ -0,0 +1,245 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package execution
+
+// Iterative evaluation of the terms of WITH RECURSIVE: the anchor is
+// evaluated once, then the recursive subselect is evaluated with the alias
+// bound to the items added by the previous iteration, until an iteration
+// adds nothing, or the levels or documents options are exhausted.
+//
+// Terms without options are bound by the server limits instead, and fail
+// rather than return partial results, should they exceed them.
+
+import (
+	atomic "github.com/couchbase/go-couchbase/platform"
+	"github.com/couchbase/query/algebra"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/value"
+)
+
+const _RECURSIVE_SET_SIZE = 64
+
+const (
+	DEF_RECURSION_LEVELS    = 1000
+	DEF_RECURSION_DOCUMENTS = 100000
+)
+
+var recursionLevels = atomic.NewAlignedInt64(DEF_RECURSION_LEVELS)
+var recursionDocuments = atomic.NewAlignedInt64(DEF_RECURSION_DOCUMENTS)
+
+// server limits for terms that set none, 0 for no limit
+func SetRecursionLevels(levels int64) {
+	if levels < 0 {
+		levels = 0
+	}
+	atomic.StoreInt64(&recursionLevels, levels)
+}
+
+func GetRecursionLevels() int64 {
+	return atomic.LoadInt64(&recursionLevels)
+}
+
+func SetRecursionDocuments(documents int64) {
+	if documents < 0 {
+		documents = 0
+	}
+	atomic.StoreInt64(&recursionDocuments, documents)
+}
+
+func GetRecursionDocuments() int64 {
+	return atomic.LoadInt64(&recursionDocuments)
+}
+
+type recursiveWith struct {
+	term         *algebra.RecursiveWith
+	levels       int64
+	documents    int64
+	serverLevels bool
+	serverDocs   bool
+	results      []interface{}
+	size         uint64
+	seen         *value.Set
+}
+
+// the cycle keys of the items from the anchor down to an item: paths
+// share their common ancestors, so that extending one is cheap
+type cyclePath struct {
+	key    value.Value
+	parent *cyclePath
+}
+
+func (this *cyclePath) has(key value.Value) bool {
+	for p := this; p != nil; p = p.parent {
+		if p.key.EquivalentTo(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *With) evaluateRecursive(term *algebra.RecursiveWith, wv value.AnnotatedValue,
+	context *Context) (value.Value, errors.Error) {
+
+	rw := &recursiveWith{
+		term:      term,
+		levels:    term.Levels(),
+		documents: term.Documents(),
+		results:   make([]interface{}, 0, _RECURSIVE_SET_SIZE),
+	}
+	if rw.levels < 0 {
+		if levels := GetRecursionLevels(); levels > 0 {
+			rw.levels = levels
+			rw.serverLevels = true
+		}
+	}
+	if rw.documents < 0 {
+		if documents := GetRecursionDocuments(); documents > 0 {
+			rw.documents = documents
+			rw.serverDocs = true
+		}
+	}
+	if term.Union() {
+		rw.seen = value.NewSet(_RECURSIVE_SET_SIZE, false, false)
+	}
+
+	// the results are held like any other WITH binding once complete
+	defer func() {
+		if context.UseRequestQuota() {
+			context.ReleaseValueSize(rw.size)
+		}
+	}()
+
+	items, err := context.EvaluateSubquery(term.Anchor(), wv)
+	if err != nil {
+		return nil, errors.NewEvaluationError(err, "WITH")
+	}
+	working, paths, rerr := rw.add(items, nil, nil, context)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	// the alias only holds what the previous iteration added
+	iv := value.NewAnnotatedValue(wv.Copy())
+	for level := int64(1); len(working) > 0 && !rw.full(); level++ {
+		if this.stopped {
+			break
+		}
+
+		// past the server limit, only fail if the recursion would go on
+		if rw.levels >= 0 && level > rw.levels && !rw.serverLevels {
+			break
+		}
+
+		// cycles are detected along each path, so with CYCLE, each item
+		// is recursed on separately, for its children to know their path
+		if len(term.Cycle()) > 0 {
+			var next, added []interface{}
+			var nextPaths []*cyclePath
+
+			for i, act := range working {
+				iv.SetField(term.Alias(), []interface{}{act})
+				items, err = context.EvaluateSubquery(term.Recursive(), iv)
+				if err != nil {
+					return nil, errors.NewEvaluationError(err, "WITH")
+				}
+				added, nextPaths, rerr = rw.add(items, paths[i], nextPaths, context)
+				if rerr != nil {
+					return nil, rerr
+				}
+				next = append(next, added...)
+				if rw.full() {
+					break
+				}
+			}
+			working, paths = next, nextPaths
+		} else {
+			iv.SetField(term.Alias(), working)
+			items, err = context.EvaluateSubquery(term.Recursive(), iv)
+			if err != nil {
+				return nil, errors.NewEvaluationError(err, "WITH")
+			}
+			working, _, rerr = rw.add(items, nil, nil, context)
+			if rerr != nil {
+				return nil, rerr
+			}
+		}
+		if rw.levels >= 0 && level > rw.levels && len(working) > 0 {
+			return nil, errors.NewRecursionLimitError(term.Alias(), rw.levels, "levels")
+		}
+	}
+
+	return value.NewValue(rw.results), nil
+}
+
+// the documents option has been exhausted
+func (this *recursiveWith) full() bool {
+	return !this.serverDocs && this.documents >= 0 && int64(len(this.results)) >= this.documents
+}
+
+// returns the items actually added, and with CYCLE, appends their paths,
+// extending that of the item they were produced from
+func (this *recursiveWith) add(items value.Value, parent *cyclePath, paths []*cyclePath,
+	context *Context) ([]interface{}, []*cyclePath, errors.Error) {
+
+	acts, _ := items.Actual().([]interface{})
+	rv := make([]interface{}, 0, len(acts))
+	cycle := this.term.Cycle()
+
+	for _, act := range acts {
+		if this.full() {
+			break
+		}
+
+		item := value.NewValue(act)
+		var key value.Value
+		if len(cycle) > 0 {
+
+			// the cycle expressions are formalized on the alias
+			av := value.NewValue(map[string]interface{}{this.term.Alias(): act})
+			keys := make([]interface{}, len(cycle))
+			for i, expr := range cycle {
+				v, err := expr.Evaluate(av, context)
+				if err != nil {
+					return nil, nil, errors.NewEvaluationError(err, "CYCLE")
+				}
+				keys[i] = v
+			}
+			key = value.NewValue(keys)
+			if parent.has(key) {
+				continue
+			}
+		}
+
+		if this.seen != nil {
+			if this.seen.Has(item) {
+				continue
+			}
+			this.seen.Put(item, nil)
+		}
+
+		if this.serverDocs && int64(len(this.results)) >= this.documents {
+			return nil, nil, errors.NewRecursionLimitError(this.term.Alias(), this.documents, "documents")
+		}
+		if context.UseRequestQuota() {
+			size := item.Size()
+			this.size += size
+			if context.TrackValueSize(size) {
+				return nil, nil, errors.NewMemoryQuotaExceededError()
+			}
+		}
+
+		this.results = append(this.results, act)
+		rv = append(rv, act)
+		if key != nil {
+			paths = append(paths, &cyclePath{key: key, parent: parent})
+		}
+	}
+	return rv, paths, nil
+}
@@ -37,7 +37,7 @@ func (this *Add) Accept(visitor Visitor) (interface{}, error) {
 	return visitor.VisitAdd(this)
 }
 
-func (this *Add) Type() value.Type { return value.NUMBER }
+func (this *Add) Type() value.Type { return arithType(this.operands) }
 
 func (this *Add) Evaluate(item value.Value, context Context) (value.Value, error) {
 	return this.Eval(this, item, context)
@@ -45,8 +45,9 @@ func (this *Add) Evaluate(item value.Value, context Context) (value.Value, error
 
 /*
 Range over input arguments, if the type is a number add it to the sum.
-If the value is missing, return a missing value. For all other types
-return a null value. Return the final sum.
+If the value is missing, return a missing value. Intervals are added
+to each other, or to a date. For all other types return a null value.
+Return the final sum.
 */
 func (this *Add) Apply(context Context, args ...value.Value) (value.Value, error) {
 	null := false
@@ -63,7 +64,7 @@ func (this *Add) Apply(context Context, args ...value.Value) (value.Value, error
 	}
 
 	if null {
-		return value.NULL_VALUE, nil
+		return addIntervals(args), nil
 	}
 
 	return sum, nil
@@ -37,20 +37,22 @@ func (this *Neg) Accept(visitor Visitor) (interface{}, error) {
 	return visitor.VisitNeg(this)
 }
 
-func (this *Neg) Type() value.Type { return value.NUMBER }
+func (this *Neg) Type() value.Type { return arithType(this.operands) }
 
 func (this *Neg) Evaluate(item value.Value, context Context) (value.Value, error) {
 	return this.UnaryEval(this, item, context)
 }
 
 /*
-Return the neagation of the input value, if the type of input is a number.
-For missing return a missing value, and for all other input types return a
-null.
+Return the neagation of the input value, if the type of input is a number
+or an interval. For missing return a missing value, and for all other input
+types return a null.
 */
 func (this *Neg) Apply(context Context, arg value.Value) (value.Value, error) {
 	if arg.Type() == value.NUMBER {
 		return value.AsNumberValue(arg).Neg(), nil
+	} else if arg.Type() == value.INTERVAL {
+		return value.NewIntervalValue(arg.Actual().(value.Interval).Neg()), nil
 	} else if arg.Type() == value.MISSING {
 		return value.MISSING_VALUE, nil
 	} else {
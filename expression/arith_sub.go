@@ -37,7 +37,7 @@ func (this *Sub) Accept(visitor Visitor) (interface{}, error) {
 	return visitor.VisitSub(this)
 }
 
-func (this *Sub) Type() value.Type { return value.NUMBER }
+func (this *Sub) Type() value.Type { return arithType(this.operands) }
 
 func (this *Sub) Evaluate(item value.Value, context Context) (value.Value, error) {
 	return this.BinaryEval(this, item, context)
@@ -47,14 +47,17 @@ func (this *Sub) Evaluate(item value.Value, context Context) (value.Value, error
 Evaluate the difference for the first and second input
 values to return a value. If both values are numbers, calculate
 the difference and return it. If either of the expressions is
-missing then return a missing value. For all other cases return
-a null value.
+missing then return a missing value. An interval can be subtracted
+from an interval or a date. For all other cases return a null value.
 */
 func (this *Sub) Apply(context Context, first, second value.Value) (value.Value, error) {
 	if first.Type() == value.NUMBER && second.Type() == value.NUMBER {
 		return value.AsNumberValue(first).Sub(value.AsNumberValue(second)), nil
 	} else if first.Type() == value.MISSING || second.Type() == value.MISSING {
 		return value.MISSING_VALUE, nil
+	} else if second.Type() == value.INTERVAL {
+		neg := value.NewIntervalValue(second.Actual().(value.Interval).Neg())
+		return addIntervals([]value.Value{first, neg}), nil
 	} else {
 		return value.NULL_VALUE, nil
 	}
@@ -0,0 +1,387 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/couchbase/query/value"
+)
+
+///////////////////////////////////////////////////
+//
+// CollationKey
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the String function COLLATION_KEY(expr, locale). It
+returns a string which sorts, compares and groups by raw byte order
+the way the string value sorts in the given locale, so that it can be
+used in ORDER BY, GROUP BY, DISTINCT and comparisons, and indexed.
+The locale is a BCP 47 language tag, such as "de-DE" or "de_DE", or ""
+for the root collation. Its extensions set options, such as
+"de-u-ks-level2" to ignore case, "de-u-ks-level1" to also ignore
+accents, and "de-u-kn-true" to sort digits numerically. Values other
+than strings are returned unchanged, so that they sort as they would
+without collation.
+*/
+type CollationKey struct {
+	BinaryFunctionBase
+}
+
+func NewCollationKey(first, second Expression) Function {
+	rv := &CollationKey{
+		*NewBinaryFunctionBase("collation_key", first, second),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *CollationKey) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *CollationKey) Type() value.Type { return value.JSON }
+
+func (this *CollationKey) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.BinaryEval(this, item, context)
+}
+
+func (this *CollationKey) Apply(context Context, first, second value.Value) (value.Value, error) {
+	if first.Type() == value.MISSING || second.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	} else if second.Type() != value.STRING {
+		return value.NULL_VALUE, nil
+	} else if first.Type() != value.STRING {
+		return first, nil
+	}
+
+	key, err := collationKey(first.Actual().(string), second.Actual().(string))
+	if err != nil {
+		return nil, err
+	}
+	return value.NewValue(key), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *CollationKey) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewCollationKey(operands[0], operands[1])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// Collate
+//
+///////////////////////////////////////////////////
+
+/*
+This represents expr COLLATE locale. Comparisons, IN, LIKE and ORDER
+BY with such an operand collate all their operands in the locale, and
+the parser replaces it there with COLLATION_KEY(). Anywhere else, such
+as in a projection, it is expr itself: it evaluates to the value of
+expr, and visitors see expr, so that it does not outlive parsing.
+*/
+type Collate struct {
+	BinaryFunctionBase
+}
+
+func NewCollate(first, second Expression) Function {
+	rv := &Collate{
+		*NewBinaryFunctionBase("collate", first, second),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern. Visitors see expr.
+*/
+func (this *Collate) Accept(visitor Visitor) (interface{}, error) {
+	return this.First().Accept(visitor)
+}
+
+func (this *Collate) Type() value.Type { return this.First().Type() }
+
+func (this *Collate) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.First().Evaluate(item, context)
+}
+
+func (this *Collate) Apply(context Context, first, second value.Value) (value.Value, error) {
+	return first, nil
+}
+
+func (this *Collate) Alias() string {
+	return this.First().Alias()
+}
+
+/*
+Factory method pattern.
+*/
+func (this *Collate) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewCollate(operands[0], operands[1])
+	}
+}
+
+/*
+Returns expr folded for a LIKE in the collation of locale: in lower case
+when the collation ignores case, and also without accents when it ignores
+accents. Other differences between the collation and the bytes of expr
+are not taken into account, as the pattern keeps its wildcards.
+*/
+func NewCollationFold(expr, locale Expression) Expression {
+	if locale.Value() == nil {
+		return expr
+	}
+	loc, ok := locale.Value().Actual().(string)
+	if !ok || loc == "" {
+		return expr
+	}
+	tag, err := language.Parse(strings.Replace(loc, "_", "-", -1))
+	if err != nil {
+		return expr
+	}
+
+	switch tag.TypeForKey("ks") {
+	case "level1":
+		return NewLower(NewUnaccent(expr))
+	case "level2":
+		return NewLower(expr)
+	default:
+		return expr
+	}
+}
+
+/*
+Collators keep state while comparing, so there is a pool of them for
+each locale.
+*/
+var collators struct {
+	sync.Mutex
+	pools map[string]*sync.Pool
+}
+
+func collatorPool(locale string) (*sync.Pool, error) {
+	collators.Lock()
+	defer collators.Unlock()
+
+	pool, ok := collators.pools[locale]
+	if ok {
+		return pool, nil
+	}
+
+	tag := language.Und
+	if locale != "" {
+		var err error
+		tag, err = language.Parse(strings.Replace(locale, "_", "-", -1))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid locale %s: %v", locale, err)
+		}
+	}
+
+	pool = &sync.Pool{
+		New: func() interface{} {
+			return collate.New(tag)
+		},
+	}
+	if collators.pools == nil {
+		collators.pools = make(map[string]*sync.Pool)
+	}
+	collators.pools[locale] = pool
+	return pool, nil
+}
+
+/*
+The collation key of s, hex encoded so that it is a valid string and
+keeps its byte order.
+*/
+func collationKey(s, locale string) (string, error) {
+	pool, err := collatorPool(locale)
+	if err != nil {
+		return "", err
+	}
+
+	c := pool.Get().(*collate.Collator)
+	defer pool.Put(c)
+
+	var buf collate.Buffer
+	return hex.EncodeToString(c.KeyFromString(&buf, s)), nil
+}
+
+///////////////////////////////////////////////////
+//
+// Unaccent
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the String function UNACCENT(expr). It returns the
+string with accents and other combining marks removed, such that
+"Crème brûlée" becomes "Creme brulee".
+*/
+type Unaccent struct {
+	UnaryFunctionBase
+}
+
+func NewUnaccent(operand Expression) Function {
+	rv := &Unaccent{
+		*NewUnaryFunctionBase("unaccent", operand),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *Unaccent) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *Unaccent) Type() value.Type { return value.STRING }
+
+func (this *Unaccent) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.UnaryEval(this, item, context)
+}
+
+func (this *Unaccent) Apply(context Context, arg value.Value) (value.Value, error) {
+	if arg.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	} else if arg.Type() != value.STRING {
+		return value.NULL_VALUE, nil
+	}
+
+	s := norm.NFD.String(arg.Actual().(string))
+	s = strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		return r
+	}, s)
+	return value.NewValue(norm.NFC.String(s)), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *Unaccent) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewUnaccent(operands[0])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// Normalize
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the String function NORMALIZE(expr [, form]). It
+returns the string in the given Unicode normalization form, one of
+"NFC", the default, "NFD", "NFKC" and "NFKD".
+*/
+type Normalize struct {
+	FunctionBase
+}
+
+func NewNormalize(operands ...Expression) Function {
+	rv := &Normalize{
+		*NewFunctionBase("normalize", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *Normalize) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *Normalize) Type() value.Type { return value.STRING }
+
+func (this *Normalize) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+var _NORMALIZATION_FORMS = map[string]norm.Form{
+	"nfc":  norm.NFC,
+	"nfd":  norm.NFD,
+	"nfkc": norm.NFKC,
+	"nfkd": norm.NFKD,
+}
+
+func (this *Normalize) Apply(context Context, args ...value.Value) (value.Value, error) {
+	null := false
+	for _, arg := range args {
+		if arg.Type() == value.MISSING {
+			return value.MISSING_VALUE, nil
+		} else if arg.Type() != value.STRING {
+			null = true
+		}
+	}
+
+	if null {
+		return value.NULL_VALUE, nil
+	}
+
+	form := norm.NFC
+	if len(args) > 1 {
+		name := args[1].Actual().(string)
+		f, ok := _NORMALIZATION_FORMS[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("Invalid normalization form %s, it must be one of NFC, NFD, NFKC and NFKD.", name)
+		}
+		form = f
+	}
+
+	return value.NewValue(form.String(args[0].Actual().(string))), nil
+}
+
+/*
+Minimum input arguments required for the defined function
+NORMALIZE is 1.
+*/
+func (this *Normalize) MinArgs() int { return 1 }
+
+/*
+Maximum input arguments allowed for the defined function
+NORMALIZE is 2.
+*/
+func (this *Normalize) MaxArgs() int { return 2 }
+
+/*
+Factory method pattern.
+*/
+func (this *Normalize) Constructor() FunctionConstructor {
+	return NewNormalize
+}
@@ -0,0 +1,95 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+import (
+	"testing"
+
+	"github.com/couchbase/query/value"
+)
+
+func TestNormalizationFunctions(t *testing.T) {
+	tests := []struct {
+		expr     Expression
+		expected interface{}
+	}{
+		{NewUnaccent(NewConstant("Crème Brûlée")), "Creme Brulee"},
+		{NewUnaccent(NewConstant("Cre\u0300me")), "Creme"},
+		{NewUnaccent(NewConstant(1)), nil},
+		{NewNormalize(NewConstant("e\u0301")), "\u00e9"},
+		{NewNormalize(NewConstant("\u00e9"), NewConstant("nfd")), "e\u0301"},
+		{NewNormalize(NewConstant("\ufb01"), NewConstant("NFKC")), "fi"},
+		{NewNormalize(NewConstant(1)), nil},
+		{NewCollationKey(NewConstant(1), NewConstant("de")), 1},
+		{NewCollationKey(NewConstant("a"), NewConstant(1)), nil},
+		{NewCollate(NewConstant("Äb"), NewConstant("de")), "Äb"},
+		{NewCollationFold(NewConstant("Zoë"), NewConstant("en-u-ks-level1")), "zoe"},
+		{NewCollationFold(NewConstant("Zoë"), NewConstant("en-u-ks-level2")), "zoë"},
+		{NewCollationFold(NewConstant("Zoë"), NewConstant("en")), "Zoë"},
+	}
+
+	for _, test := range tests {
+		rv, err := test.expr.Evaluate(nil, nil)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", test.expr, err)
+			continue
+		}
+		if rv.Collate(value.NewValue(test.expected)) != 0 {
+			t.Errorf("%v: expected %v, got %v", test.expr, test.expected, rv)
+		}
+	}
+
+	_, err := NewNormalize(NewConstant("a"), NewConstant("NFX")).Evaluate(nil, nil)
+	if err == nil {
+		t.Errorf("expected error for an invalid normalization form")
+	}
+}
+
+func TestCollationKey(t *testing.T) {
+	key := func(s, locale string) value.Value {
+		rv, err := NewCollationKey(NewConstant(s), NewConstant(locale)).Evaluate(nil, nil)
+		if err != nil {
+			t.Fatalf("COLLATION_KEY(%s, %s): unexpected error %v", s, locale, err)
+		}
+		return rv
+	}
+
+	// each list is in ascending order for the locale
+	tests := []struct {
+		locale string
+		sorted []string
+	}{
+		{"en", []string{"a", "B", "c"}},
+		{"de_DE", []string{"a", "ä", "b", "Zebra"}},
+		{"sv", []string{"z", "å", "ä", "ö"}},
+		{"en-u-kn-true", []string{"item2", "item10"}},
+	}
+
+	for _, test := range tests {
+		for i := 1; i < len(test.sorted); i++ {
+			prev, next := key(test.sorted[i-1], test.locale), key(test.sorted[i], test.locale)
+			if prev.Collate(next) >= 0 {
+				t.Errorf("%s: expected %s before %s", test.locale, test.sorted[i-1], test.sorted[i])
+			}
+		}
+	}
+
+	if key("abc", "en-u-ks-level2").Collate(key("ABC", "en-u-ks-level2")) != 0 {
+		t.Errorf("expected case insensitive keys to be equal")
+	}
+	if key("abc", "en").Collate(key("ABC", "en")) == 0 {
+		t.Errorf("expected case sensitive keys to differ")
+	}
+
+	_, err := NewCollationKey(NewConstant("a"), NewConstant("not a locale!")).Evaluate(nil, nil)
+	if err == nil {
+		t.Errorf("expected error for an invalid locale")
+	}
+}
@@ -2631,13 +2631,13 @@ func datePart(t time.Time, part string) (int, error) {
 		return t.YearDay(), nil
 	case "day_of_week", "dow":
 		return int(t.Weekday()), nil
-	case "iso_week":
+	case "iso_week", "isoweek":
 		_, w := t.ISOWeek()
 		return w, nil
-	case "iso_year":
+	case "iso_year", "isoyear":
 		y, _ := t.ISOWeek()
 		return y, nil
-	case "iso_dow":
+	case "iso_dow", "isodow":
 		d := int(t.Weekday())
 		if d == 0 {
 			d = 7
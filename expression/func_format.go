@@ -0,0 +1,376 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+import (
+	"github.com/couchbase/query/value"
+)
+
+///////////////////////////////////////////////////
+//
+// FormatNumber
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the Number function FORMAT_NUMBER(expr, pattern
+[, locale ]). It returns the number formatted by the pattern, such as
+"#,##0.00" or "0.#%", with the decimal and grouping separators of the
+locale.
+*/
+type FormatNumber struct {
+	FunctionBase
+}
+
+func NewFormatNumber(operands ...Expression) Function {
+	rv := &FormatNumber{
+		*NewFunctionBase("format_number", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *FormatNumber) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *FormatNumber) Type() value.Type { return value.STRING }
+
+func (this *FormatNumber) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *FormatNumber) Apply(context Context, args ...value.Value) (value.Value, error) {
+	pattern, loc, rv := formatArgs(args, value.NUMBER)
+	if rv != nil {
+		return rv, nil
+	}
+
+	number, ok := formatDecimal(args[0])
+	if !ok {
+		return value.NULL_VALUE, nil
+	}
+
+	np, err := newNumberPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := getFormatLocale(loc)
+	if err != nil {
+		return nil, err
+	}
+
+	return value.NewValue(np.format(number, l)), nil
+}
+
+/*
+Minimum input arguments required for the defined function
+FORMAT_NUMBER is 2.
+*/
+func (this *FormatNumber) MinArgs() int { return 2 }
+
+/*
+Maximum input arguments allowed for the defined function
+FORMAT_NUMBER is 3.
+*/
+func (this *FormatNumber) MaxArgs() int { return 3 }
+
+/*
+Factory method pattern.
+*/
+func (this *FormatNumber) Constructor() FunctionConstructor {
+	return NewFormatNumber
+}
+
+///////////////////////////////////////////////////
+//
+// ParseNumber
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the Number function PARSE_NUMBER(expr, pattern
+[, locale ]). It is the reverse of FORMAT_NUMBER, and returns NULL
+unless the string is formatted exactly as the pattern formats numbers.
+Grouping separators may be left out.
+*/
+type ParseNumber struct {
+	FunctionBase
+}
+
+func NewParseNumber(operands ...Expression) Function {
+	rv := &ParseNumber{
+		*NewFunctionBase("parse_number", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *ParseNumber) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *ParseNumber) Type() value.Type { return value.NUMBER }
+
+func (this *ParseNumber) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *ParseNumber) Apply(context Context, args ...value.Value) (value.Value, error) {
+	pattern, loc, rv := formatArgs(args, value.STRING)
+	if rv != nil {
+		return rv, nil
+	}
+
+	np, err := newNumberPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := getFormatLocale(loc)
+	if err != nil {
+		return nil, err
+	}
+
+	rv, ok := np.parse(args[0].Actual().(string), l)
+	if !ok {
+		return value.NULL_VALUE, nil
+	}
+	return rv, nil
+}
+
+/*
+Minimum input arguments required for the defined function
+PARSE_NUMBER is 2.
+*/
+func (this *ParseNumber) MinArgs() int { return 2 }
+
+/*
+Maximum input arguments allowed for the defined function
+PARSE_NUMBER is 3.
+*/
+func (this *ParseNumber) MaxArgs() int { return 3 }
+
+/*
+Factory method pattern.
+*/
+func (this *ParseNumber) Constructor() FunctionConstructor {
+	return NewParseNumber
+}
+
+///////////////////////////////////////////////////
+//
+// ToChar
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the Date function TO_CHAR(expr, mask [, locale ]). It
+returns the date formatted by the SQL format mask, such as
+"FMDay, DD Month YYYY HH24:MI", with month and day names in the
+language of the locale. The date expr is a date string in a supported
+format, or a number representing UNIX milliseconds in local time.
+*/
+type ToChar struct {
+	FunctionBase
+}
+
+func NewToChar(operands ...Expression) Function {
+	rv := &ToChar{
+		*NewFunctionBase("to_char", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *ToChar) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *ToChar) Type() value.Type { return value.STRING }
+
+func (this *ToChar) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *ToChar) Apply(context Context, args ...value.Value) (value.Value, error) {
+	mask, loc, rv := formatArgs(args, value.STRING, value.NUMBER)
+	if rv != nil {
+		return rv, nil
+	}
+
+	elems, err := parseDateMask(mask)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := getFormatLocale(loc)
+	if err != nil {
+		return nil, err
+	}
+
+	date := args[0].Actual()
+	if millis, ok := date.(float64); ok {
+		return value.NewValue(formatDateMask(millisToTime(millis), elems, l)), nil
+	}
+
+	t, err := strToTime(date.(string))
+	if err != nil {
+		return value.NULL_VALUE, nil
+	}
+	return value.NewValue(formatDateMask(t, elems, l)), nil
+}
+
+/*
+Minimum input arguments required for the defined function
+TO_CHAR is 2.
+*/
+func (this *ToChar) MinArgs() int { return 2 }
+
+/*
+Maximum input arguments allowed for the defined function
+TO_CHAR is 3.
+*/
+func (this *ToChar) MaxArgs() int { return 3 }
+
+/*
+Factory method pattern.
+*/
+func (this *ToChar) Constructor() FunctionConstructor {
+	return NewToChar
+}
+
+///////////////////////////////////////////////////
+//
+// ToDate
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the Date function TO_DATE(expr, mask [, locale ]). It
+is the reverse of TO_CHAR, and returns the date as a string in the
+default format, in local time unless the mask has a time zone. It
+returns NULL unless the string matches the mask exactly and is a valid
+date.
+*/
+type ToDate struct {
+	FunctionBase
+}
+
+func NewToDate(operands ...Expression) Function {
+	rv := &ToDate{
+		*NewFunctionBase("to_date", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *ToDate) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *ToDate) Type() value.Type { return value.STRING }
+
+func (this *ToDate) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *ToDate) Apply(context Context, args ...value.Value) (value.Value, error) {
+	mask, loc, rv := formatArgs(args, value.STRING)
+	if rv != nil {
+		return rv, nil
+	}
+
+	elems, err := parseDateMask(mask)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := getFormatLocale(loc)
+	if err != nil {
+		return nil, err
+	}
+
+	t, ok := parseDateMaskValue(args[0].Actual().(string), elems, l)
+	if !ok {
+		return value.NULL_VALUE, nil
+	}
+	return value.NewValue(t.Format(DEFAULT_FORMAT)), nil
+}
+
+/*
+Minimum input arguments required for the defined function
+TO_DATE is 2.
+*/
+func (this *ToDate) MinArgs() int { return 2 }
+
+/*
+Maximum input arguments allowed for the defined function
+TO_DATE is 3.
+*/
+func (this *ToDate) MaxArgs() int { return 3 }
+
+/*
+Factory method pattern.
+*/
+func (this *ToDate) Constructor() FunctionConstructor {
+	return NewToDate
+}
+
+/*
+Checks the arguments of the formatting functions, which are a value of
+one of the given types, a pattern and an optional locale. It returns
+the pattern and the locale, or the MISSING or NULL result.
+*/
+func formatArgs(args []value.Value, types ...value.Type) (string, string, value.Value) {
+	null := false
+	for i, arg := range args {
+		if arg.Type() == value.MISSING {
+			return "", "", value.MISSING_VALUE
+		} else if i > 0 && arg.Type() != value.STRING {
+			null = true
+		}
+	}
+
+	if null {
+		return "", "", value.NULL_VALUE
+	}
+
+	ok := false
+	for _, t := range types {
+		ok = ok || args[0].Type() == t
+	}
+	if !ok {
+		return "", "", value.NULL_VALUE
+	}
+
+	loc := ""
+	if len(args) > 2 {
+		loc = args[2].Actual().(string)
+	}
+	return args[1].Actual().(string), loc, nil
+}
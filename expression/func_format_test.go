@@ -0,0 +1,119 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+import (
+	"testing"
+
+	"github.com/couchbase/query/value"
+)
+
+func TestFormatFunctions(t *testing.T) {
+	date := NewConstant("2020-03-01T14:05:09.123-05:30")
+
+	tests := []struct {
+		expr     Expression
+		expected interface{}
+	}{
+		{NewFormatNumber(NewConstant(1234567.891), NewConstant("#,##0.00")), "1,234,567.89"},
+		{NewFormatNumber(NewConstant(1234567.891), NewConstant("#,##0.00"), NewConstant("de_DE")), "1.234.567,89"},
+		{NewFormatNumber(NewConstant(0.256), NewConstant("0.#%")), "25.6%"},
+		{NewFormatNumber(NewConstant(-12), NewConstant("#,##0;(#,##0)")), "(12)"},
+		{NewFormatNumber(NewConstant(-0.001), NewConstant("0.0")), "0.0"},
+		{NewFormatNumber(NewConstant("1"), NewConstant("0")), nil},
+		{NewFormatNumber(NewConstant(int64(9007199254740993)), NewConstant("#,##0")), "9,007,199,254,740,993"},
+		{NewFormatNumber(NewConstant(1.005), NewConstant("0.00")), "1.01"},
+		{NewFormatNumber(NewConstant(9.995), NewConstant("0.00")), "10.00"},
+		{NewFormatNumber(NewConstant(0.1), NewConstant("0.##################")), "0.1"},
+		{NewFormatNumber(NewConstant(0.00015), NewConstant("0.##%")), "0.02%"},
+		{NewFormatNumber(NewConstant(12345678.5), NewConstant("#,##0.0"), NewConstant("en-IN")), "1,23,45,678.5"},
+		{NewFormatNumber(NewConstant(1234567.891), NewConstant("#,##0.00"), NewConstant("de-CH")), "1’234’567.89"},
+		{NewFormatNumber(NewConstant(1234567.891), NewConstant("#,##0.00"), NewConstant("fr_CH")), "1\u202f234\u202f567,89"},
+		{NewFormatNumber(NewConstant(1234567.891), NewConstant("#,##0.00"), NewConstant("de-AT")), "1.234.567,89"},
+		{NewParseNumber(NewConstant("1.234,50"), NewConstant("#,##0.00"), NewConstant("de")), 1234.5},
+		{NewParseNumber(NewConstant("1234.50"), NewConstant("#,##0.00")), 1234.5},
+		{NewParseNumber(NewConstant("(12)"), NewConstant("#,##0;(#,##0)")), -12},
+		{NewParseNumber(NewConstant("12,34.50"), NewConstant("#,##0.00")), nil},
+		{NewParseNumber(NewConstant("1,234.5"), NewConstant("#,##0.00")), nil},
+		{NewParseNumber(NewConstant("1,234.50 "), NewConstant("#,##0.00")), nil},
+		{NewParseNumber(NewConstant("1,23,45,678.5"), NewConstant("#,##0.0"), NewConstant("en_IN")), 12345678.5},
+		{NewParseNumber(NewConstant("12,345,678.5"), NewConstant("#,##0.0"), NewConstant("en_IN")), nil},
+		{NewParseNumber(NewConstant("1’234.50"), NewConstant("#,##0.00"), NewConstant("de-CH")), 1234.5},
+		{NewParseNumber(NewConstant("12.5%"), NewConstant("0.#%")), 0.125},
+
+		{NewToChar(date, NewConstant("YYYY-MM-DD HH24:MI:SS.MS TZH:TZM")), "2020-03-01 14:05:09.123 -05:30"},
+		{NewToChar(date, NewConstant("Day, DD Month YYYY")), "Sunday   , 01 March     2020"},
+		{NewToChar(date, NewConstant("FMDay, FMDD FMMonth YYYY")), "Sunday, 1 March 2020"},
+		{NewToChar(date, NewConstant("FMDay DD FMmonth YYYY"), NewConstant("fr-FR")), "Dimanche 01 mars 2020"},
+		{NewToChar(date, NewConstant("DY DD MON YY HH12:MI AM"), NewConstant("de")), "SO 01 MÄR 20 02:05 PM"},
+		{NewToChar(date, NewConstant("IYYY-\"W\"IW-ID Q DDD")), "2020-W09-7 1 061"},
+		{NewToChar(NewConstant("2020-13-01"), NewConstant("YYYY")), nil},
+
+		{NewToDate(NewConstant("2020-02-29 10:00 +05:30"), NewConstant("YYYY-MM-DD HH24:MI TZH:TZM")),
+			"2020-02-29T10:00:00+05:30"},
+		{NewToDate(NewConstant("Sunday 1 mars 2020 -00:00"), NewConstant("FMDay FMDD FMMonth YYYY TZH:TZM"),
+			NewConstant("fr")), nil},
+		{NewToDate(NewConstant("dimanche 1 mars 2020 +00:00"), NewConstant("FMDay FMDD FMMonth YYYY TZH:TZM"),
+			NewConstant("fr")), "2020-03-01T00:00:00Z"},
+		{NewToDate(NewConstant("2020-W10-1 +00:00"), NewConstant("IYYY-\"W\"IW-ID TZH:TZM")), "2020-03-02T00:00:00Z"},
+		{NewToDate(NewConstant("2020-02-30"), NewConstant("YYYY-MM-DD")), nil},
+		{NewToDate(NewConstant("2020-2-9"), NewConstant("YYYY-MM-DD")), nil},
+		{NewToDate(NewConstant("Monday 01 March 2020"), NewConstant("Day DD Month YYYY")), nil},
+		{NewToDate(NewConstant("13:00 PM"), NewConstant("HH:MI AM")), nil},
+		{NewToDate(NewConstant("2020-03-01x"), NewConstant("YYYY-MM-DD")), nil},
+	}
+
+	for _, test := range tests {
+		rv, err := test.expr.Evaluate(nil, nil)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", test.expr, err)
+			continue
+		}
+		if rv.Collate(value.NewValue(test.expected)) != 0 {
+			t.Errorf("%v: expected %v, got %v", test.expr, test.expected, rv)
+		}
+	}
+
+	rv, err := NewParseNumber(NewConstant("9,007,199,254,740,993"), NewConstant("#,##0")).Evaluate(nil, nil)
+	if err != nil || value.AsNumberValue(rv).Int64() != 9007199254740993 {
+		t.Errorf("expected 9007199254740993, got %v, error %v", rv, err)
+	}
+
+	errs := []Expression{
+		NewFormatNumber(NewConstant(1), NewConstant("#0#")),
+		NewFormatNumber(NewConstant(1), NewConstant("0.0#0")),
+		NewFormatNumber(NewConstant(1), NewConstant("0"), NewConstant("xx-!!")),
+		NewParseNumber(NewConstant("1"), NewConstant("abc")),
+		NewToChar(date, NewConstant("YYYX")),
+		NewToChar(date, NewConstant("\"abc")),
+		NewToDate(NewConstant("2020"), NewConstant("YYYY FM")),
+	}
+
+	for _, expr := range errs {
+		if _, err := expr.Evaluate(nil, nil); err == nil {
+			t.Errorf("%v: expected an error", expr)
+		}
+	}
+}
+
+func TestDatePartISO(t *testing.T) {
+	for part, expected := range map[string]int{
+		"iso_year": 2020, "isoyear": 2020, "iso_week": 53, "isoweek": 53, "iso_dow": 5, "isodow": 5,
+	} {
+		rv, err := NewDatePartStr(NewConstant("2021-01-01T00:00:00Z"), NewConstant(part)).Evaluate(nil, nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", part, err)
+			continue
+		}
+		if rv.Collate(value.NewValue(expected)) != 0 {
+			t.Errorf("%s: expected %v, got %v", part, expected, rv)
+		}
+	}
+}
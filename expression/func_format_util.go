@@ -0,0 +1,1028 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+// Number patterns are a subset of the ICU / Java DecimalFormat patterns:
+// 0 is a required digit, # an optional digit, , the grouping separator,
+// . the decimal separator and % multiplies by 100. Other characters, and
+// any text quoted with ', are copied as they are. A pattern may have a
+// negative subpattern after a ;, which only contributes its prefix and
+// suffix.
+//
+// Date masks are the SQL TO_CHAR / TO_DATE format masks. Names of months
+// and days, and number separators, come from the locale or its language.
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
+
+	"github.com/couchbase/query/value"
+)
+
+///////////////////////////////////////////////////
+//
+// Locales
+//
+///////////////////////////////////////////////////
+
+type formatLocale struct {
+	decimal     string
+	group       string
+	secondary   int
+	months      []string
+	shortMonths []string
+	days        []string
+	shortDays   []string
+}
+
+var _FORMAT_LOCALES = map[string]*formatLocale{
+	"en": &formatLocale{
+		decimal: ".",
+		group:   ",",
+		months: []string{"January", "February", "March", "April", "May", "June",
+			"July", "August", "September", "October", "November", "December"},
+		shortMonths: []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun",
+			"Jul", "Aug", "Sep", "Oct", "Nov", "Dec"},
+		days:      []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"},
+		shortDays: []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+	},
+	"de": &formatLocale{
+		decimal: ",",
+		group:   ".",
+		months: []string{"Januar", "Februar", "März", "April", "Mai", "Juni",
+			"Juli", "August", "September", "Oktober", "November", "Dezember"},
+		shortMonths: []string{"Jan", "Feb", "Mär", "Apr", "Mai", "Jun",
+			"Jul", "Aug", "Sep", "Okt", "Nov", "Dez"},
+		days:      []string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		shortDays: []string{"So", "Mo", "Di", "Mi", "Do", "Fr", "Sa"},
+	},
+	"es": &formatLocale{
+		decimal: ",",
+		group:   ".",
+		months: []string{"enero", "febrero", "marzo", "abril", "mayo", "junio",
+			"julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		shortMonths: []string{"ene", "feb", "mar", "abr", "may", "jun",
+			"jul", "ago", "sep", "oct", "nov", "dic"},
+		days:      []string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		shortDays: []string{"dom", "lun", "mar", "mié", "jue", "vie", "sáb"},
+	},
+	"fr": &formatLocale{
+		decimal: ",",
+		group:   "\u202f",
+		months: []string{"janvier", "février", "mars", "avril", "mai", "juin",
+			"juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		shortMonths: []string{"janv.", "févr.", "mars", "avr.", "mai", "juin",
+			"juil.", "août", "sept.", "oct.", "nov.", "déc."},
+		days:      []string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		shortDays: []string{"dim.", "lun.", "mar.", "mer.", "jeu.", "ven.", "sam."},
+	},
+	"it": &formatLocale{
+		decimal: ",",
+		group:   ".",
+		months: []string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno",
+			"luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+		shortMonths: []string{"gen", "feb", "mar", "apr", "mag", "giu",
+			"lug", "ago", "set", "ott", "nov", "dic"},
+		days:      []string{"domenica", "lunedì", "martedì", "mercoledì", "giovedì", "venerdì", "sabato"},
+		shortDays: []string{"dom", "lun", "mar", "mer", "gio", "ven", "sab"},
+	},
+	"nl": &formatLocale{
+		decimal: ",",
+		group:   ".",
+		months: []string{"januari", "februari", "maart", "april", "mei", "juni",
+			"juli", "augustus", "september", "oktober", "november", "december"},
+		shortMonths: []string{"jan", "feb", "mrt", "apr", "mei", "jun",
+			"jul", "aug", "sep", "okt", "nov", "dec"},
+		days:      []string{"zondag", "maandag", "dinsdag", "woensdag", "donderdag", "vrijdag", "zaterdag"},
+		shortDays: []string{"zo", "ma", "di", "wo", "do", "vr", "za"},
+	},
+	"pt": &formatLocale{
+		decimal: ",",
+		group:   ".",
+		months: []string{"janeiro", "fevereiro", "março", "abril", "maio", "junho",
+			"julho", "agosto", "setembro", "outubro", "novembro", "dezembro"},
+		shortMonths: []string{"jan", "fev", "mar", "abr", "mai", "jun",
+			"jul", "ago", "set", "out", "nov", "dez"},
+		days: []string{"domingo", "segunda-feira", "terça-feira", "quarta-feira",
+			"quinta-feira", "sexta-feira", "sábado"},
+		shortDays: []string{"dom", "seg", "ter", "qua", "qui", "sex", "sáb"},
+	},
+}
+
+/*
+Regions whose separators differ from those of their language. The
+secondary grouping size is for grouping such as the Indian lakh and
+crore, where only the first group has the size of the pattern.
+*/
+var _REGIONAL_FORMAT_LOCALES = map[string]struct {
+	base      string
+	decimal   string
+	group     string
+	secondary int
+}{
+	"de-CH": {"de", ".", "’", 0},
+	"it-CH": {"it", ".", "’", 0},
+	"en-IN": {"en", ".", ",", 2},
+}
+
+func init() {
+	for name, r := range _REGIONAL_FORMAT_LOCALES {
+		loc := *_FORMAT_LOCALES[r.base]
+		loc.decimal = r.decimal
+		loc.group = r.group
+		loc.secondary = r.secondary
+		_FORMAT_LOCALES[name] = &loc
+	}
+}
+
+/*
+Returns the names and separators of the locale, a BCP 47 language tag
+such as "de-CH" or "de_CH". Locales without an entry of their own use
+those of their language. The empty locale is English.
+*/
+func getFormatLocale(locale string) (*formatLocale, error) {
+	if locale == "" {
+		return _FORMAT_LOCALES["en"], nil
+	}
+
+	tag, err := language.Parse(strings.Replace(locale, "_", "-", -1))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid locale %s: %v", locale, err)
+	}
+
+	base, _, region := tag.Raw()
+	if region != (language.Region{}) {
+		if rv, ok := _FORMAT_LOCALES[base.String()+"-"+region.String()]; ok {
+			return rv, nil
+		}
+	}
+
+	rv, ok := _FORMAT_LOCALES[base.String()]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported locale %s.", locale)
+	}
+	return rv, nil
+}
+
+///////////////////////////////////////////////////
+//
+// Number patterns
+//
+///////////////////////////////////////////////////
+
+type numberPattern struct {
+	posPrefix string
+	posSuffix string
+	negPrefix string
+	negSuffix string
+	minInt    int
+	minFrac   int
+	maxFrac   int
+	grouping  int
+	percent   bool
+}
+
+func newNumberPattern(pattern string) (*numberPattern, error) {
+	subs, err := splitNumberPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	rv := &numberPattern{}
+	pos := subs[0]
+	rv.posPrefix = pos[0]
+	rv.posSuffix = pos[2]
+	rv.negPrefix = "-" + pos[0]
+	rv.negSuffix = pos[2]
+	if len(subs) > 1 {
+		rv.negPrefix = subs[1][0]
+		rv.negSuffix = subs[1][2]
+	}
+	rv.percent = strings.Contains(pos[0], "%") || strings.Contains(pos[2], "%")
+
+	body := pos[1]
+	point := strings.IndexByte(body, '.')
+	intPart, fracPart := body, ""
+	if point >= 0 {
+		intPart, fracPart = body[:point], body[point+1:]
+	}
+
+	if strings.ContainsAny(fracPart, ".,") {
+		return nil, fmt.Errorf("Invalid number pattern %s.", pattern)
+	}
+
+	zero := false
+	for _, c := range intPart {
+		switch c {
+		case '0':
+			zero = true
+			rv.minInt++
+		case '#':
+			if zero {
+				return nil, fmt.Errorf("Invalid number pattern %s: # after 0 in the integer part.", pattern)
+			}
+		}
+	}
+
+	if comma := strings.LastIndexByte(intPart, ','); comma >= 0 {
+		rv.grouping = len(intPart) - comma - 1
+		if rv.grouping == 0 {
+			return nil, fmt.Errorf("Invalid number pattern %s: grouping separator at the end of the integer part.", pattern)
+		}
+	}
+
+	hash := false
+	for _, c := range fracPart {
+		switch c {
+		case '0':
+			if hash {
+				return nil, fmt.Errorf("Invalid number pattern %s: 0 after # in the fraction.", pattern)
+			}
+			rv.minFrac++
+		case '#':
+			hash = true
+		}
+		rv.maxFrac++
+	}
+
+	return rv, nil
+}
+
+/*
+Splits the pattern into subpatterns of prefix, digits and suffix, with
+quoted text and the percent sign left in the prefix and suffix.
+*/
+func splitNumberPattern(pattern string) ([][3]string, error) {
+	var rv [][3]string
+	var parts [3]strings.Builder
+	part := 0
+	quoted := false
+
+	for _, c := range pattern {
+		if quoted {
+			if c == '\'' {
+				quoted = false
+			} else {
+				parts[part].WriteRune(c)
+			}
+			continue
+		}
+
+		switch c {
+		case '\'':
+			quoted = true
+		case '0', '#', ',', '.':
+			if part == 2 {
+				return nil, fmt.Errorf("Invalid number pattern %s: digits after the suffix.", pattern)
+			}
+			part = 1
+			parts[1].WriteRune(c)
+		case ';':
+			if len(rv) > 0 {
+				return nil, fmt.Errorf("Invalid number pattern %s: too many subpatterns.", pattern)
+			}
+			rv = append(rv, [3]string{parts[0].String(), parts[1].String(), parts[2].String()})
+			parts = [3]strings.Builder{}
+			part = 0
+		default:
+			if part == 1 {
+				part = 2
+			}
+			parts[part].WriteRune(c)
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("Invalid number pattern %s: unterminated quote.", pattern)
+	}
+
+	rv = append(rv, [3]string{parts[0].String(), parts[1].String(), parts[2].String()})
+	if strings.IndexAny(rv[0][1], "0#") < 0 {
+		return nil, fmt.Errorf("Invalid number pattern %s: no digits.", pattern)
+	}
+	return rv, nil
+}
+
+/*
+Returns the shortest decimal that represents the number exactly, so
+that integers beyond the precision of float64 keep all their digits.
+*/
+func formatDecimal(v value.Value) (string, bool) {
+	n := value.AsNumberValue(v)
+	f := n.Float64()
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", false
+	}
+
+	if i := n.Int64(); float64(i) == f {
+		return strconv.FormatInt(i, 10), true
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64), true
+}
+
+/*
+Formats number, a decimal such as "-1234.5" that represents the value
+exactly. The fraction is rounded half away from zero.
+*/
+func (this *numberPattern) format(number string, loc *formatLocale) string {
+	negative := strings.HasPrefix(number, "-")
+	if negative {
+		number = number[1:]
+	}
+
+	intPart, fracPart := number, ""
+	if point := strings.IndexByte(number, '.'); point >= 0 {
+		intPart, fracPart = number[:point], number[point+1:]
+	}
+
+	if this.percent {
+		for len(fracPart) < 2 {
+			fracPart += "0"
+		}
+		intPart, fracPart = intPart+fracPart[:2], fracPart[2:]
+	}
+
+	if len(fracPart) > this.maxFrac {
+		up := fracPart[this.maxFrac] >= '5'
+		fracPart = fracPart[:this.maxFrac]
+		if up {
+			digits := roundUpDigits(intPart + fracPart)
+			intPart, fracPart = digits[:len(digits)-len(fracPart)], digits[len(digits)-len(fracPart):]
+		}
+	}
+
+	for len(fracPart) > this.minFrac && fracPart[len(fracPart)-1] == '0' {
+		fracPart = fracPart[:len(fracPart)-1]
+	}
+
+	intPart = strings.TrimLeft(intPart, "0")
+	if len(intPart) < this.minInt {
+		intPart = strings.Repeat("0", this.minInt-len(intPart)) + intPart
+	}
+	if intPart == "" && fracPart == "" {
+		intPart = "0"
+	}
+
+	negative = negative && strings.Trim(intPart+fracPart, "0") != ""
+
+	var buf strings.Builder
+	if negative {
+		buf.WriteString(this.negPrefix)
+	} else {
+		buf.WriteString(this.posPrefix)
+	}
+
+	for i, c := range intPart {
+		if i > 0 && this.groupBoundary(len(intPart)-i, loc) {
+			buf.WriteString(loc.group)
+		}
+		buf.WriteRune(c)
+	}
+
+	if fracPart != "" {
+		buf.WriteString(loc.decimal)
+		buf.WriteString(fracPart)
+	}
+
+	if negative {
+		buf.WriteString(this.negSuffix)
+	} else {
+		buf.WriteString(this.posSuffix)
+	}
+	return buf.String()
+}
+
+/*
+Whether a grouping separator goes before the last n digits of the
+integer part.
+*/
+func (this *numberPattern) groupBoundary(n int, loc *formatLocale) bool {
+	if this.grouping == 0 || n < this.grouping {
+		return false
+	}
+	if loc.secondary == 0 {
+		return n%this.grouping == 0
+	}
+	return (n-this.grouping)%loc.secondary == 0
+}
+
+/*
+Adds one to the last of the decimal digits.
+*/
+func roundUpDigits(digits string) string {
+	b := []byte(digits)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < '9' {
+			b[i]++
+			return string(b)
+		}
+		b[i] = '0'
+	}
+	return "1" + string(b)
+}
+
+/*
+Parses s, which must be formatted exactly as the pattern formats
+numbers, except that grouping separators may be left out.
+*/
+func (this *numberPattern) parse(s string, loc *formatLocale) (value.Value, bool) {
+	negative := false
+	if this.negPrefix != this.posPrefix && strings.HasPrefix(s, this.negPrefix) &&
+		strings.HasSuffix(s[len(this.negPrefix):], this.negSuffix) {
+		negative = true
+		s = s[len(this.negPrefix) : len(s)-len(this.negSuffix)]
+	} else if strings.HasPrefix(s, this.posPrefix) && strings.HasSuffix(s[len(this.posPrefix):], this.posSuffix) {
+		s = s[len(this.posPrefix) : len(s)-len(this.posSuffix)]
+	} else {
+		return nil, false
+	}
+
+	intPart, fracPart := s, ""
+	if point := strings.Index(s, loc.decimal); point >= 0 {
+		intPart, fracPart = s[:point], s[point+len(loc.decimal):]
+		if fracPart == "" {
+			return nil, false
+		}
+	}
+
+	if this.grouping > 0 && strings.Contains(intPart, loc.group) {
+		groups := strings.Split(intPart, loc.group)
+		secondary := loc.secondary
+		if secondary == 0 {
+			secondary = this.grouping
+		}
+		last := len(groups) - 1
+		if len(groups[last]) != this.grouping {
+			return nil, false
+		}
+		for i, g := range groups[:last] {
+			if len(g) > secondary || len(g) == 0 || (i > 0 && len(g) != secondary) {
+				return nil, false
+			}
+		}
+		intPart = strings.Join(groups, "")
+	}
+
+	if len(intPart) < this.minInt || len(fracPart) < this.minFrac || len(fracPart) > this.maxFrac ||
+		intPart+fracPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return nil, false
+	}
+
+	if this.percent {
+		intPart = "00" + intPart
+		intPart, fracPart = intPart[:len(intPart)-2], intPart[len(intPart)-2:]+fracPart
+	}
+
+	if intPart == "" {
+		intPart = "0"
+	}
+	if negative {
+		intPart = "-" + intPart
+	}
+
+	if strings.Trim(fracPart, "0") == "" {
+		if i, err := strconv.ParseInt(intPart, 10, 64); err == nil {
+			return value.NewValue(i), true
+		}
+	}
+
+	f, err := strconv.ParseFloat(intPart+"."+fracPart+"0", 64)
+	if err != nil {
+		return nil, false
+	}
+	return value.NewValue(f), true
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+///////////////////////////////////////////////////
+//
+// Date masks
+//
+///////////////////////////////////////////////////
+
+const (
+	_MASK_LITERAL = iota
+	_MASK_NUMBER
+	_MASK_NAME
+	_MASK_MERIDIEM
+)
+
+const (
+	_MASK_UPPER = iota
+	_MASK_TITLE
+	_MASK_LOWER
+)
+
+type maskElement struct {
+	kind    int
+	code    string
+	text    string
+	width   int
+	fill    bool
+	letters int
+}
+
+/*
+Mask elements, longest first so that the longest one matches.
+*/
+var _MASK_CODES = []struct {
+	code  string
+	kind  int
+	width int
+}{
+	{"MONTH", _MASK_NAME, 0},
+	{"HH24", _MASK_NUMBER, 2},
+	{"HH12", _MASK_NUMBER, 2},
+	{"IYYY", _MASK_NUMBER, 4},
+	{"YYYY", _MASK_NUMBER, 4},
+	{"FF1", _MASK_NUMBER, 1},
+	{"FF2", _MASK_NUMBER, 2},
+	{"FF3", _MASK_NUMBER, 3},
+	{"FF4", _MASK_NUMBER, 4},
+	{"FF5", _MASK_NUMBER, 5},
+	{"FF6", _MASK_NUMBER, 6},
+	{"FF7", _MASK_NUMBER, 7},
+	{"FF8", _MASK_NUMBER, 8},
+	{"FF9", _MASK_NUMBER, 9},
+	{"TZH", _MASK_NUMBER, 2},
+	{"TZM", _MASK_NUMBER, 2},
+	{"DAY", _MASK_NAME, 0},
+	{"DDD", _MASK_NUMBER, 3},
+	{"MON", _MASK_NAME, 0},
+	{"AM", _MASK_MERIDIEM, 2},
+	{"PM", _MASK_MERIDIEM, 2},
+	{"DD", _MASK_NUMBER, 2},
+	{"DY", _MASK_NAME, 0},
+	{"HH", _MASK_NUMBER, 2},
+	{"ID", _MASK_NUMBER, 1},
+	{"IW", _MASK_NUMBER, 2},
+	{"MI", _MASK_NUMBER, 2},
+	{"MM", _MASK_NUMBER, 2},
+	{"MS", _MASK_NUMBER, 3},
+	{"SS", _MASK_NUMBER, 2},
+	{"US", _MASK_NUMBER, 6},
+	{"WW", _MASK_NUMBER, 2},
+	{"YY", _MASK_NUMBER, 2},
+	{"D", _MASK_NUMBER, 1},
+	{"Q", _MASK_NUMBER, 1},
+}
+
+/*
+Parses a date mask. Text in double quotes, white space and punctuation
+are copied as they are; any other letters must be mask elements. FM
+before an element turns off its padding.
+*/
+func parseDateMask(mask string) ([]*maskElement, error) {
+	var rv []*maskElement
+	fill := false
+
+	literal := func(s string) {
+		if n := len(rv); n > 0 && rv[n-1].kind == _MASK_LITERAL {
+			rv[n-1].text += s
+		} else {
+			rv = append(rv, &maskElement{kind: _MASK_LITERAL, text: s})
+		}
+	}
+
+	for i := 0; i < len(mask); {
+		if mask[i] == '"' {
+			end := strings.IndexByte(mask[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("Invalid date mask %s: unterminated quote.", mask)
+			}
+			literal(mask[i+1 : i+1+end])
+			i += end + 2
+			continue
+		}
+
+		rest := strings.ToUpper(mask[i:])
+		if strings.HasPrefix(rest, "FM") {
+			fill = true
+			i += 2
+			continue
+		}
+
+		matched := false
+		for _, c := range _MASK_CODES {
+			if strings.HasPrefix(rest, c.code) {
+				text := mask[i : i+len(c.code)]
+				rv = append(rv, &maskElement{
+					kind:    c.kind,
+					code:    c.code,
+					text:    text,
+					width:   c.width,
+					fill:    fill,
+					letters: letterCase(text),
+				})
+				fill = false
+				i += len(c.code)
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(mask[i:])
+		if !unicode.IsSpace(r) && !unicode.IsPunct(r) && !unicode.IsSymbol(r) {
+			return nil, fmt.Errorf("Invalid date mask %s: unknown element at %s.", mask, mask[i:])
+		}
+		literal(mask[i : i+size])
+		i += size
+	}
+
+	if fill {
+		return nil, fmt.Errorf("Invalid date mask %s: FM at the end.", mask)
+	}
+	return rv, nil
+}
+
+func letterCase(s string) int {
+	if strings.ToLower(s) == s {
+		return _MASK_LOWER
+	} else if strings.ToUpper(s[1:]) != s[1:] {
+		return _MASK_TITLE
+	}
+	return _MASK_UPPER
+}
+
+/*
+The value of a numeric, name or meridiem element in t. Names are
+indexes into the month and day names.
+*/
+func maskValue(t time.Time, code string) int {
+	switch code {
+	case "YYYY":
+		return t.Year()
+	case "YY":
+		return t.Year() % 100
+	case "IYYY":
+		y, _ := t.ISOWeek()
+		return y
+	case "IW":
+		_, w := t.ISOWeek()
+		return w
+	case "WW":
+		return (t.YearDay()-1)/7 + 1
+	case "Q":
+		return (int(t.Month()) + 2) / 3
+	case "MM", "MONTH", "MON":
+		return int(t.Month())
+	case "DDD":
+		return t.YearDay()
+	case "DD":
+		return t.Day()
+	case "D":
+		return int(t.Weekday()) + 1
+	case "DAY", "DY":
+		return int(t.Weekday())
+	case "ID":
+		d := int(t.Weekday())
+		if d == 0 {
+			d = 7
+		}
+		return d
+	case "HH", "HH12":
+		h := t.Hour() % 12
+		if h == 0 {
+			h = 12
+		}
+		return h
+	case "HH24":
+		return t.Hour()
+	case "AM", "PM":
+		return t.Hour() / 12
+	case "MI":
+		return t.Minute()
+	case "SS":
+		return t.Second()
+	case "MS":
+		return t.Nanosecond() / int(time.Millisecond)
+	case "US":
+		return t.Nanosecond() / int(time.Microsecond)
+	case "TZH":
+		_, z := t.Zone()
+		return z / (60 * 60)
+	case "TZM":
+		_, z := t.Zone()
+		if z < 0 {
+			z = -z
+		}
+		return (z / 60) % 60
+	}
+
+	// FF1 to FF9
+	n := int(code[2] - '0')
+	return t.Nanosecond() / int(math.Pow10(9-n))
+}
+
+func maskNames(loc *formatLocale, code string) []string {
+	switch code {
+	case "MONTH":
+		return loc.months
+	case "MON":
+		return loc.shortMonths
+	case "DAY":
+		return loc.days
+	default:
+		return loc.shortDays
+	}
+}
+
+/*
+Names are padded to the width of the longest name, as in SQL.
+*/
+func namesWidth(names []string) int {
+	rv := 0
+	for _, name := range names {
+		if n := utf8.RuneCountInString(name); n > rv {
+			rv = n
+		}
+	}
+	return rv
+}
+
+func setLetterCase(s string, letters int) string {
+	switch letters {
+	case _MASK_UPPER:
+		return strings.ToUpper(s)
+	case _MASK_LOWER:
+		return strings.ToLower(s)
+	}
+
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
+func formatDateMask(t time.Time, elems []*maskElement, loc *formatLocale) string {
+	var buf strings.Builder
+	for _, e := range elems {
+		switch e.kind {
+		case _MASK_LITERAL:
+			buf.WriteString(e.text)
+		case _MASK_NAME:
+			names := maskNames(loc, e.code)
+			v := maskValue(t, e.code)
+			if e.code == "MONTH" || e.code == "MON" {
+				v--
+			}
+			name := setLetterCase(names[v], e.letters)
+			buf.WriteString(name)
+			if !e.fill {
+				buf.WriteString(strings.Repeat(" ", namesWidth(names)-utf8.RuneCountInString(name)))
+			}
+		case _MASK_MERIDIEM:
+			m := "AM"
+			if maskValue(t, e.code) == 1 {
+				m = "PM"
+			}
+			if e.letters == _MASK_LOWER {
+				m = strings.ToLower(m)
+			}
+			buf.WriteString(m)
+		default:
+			v := maskValue(t, e.code)
+			if e.code == "TZH" {
+				_, z := t.Zone()
+				if z < 0 {
+					buf.WriteByte('-')
+					v = -v
+				} else {
+					buf.WriteByte('+')
+				}
+			}
+			s := strconv.Itoa(v)
+			if !e.fill && len(s) < e.width {
+				s = strings.Repeat("0", e.width-len(s)) + s
+			}
+			buf.WriteString(s)
+		}
+	}
+	return buf.String()
+}
+
+/*
+Parses s, which must match the mask exactly: numbers have the width
+of their element unless it has FM, and the elements must agree with
+each other, so that February 30th or a wrong day name are rejected.
+White space in the mask matches any white space.
+*/
+func parseDateMaskValue(s string, elems []*maskElement, loc *formatLocale) (time.Time, bool) {
+	var zero time.Time
+	values := make(map[string]int, len(elems))
+	padded := false
+	tzNegative := false
+
+	for _, e := range elems {
+		switch e.kind {
+		case _MASK_LITERAL:
+			for _, r := range e.text {
+				if unicode.IsSpace(r) {
+					n := len(s)
+					s = strings.TrimLeftFunc(s, unicode.IsSpace)
+					if n == len(s) && !padded {
+						return zero, false
+					}
+					padded = false
+					continue
+				}
+				c, size := utf8.DecodeRuneInString(s)
+				if size == 0 || c != r {
+					return zero, false
+				}
+				s = s[size:]
+				padded = false
+			}
+			continue
+
+		case _MASK_NAME:
+			names := maskNames(loc, e.code)
+			index, length := -1, 0
+			for i, name := range names {
+				if len(name) > length && len(s) >= len(name) && strings.EqualFold(s[:len(name)], name) {
+					index, length = i, len(name)
+				}
+			}
+			if index < 0 {
+				return zero, false
+			}
+			s = s[length:]
+
+			padded = false
+			if !e.fill {
+				pad := namesWidth(names) - utf8.RuneCountInString(names[index])
+				for ; pad > 0 && len(s) > 0 && s[0] == ' '; pad-- {
+					s = s[1:]
+					padded = true
+				}
+			}
+			if e.code == "MONTH" || e.code == "MON" {
+				index++
+			}
+			if !setMaskValue(values, e.code, index) {
+				return zero, false
+			}
+			continue
+
+		case _MASK_MERIDIEM:
+			if len(s) < 2 {
+				return zero, false
+			}
+			m := strings.ToUpper(s[:2])
+			if m != "AM" && m != "PM" {
+				return zero, false
+			}
+			s = s[2:]
+			if !setMaskValue(values, "AM", strings.Index("AMPM", m)/2) {
+				return zero, false
+			}
+
+		default:
+			if e.code == "TZH" {
+				if len(s) == 0 || (s[0] != '+' && s[0] != '-') {
+					return zero, false
+				}
+				tzNegative = s[0] == '-'
+				s = s[1:]
+			}
+
+			n := 0
+			for n < len(s) && n < e.width && s[n] >= '0' && s[n] <= '9' {
+				n++
+			}
+			if n == 0 || (!e.fill && n < e.width) {
+				return zero, false
+			}
+			v, _ := strconv.Atoi(s[:n])
+			s = s[n:]
+
+			if e.code == "TZH" && tzNegative {
+				v = -v
+			}
+			if !setMaskValue(values, e.code, v) {
+				return zero, false
+			}
+		}
+		padded = false
+	}
+
+	if s != "" {
+		return zero, false
+	}
+
+	t, ok := maskTime(values, tzNegative)
+	if !ok {
+		return zero, false
+	}
+
+	for code, v := range values {
+		if maskValue(t, code) != v {
+			return zero, false
+		}
+	}
+	return t, true
+}
+
+func setMaskValue(values map[string]int, code string, v int) bool {
+	if code == "HH12" {
+		code = "HH"
+	} else if code == "PM" {
+		code = "AM"
+	}
+
+	if old, ok := values[code]; ok && old != v {
+		return false
+	}
+	values[code] = v
+	return true
+}
+
+/*
+Builds the time from the parsed elements. Elements that are not needed
+to build it are checked against it afterwards.
+*/
+func maskTime(values map[string]int, tzNegative bool) (time.Time, bool) {
+	get := func(code string, dflt int) int {
+		if v, ok := values[code]; ok {
+			return v
+		}
+		return dflt
+	}
+
+	year := get("IYYY", 0)
+	if yy, ok := values["YY"]; ok {
+		if yy < 70 {
+			year = 2000 + yy
+		} else {
+			year = 1900 + yy
+		}
+	}
+	year = get("YYYY", year)
+
+	month := get("MM", get("MONTH", get("MON", 1)))
+	day := get("DD", 1)
+
+	hour := get("HH24", -1)
+	if hour < 0 {
+		hour = get("HH", 12)
+		if hour < 1 || hour > 12 {
+			return time.Time{}, false
+		}
+		hour = hour%12 + 12*get("AM", 0)
+	}
+
+	nanos := get("MS", 0) * int(time.Millisecond)
+	nanos = get("US", nanos/int(time.Microsecond)) * int(time.Microsecond)
+	for n := 1; n <= 9; n++ {
+		if v, ok := values["FF"+strconv.Itoa(n)]; ok {
+			nanos = v * int(math.Pow10(9-n))
+		}
+	}
+
+	loc := time.Local
+	_, tzh := values["TZH"]
+	_, tzm := values["TZM"]
+	if tzh || tzm {
+		minutes := get("TZM", 0)
+		if tzNegative {
+			minutes = -minutes
+		}
+		loc = time.FixedZone("", get("TZH", 0)*60*60+minutes*60)
+	}
+
+	var t time.Time
+	if week, ok := values["IW"]; ok && values["IYYY"] != 0 {
+		jan4 := time.Date(values["IYYY"], time.January, 4, 0, 0, 0, 0, loc)
+		monday := jan4.AddDate(0, 0, -((int(jan4.Weekday()) + 6) % 7))
+		t = monday.AddDate(0, 0, (week-1)*7+get("ID", 1)-1)
+		t = time.Date(t.Year(), t.Month(), t.Day(), hour, get("MI", 0), get("SS", 0), nanos, loc)
+	} else if doy, ok := values["DDD"]; ok {
+		t = time.Date(year, time.January, doy, hour, get("MI", 0), get("SS", 0), nanos, loc)
+	} else {
+		t = time.Date(year, time.Month(month), day, hour, get("MI", 0), get("SS", 0), nanos, loc)
+	}
+	return t, true
+}
@@ -0,0 +1,780 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+import (
+	"math"
+
+	"github.com/couchbase/query/value"
+)
+
+///////////////////////////////////////////////////
+//
+// StDistance
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function ST_DISTANCE(point1, point2 [, unit]). It returns
+the great circle distance between two points, in meters, or in unit, one of
+"m", "km", "ft", "mi" or "nmi".
+*/
+type StDistance struct {
+	FunctionBase
+}
+
+func NewStDistance(operands ...Expression) Function {
+	rv := &StDistance{
+		*NewFunctionBase("st_distance", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *StDistance) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *StDistance) Type() value.Type { return value.NUMBER }
+
+func (this *StDistance) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *StDistance) Apply(context Context, args ...value.Value) (value.Value, error) {
+	if rv := geoMissing(args); rv != nil {
+		return rv, nil
+	}
+
+	p1, ok1 := geoPointArg(args[0])
+	p2, ok2 := geoPointArg(args[1])
+	unit, ok3 := geoUnit(args[2:])
+	if !ok1 || !ok2 || !ok3 {
+		return value.NULL_VALUE, nil
+	}
+	return value.NewValue(geoDistance(p1, p2) / unit), nil
+}
+
+func (this *StDistance) MinArgs() int { return 2 }
+
+func (this *StDistance) MaxArgs() int { return 3 }
+
+/*
+Factory method pattern.
+*/
+func (this *StDistance) Constructor() FunctionConstructor {
+	return NewStDistance
+}
+
+///////////////////////////////////////////////////
+//
+// StDWithin
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function ST_DWITHIN(point1, point2, distance [, unit]).
+It returns true if the two points are no further apart than distance,
+in meters or unit.
+*/
+type StDWithin struct {
+	FunctionBase
+}
+
+func NewStDWithin(operands ...Expression) Function {
+	rv := &StDWithin{
+		*NewFunctionBase("st_dwithin", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *StDWithin) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *StDWithin) Type() value.Type { return value.BOOLEAN }
+
+func (this *StDWithin) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *StDWithin) Apply(context Context, args ...value.Value) (value.Value, error) {
+	if rv := geoMissing(args); rv != nil {
+		return rv, nil
+	}
+
+	p1, ok1 := geoPointArg(args[0])
+	p2, ok2 := geoPointArg(args[1])
+	unit, ok3 := geoUnit(args[3:])
+	if !ok1 || !ok2 || !ok3 || args[2].Type() != value.NUMBER {
+		return value.NULL_VALUE, nil
+	}
+	distance := value.AsNumberValue(args[2]).Float64() * unit
+	return value.NewValue(geoDistance(p1, p2) <= distance), nil
+}
+
+func (this *StDWithin) MinArgs() int { return 3 }
+
+func (this *StDWithin) MaxArgs() int { return 4 }
+
+/*
+Factory method pattern.
+*/
+func (this *StDWithin) Constructor() FunctionConstructor {
+	return NewStDWithin
+}
+
+///////////////////////////////////////////////////
+//
+// StWithin
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function ST_WITHIN(geometry1, geometry2). It returns
+true if geometry1 lies entirely within geometry2, boundary included.
+*/
+type StWithin struct {
+	BinaryFunctionBase
+}
+
+func NewStWithin(first, second Expression) Function {
+	rv := &StWithin{
+		*NewBinaryFunctionBase("st_within", first, second),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *StWithin) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *StWithin) Type() value.Type { return value.BOOLEAN }
+
+func (this *StWithin) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.BinaryEval(this, item, context)
+}
+
+func (this *StWithin) Apply(context Context, first, second value.Value) (value.Value, error) {
+	g1, g2, rv := geoBinaryArgs(first, second)
+	if rv != nil {
+		return rv, nil
+	}
+	return value.NewValue(g1.within(g2)), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *StWithin) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewStWithin(operands[0], operands[1])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// StContains
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function ST_CONTAINS(geometry1, geometry2). It returns
+true if geometry2 lies entirely within geometry1, boundary included.
+*/
+type StContains struct {
+	BinaryFunctionBase
+}
+
+func NewStContains(first, second Expression) Function {
+	rv := &StContains{
+		*NewBinaryFunctionBase("st_contains", first, second),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *StContains) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *StContains) Type() value.Type { return value.BOOLEAN }
+
+func (this *StContains) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.BinaryEval(this, item, context)
+}
+
+func (this *StContains) Apply(context Context, first, second value.Value) (value.Value, error) {
+	g1, g2, rv := geoBinaryArgs(first, second)
+	if rv != nil {
+		return rv, nil
+	}
+	return value.NewValue(g2.within(g1)), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *StContains) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewStContains(operands[0], operands[1])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// StIntersects
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function ST_INTERSECTS(geometry1, geometry2). It returns
+true if the two geometries have at least one point in common.
+*/
+type StIntersects struct {
+	BinaryFunctionBase
+}
+
+func NewStIntersects(first, second Expression) Function {
+	rv := &StIntersects{
+		*NewBinaryFunctionBase("st_intersects", first, second),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *StIntersects) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *StIntersects) Type() value.Type { return value.BOOLEAN }
+
+func (this *StIntersects) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.BinaryEval(this, item, context)
+}
+
+func (this *StIntersects) Apply(context Context, first, second value.Value) (value.Value, error) {
+	g1, g2, rv := geoBinaryArgs(first, second)
+	if rv != nil {
+		return rv, nil
+	}
+	return value.NewValue(g1.intersects(g2)), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *StIntersects) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewStIntersects(operands[0], operands[1])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// StBuffer
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function ST_BUFFER(point, distance [, unit]). It returns
+a GeoJSON polygon approximating the circle of the given radius around point.
+*/
+type StBuffer struct {
+	FunctionBase
+}
+
+func NewStBuffer(operands ...Expression) Function {
+	rv := &StBuffer{
+		*NewFunctionBase("st_buffer", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *StBuffer) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *StBuffer) Type() value.Type { return value.OBJECT }
+
+func (this *StBuffer) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+// vertices of the polygons returned by ST_BUFFER()
+const _BUFFER_SEGMENTS = 32
+
+func (this *StBuffer) Apply(context Context, args ...value.Value) (value.Value, error) {
+	if rv := geoMissing(args); rv != nil {
+		return rv, nil
+	}
+
+	center, ok1 := geoPointArg(args[0])
+	unit, ok2 := geoUnit(args[2:])
+	if !ok1 || !ok2 || args[1].Type() != value.NUMBER {
+		return value.NULL_VALUE, nil
+	}
+	distance := value.AsNumberValue(args[1]).Float64() * unit
+	if distance <= 0.0 {
+		return value.NULL_VALUE, nil
+	}
+
+	ring := make([]geoPoint, _BUFFER_SEGMENTS+1)
+	for i := 0; i < _BUFFER_SEGMENTS; i++ {
+		ring[i] = geoDestination(center, distance, 2*math.Pi*float64(i)/_BUFFER_SEGMENTS)
+	}
+	ring[_BUFFER_SEGMENTS] = ring[0]
+	return polygonValue(ring), nil
+}
+
+func (this *StBuffer) MinArgs() int { return 2 }
+
+func (this *StBuffer) MaxArgs() int { return 3 }
+
+/*
+Factory method pattern.
+*/
+func (this *StBuffer) Constructor() FunctionConstructor {
+	return NewStBuffer
+}
+
+///////////////////////////////////////////////////
+//
+// StBbox
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function ST_BBOX(geometry). It returns the bounding box
+of geometry, as [min longitude, min latitude, max longitude, max latitude].
+*/
+type StBbox struct {
+	UnaryFunctionBase
+}
+
+func NewStBbox(operand Expression) Function {
+	rv := &StBbox{
+		*NewUnaryFunctionBase("st_bbox", operand),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *StBbox) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *StBbox) Type() value.Type { return value.ARRAY }
+
+func (this *StBbox) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.UnaryEval(this, item, context)
+}
+
+func (this *StBbox) Apply(context Context, arg value.Value) (value.Value, error) {
+	if arg.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	}
+	g, ok := newGeometry(arg)
+	if !ok {
+		return value.NULL_VALUE, nil
+	}
+	return bboxValue(g.bbox()), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *StBbox) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewStBbox(operands[0])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// StMakeEnvelope
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function ST_MAKE_ENVELOPE(min_lon, min_lat, max_lon, max_lat).
+It returns the GeoJSON polygon of a bounding box.
+*/
+type StMakeEnvelope struct {
+	FunctionBase
+}
+
+func NewStMakeEnvelope(operands ...Expression) Function {
+	rv := &StMakeEnvelope{
+		*NewFunctionBase("st_make_envelope", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *StMakeEnvelope) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *StMakeEnvelope) Type() value.Type { return value.OBJECT }
+
+func (this *StMakeEnvelope) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *StMakeEnvelope) Apply(context Context, args ...value.Value) (value.Value, error) {
+	if rv := geoMissing(args); rv != nil {
+		return rv, nil
+	}
+
+	var bbox [4]float64
+	for i, arg := range args {
+		if arg.Type() != value.NUMBER {
+			return value.NULL_VALUE, nil
+		}
+		bbox[i] = value.AsNumberValue(arg).Float64()
+	}
+	if bbox[0] > bbox[2] || bbox[1] > bbox[3] ||
+		!validLatLon(bbox[1], bbox[0]) || !validLatLon(bbox[3], bbox[2]) {
+		return value.NULL_VALUE, nil
+	}
+
+	return polygonValue([]geoPoint{
+		{bbox[0], bbox[1]}, {bbox[2], bbox[1]}, {bbox[2], bbox[3]}, {bbox[0], bbox[3]}, {bbox[0], bbox[1]},
+	}), nil
+}
+
+func (this *StMakeEnvelope) MinArgs() int { return 4 }
+
+func (this *StMakeEnvelope) MaxArgs() int { return 4 }
+
+/*
+Factory method pattern.
+*/
+func (this *StMakeEnvelope) Constructor() FunctionConstructor {
+	return NewStMakeEnvelope
+}
+
+///////////////////////////////////////////////////
+//
+// GeohashEncode
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function GEOHASH_ENCODE(geometry [, precision]). It
+returns the geohash, precision characters long, 12 by default, of a point,
+or of the center of the bounding box of any other geometry. Index keys on
+GEOHASH_ENCODE() are used to scan for ST_WITHIN(), ST_CONTAINS() and
+ST_DWITHIN() against constant geometries.
+*/
+type GeohashEncode struct {
+	FunctionBase
+}
+
+func NewGeohashEncode(operands ...Expression) Function {
+	rv := &GeohashEncode{
+		*NewFunctionBase("geohash_encode", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *GeohashEncode) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *GeohashEncode) Type() value.Type { return value.STRING }
+
+func (this *GeohashEncode) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *GeohashEncode) Apply(context Context, args ...value.Value) (value.Value, error) {
+	if rv := geoMissing(args); rv != nil {
+		return rv, nil
+	}
+
+	g, ok := newGeometry(args[0])
+	if !ok {
+		return value.NULL_VALUE, nil
+	}
+	p, ok := g.point()
+	if !ok {
+		bbox := g.bbox()
+		p = geoPoint{(bbox[0] + bbox[2]) / 2, (bbox[1] + bbox[3]) / 2}
+	}
+	precision := GEOHASH_PRECISION
+	if len(args) > 1 {
+		precision, ok = geohashPrecision(args[1])
+		if !ok {
+			return value.NULL_VALUE, nil
+		}
+	}
+	return value.NewValue(geohashEncode(p, precision)), nil
+}
+
+func (this *GeohashEncode) MinArgs() int { return 1 }
+
+func (this *GeohashEncode) MaxArgs() int { return 2 }
+
+/*
+Factory method pattern.
+*/
+func (this *GeohashEncode) Constructor() FunctionConstructor {
+	return NewGeohashEncode
+}
+
+///////////////////////////////////////////////////
+//
+// GeohashDecode
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function GEOHASH_DECODE(geohash). It returns the
+GeoJSON point at the center of the geohash cell.
+*/
+type GeohashDecode struct {
+	UnaryFunctionBase
+}
+
+func NewGeohashDecode(operand Expression) Function {
+	rv := &GeohashDecode{
+		*NewUnaryFunctionBase("geohash_decode", operand),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *GeohashDecode) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *GeohashDecode) Type() value.Type { return value.OBJECT }
+
+func (this *GeohashDecode) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.UnaryEval(this, item, context)
+}
+
+func (this *GeohashDecode) Apply(context Context, arg value.Value) (value.Value, error) {
+	if arg.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	} else if arg.Type() != value.STRING {
+		return value.NULL_VALUE, nil
+	}
+	bbox, ok := geohashDecode(arg.Actual().(string))
+	if !ok {
+		return value.NULL_VALUE, nil
+	}
+	return pointValue(geoPoint{(bbox[0] + bbox[2]) / 2, (bbox[1] + bbox[3]) / 2}), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *GeohashDecode) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewGeohashDecode(operands[0])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// GeohashBbox
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function GEOHASH_BBOX(geohash). It returns the
+bounding box of the geohash cell, as ST_BBOX() does.
+*/
+type GeohashBbox struct {
+	UnaryFunctionBase
+}
+
+func NewGeohashBbox(operand Expression) Function {
+	rv := &GeohashBbox{
+		*NewUnaryFunctionBase("geohash_bbox", operand),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *GeohashBbox) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *GeohashBbox) Type() value.Type { return value.ARRAY }
+
+func (this *GeohashBbox) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.UnaryEval(this, item, context)
+}
+
+func (this *GeohashBbox) Apply(context Context, arg value.Value) (value.Value, error) {
+	if arg.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	} else if arg.Type() != value.STRING {
+		return value.NULL_VALUE, nil
+	}
+	bbox, ok := geohashDecode(arg.Actual().(string))
+	if !ok {
+		return value.NULL_VALUE, nil
+	}
+	return bboxValue(bbox), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *GeohashBbox) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewGeohashBbox(operands[0])
+	}
+}
+
+func geoMissing(args []value.Value) value.Value {
+	for _, arg := range args {
+		if arg.Type() == value.MISSING {
+			return value.MISSING_VALUE
+		}
+	}
+	return nil
+}
+
+func geoPointArg(arg value.Value) (geoPoint, bool) {
+	g, ok := newGeometry(arg)
+	if !ok {
+		return geoPoint{}, false
+	}
+	return g.point()
+}
+
+func geoBinaryArgs(first, second value.Value) (*geometry, *geometry, value.Value) {
+	if first.Type() == value.MISSING || second.Type() == value.MISSING {
+		return nil, nil, value.MISSING_VALUE
+	}
+	g1, ok1 := newGeometry(first)
+	g2, ok2 := newGeometry(second)
+	if !ok1 || !ok2 {
+		return nil, nil, value.NULL_VALUE
+	}
+	return g1, g2, nil
+}
+
+func geohashPrecision(arg value.Value) (int, bool) {
+	if arg.Type() != value.NUMBER {
+		return 0, false
+	}
+	f := value.AsNumberValue(arg).Float64()
+	if f < 1 || f > GEOHASH_PRECISION || f != math.Trunc(f) {
+		return 0, false
+	}
+	return int(f), true
+}
+
+/*
+For geospatial predicates restricting an expression to a constant
+region, return the expression and the bounding box of the region.
+The geohash of the expression then falls within that bounding box.
+*/
+func GeoRegion(pred Function) (Expression, [4]float64, bool) {
+	var source, region Expression
+	var distance Expressions
+
+	switch pred := pred.(type) {
+	case *StWithin:
+		source, region = pred.First(), pred.Second()
+	case *StContains:
+		source, region = pred.Second(), pred.First()
+	case *StDWithin:
+		operands := pred.Operands()
+		source, region, distance = operands[0], operands[1], operands[2:]
+		if region.Value() == nil {
+			source, region = region, source
+		}
+	default:
+		return nil, [4]float64{}, false
+	}
+
+	rv := region.Value()
+	if rv == nil {
+		return nil, [4]float64{}, false
+	}
+	g, ok := newGeometry(rv)
+	if !ok {
+		return nil, [4]float64{}, false
+	}
+	if distance == nil {
+		return source, g.bbox(), true
+	}
+
+	args := make([]value.Value, len(distance))
+	for i, d := range distance {
+		args[i] = d.Value()
+		if args[i] == nil {
+			return nil, [4]float64{}, false
+		}
+	}
+	center, ok1 := g.point()
+	unit, ok2 := geoUnit(args[1:])
+	if !ok1 || !ok2 || args[0].Type() != value.NUMBER {
+		return nil, [4]float64{}, false
+	}
+	bbox, ok := geoCircleBBox(center, value.AsNumberValue(args[0]).Float64()*unit)
+	return source, bbox, ok
+}
@@ -0,0 +1,79 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+import (
+	"testing"
+
+	"github.com/couchbase/query/value"
+)
+
+func TestGeoFunctions(t *testing.T) {
+	square := NewConstant(value.NewValue([]byte(`{"type": "Polygon", "coordinates": [
+		[[0, 0], [10, 0], [10, 10], [0, 10], [0, 0]],
+		[[4, 4], [6, 4], [6, 6], [4, 6], [4, 4]]]}`)))
+	london := NewConstant(value.NewValue([]byte(`{"lat": 51.5074, "lon": -0.1278}`)))
+	paris := NewConstant(value.NewValue([]byte(`{"type": "Point", "coordinates": [2.3522, 48.8566]}`)))
+	inside := NewConstant([]interface{}{1, 1})
+	hole := NewConstant([]interface{}{5, 5})
+	crossing := NewConstant(value.NewValue([]byte(`{"type": "LineString", "coordinates": [[-1, 5], [1, 5]]}`)))
+
+	tests := []struct {
+		expr     Expression
+		expected interface{}
+	}{
+		{NewStWithin(inside, square), true},
+		{NewStWithin(hole, square), false},
+		{NewStContains(square, inside), true},
+		{NewStWithin(crossing, square), false},
+		{NewStIntersects(crossing, square), true},
+		{NewStDWithin(london, paris, NewConstant(350), NewConstant("km")), true},
+		{NewStDWithin(london, paris, NewConstant(300), NewConstant("km")), false},
+		{NewGeohashEncode(NewConstant([]interface{}{-5.6, 42.6}), NewConstant(5)), "ezs42"},
+		{NewStBbox(square), []interface{}{0, 0, 10, 10}},
+		{NewGeohashBbox(NewConstant("s")), []interface{}{0, 0, 45, 45}},
+		{NewStWithin(NewConstant("nowhere"), square), nil},
+		{NewStDistance(square, london), nil},
+	}
+
+	for _, test := range tests {
+		rv, err := test.expr.Evaluate(nil, nil)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", test.expr, err)
+			continue
+		}
+		if rv.Collate(value.NewValue(test.expected)) != 0 {
+			t.Errorf("%v: expected %v, got %v", test.expr, test.expected, rv)
+		}
+	}
+
+	d, _ := NewStDistance(london, paris, NewConstant("km")).Evaluate(nil, nil)
+	if km := d.Actual().(float64); km < 343 || km > 345 {
+		t.Errorf("ST_DISTANCE() london to paris, expected about 344km, got %v", km)
+	}
+
+	buffer, _ := NewStBuffer(paris, NewConstant(1000)).Evaluate(nil, nil)
+	within, _ := NewStWithin(paris, NewConstant(buffer)).Evaluate(nil, nil)
+	if !within.Truth() {
+		t.Errorf("ST_BUFFER() %v does not contain its center", buffer)
+	}
+
+	cells := GeohashCover([4]float64{2.34, 48.85, 2.36, 48.86}, 6)
+	hash, _ := NewGeohashEncode(paris, NewConstant(6)).Evaluate(nil, nil)
+	covered := false
+	for _, cell := range cells {
+		if len(cell) <= 6 && hash.Actual().(string)[:len(cell)] == cell {
+			covered = true
+		}
+	}
+	if !covered {
+		t.Errorf("GeohashCover() %v does not cover %v", cells, hash)
+	}
+}
@@ -0,0 +1,644 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+// Geometries are GeoJSON Point, MultiPoint, LineString, MultiLineString,
+// Polygon, MultiPolygon and GeometryCollection objects, or Features
+// wrapping them, with coordinates in [longitude, latitude] order.
+// A point can also be given as a [longitude, latitude] array, or as an
+// object with lat and lon fields.
+//
+// Distances are great circle distances on a spherical earth; topological
+// predicates treat edges as straight lines in the longitude / latitude
+// plane, and do not handle geometries crossing the antimeridian.
+
+import (
+	"math"
+	"strings"
+
+	"github.com/couchbase/query/value"
+)
+
+const _EARTH_RADIUS = 6371008.8 // mean radius, in meters
+
+var geoUnits = map[string]float64{
+	"m":   1.0,
+	"km":  1000.0,
+	"ft":  0.3048,
+	"mi":  1609.344,
+	"nmi": 1852.0,
+}
+
+type geoPoint [2]float64 // longitude, latitude
+
+type geometry struct {
+	points   []geoPoint
+	lines    [][]geoPoint
+	polygons [][][]geoPoint // outer ring, then holes
+}
+
+func newGeometry(v value.Value) (*geometry, bool) {
+	rv := &geometry{}
+	if !rv.add(v.Actual(), 0) || rv.empty() {
+		return nil, false
+	}
+	return rv, true
+}
+
+func (this *geometry) empty() bool {
+	return len(this.points) == 0 && len(this.lines) == 0 && len(this.polygons) == 0
+}
+
+// single point geometries, as required by distances
+func (this *geometry) point() (geoPoint, bool) {
+	if len(this.points) == 1 && len(this.lines) == 0 && len(this.polygons) == 0 {
+		return this.points[0], true
+	}
+	return geoPoint{}, false
+}
+
+func (this *geometry) add(act interface{}, depth int) bool {
+	if depth > 16 {
+		return false
+	}
+
+	act = geoActual(act)
+	switch act := act.(type) {
+	case []interface{}:
+		p, ok := geoCoordinates(act)
+		if ok {
+			this.points = append(this.points, p)
+		}
+		return ok
+	case map[string]interface{}:
+	default:
+		return false
+	}
+
+	obj := act.(map[string]interface{})
+	if lat, ok := geoNumber(obj["lat"]); ok {
+		lon, ok := geoNumber(obj["lon"])
+		if !ok {
+			lon, ok = geoNumber(obj["lng"])
+		}
+		if !ok || !validLatLon(lat, lon) {
+			return false
+		}
+		this.points = append(this.points, geoPoint{lon, lat})
+		return true
+	}
+
+	t, _ := geoActual(obj["type"]).(string)
+	switch t {
+	case "Feature":
+		return this.add(obj["geometry"], depth+1)
+	case "GeometryCollection":
+		geometries, ok := geoActual(obj["geometries"]).([]interface{})
+		if !ok {
+			return false
+		}
+		for _, g := range geometries {
+			if !this.add(g, depth+1) {
+				return false
+			}
+		}
+		return true
+	}
+
+	coords := geoActual(obj["coordinates"])
+	switch t {
+	case "Point":
+		a, _ := coords.([]interface{})
+		p, ok := geoCoordinates(a)
+		if ok {
+			this.points = append(this.points, p)
+		}
+		return ok
+	case "MultiPoint":
+		points, ok := geoLine(coords, 1)
+		this.points = append(this.points, points...)
+		return ok
+	case "LineString":
+		line, ok := geoLine(coords, 2)
+		if ok {
+			this.lines = append(this.lines, line)
+		}
+		return ok
+	case "MultiLineString":
+		lines, ok := coords.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, l := range lines {
+			line, ok := geoLine(l, 2)
+			if !ok {
+				return false
+			}
+			this.lines = append(this.lines, line)
+		}
+		return true
+	case "Polygon":
+		polygon, ok := geoPolygon(coords)
+		if ok {
+			this.polygons = append(this.polygons, polygon)
+		}
+		return ok
+	case "MultiPolygon":
+		polygons, ok := coords.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, p := range polygons {
+			polygon, ok := geoPolygon(p)
+			if !ok {
+				return false
+			}
+			this.polygons = append(this.polygons, polygon)
+		}
+		return true
+	}
+	return false
+}
+
+// nested values may or may not be wrapped
+func geoActual(act interface{}) interface{} {
+	if v, ok := act.(value.Value); ok {
+		return v.Actual()
+	}
+	return act
+}
+
+// coordinates can be any of the numeric types a value holds
+func geoNumber(act interface{}) (float64, bool) {
+	v := value.NewValue(act)
+	if v.Type() != value.NUMBER {
+		return 0, false
+	}
+	return value.AsNumberValue(v).Float64(), true
+}
+
+func validLatLon(lat, lon float64) bool {
+	return lat >= -90.0 && lat <= 90.0 && lon >= -180.0 && lon <= 180.0
+}
+
+func geoCoordinates(a []interface{}) (geoPoint, bool) {
+	if len(a) < 2 {
+		return geoPoint{}, false
+	}
+	lon, ok1 := geoNumber(a[0])
+	lat, ok2 := geoNumber(a[1])
+	if !ok1 || !ok2 || !validLatLon(lat, lon) {
+		return geoPoint{}, false
+	}
+	return geoPoint{lon, lat}, true
+}
+
+func geoLine(act interface{}, min int) ([]geoPoint, bool) {
+	a, ok := geoActual(act).([]interface{})
+	if !ok || len(a) < min {
+		return nil, false
+	}
+	rv := make([]geoPoint, len(a))
+	for i, c := range a {
+		coords, _ := geoActual(c).([]interface{})
+		rv[i], ok = geoCoordinates(coords)
+		if !ok {
+			return nil, false
+		}
+	}
+	return rv, true
+}
+
+func geoPolygon(act interface{}) ([][]geoPoint, bool) {
+	rings, ok := geoActual(act).([]interface{})
+	if !ok || len(rings) == 0 {
+		return nil, false
+	}
+	rv := make([][]geoPoint, len(rings))
+	for i, r := range rings {
+
+		// rings are closed: at least a triangle, and the last point is the first
+		rv[i], ok = geoLine(r, 4)
+		if !ok || rv[i][0] != rv[i][len(rv[i])-1] {
+			return nil, false
+		}
+	}
+	return rv, true
+}
+
+// [min longitude, min latitude, max longitude, max latitude]
+func (this *geometry) bbox() [4]float64 {
+	rv := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	extend := func(p geoPoint) {
+		rv[0] = math.Min(rv[0], p[0])
+		rv[1] = math.Min(rv[1], p[1])
+		rv[2] = math.Max(rv[2], p[0])
+		rv[3] = math.Max(rv[3], p[1])
+	}
+	for _, p := range this.points {
+		extend(p)
+	}
+	for _, l := range this.lines {
+		for _, p := range l {
+			extend(p)
+		}
+	}
+	for _, polygon := range this.polygons {
+		for _, p := range polygon[0] {
+			extend(p)
+		}
+	}
+	return rv
+}
+
+func (this *geometry) vertices() []geoPoint {
+	rv := append([]geoPoint{}, this.points...)
+	for _, l := range this.lines {
+		rv = append(rv, l...)
+	}
+	for _, polygon := range this.polygons {
+		for _, ring := range polygon {
+			rv = append(rv, ring...)
+		}
+	}
+	return rv
+}
+
+// all the edges of lines and polygon rings
+func (this *geometry) edges() [][2]geoPoint {
+	var rv [][2]geoPoint
+	add := func(l []geoPoint) {
+		for i := 1; i < len(l); i++ {
+			rv = append(rv, [2]geoPoint{l[i-1], l[i]})
+		}
+	}
+	for _, l := range this.lines {
+		add(l)
+	}
+	for _, polygon := range this.polygons {
+		for _, ring := range polygon {
+			add(ring)
+		}
+	}
+	return rv
+}
+
+// whether this geometry lies entirely within other
+func (this *geometry) within(other *geometry) bool {
+	for _, p := range this.vertices() {
+		if !other.covers(p) {
+			return false
+		}
+	}
+
+	// for polygons, edges must not cross the boundary of other,
+	// and the holes of other must not be inside this
+	if len(other.polygons) > 0 {
+		for _, e := range this.edges() {
+			for _, o := range other.edges() {
+				if segmentsCross(e[0], e[1], o[0], o[1]) {
+					return false
+				}
+			}
+		}
+		for _, polygon := range other.polygons {
+			for _, hole := range polygon[1:] {
+				for _, p := range hole {
+					if this.coversInterior(p) {
+						return false
+					}
+				}
+			}
+		}
+	}
+	return true
+}
+
+func (this *geometry) coversInterior(p geoPoint) bool {
+	for _, polygon := range this.polygons {
+		if inPolygon(p, polygon) && !onRing(p, polygon[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *geometry) intersects(other *geometry) bool {
+	for _, p := range this.vertices() {
+		if other.covers(p) {
+			return true
+		}
+	}
+	for _, p := range other.vertices() {
+		if this.covers(p) {
+			return true
+		}
+	}
+	for _, e := range this.edges() {
+		for _, o := range other.edges() {
+			if segmentsIntersect(e[0], e[1], o[0], o[1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// whether a point lies in or on the geometry
+func (this *geometry) covers(p geoPoint) bool {
+	for _, q := range this.points {
+		if p == q {
+			return true
+		}
+	}
+	for _, l := range this.lines {
+		for i := 1; i < len(l); i++ {
+			if onSegment(p, l[i-1], l[i]) {
+				return true
+			}
+		}
+	}
+	for _, polygon := range this.polygons {
+		if inPolygon(p, polygon) {
+			return true
+		}
+	}
+	return false
+}
+
+func inPolygon(p geoPoint, polygon [][]geoPoint) bool {
+	if !inRing(p, polygon[0]) {
+		return false
+	}
+	for _, hole := range polygon[1:] {
+		if inRing(p, hole) && !onRing(p, hole) {
+			return false
+		}
+	}
+	return true
+}
+
+// ray casting, with points on the boundary inside
+func inRing(p geoPoint, ring []geoPoint) bool {
+	if onRing(p, ring) {
+		return true
+	}
+	in := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > p[1]) != (b[1] > p[1]) &&
+			p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0] {
+			in = !in
+		}
+	}
+	return in
+}
+
+func onRing(p geoPoint, ring []geoPoint) bool {
+	for i := 1; i < len(ring); i++ {
+		if onSegment(p, ring[i-1], ring[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+const _GEO_EPSILON = 1e-12
+
+func orientation(a, b, c geoPoint) float64 {
+	return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+}
+
+func onSegment(p, a, b geoPoint) bool {
+	return math.Abs(orientation(a, b, p)) <= _GEO_EPSILON &&
+		p[0] >= math.Min(a[0], b[0])-_GEO_EPSILON && p[0] <= math.Max(a[0], b[0])+_GEO_EPSILON &&
+		p[1] >= math.Min(a[1], b[1])-_GEO_EPSILON && p[1] <= math.Max(a[1], b[1])+_GEO_EPSILON
+}
+
+// proper crossing, with each segment strictly on both sides of the other
+func segmentsCross(a, b, c, d geoPoint) bool {
+	o1 := orientation(a, b, c)
+	o2 := orientation(a, b, d)
+	o3 := orientation(c, d, a)
+	o4 := orientation(c, d, b)
+	return ((o1 > _GEO_EPSILON && o2 < -_GEO_EPSILON) || (o1 < -_GEO_EPSILON && o2 > _GEO_EPSILON)) &&
+		((o3 > _GEO_EPSILON && o4 < -_GEO_EPSILON) || (o3 < -_GEO_EPSILON && o4 > _GEO_EPSILON))
+}
+
+func segmentsIntersect(a, b, c, d geoPoint) bool {
+	return segmentsCross(a, b, c, d) ||
+		onSegment(c, a, b) || onSegment(d, a, b) || onSegment(a, c, d) || onSegment(b, c, d)
+}
+
+func radians(d float64) float64 {
+	return d * math.Pi / 180.0
+}
+
+func degrees(r float64) float64 {
+	return r * 180.0 / math.Pi
+}
+
+// haversine distance, in meters
+func geoDistance(p1, p2 geoPoint) float64 {
+	lat1, lat2 := radians(p1[1]), radians(p2[1])
+	dLat := lat2 - lat1
+	dLon := radians(p2[0] - p1[0])
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * _EARTH_RADIUS * math.Asin(math.Min(1.0, math.Sqrt(h)))
+}
+
+// the point at distance meters from p, following bearing radians
+func geoDestination(p geoPoint, distance, bearing float64) geoPoint {
+	lat1, lon1 := radians(p[1]), radians(p[0])
+	d := distance / _EARTH_RADIUS
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(d) + math.Cos(lat1)*math.Sin(d)*math.Cos(bearing))
+	lon2 := lon1 + math.Atan2(math.Sin(bearing)*math.Sin(d)*math.Cos(lat1), math.Cos(d)-math.Sin(lat1)*math.Sin(lat2))
+	lon := math.Mod(degrees(lon2)+540.0, 360.0) - 180.0
+	return geoPoint{lon, degrees(lat2)}
+}
+
+// bounding box of the points within distance meters of p, false if it
+// includes a pole or crosses the antimeridian
+func geoCircleBBox(p geoPoint, distance float64) ([4]float64, bool) {
+	dLat := degrees(distance / _EARTH_RADIUS)
+	minLat, maxLat := p[1]-dLat, p[1]+dLat
+	if minLat <= -90.0 || maxLat >= 90.0 {
+		return [4]float64{}, false
+	}
+	dLon := degrees(math.Asin(math.Min(1.0, math.Sin(distance/_EARTH_RADIUS)/math.Cos(radians(p[1])))))
+	minLon, maxLon := p[0]-dLon, p[0]+dLon
+	if minLon < -180.0 || maxLon > 180.0 {
+		return [4]float64{}, false
+	}
+	return [4]float64{minLon, minLat, maxLon, maxLat}, true
+}
+
+func geoUnit(args []value.Value) (float64, bool) {
+	if len(args) == 0 {
+		return 1.0, true
+	}
+	if args[0].Type() != value.STRING {
+		return 0, false
+	}
+	rv, ok := geoUnits[strings.ToLower(args[0].Actual().(string))]
+	return rv, ok
+}
+
+func pointValue(p geoPoint) value.Value {
+	return value.NewValue(map[string]interface{}{
+		"type":        "Point",
+		"coordinates": []interface{}{p[0], p[1]},
+	})
+}
+
+func bboxValue(bbox [4]float64) value.Value {
+	return value.NewValue([]interface{}{bbox[0], bbox[1], bbox[2], bbox[3]})
+}
+
+func polygonValue(ring []geoPoint) value.Value {
+	coords := make([]interface{}, len(ring))
+	for i, p := range ring {
+		coords[i] = []interface{}{p[0], p[1]}
+	}
+	return value.NewValue(map[string]interface{}{
+		"type":        "Polygon",
+		"coordinates": []interface{}{coords},
+	})
+}
+
+///////////////////////////////////////////////////
+//
+// Geohash
+//
+///////////////////////////////////////////////////
+
+const _GEOHASH_BASE32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// the default and maximum geohash length
+const GEOHASH_PRECISION = 12
+
+func geohashEncode(p geoPoint, precision int) string {
+	minLat, maxLat := -90.0, 90.0
+	minLon, maxLon := -180.0, 180.0
+	rv := make([]byte, precision)
+
+	even := true
+	for i := 0; i < precision; i++ {
+		c := 0
+		for b := 4; b >= 0; b-- {
+			if even {
+				mid := (minLon + maxLon) / 2
+				if p[0] >= mid {
+					c |= 1 << uint(b)
+					minLon = mid
+				} else {
+					maxLon = mid
+				}
+			} else {
+				mid := (minLat + maxLat) / 2
+				if p[1] >= mid {
+					c |= 1 << uint(b)
+					minLat = mid
+				} else {
+					maxLat = mid
+				}
+			}
+			even = !even
+		}
+		rv[i] = _GEOHASH_BASE32[c]
+	}
+	return string(rv)
+}
+
+// bounding box of a geohash cell
+func geohashDecode(hash string) ([4]float64, bool) {
+	minLat, maxLat := -90.0, 90.0
+	minLon, maxLon := -180.0, 180.0
+
+	if hash == "" {
+		return [4]float64{}, false
+	}
+	even := true
+	for _, r := range strings.ToLower(hash) {
+		c := strings.IndexRune(_GEOHASH_BASE32, r)
+		if c < 0 {
+			return [4]float64{}, false
+		}
+		for b := 4; b >= 0; b-- {
+			bit := c&(1<<uint(b)) != 0
+			if even {
+				mid := (minLon + maxLon) / 2
+				if bit {
+					minLon = mid
+				} else {
+					maxLon = mid
+				}
+			} else {
+				mid := (minLat + maxLat) / 2
+				if bit {
+					minLat = mid
+				} else {
+					maxLat = mid
+				}
+			}
+			even = !even
+		}
+	}
+	return [4]float64{minLon, minLat, maxLon, maxLat}, true
+}
+
+// cap on the number of index spans a geospatial predicate turns into
+const _GEOHASH_MAX_CELLS = 16
+
+/*
+The geohash prefixes, of at most precision characters, of the cells
+covering a bounding box, using the longest prefixes that need no more
+than a handful of cells. Returns nil if even single character cells
+are too many.
+*/
+func GeohashCover(bbox [4]float64, precision int) []string {
+	if precision > GEOHASH_PRECISION {
+		precision = GEOHASH_PRECISION
+	}
+
+	for c := precision; c > 0; c-- {
+		lonBits := uint((5*c + 1) / 2)
+		latBits := uint(5 * c / 2)
+		w := 360.0 / float64(uint64(1)<<lonBits)
+		h := 180.0 / float64(uint64(1)<<latBits)
+
+		x0, x1 := geohashCell(bbox[0]+180.0, w, lonBits), geohashCell(bbox[2]+180.0, w, lonBits)
+		y0, y1 := geohashCell(bbox[1]+90.0, h, latBits), geohashCell(bbox[3]+90.0, h, latBits)
+		if (x1-x0+1)*(y1-y0+1) > _GEOHASH_MAX_CELLS {
+			continue
+		}
+
+		rv := make([]string, 0, (x1-x0+1)*(y1-y0+1))
+		for x := x0; x <= x1; x++ {
+			for y := y0; y <= y1; y++ {
+				center := geoPoint{(float64(x)+0.5)*w - 180.0, (float64(y)+0.5)*h - 90.0}
+				rv = append(rv, geohashEncode(center, c))
+			}
+		}
+		return rv
+	}
+	return nil
+}
+
+func geohashCell(offset, size float64, bits uint) int {
+	rv := int(math.Floor(offset / size))
+	if max := int(uint64(1)<<bits) - 1; rv > max {
+		rv = max
+	} else if rv < 0 {
+		rv = 0
+	}
+	return rv
+}
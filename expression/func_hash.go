@@ -0,0 +1,612 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"strings"
+
+	"github.com/couchbase/query/util"
+	"github.com/couchbase/query/value"
+)
+
+// Strings are hashed as their bytes, binary values as is, and any
+// other value as its JSON encoding, with object fields in sorted order,
+// so that equal documents have equal hashes.
+// The digest is returned as a hex string, or as a base64 string if the
+// last argument is "base64".
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha224": sha256.New224,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+///////////////////////////////////////////////////
+//
+// Md5
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function MD5(expr [, format]). It returns
+the MD5 digest of expr.
+*/
+type Md5 struct {
+	FunctionBase
+}
+
+func NewMd5(operands ...Expression) Function {
+	rv := &Md5{
+		*NewFunctionBase("md5", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *Md5) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *Md5) Type() value.Type { return value.STRING }
+
+func (this *Md5) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *Md5) Apply(context Context, args ...value.Value) (value.Value, error) {
+	return hashDigest(md5.New, args[0], args[1:])
+}
+
+func (this *Md5) MinArgs() int { return 1 }
+
+func (this *Md5) MaxArgs() int { return 2 }
+
+/*
+Factory method pattern.
+*/
+func (this *Md5) Constructor() FunctionConstructor {
+	return NewMd5
+}
+
+///////////////////////////////////////////////////
+//
+// Sha1
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function SHA1(expr [, format]). It returns
+the SHA-1 digest of expr.
+*/
+type Sha1 struct {
+	FunctionBase
+}
+
+func NewSha1(operands ...Expression) Function {
+	rv := &Sha1{
+		*NewFunctionBase("sha1", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *Sha1) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *Sha1) Type() value.Type { return value.STRING }
+
+func (this *Sha1) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *Sha1) Apply(context Context, args ...value.Value) (value.Value, error) {
+	return hashDigest(sha1.New, args[0], args[1:])
+}
+
+func (this *Sha1) MinArgs() int { return 1 }
+
+func (this *Sha1) MaxArgs() int { return 2 }
+
+/*
+Factory method pattern.
+*/
+func (this *Sha1) Constructor() FunctionConstructor {
+	return NewSha1
+}
+
+///////////////////////////////////////////////////
+//
+// Sha224
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function SHA224(expr [, format]). It returns
+the SHA-224 digest of expr.
+*/
+type Sha224 struct {
+	FunctionBase
+}
+
+func NewSha224(operands ...Expression) Function {
+	rv := &Sha224{
+		*NewFunctionBase("sha224", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *Sha224) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *Sha224) Type() value.Type { return value.STRING }
+
+func (this *Sha224) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *Sha224) Apply(context Context, args ...value.Value) (value.Value, error) {
+	return hashDigest(sha256.New224, args[0], args[1:])
+}
+
+func (this *Sha224) MinArgs() int { return 1 }
+
+func (this *Sha224) MaxArgs() int { return 2 }
+
+/*
+Factory method pattern.
+*/
+func (this *Sha224) Constructor() FunctionConstructor {
+	return NewSha224
+}
+
+///////////////////////////////////////////////////
+//
+// Sha256
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function SHA256(expr [, format]). It returns
+the SHA-256 digest of expr.
+*/
+type Sha256 struct {
+	FunctionBase
+}
+
+func NewSha256(operands ...Expression) Function {
+	rv := &Sha256{
+		*NewFunctionBase("sha256", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *Sha256) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *Sha256) Type() value.Type { return value.STRING }
+
+func (this *Sha256) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *Sha256) Apply(context Context, args ...value.Value) (value.Value, error) {
+	return hashDigest(sha256.New, args[0], args[1:])
+}
+
+func (this *Sha256) MinArgs() int { return 1 }
+
+func (this *Sha256) MaxArgs() int { return 2 }
+
+/*
+Factory method pattern.
+*/
+func (this *Sha256) Constructor() FunctionConstructor {
+	return NewSha256
+}
+
+///////////////////////////////////////////////////
+//
+// Sha384
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function SHA384(expr [, format]). It returns
+the SHA-384 digest of expr.
+*/
+type Sha384 struct {
+	FunctionBase
+}
+
+func NewSha384(operands ...Expression) Function {
+	rv := &Sha384{
+		*NewFunctionBase("sha384", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *Sha384) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *Sha384) Type() value.Type { return value.STRING }
+
+func (this *Sha384) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *Sha384) Apply(context Context, args ...value.Value) (value.Value, error) {
+	return hashDigest(sha512.New384, args[0], args[1:])
+}
+
+func (this *Sha384) MinArgs() int { return 1 }
+
+func (this *Sha384) MaxArgs() int { return 2 }
+
+/*
+Factory method pattern.
+*/
+func (this *Sha384) Constructor() FunctionConstructor {
+	return NewSha384
+}
+
+///////////////////////////////////////////////////
+//
+// Sha512
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function SHA512(expr [, format]). It returns
+the SHA-512 digest of expr.
+*/
+type Sha512 struct {
+	FunctionBase
+}
+
+func NewSha512(operands ...Expression) Function {
+	rv := &Sha512{
+		*NewFunctionBase("sha512", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *Sha512) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *Sha512) Type() value.Type { return value.STRING }
+
+func (this *Sha512) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *Sha512) Apply(context Context, args ...value.Value) (value.Value, error) {
+	return hashDigest(sha512.New, args[0], args[1:])
+}
+
+func (this *Sha512) MinArgs() int { return 1 }
+
+func (this *Sha512) MaxArgs() int { return 2 }
+
+/*
+Factory method pattern.
+*/
+func (this *Sha512) Constructor() FunctionConstructor {
+	return NewSha512
+}
+
+///////////////////////////////////////////////////
+//
+// Hmac
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function HMAC(key, expr [, algorithm [, format]]).
+It returns the HMAC of expr keyed by key, using one of md5, sha1,
+sha224, sha256 (the default), sha384 or sha512.
+*/
+type Hmac struct {
+	FunctionBase
+}
+
+func NewHmac(operands ...Expression) Function {
+	rv := &Hmac{
+		*NewFunctionBase("hmac", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *Hmac) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *Hmac) Type() value.Type { return value.STRING }
+
+func (this *Hmac) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *Hmac) Apply(context Context, args ...value.Value) (value.Value, error) {
+	newHash := sha256.New
+	if len(args) > 2 {
+		if args[2].Type() == value.MISSING {
+			return value.MISSING_VALUE, nil
+		} else if args[2].Type() != value.STRING {
+			return value.NULL_VALUE, nil
+		}
+		newHash = hashAlgorithms[strings.ToLower(args[2].Actual().(string))]
+		if newHash == nil {
+			return value.NULL_VALUE, nil
+		}
+	}
+
+	key := args[0]
+	if key.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	} else if key.Type() == value.NULL {
+		return value.NULL_VALUE, nil
+	}
+
+	var format value.Values
+	if len(args) > 3 {
+		format = args[3:]
+	}
+
+	return hashDigest(func() hash.Hash {
+		return hmac.New(newHash, hashInput(key))
+	}, args[1], format)
+}
+
+func (this *Hmac) MinArgs() int { return 2 }
+
+func (this *Hmac) MaxArgs() int { return 4 }
+
+/*
+Factory method pattern.
+*/
+func (this *Hmac) Constructor() FunctionConstructor {
+	return NewHmac
+}
+
+///////////////////////////////////////////////////
+//
+// Crc32
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function CRC32(expr [, format]). It returns the
+IEEE CRC-32 checksum of expr, or, if format is "number", the checksum
+as a number.
+*/
+type Crc32 struct {
+	FunctionBase
+}
+
+func NewCrc32(operands ...Expression) Function {
+	rv := &Crc32{
+		*NewFunctionBase("crc32", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *Crc32) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *Crc32) Type() value.Type { return value.JSON }
+
+func (this *Crc32) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *Crc32) Apply(context Context, args ...value.Value) (value.Value, error) {
+	return hashDigest(func() hash.Hash { return crc32.NewIEEE() }, args[0], args[1:])
+}
+
+func (this *Crc32) MinArgs() int { return 1 }
+
+func (this *Crc32) MaxArgs() int { return 2 }
+
+/*
+Factory method pattern.
+*/
+func (this *Crc32) Constructor() FunctionConstructor {
+	return NewCrc32
+}
+
+///////////////////////////////////////////////////
+//
+// Hash64
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function HASH64(expr [, format]). It returns a fast,
+non cryptographic, 64 bit hash of expr, or, if format is "number", the
+hash as a (signed) number.
+*/
+type Hash64 struct {
+	FunctionBase
+}
+
+func NewHash64(operands ...Expression) Function {
+	rv := &Hash64{
+		*NewFunctionBase("hash64", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *Hash64) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *Hash64) Type() value.Type { return value.JSON }
+
+func (this *Hash64) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *Hash64) Apply(context Context, args ...value.Value) (value.Value, error) {
+	return hashDigest(newSeaHash64, args[0], args[1:])
+}
+
+func (this *Hash64) MinArgs() int { return 1 }
+
+func (this *Hash64) MaxArgs() int { return 2 }
+
+/*
+Factory method pattern.
+*/
+func (this *Hash64) Constructor() FunctionConstructor {
+	return NewHash64
+}
+
+func hashInput(arg value.Value) []byte {
+	switch arg.Type() {
+	case value.STRING:
+		return []byte(arg.Actual().(string))
+	case value.BINARY:
+		return arg.Actual().([]byte)
+	default:
+		bytes, _ := arg.MarshalJSON()
+		return bytes
+	}
+}
+
+func hashDigest(newHash func() hash.Hash, arg value.Value, format value.Values) (value.Value, error) {
+	if arg.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	}
+	f := "hex"
+	if len(format) > 0 {
+		if format[0].Type() == value.MISSING {
+			return value.MISSING_VALUE, nil
+		} else if format[0].Type() != value.STRING {
+			return value.NULL_VALUE, nil
+		}
+		f = strings.ToLower(format[0].Actual().(string))
+	}
+	if arg.Type() == value.NULL {
+		return value.NULL_VALUE, nil
+	}
+
+	h := newHash()
+	h.Write(hashInput(arg))
+	sum := h.Sum(nil)
+
+	switch f {
+	case "hex":
+		return value.NewValue(hex.EncodeToString(sum)), nil
+	case "base64":
+		return value.NewValue(base64.StdEncoding.EncodeToString(sum)), nil
+	case "number":
+		switch len(sum) {
+		case 4:
+			return value.NewValue(int64(binary.BigEndian.Uint32(sum))), nil
+		case 8:
+			return value.NewValue(int64(binary.BigEndian.Uint64(sum))), nil
+		}
+	}
+	return value.NULL_VALUE, nil
+}
+
+// seahash as a hash.Hash, for HASH64()
+type seaHash64 struct {
+	bytes []byte
+}
+
+func newSeaHash64() hash.Hash {
+	return &seaHash64{}
+}
+
+func (this *seaHash64) Write(p []byte) (int, error) {
+	this.bytes = append(this.bytes, p...)
+	return len(p), nil
+}
+
+func (this *seaHash64) Sum(b []byte) []byte {
+	var sum [8]byte
+	binary.BigEndian.PutUint64(sum[:], util.SeaHashSum64(this.bytes))
+	return append(b, sum[:]...)
+}
+
+func (this *seaHash64) Reset() {
+	this.bytes = this.bytes[:0]
+}
+
+func (this *seaHash64) Size() int {
+	return 8
+}
+
+func (this *seaHash64) BlockSize() int {
+	return 1
+}
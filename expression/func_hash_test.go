@@ -0,0 +1,63 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+import (
+	"testing"
+
+	"github.com/couchbase/query/value"
+)
+
+func TestHashFunctions(t *testing.T) {
+	abc := NewConstant("abc")
+	tests := []struct {
+		expr     Expression
+		expected interface{}
+	}{
+		{NewMd5(abc), "900150983cd24fb0d6963f7d28e17f72"},
+		{NewSha1(abc), "a9993e364706816aba3e25717850c26c9cd0d89d"},
+		{NewSha224(abc), "23097d223405d8228642a477bda255b32aadbce4bda0b3f7e36c9da7"},
+		{NewSha256(abc), "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{NewSha256(abc, NewConstant("base64")), "ungWv48Bz+pBQUDeXa4iI7ADYaOWF3qctBD/YfIAFa0="},
+		{NewSha384(abc), "cb00753f45a35e8bb5a03d699ac65007272c32ab0eded1631a8b605a43ff5bed" +
+			"8086072ba1e7cc2358baeca134c825a7"},
+		{NewSha512(abc), "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a" +
+			"2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f"},
+		{NewHmac(NewConstant("key"), NewConstant("The quick brown fox jumps over the lazy dog")),
+			"f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"},
+		{NewHmac(NewConstant("key"), NewConstant("The quick brown fox jumps over the lazy dog"), NewConstant("md5")),
+			"80070713463e7749b90c2dc24911e275"},
+		{NewHmac(NewConstant("key"), NewConstant("The quick brown fox jumps over the lazy dog"), NewConstant("sha256"),
+			NewConstant("base64")), "97yD9DBThCSxMpjmqm+xQ+9NWaFJRhdZl0edvC0aPNg="},
+		{NewCrc32(abc), "352441c2"},
+		{NewCrc32(abc, NewConstant("number")), int64(891568578)},
+		{NewMd5(NewConstant(nil)), nil},
+		{NewHmac(NewConstant("key"), abc, NewConstant("sha3")), nil},
+		{NewMd5(abc, NewConstant("octal")), nil},
+	}
+
+	for _, test := range tests {
+		rv, err := test.expr.Evaluate(nil, nil)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", test.expr, err)
+			continue
+		}
+		if rv.Collate(value.NewValue(test.expected)) != 0 {
+			t.Errorf("%v: expected %v, got %v", test.expr, test.expected, rv)
+		}
+	}
+
+	// objects hash the same whatever their field order
+	h1, _ := NewHash64(NewConstant(map[string]interface{}{"a": 1, "b": 2})).Evaluate(nil, nil)
+	h2, _ := NewHash64(NewConstant(map[string]interface{}{"b": 2, "a": 1})).Evaluate(nil, nil)
+	if h1.Type() != value.STRING || h1.Collate(h2) != 0 {
+		t.Errorf("HASH64 mismatch %v %v", h1, h2)
+	}
+}
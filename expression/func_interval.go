@@ -0,0 +1,569 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/value"
+)
+
+///////////////////////////////////////////////////
+//
+// ToInterval
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the Date function TO_INTERVAL(expr). It converts an
+ISO 8601 duration such as "P1DT2H", a list of quantities and units
+such as "1 day 2 hours", or a number of milliseconds to an interval.
+The literal INTERVAL "1 day 2 hours" is the same interval.
+*/
+type ToInterval struct {
+	UnaryFunctionBase
+}
+
+func NewToInterval(operand Expression) Function {
+	rv := &ToInterval{
+		*NewUnaryFunctionBase("to_interval", operand),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *ToInterval) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *ToInterval) Type() value.Type { return value.INTERVAL }
+
+func (this *ToInterval) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.UnaryEval(this, item, context)
+}
+
+func (this *ToInterval) Apply(context Context, arg value.Value) (value.Value, error) {
+	iv, ok := toInterval(arg)
+	if !ok {
+		if arg.Type() == value.MISSING {
+			return value.MISSING_VALUE, nil
+		}
+		return value.NULL_VALUE, nil
+	}
+	return value.NewIntervalValue(iv), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *ToInterval) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewToInterval(operands[0])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// Age
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the Date function AGE(expr1 [, expr2 ]). It returns
+the interval from expr2 to expr1 in years, months, days and time, such
+as P1Y2M3DT4H. The default for expr2 is the start of the statement.
+The dates are date strings in a supported format, or numbers
+representing UNIX milliseconds.
+*/
+type Age struct {
+	FunctionBase
+}
+
+func NewAge(operands ...Expression) Function {
+	rv := &Age{
+		*NewFunctionBase("age", operands...),
+	}
+
+	if len(operands) < 2 {
+		rv.setVolatile()
+	}
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *Age) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *Age) Type() value.Type { return value.INTERVAL }
+
+func (this *Age) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *Age) Apply(context Context, args ...value.Value) (value.Value, error) {
+	for _, arg := range args {
+		if arg.Type() == value.MISSING {
+			return value.MISSING_VALUE, nil
+		}
+	}
+
+	t1, _, ok := valueToTime(args[0], time.Local)
+	if !ok {
+		return value.NULL_VALUE, nil
+	}
+
+	var t2 time.Time
+	if len(args) > 1 {
+		t2, _, ok = valueToTime(args[1], time.Local)
+		if !ok {
+			return value.NULL_VALUE, nil
+		}
+	} else {
+		t2 = context.Now()
+	}
+
+	return value.NewIntervalValue(age(t1, t2)), nil
+}
+
+/*
+Minimum input arguments required for the defined function
+AGE is 1.
+*/
+func (this *Age) MinArgs() int { return 1 }
+
+/*
+Maximum input arguments allowed for the defined function
+AGE is 2.
+*/
+func (this *Age) MaxArgs() int { return 2 }
+
+/*
+Factory method pattern.
+*/
+func (this *Age) Constructor() FunctionConstructor {
+	return NewAge
+}
+
+///////////////////////////////////////////////////
+//
+// DateBin
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the Date function DATE_BIN(stride, expr [, origin
+[, tz ]]). It returns the start of the bin of expr, where the bins are
+stride long and one of them starts at origin, for grouping time series.
+The stride is an interval, or a string to convert to one, of either
+months, days, or a fixed length. Months and days are calendar months
+and days in the time zone tz, or that of expr, so that the bins follow
+daylight saving time. The default origin is Monday 2000-01-03T00:00:00, so that weekly
+bins start on Mondays. The result is a date string in the format of
+expr, or UNIX milliseconds if expr is a number.
+*/
+type DateBin struct {
+	FunctionBase
+}
+
+func NewDateBin(operands ...Expression) Function {
+	rv := &DateBin{
+		*NewFunctionBase("date_bin", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *DateBin) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *DateBin) Type() value.Type { return value.JSON }
+
+func (this *DateBin) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *DateBin) Apply(context Context, args ...value.Value) (value.Value, error) {
+	for _, arg := range args {
+		if arg.Type() == value.MISSING {
+			return value.MISSING_VALUE, nil
+		}
+	}
+
+	loc, ok := intervalLocation(args, 3)
+	if !ok {
+		return value.NULL_VALUE, nil
+	}
+
+	stride, ok := toInterval(args[0])
+	if !ok {
+		return value.NULL_VALUE, nil
+	}
+
+	t, format, ok := valueToTime(args[1], loc)
+	if !ok {
+		return value.NULL_VALUE, nil
+	} else if len(args) <= 3 {
+		loc = t.Location()
+	}
+
+	origin := time.Date(2000, time.January, 3, 0, 0, 0, 0, loc)
+	if len(args) > 2 {
+		origin, _, ok = valueToTime(args[2], loc)
+		if !ok {
+			return value.NULL_VALUE, nil
+		}
+	}
+
+	bin, err := dateBin(stride, t.In(loc), origin.In(loc))
+	if err != nil {
+		return nil, err
+	}
+	return timeToValue(bin, format, stride), nil
+}
+
+/*
+Minimum input arguments required for the defined function
+DATE_BIN is 2.
+*/
+func (this *DateBin) MinArgs() int { return 2 }
+
+/*
+Maximum input arguments allowed for the defined function
+DATE_BIN is 4.
+*/
+func (this *DateBin) MaxArgs() int { return 4 }
+
+/*
+Factory method pattern.
+*/
+func (this *DateBin) Constructor() FunctionConstructor {
+	return NewDateBin
+}
+
+///////////////////////////////////////////////////
+//
+// DateRange
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the Date function DATE_RANGE(start, end, step [, tz ]).
+Like generate_series, it returns the dates from start to end,
+including end, step apart. The step is an interval, or a string to
+convert to one, and may be negative. Each date is start plus a
+multiple of step, in calendar months and days in the time zone tz, or
+that of start, so that the dates do not drift at the end of months and follow daylight
+saving time. The dates are date strings in the format of start, or
+UNIX milliseconds if start is a number.
+*/
+type DateRange struct {
+	FunctionBase
+}
+
+func NewDateRange(operands ...Expression) Function {
+	rv := &DateRange{
+		*NewFunctionBase("date_range", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *DateRange) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *DateRange) Type() value.Type { return value.ARRAY }
+
+func (this *DateRange) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *DateRange) Apply(context Context, args ...value.Value) (value.Value, error) {
+	for _, arg := range args {
+		if arg.Type() == value.MISSING {
+			return value.MISSING_VALUE, nil
+		}
+	}
+
+	loc, ok := intervalLocation(args, 3)
+	if !ok {
+		return value.NULL_VALUE, nil
+	}
+
+	start, format, ok := valueToTime(args[0], loc)
+	if !ok {
+		return value.NULL_VALUE, nil
+	} else if len(args) <= 3 {
+		loc = start.Location()
+	}
+
+	end, _, ok := valueToTime(args[1], loc)
+	if !ok {
+		return value.NULL_VALUE, nil
+	}
+
+	step, ok := toInterval(args[2])
+	if !ok {
+		return value.NULL_VALUE, nil
+	}
+
+	length := step.Length()
+	if length == 0 {
+		return nil, fmt.Errorf("DATE_RANGE() step %s must not be zero.", step)
+	}
+
+	start = start.In(loc)
+	rv := make([]interface{}, 0, 16)
+	for n := int64(0); ; n++ {
+		t := step.Scale(n).AddTo(start)
+		if (length > 0 && t.After(end)) || (length < 0 && t.Before(end)) {
+			break
+		}
+		if len(rv) >= RANGE_LIMIT {
+			return nil, errors.NewRangeError("DATE_RANGE()")
+		}
+		rv = append(rv, timeToValue(t, format, step))
+	}
+
+	return value.NewValue(rv), nil
+}
+
+/*
+Minimum input arguments required for the defined function
+DATE_RANGE is 3.
+*/
+func (this *DateRange) MinArgs() int { return 3 }
+
+/*
+Maximum input arguments allowed for the defined function
+DATE_RANGE is 4.
+*/
+func (this *DateRange) MaxArgs() int { return 4 }
+
+/*
+Factory method pattern.
+*/
+func (this *DateRange) Constructor() FunctionConstructor {
+	return NewDateRange
+}
+
+/*
+Converts an interval, a string or a number of milliseconds to an
+interval.
+*/
+func toInterval(arg value.Value) (value.Interval, bool) {
+	switch arg.Type() {
+	case value.INTERVAL:
+		return arg.Actual().(value.Interval), true
+	case value.STRING:
+		iv, err := value.ParseInterval(arg.Actual().(string))
+		return iv, err == nil
+	case value.NUMBER:
+		return value.Interval{Millis: int64(value.AsNumberValue(arg).Float64())}, true
+	default:
+		return value.Interval{}, false
+	}
+}
+
+/*
+Returns the time zone argument at pos, or the local time zone.
+*/
+func intervalLocation(args []value.Value, pos int) (*time.Location, bool) {
+	if len(args) <= pos {
+		return time.Local, true
+	}
+	if args[pos].Type() != value.STRING {
+		return nil, false
+	}
+
+	loc, err := time.LoadLocation(args[pos].Actual().(string))
+	return loc, err == nil
+}
+
+/*
+Converts a date string or a number of milliseconds to a time. Date
+strings without a time zone are in loc. The format is empty for
+milliseconds.
+*/
+func valueToTime(arg value.Value, loc *time.Location) (time.Time, string, bool) {
+	switch arg.Type() {
+	case value.NUMBER:
+		return millisToTime(value.AsNumberValue(arg).Float64()).In(loc), "", true
+	case value.STRING:
+		s := arg.Actual().(string)
+		for _, f := range _DATE_FORMATS {
+			t, err := time.ParseInLocation(f, s, loc)
+			if err == nil {
+				return t, f, true
+			}
+		}
+	}
+	return time.Time{}, "", false
+}
+
+/*
+Converts a time to a date string in format, or to milliseconds if the
+format is empty. Dates without a time of day get one if the interval
+has a time part.
+*/
+func timeToValue(t time.Time, format string, iv value.Interval) value.Value {
+	if format == "" {
+		return value.NewValue(timeToMillis(t))
+	}
+	if iv.Millis != 0 && !strings.Contains(format, "15") {
+		format = DEFAULT_FORMAT
+	}
+	return value.NewValue(t.Format(format))
+}
+
+/*
+Adds intervals to each other, or to one date string or number of
+milliseconds. Other combinations are NULL.
+*/
+func addIntervals(args []value.Value) value.Value {
+	var sum value.Interval
+	var date value.Value
+	intervals := false
+
+	for _, arg := range args {
+		switch arg.Type() {
+		case value.INTERVAL:
+			sum = sum.Add(arg.Actual().(value.Interval))
+			intervals = true
+		case value.STRING, value.NUMBER:
+			if date != nil {
+				return value.NULL_VALUE
+			}
+			date = arg
+		default:
+			return value.NULL_VALUE
+		}
+	}
+
+	if !intervals {
+		return value.NULL_VALUE
+	} else if date == nil {
+		return value.NewIntervalValue(sum)
+	}
+
+	t, format, ok := valueToTime(date, time.Local)
+	if !ok {
+		return value.NULL_VALUE
+	}
+	return timeToValue(sum.AddTo(t), format, sum)
+}
+
+/*
+The type of addition, subtraction and negation, which are intervals or
+dates when there are intervals.
+*/
+func arithType(operands Expressions) value.Type {
+	for _, op := range operands {
+		if op.Type() == value.INTERVAL {
+			return value.JSON
+		}
+	}
+	return value.NUMBER
+}
+
+/*
+The interval from t2 to t1 in months, days and milliseconds, with as
+many whole months and then days as fit.
+*/
+func age(t1, t2 time.Time) value.Interval {
+	neg := t1.Before(t2)
+	if neg {
+		t1, t2 = t2, t1
+	}
+	t2 = t2.In(t1.Location())
+
+	months := int64(t1.Year()-t2.Year())*12 + int64(t1.Month()-t2.Month())
+	for months > 0 && (value.Interval{Months: months}).AddTo(t2).After(t1) {
+		months--
+	}
+	mid := (value.Interval{Months: months}).AddTo(t2)
+
+	days := int64(0)
+	for !mid.AddDate(0, 0, int(days+1)).After(t1) {
+		days++
+	}
+	mid = mid.AddDate(0, 0, int(days))
+
+	rv := value.Interval{Months: months, Days: days, Millis: int64(t1.Sub(mid) / time.Millisecond)}
+	if neg {
+		rv = rv.Neg()
+	}
+	return rv
+}
+
+/*
+The start of the bin of t. Month and day strides count calendar months
+and days from the origin, and other strides are fixed lengths.
+*/
+func dateBin(stride value.Interval, t, origin time.Time) (time.Time, error) {
+	var n, size int64
+
+	switch {
+	case stride.Length() <= 0:
+		return t, fmt.Errorf("DATE_BIN() stride %s must be positive.", stride)
+	case stride.Months != 0 && (stride.Days != 0 || stride.Millis != 0):
+		return t, fmt.Errorf("DATE_BIN() stride %s must not mix months with days or time.", stride)
+	case stride.Months != 0:
+		n = int64(t.Year()-origin.Year())*12 + int64(t.Month()-origin.Month())
+		size = stride.Months
+	case stride.Millis == 0:
+		n = civilDays(t) - civilDays(origin)
+		size = stride.Days
+	default:
+		n = int64(t.Sub(origin) / time.Millisecond)
+		size = stride.Days*24*60*60*1000 + stride.Millis
+		stride = value.Interval{Millis: size}
+	}
+
+	k := n / size
+	if n%size != 0 && n < 0 {
+		k--
+	}
+
+	/* The time of day of t may be before that of the origin. */
+	bin := stride.Scale(k).AddTo(origin)
+	if bin.After(t) {
+		bin = stride.Scale(k - 1).AddTo(origin)
+	}
+	return bin, nil
+}
+
+/*
+The number of the calendar day of t, counting from 1970-01-01.
+*/
+func civilDays(t time.Time) int64 {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / (24 * 60 * 60)
+}
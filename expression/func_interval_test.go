@@ -0,0 +1,101 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+import (
+	"testing"
+
+	"github.com/couchbase/query/value"
+)
+
+func TestIntervalFunctions(t *testing.T) {
+	interval := func(s string) Expression {
+		iv, err := value.ParseInterval(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		return NewConstant(value.NewIntervalValue(iv))
+	}
+	ny := NewConstant("America/New_York")
+
+	tests := []struct {
+		expr     Expression
+		expected interface{}
+	}{
+		{NewAdd(NewConstant("2020-01-31"), interval("1 month")), "2020-02-29"},
+		{NewAdd(NewConstant("2020-01-31T10:00:00Z"), interval("1 day 2 hours")), "2020-02-01T12:00:00Z"},
+		{NewAdd(interval("1 day"), interval("PT2H")), value.NewIntervalValue(value.Interval{Days: 1, Millis: 7200000})},
+		{NewAdd(NewConstant(1), interval("1 hour"), interval("1 hour")), 2*3600000 + 1},
+		{NewAdd(NewConstant("2020-01-31"), NewConstant("x"), interval("1 day")), nil},
+		{NewSub(NewConstant("2020-03-31T00:00:00Z"), interval("P1M")), "2020-02-29T00:00:00Z"},
+		{NewSub(interval("P1D"), interval("P1D")), value.NewIntervalValue(value.Interval{})},
+		{NewSub(interval("P1D"), NewConstant("2020-01-01")), nil},
+		{NewNeg(interval("P1M")), value.NewIntervalValue(value.Interval{Months: -1})},
+		{NewToString(interval("90 minutes")), "PT1H30M"},
+		{NewToInterval(NewConstant("-3 days 04:00")), value.NewIntervalValue(value.Interval{Days: -3, Millis: 4 * 3600000})},
+		{NewToInterval(NewConstant("3 fortnights")), nil},
+
+		{NewAge(NewConstant("2021-03-31T10:00:00Z"), NewConstant("2020-01-31T12:00:00Z")),
+			value.NewIntervalValue(value.Interval{Months: 13, Days: 30, Millis: 22 * 3600000})},
+		{NewAge(NewConstant("2020-01-31T12:00:00Z"), NewConstant("2021-03-31T10:00:00Z")),
+			value.NewIntervalValue(value.Interval{Months: -13, Days: -30, Millis: -22 * 3600000})},
+
+		{NewDateBin(NewConstant("P1W"), NewConstant("2020-03-11T15:00:00Z"), NewConstant("2000-01-03T00:00:00Z"),
+			NewConstant("UTC")), "2020-03-09T00:00:00Z"},
+		{NewDateBin(NewConstant("15 minutes"), NewConstant("2020-03-08T15:07:30-04:00"), NewConstant("2000-01-01T00:00:00Z")),
+			"2020-03-08T15:00:00-04:00"},
+		{NewDateBin(NewConstant("P3M"), NewConstant("2020-05-31"), NewConstant("2020-01-01")), "2020-04-01"},
+		{NewDateBin(NewConstant("P1D"), NewConstant("2020-03-08T15:00:00"), NewConstant("2000-01-01T00:00:00"), ny),
+			"2020-03-08T00:00:00"},
+		{NewDateBin(NewConstant("P1D"), NewConstant("2020-03-08T15:00:00"), NewConstant("2000-01-01"), NewConstant("Nowhere")),
+			nil},
+
+		{NewDateRange(NewConstant("2020-01-31"), NewConstant("2020-04-30"), NewConstant("1 month")),
+			[]interface{}{"2020-01-31", "2020-02-29", "2020-03-31", "2020-04-30"}},
+		{NewDateRange(NewConstant("2020-03-07T12:00:00"), NewConstant("2020-03-09T12:00:00"), interval("P1D"), ny),
+			[]interface{}{"2020-03-07T12:00:00", "2020-03-08T12:00:00", "2020-03-09T12:00:00"}},
+		{NewDateRange(NewConstant("2020-01-03"), NewConstant("2020-01-01"), NewConstant("-1 day")),
+			[]interface{}{"2020-01-03", "2020-01-02", "2020-01-01"}},
+		{NewDateRange(NewConstant("2020-01-03"), NewConstant("2020-01-01"), NewConstant("1 day")), []interface{}{}},
+	}
+
+	for _, test := range tests {
+		rv, err := test.expr.Evaluate(nil, nil)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", test.expr, err)
+			continue
+		}
+		expected := value.NewValue(test.expected)
+		if rv.Collate(expected) != 0 {
+			t.Errorf("%v: expected %v, got %v", test.expr, expected, rv)
+		}
+	}
+
+	errs := []Expression{
+		NewDateBin(NewConstant("P1M1D"), NewConstant("2020-01-01")),
+		NewDateBin(NewConstant("-1 hour"), NewConstant("2020-01-01")),
+		NewDateRange(NewConstant("2020-01-01"), NewConstant("2020-01-02"), NewConstant("PT0S")),
+		NewDateRange(NewConstant(0), NewConstant(1e12), NewConstant(1)),
+	}
+
+	for _, expr := range errs {
+		if _, err := expr.Evaluate(nil, nil); err == nil {
+			t.Errorf("%v: expected an error", expr)
+		}
+	}
+}
+
+func TestIntervalConstantString(t *testing.T) {
+	expr := NewAdd(NewField(NewIdentifier("d"), NewFieldName("ts", false)),
+		NewConstant(value.NewIntervalValue(value.Interval{Days: 1})))
+	if s := expr.String(); s != "((`d`.`ts`) + to_interval(\"P1D\"))" {
+		t.Errorf("Unexpected string %s", s)
+	}
+}
@@ -0,0 +1,477 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+import (
+	"fmt"
+
+	"github.com/couchbase/query/value"
+)
+
+///////////////////////////////////////////////////
+//
+// JSONPointerGet
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the json function JSON_POINTER_GET(expr, pointer).
+It returns the value at the RFC 6901 pointer in expr, or MISSING if
+there is none. The empty pointer refers to expr itself.
+*/
+type JSONPointerGet struct {
+	BinaryFunctionBase
+}
+
+func NewJSONPointerGet(first, second Expression) Function {
+	rv := &JSONPointerGet{
+		*NewBinaryFunctionBase("json_pointer_get", first, second),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *JSONPointerGet) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *JSONPointerGet) Type() value.Type { return value.JSON }
+
+func (this *JSONPointerGet) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.BinaryEval(this, item, context)
+}
+
+func (this *JSONPointerGet) Apply(context Context, first, second value.Value) (value.Value, error) {
+	if first.Type() == value.MISSING || second.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	} else if first.Type() == value.NULL || second.Type() != value.STRING {
+		return value.NULL_VALUE, nil
+	}
+
+	tokens, err := parsePointer(second.Actual().(string))
+	if err != nil {
+		return nil, fmt.Errorf("%s() %v", this.Name(), err)
+	}
+	rv, ok := pointerGet(first.Actual(), tokens)
+	if !ok {
+		return value.MISSING_VALUE, nil
+	}
+	return value.NewValue(rv), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *JSONPointerGet) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewJSONPointerGet(operands[0], operands[1])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// JSONPointerSet
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the json function JSON_POINTER_SET(expr, pointer, value).
+It returns a copy of expr with the value at the RFC 6901 pointer set,
+replacing any existing value. Object members are added if needed, and
+the array index "-" appends. If the parent of the pointer does not
+exist, the result is NULL.
+*/
+type JSONPointerSet struct {
+	TernaryFunctionBase
+}
+
+func NewJSONPointerSet(first, second, third Expression) Function {
+	rv := &JSONPointerSet{
+		*NewTernaryFunctionBase("json_pointer_set", first, second, third),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *JSONPointerSet) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *JSONPointerSet) Type() value.Type { return value.JSON }
+
+func (this *JSONPointerSet) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.TernaryEval(this, item, context)
+}
+
+func (this *JSONPointerSet) Apply(context Context, first, second, third value.Value) (value.Value, error) {
+	if first.Type() == value.MISSING || second.Type() == value.MISSING || third.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	} else if first.Type() == value.NULL || second.Type() != value.STRING {
+		return value.NULL_VALUE, nil
+	}
+
+	tokens, err := parsePointer(second.Actual().(string))
+	if err != nil {
+		return nil, fmt.Errorf("%s() %v", this.Name(), err)
+	}
+	rv, err := pointerSet(pointerCopyPath(first.Actual(), tokens), tokens, jsonCopy(third.Actual()))
+	if err != nil {
+		return value.NULL_VALUE, nil
+	}
+	return value.NewValue(rv), nil
+}
+
+/*
+The value set can be NULL.
+*/
+func (this *JSONPointerSet) PropagatesNull() bool {
+	return false
+}
+
+/*
+Factory method pattern.
+*/
+func (this *JSONPointerSet) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewJSONPointerSet(operands[0], operands[1], operands[2])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// JSONPointerRemove
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the json function JSON_POINTER_REMOVE(expr, pointer).
+It returns a copy of expr without the value at the RFC 6901 pointer,
+or expr itself if there is no such value.
+*/
+type JSONPointerRemove struct {
+	BinaryFunctionBase
+}
+
+func NewJSONPointerRemove(first, second Expression) Function {
+	rv := &JSONPointerRemove{
+		*NewBinaryFunctionBase("json_pointer_remove", first, second),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *JSONPointerRemove) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *JSONPointerRemove) Type() value.Type { return value.JSON }
+
+func (this *JSONPointerRemove) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.BinaryEval(this, item, context)
+}
+
+func (this *JSONPointerRemove) Apply(context Context, first, second value.Value) (value.Value, error) {
+	if first.Type() == value.MISSING || second.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	} else if first.Type() == value.NULL || second.Type() != value.STRING {
+		return value.NULL_VALUE, nil
+	}
+
+	tokens, err := parsePointer(second.Actual().(string))
+	if err != nil {
+		return nil, fmt.Errorf("%s() %v", this.Name(), err)
+	}
+	if len(tokens) == 0 {
+		return value.MISSING_VALUE, nil
+	}
+	rv, err := pointerRemove(pointerCopyPath(first.Actual(), tokens), tokens)
+	if err != nil {
+		return first, nil
+	}
+	return value.NewValue(rv), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *JSONPointerRemove) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewJSONPointerRemove(operands[0], operands[1])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// JSONPath
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the json function JSONPATH(expr, path). It returns
+the array of the values in expr matching the JSONPath expression path,
+in document order, with object members taken in name order.
+*/
+type JSONPath struct {
+	BinaryFunctionBase
+	steps []*jsonPathStep
+}
+
+func NewJSONPath(first, second Expression) Function {
+	rv := &JSONPath{
+		BinaryFunctionBase: *NewBinaryFunctionBase("jsonpath", first, second),
+	}
+
+	if v := second.Value(); v != nil {
+		if path, ok := v.Actual().(string); ok {
+			rv.steps, _ = parseJSONPath(path)
+		}
+	}
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *JSONPath) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *JSONPath) Type() value.Type { return value.ARRAY }
+
+func (this *JSONPath) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.BinaryEval(this, item, context)
+}
+
+func (this *JSONPath) Apply(context Context, first, second value.Value) (value.Value, error) {
+	if first.Type() == value.MISSING || second.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	} else if first.Type() == value.NULL || second.Type() != value.STRING {
+		return value.NULL_VALUE, nil
+	}
+
+	steps := this.steps
+	if steps == nil {
+		var err error
+		steps, err = parseJSONPath(second.Actual().(string))
+		if err != nil {
+			return nil, fmt.Errorf("%s() %v", this.Name(), err)
+		}
+	}
+	return value.NewValue(jsonPathQuery(jsonCopy(first.Actual()), steps)), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *JSONPath) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewJSONPath(operands[0], operands[1])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// JSONPatch
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the json function JSON_PATCH(expr, patch). It
+returns a copy of expr with the RFC 6902 patch, an array of
+operations, applied. A patch that cannot be applied, including one
+whose test operation fails, is an error, so that no partially
+patched document is ever returned.
+*/
+type JSONPatch struct {
+	BinaryFunctionBase
+}
+
+func NewJSONPatch(first, second Expression) Function {
+	rv := &JSONPatch{
+		*NewBinaryFunctionBase("json_patch", first, second),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *JSONPatch) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *JSONPatch) Type() value.Type { return value.JSON }
+
+func (this *JSONPatch) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.BinaryEval(this, item, context)
+}
+
+func (this *JSONPatch) Apply(context Context, first, second value.Value) (value.Value, error) {
+	if first.Type() == value.MISSING || second.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	} else if first.Type() == value.NULL || second.Type() != value.ARRAY {
+		return value.NULL_VALUE, nil
+	}
+
+	rv, err := jsonPatch(jsonCopy(first.Actual()), second.Actual().([]interface{}))
+	if err != nil {
+		return nil, fmt.Errorf("%s() %v", this.Name(), err)
+	}
+	return value.NewValue(rv), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *JSONPatch) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewJSONPatch(operands[0], operands[1])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// JSONMergePatch
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the json function JSON_MERGE_PATCH(expr, patch). It
+returns expr merged with the RFC 7386 patch: members of an object patch
+replace those of expr recursively, and null members remove them. A patch
+that is not an object replaces expr.
+*/
+type JSONMergePatch struct {
+	BinaryFunctionBase
+}
+
+func NewJSONMergePatch(first, second Expression) Function {
+	rv := &JSONMergePatch{
+		*NewBinaryFunctionBase("json_merge_patch", first, second),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *JSONMergePatch) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *JSONMergePatch) Type() value.Type { return value.JSON }
+
+func (this *JSONMergePatch) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.BinaryEval(this, item, context)
+}
+
+func (this *JSONMergePatch) Apply(context Context, first, second value.Value) (value.Value, error) {
+	if first.Type() == value.MISSING || second.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	}
+
+	return value.NewValue(jsonMergePatch(jsonCopy(first.Actual()), jsonCopy(second.Actual()))), nil
+}
+
+/*
+JSON_MERGE_PATCH(expr, NULL) is NULL, as RFC 7386 requires.
+*/
+func (this *JSONMergePatch) PropagatesNull() bool {
+	return false
+}
+
+/*
+Factory method pattern.
+*/
+func (this *JSONMergePatch) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewJSONMergePatch(operands[0], operands[1])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// JSONDiff
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the json function JSON_DIFF(from, to). It returns the
+RFC 6902 patch that turns from into to when applied with JSON_PATCH():
+objects are compared member by member, and arrays element by element,
+with trailing elements removed or appended.
+*/
+type JSONDiff struct {
+	BinaryFunctionBase
+}
+
+func NewJSONDiff(first, second Expression) Function {
+	rv := &JSONDiff{
+		*NewBinaryFunctionBase("json_diff", first, second),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *JSONDiff) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *JSONDiff) Type() value.Type { return value.ARRAY }
+
+func (this *JSONDiff) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.BinaryEval(this, item, context)
+}
+
+func (this *JSONDiff) Apply(context Context, first, second value.Value) (value.Value, error) {
+	if first.Type() == value.MISSING || second.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	}
+
+	patch := jsonDiff("", jsonCopy(first.Actual()), jsonCopy(second.Actual()), []interface{}{})
+	return value.NewValue(patch), nil
+}
+
+/*
+Differences with NULL are replacements.
+*/
+func (this *JSONDiff) PropagatesNull() bool {
+	return false
+}
+
+/*
+Factory method pattern.
+*/
+func (this *JSONDiff) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewJSONDiff(operands[0], operands[1])
+	}
+}
@@ -0,0 +1,133 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/query/value"
+)
+
+func TestJSONPatchFunctions(t *testing.T) {
+	doc := NewConstant(value.NewValue([]byte(`{"a": {"b": [1, 2, 3]}, "c/d": "x", "e~f": null,
+		"store": {"book": [{"title": "A", "price": 8}, {"title": "B", "price": 12}, {"title": "C"}]}}`)))
+	jsonValue := func(s string) Expression {
+		return NewConstant(value.NewValue([]byte(s)))
+	}
+
+	tests := []struct {
+		expr     Expression
+		expected interface{}
+	}{
+		{NewJSONPointerGet(doc, NewConstant("/a/b/1")), 2},
+		{NewJSONPointerGet(doc, NewConstant("/c~1d")), "x"},
+		{NewJSONPointerGet(doc, NewConstant("/e~0f")), nil},
+		{NewJSONPointerGet(doc, NewConstant("/a/b/3")), value.MISSING_VALUE},
+		{NewJSONPointerGet(doc, NewConstant("/a/b/01")), value.MISSING_VALUE},
+		{NewJSONPointerGet(NewConstant([]interface{}{1}), NewConstant("")), []interface{}{1}},
+		{NewJSONPointerSet(jsonValue(`{"a": [1]}`), NewConstant("/a/-"), NewConstant(2)),
+			map[string]interface{}{"a": []interface{}{1, 2}}},
+		{NewJSONPointerSet(jsonValue(`{"a": [1]}`), NewConstant("/a/0"), NewConstant(nil)),
+			map[string]interface{}{"a": []interface{}{nil}}},
+		{NewJSONPointerSet(jsonValue(`{"a": [1]}`), NewConstant("/b/c"), NewConstant(2)), nil},
+		{NewJSONPointerRemove(jsonValue(`{"a": [1, 2], "b": 3}`), NewConstant("/a/0")),
+			map[string]interface{}{"a": []interface{}{2}, "b": 3}},
+		{NewJSONPointerRemove(jsonValue(`{"b": 3}`), NewConstant("/c")), map[string]interface{}{"b": 3}},
+		{NewJSONPath(doc, NewConstant("$.store.book[*].title")), []interface{}{"A", "B", "C"}},
+		{NewJSONPath(doc, NewConstant("$..price")), []interface{}{8, 12}},
+		{NewJSONPath(doc, NewConstant("$.store.book[?(@.price < 10)].title")), []interface{}{"A"}},
+		{NewJSONPath(doc, NewConstant("$.store.book[?(@.price)].title")), []interface{}{"A", "B"}},
+		{NewJSONPath(doc, NewConstant("$.store.book[-1:].title")), []interface{}{"C"}},
+		{NewJSONPath(doc, NewConstant("$.a.b[0, 2]")), []interface{}{1, 3}},
+		{NewJSONPath(doc, NewConstant("$['c/d']")), []interface{}{"x"}},
+		{NewJSONPath(doc, NewConstant("$.nothing")), []interface{}{}},
+		{NewJSONPatch(jsonValue(`{"a": [1, 2], "b": {"c": 1}}`), jsonValue(`[
+			{"op": "test", "path": "/b/c", "value": 1},
+			{"op": "add", "path": "/a/1", "value": 5},
+			{"op": "remove", "path": "/a/0"},
+			{"op": "move", "from": "/b/c", "path": "/d"},
+			{"op": "copy", "from": "/a", "path": "/b/a"},
+			{"op": "replace", "path": "/a/1", "value": 0}]`)),
+			map[string]interface{}{"a": []interface{}{5, 0}, "b": map[string]interface{}{"a": []interface{}{5, 2}}, "d": 1}},
+		{NewJSONMergePatch(jsonValue(`{"a": "b", "c": {"d": "e", "f": "g"}}`), jsonValue(`{"a": "z", "c": {"f": null}}`)),
+			map[string]interface{}{"a": "z", "c": map[string]interface{}{"d": "e"}}},
+		{NewJSONMergePatch(jsonValue(`{"a": 1}`), NewConstant(nil)), nil},
+		{NewJSONDiff(jsonValue(`{"a": 1, "b": [1, 2, 3], "c/d": 1}`), jsonValue(`{"a": 2, "b": [1], "e": true}`)),
+			[]interface{}{
+				map[string]interface{}{"op": "replace", "path": "/a", "value": 2},
+				map[string]interface{}{"op": "remove", "path": "/b/2"},
+				map[string]interface{}{"op": "remove", "path": "/b/1"},
+				map[string]interface{}{"op": "remove", "path": "/c~1d"},
+				map[string]interface{}{"op": "add", "path": "/e", "value": true},
+			}},
+	}
+
+	for _, test := range tests {
+		rv, err := test.expr.Evaluate(nil, nil)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", test.expr, err)
+			continue
+		}
+		if rv.Collate(value.NewValue(test.expected)) != 0 {
+			t.Errorf("%v: expected %v, got %v", test.expr, test.expected, rv)
+		}
+	}
+
+	failures := []Expression{
+		NewJSONPatch(jsonValue(`{"a": 1}`), jsonValue(`[{"op": "test", "path": "/a", "value": 2}]`)),
+		NewJSONPatch(jsonValue(`{"a": [1]}`), jsonValue(`[{"op": "replace", "path": "/a/-", "value": 2}]`)),
+		NewJSONPatch(jsonValue(`{"a": {}}`), jsonValue(`[{"op": "move", "from": "/a", "path": "/a/b"}]`)),
+		NewJSONPatch(jsonValue(`{"a": 1}`), jsonValue(`[{"op": "rename", "path": "/a"}]`)),
+		NewJSONPath(doc, NewConstant("store.book")),
+	}
+
+	for _, expr := range failures {
+		rv, err := expr.Evaluate(nil, nil)
+		if err == nil {
+			t.Errorf("%v: expected an error, got %v", expr, rv)
+		}
+	}
+
+	// applying a diff must give the target, and leave the source alone
+	from := jsonValue(`{"a": [1, {"b": 2}], "c": "d", "e": {"f": [true]}}`)
+	to := jsonValue(`{"a": [1, {"b": 3}, 4], "e": {"f": [], "g": null}}`)
+	patch, _ := NewJSONDiff(from, to).Evaluate(nil, nil)
+	rv, err := NewJSONPatch(from, NewConstant(patch)).Evaluate(nil, nil)
+	if err != nil || rv.Collate(to.Value()) != 0 {
+		t.Errorf("JSON_PATCH() of JSON_DIFF() %v, expected %v, got %v, %v", patch, to, rv, err)
+	}
+	if f, _ := from.Evaluate(nil, nil); f.Collate(jsonValue(`{"a": [1, {"b": 2}], "c": "d", "e": {"f": [true]}}`).Value()) != 0 {
+		t.Errorf("JSON_PATCH() modified its input %v", f)
+	}
+
+	// pointers read nested values, and only copy the containers along their path
+	nested := map[string]interface{}{
+		"a": value.NewValue(map[string]interface{}{"b": []interface{}{1, 2}}),
+		"c": map[string]interface{}{"d": 1},
+	}
+	input := NewConstant(value.NewValue(nested))
+	if rv, _ := NewJSONPointerGet(input, NewConstant("/a/b/1")).Evaluate(nil, nil); rv.Collate(value.NewValue(2)) != 0 {
+		t.Errorf("JSON_POINTER_GET() of a nested value, expected 2, got %v", rv)
+	}
+	set, _ := NewJSONPointerSet(input, NewConstant("/a/b/0"), NewConstant(5)).Evaluate(nil, nil)
+	removed, _ := NewJSONPointerRemove(input, NewConstant("/a/b/0")).Evaluate(nil, nil)
+	if set.Collate(jsonValue(`{"a": {"b": [5, 2]}, "c": {"d": 1}}`).Value()) != 0 ||
+		removed.Collate(jsonValue(`{"a": {"b": [2]}, "c": {"d": 1}}`).Value()) != 0 {
+		t.Errorf("Unexpected JSON_POINTER_SET() %v or JSON_POINTER_REMOVE() %v", set, removed)
+	}
+	if value.NewValue(nested).Collate(jsonValue(`{"a": {"b": [1, 2]}, "c": {"d": 1}}`).Value()) != 0 {
+		t.Errorf("JSON_POINTER_SET() or JSON_POINTER_REMOVE() modified its input %v", nested)
+	}
+	c, _ := set.Field("c")
+	if reflect.ValueOf(c.Actual()).Pointer() != reflect.ValueOf(nested["c"]).Pointer() {
+		t.Errorf("JSON_POINTER_SET() copied members outside of the pointer")
+	}
+}
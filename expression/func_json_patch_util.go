@@ -0,0 +1,798 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+// JSON Pointer (RFC 6901), JSON Patch (RFC 6902), JSON Merge Patch
+// (RFC 7386) and JSONPath over plain JSON values: documents are copied
+// before being modified, so that the input values are left alone. A
+// single pointer only needs the containers along its path copied, and
+// reading through a pointer copies nothing.
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/couchbase/query/value"
+)
+
+// deep copy, unwrapping nested values
+func jsonCopy(act interface{}) interface{} {
+	switch act := act.(type) {
+	case value.Value:
+		return jsonCopy(act.Actual())
+	case map[string]interface{}:
+		rv := make(map[string]interface{}, len(act))
+		for k, v := range act {
+			rv[k] = jsonCopy(v)
+		}
+		return rv
+	case []interface{}:
+		rv := make([]interface{}, len(act))
+		for i, v := range act {
+			rv[i] = jsonCopy(v)
+		}
+		return rv
+	default:
+		return act
+	}
+}
+
+// shallow copy, unwrapping the value itself but not its members
+func jsonShallowCopy(act interface{}) interface{} {
+	switch act := act.(type) {
+	case value.Value:
+		return jsonShallowCopy(act.Actual())
+	case map[string]interface{}:
+		rv := make(map[string]interface{}, len(act))
+		for k, v := range act {
+			rv[k] = v
+		}
+		return rv
+	case []interface{}:
+		return append([]interface{}{}, act...)
+	default:
+		return act
+	}
+}
+
+func jsonEquals(a, b interface{}) bool {
+	return value.NewValue(a).Equals(value.NewValue(b)).Truth()
+}
+
+///////////////////////////////////////////////////
+//
+// JSON Pointer
+//
+///////////////////////////////////////////////////
+
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("Invalid JSON pointer %s", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func escapePointer(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
+
+// array index of a token, len(a) for "-" if end is allowed
+func pointerIndex(token string, a []interface{}, end bool) (int, bool) {
+	if token == "-" && end {
+		return len(a), true
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, false
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > len(a) || (i == len(a) && !end) {
+		return 0, false
+	}
+	return i, true
+}
+
+// the document is only read, and can contain nested values
+func pointerGet(doc interface{}, tokens []string) (interface{}, bool) {
+	for _, t := range tokens {
+		if v, ok := doc.(value.Value); ok {
+			doc = v.Actual()
+		}
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			v, ok := d[t]
+			if !ok {
+				return nil, false
+			}
+			doc = v
+		case []interface{}:
+			i, ok := pointerIndex(t, d, false)
+			if !ok {
+				return nil, false
+			}
+			doc = d[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+/*
+Copy the document and the containers along the pointer, down to the
+container of the last token, so that the pointer can be updated in
+place: everything else is shared with the original document.
+*/
+func pointerCopyPath(doc interface{}, tokens []string) interface{} {
+	rv := jsonShallowCopy(doc)
+	if len(tokens) == 0 {
+		return rv
+	}
+
+	parent := rv
+	for _, t := range tokens[:len(tokens)-1] {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			child, ok := p[t]
+			if !ok {
+				return rv
+			}
+			parent = jsonShallowCopy(child)
+			p[t] = parent
+		case []interface{}:
+			i, ok := pointerIndex(t, p, false)
+			if !ok {
+				return rv
+			}
+			parent = jsonShallowCopy(p[i])
+			p[i] = parent
+		default:
+			return rv
+		}
+	}
+	return rv
+}
+
+/*
+Apply f to the container of the last token, returning the updated document.
+The document is modified in place, and the containers along the pointer
+must have been copied by the caller. The pointer must not be empty.
+*/
+func pointerUpdate(doc interface{}, tokens []string,
+	f func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+
+	parent, ok := pointerGet(doc, tokens[:len(tokens)-1])
+	if !ok {
+		return nil, fmt.Errorf("Path /%s not found", strings.Join(tokens[:len(tokens)-1], "/"))
+	}
+	container, err := f(parent, tokens[len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	// arrays may have been reallocated, so store them back in their parent
+	if len(tokens) == 1 {
+		return container, nil
+	}
+	grandParent, _ := pointerGet(doc, tokens[:len(tokens)-2])
+	switch g := grandParent.(type) {
+	case map[string]interface{}:
+		g[tokens[len(tokens)-2]] = container
+	case []interface{}:
+		i, _ := pointerIndex(tokens[len(tokens)-2], g, false)
+		g[i] = container
+	}
+	return doc, nil
+}
+
+// add, as in RFC 6902: insert into arrays, add or replace object members
+func pointerAdd(doc interface{}, tokens []string, val interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return val, nil
+	}
+	return pointerUpdate(doc, tokens, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			c[token] = val
+			return c, nil
+		case []interface{}:
+			i, ok := pointerIndex(token, c, true)
+			if !ok {
+				return nil, fmt.Errorf("Invalid array index %s", token)
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = val
+			return c, nil
+		}
+		return nil, fmt.Errorf("Cannot add %s to a scalar", token)
+	})
+}
+
+// replace, or add to objects, or append to arrays for "-"
+func pointerSet(doc interface{}, tokens []string, val interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return val, nil
+	}
+	return pointerUpdate(doc, tokens, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			c[token] = val
+			return c, nil
+		case []interface{}:
+			i, ok := pointerIndex(token, c, true)
+			if !ok {
+				return nil, fmt.Errorf("Invalid array index %s", token)
+			}
+			if i == len(c) {
+				return append(c, val), nil
+			}
+			c[i] = val
+			return c, nil
+		}
+		return nil, fmt.Errorf("Cannot set %s in a scalar", token)
+	})
+}
+
+func pointerReplace(doc interface{}, tokens []string, val interface{}) (interface{}, error) {
+	if _, ok := pointerGet(doc, tokens); !ok {
+		return nil, fmt.Errorf("Path /%s not found", strings.Join(tokens, "/"))
+	}
+	return pointerSet(doc, tokens, val)
+}
+
+func pointerRemove(doc interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("Cannot remove the whole document")
+	}
+	return pointerUpdate(doc, tokens, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			if _, ok := c[token]; !ok {
+				return nil, fmt.Errorf("Member %s not found", token)
+			}
+			delete(c, token)
+			return c, nil
+		case []interface{}:
+			i, ok := pointerIndex(token, c, false)
+			if !ok {
+				return nil, fmt.Errorf("Invalid array index %s", token)
+			}
+			return append(c[:i], c[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("Cannot remove %s from a scalar", token)
+	})
+}
+
+///////////////////////////////////////////////////
+//
+// JSON Patch
+//
+///////////////////////////////////////////////////
+
+func jsonPatch(doc interface{}, patch []interface{}) (interface{}, error) {
+	for n, o := range patch {
+		op, ok := jsonCopy(o).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Patch operation %d is not an object", n)
+		}
+		name, _ := op["op"].(string)
+		path, ok := op["path"].(string)
+		if !ok {
+			return nil, fmt.Errorf("Patch operation %d has no path", n)
+		}
+		tokens, err := parsePointer(path)
+		if err != nil {
+			return nil, err
+		}
+
+		val, hasValue := op["value"]
+		var from []string
+		switch name {
+		case "add", "replace", "test":
+			if !hasValue {
+				return nil, fmt.Errorf("Patch operation %d has no value", n)
+			}
+		case "move", "copy":
+			f, ok := op["from"].(string)
+			if !ok {
+				return nil, fmt.Errorf("Patch operation %d has no from", n)
+			}
+			from, err = parsePointer(f)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		switch name {
+		case "add":
+			doc, err = pointerAdd(doc, tokens, val)
+		case "remove":
+			doc, err = pointerRemove(doc, tokens)
+		case "replace":
+			doc, err = pointerReplace(doc, tokens, val)
+		case "move":
+			if len(from) < len(tokens) && strings.HasPrefix(path+"/", op["from"].(string)+"/") {
+				return nil, fmt.Errorf("Cannot move %s into itself", op["from"])
+			}
+			val, ok = pointerGet(doc, from)
+			if !ok {
+				return nil, fmt.Errorf("Path %s not found", op["from"])
+			}
+			doc, err = pointerRemove(doc, from)
+			if err == nil {
+				doc, err = pointerAdd(doc, tokens, val)
+			}
+		case "copy":
+			val, ok = pointerGet(doc, from)
+			if !ok {
+				return nil, fmt.Errorf("Path %s not found", op["from"])
+			}
+			doc, err = pointerAdd(doc, tokens, jsonCopy(val))
+		case "test":
+			cur, ok := pointerGet(doc, tokens)
+			if !ok || !jsonEquals(cur, val) {
+				return nil, fmt.Errorf("Patch test at %s failed", path)
+			}
+		default:
+			return nil, fmt.Errorf("Invalid patch operation %v", op["op"])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func jsonMergePatch(doc, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	d, ok := doc.(map[string]interface{})
+	if !ok {
+		d = make(map[string]interface{}, len(p))
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(d, k)
+		} else {
+			d[k] = jsonMergePatch(d[k], v)
+		}
+	}
+	return d
+}
+
+// the RFC 6902 patch turning from into to
+func jsonDiff(path string, from, to interface{}, patch []interface{}) []interface{} {
+	switch f := from.(type) {
+	case map[string]interface{}:
+		t, ok := to.(map[string]interface{})
+		if !ok {
+			break
+		}
+		names := make([]string, 0, len(f)+len(t))
+		for k, _ := range f {
+			names = append(names, k)
+		}
+		for k, _ := range t {
+			if _, ok := f[k]; !ok {
+				names = append(names, k)
+			}
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			p := path + "/" + escapePointer(k)
+			fv, inFrom := f[k]
+			tv, inTo := t[k]
+			switch {
+			case !inTo:
+				patch = append(patch, patchOp("remove", p, nil, false))
+			case !inFrom:
+				patch = append(patch, patchOp("add", p, tv, true))
+			default:
+				patch = jsonDiff(p, fv, tv, patch)
+			}
+		}
+		return patch
+	case []interface{}:
+		t, ok := to.([]interface{})
+		if !ok {
+			break
+		}
+		n := len(f)
+		if len(t) < n {
+			n = len(t)
+		}
+		for i := 0; i < n; i++ {
+			patch = jsonDiff(path+"/"+strconv.Itoa(i), f[i], t[i], patch)
+		}
+		for i := len(f) - 1; i >= n; i-- {
+			patch = append(patch, patchOp("remove", path+"/"+strconv.Itoa(i), nil, false))
+		}
+		for i := n; i < len(t); i++ {
+			patch = append(patch, patchOp("add", path+"/-", t[i], true))
+		}
+		return patch
+	}
+
+	if !jsonEquals(from, to) {
+		patch = append(patch, patchOp("replace", path, to, true))
+	}
+	return patch
+}
+
+func patchOp(op, path string, val interface{}, hasValue bool) interface{} {
+	rv := map[string]interface{}{
+		"op":   op,
+		"path": path,
+	}
+	if hasValue {
+		rv["value"] = val
+	}
+	return rv
+}
+
+///////////////////////////////////////////////////
+//
+// JSONPath
+//
+///////////////////////////////////////////////////
+
+/*
+JSONPath, in the subset most implementations agree on:
+
+	$              the document
+	.name, ['name'] a member, several names can be listed in brackets
+	.*, [*]        all members or elements
+	[n], [n, m]    array elements, negative indexes count from the end
+	[start:end]    array slices, with an optional :step
+	..             descendants, followed by any of the above
+	[?(@.path op literal)], [?(@.path)]
+	               elements and members matching a filter, op being one
+	               of ==, !=, <, <=, > and >=
+*/
+type jsonPathStep struct {
+	descend bool
+	all     bool
+	names   []string
+	indexes []int
+	slice   []*int
+	filter  *jsonPathFilter
+}
+
+type jsonPathFilter struct {
+	path    []string
+	op      string
+	operand value.Value
+}
+
+func parseJSONPath(path string) ([]*jsonPathStep, error) {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("JSONPath %s must start with $", path)
+	}
+
+	var steps []*jsonPathStep
+	i := 1
+	for i < len(path) {
+		step := &jsonPathStep{}
+		switch {
+		case strings.HasPrefix(path[i:], ".."):
+			step.descend = true
+			i += 2
+			if i < len(path) && path[i] == '[' {
+				break
+			}
+			fallthrough
+		case path[i] == '.':
+			if !step.descend {
+				i++
+			}
+			j := i
+			for j < len(path) && path[j] != '.' && path[j] != '[' {
+				j++
+			}
+			name := path[i:j]
+			if name == "" {
+				return nil, fmt.Errorf("Invalid JSONPath %s", path)
+			} else if name == "*" {
+				step.all = true
+			} else {
+				step.names = []string{name}
+			}
+			i = j
+			steps = append(steps, step)
+			continue
+		}
+
+		if i >= len(path) || path[i] != '[' {
+			return nil, fmt.Errorf("Invalid JSONPath %s", path)
+		}
+		j := closingBracket(path, i)
+		if j < 0 {
+			return nil, fmt.Errorf("Unterminated [ in JSONPath %s", path)
+		}
+		err := step.parseBracket(strings.TrimSpace(path[i+1 : j]))
+		if err != nil {
+			return nil, err
+		}
+		i = j + 1
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func closingBracket(path string, i int) int {
+	var quote byte
+	for j := i + 1; j < len(path); j++ {
+		switch c := path[j]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ']':
+			return j
+		}
+	}
+	return -1
+}
+
+func (this *jsonPathStep) parseBracket(s string) error {
+	switch {
+	case s == "*":
+		this.all = true
+		return nil
+	case strings.HasPrefix(s, "?(") && strings.HasSuffix(s, ")"):
+		return this.parseFilter(strings.TrimSpace(s[2 : len(s)-1]))
+	case strings.Contains(s, ":"):
+		parts := strings.Split(s, ":")
+		if len(parts) > 3 {
+			return fmt.Errorf("Invalid JSONPath slice %s", s)
+		}
+		this.slice = make([]*int, 3)
+		for i, p := range parts {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			n, err := strconv.Atoi(p)
+			if err != nil {
+				return fmt.Errorf("Invalid JSONPath slice %s", s)
+			}
+			this.slice[i] = &n
+		}
+		if this.slice[2] != nil && *this.slice[2] == 0 {
+			return fmt.Errorf("Invalid JSONPath slice step %s", s)
+		}
+		return nil
+	}
+
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if len(p) >= 2 && (p[0] == '\'' || p[0] == '"') && p[len(p)-1] == p[0] {
+			this.names = append(this.names, p[1:len(p)-1])
+			continue
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return fmt.Errorf("Invalid JSONPath subscript %s", p)
+		}
+		this.indexes = append(this.indexes, n)
+	}
+	return nil
+}
+
+var jsonPathOps = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+func (this *jsonPathStep) parseFilter(s string) error {
+	this.filter = &jsonPathFilter{}
+	lhs := s
+
+	// the left hand side is a plain path, so the first operator is the one
+scan:
+	for i := range s {
+		for _, op := range jsonPathOps {
+			if !strings.HasPrefix(s[i:], op) {
+				continue
+			}
+			lhs = strings.TrimSpace(s[:i])
+			this.filter.op = op
+			rhs := strings.TrimSpace(s[i+len(op):])
+			if len(rhs) >= 2 && rhs[0] == '\'' && rhs[len(rhs)-1] == '\'' {
+				this.filter.operand = value.NewValue(rhs[1 : len(rhs)-1])
+			} else {
+				this.filter.operand = value.NewValue([]byte(rhs))
+				if this.filter.operand.Type() == value.BINARY {
+					return fmt.Errorf("Invalid JSONPath filter literal %s", rhs)
+				}
+			}
+			break scan
+		}
+	}
+
+	if lhs != "@" && !strings.HasPrefix(lhs, "@.") {
+		return fmt.Errorf("Invalid JSONPath filter %s", s)
+	}
+	if lhs != "@" {
+		this.filter.path = strings.Split(lhs[2:], ".")
+	}
+	return nil
+}
+
+func (this *jsonPathFilter) matches(act interface{}) bool {
+	for _, name := range this.path {
+		obj, ok := act.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		act, ok = obj[name]
+		if !ok {
+			return false
+		}
+	}
+	if this.op == "" {
+		return true
+	}
+
+	v := value.NewValue(act)
+	if this.op == "==" || this.op == "!=" {
+		return v.Equals(this.operand).Truth() == (this.op == "==")
+	}
+	if v.Type() != this.operand.Type() {
+		return false
+	}
+	c := v.Collate(this.operand)
+	switch this.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+func jsonPathQuery(doc interface{}, steps []*jsonPathStep) []interface{} {
+	nodes := []interface{}{doc}
+	for _, step := range steps {
+		if step.descend {
+			var all []interface{}
+			for _, n := range nodes {
+				all = descendants(n, all)
+			}
+			nodes = all
+		}
+		var next []interface{}
+		for _, n := range nodes {
+			next = step.apply(n, next)
+		}
+		nodes = next
+	}
+	if nodes == nil {
+		return []interface{}{}
+	}
+	return nodes
+}
+
+func descendants(act interface{}, rv []interface{}) []interface{} {
+	rv = append(rv, act)
+	switch act := act.(type) {
+	case map[string]interface{}:
+		names := make([]string, 0, len(act))
+		for k, _ := range act {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			rv = descendants(act[k], rv)
+		}
+	case []interface{}:
+		for _, v := range act {
+			rv = descendants(v, rv)
+		}
+	}
+	return rv
+}
+
+func (this *jsonPathStep) apply(act interface{}, rv []interface{}) []interface{} {
+	switch a := act.(type) {
+	case map[string]interface{}:
+		if this.all || this.filter != nil {
+			names := make([]string, 0, len(a))
+			for k, _ := range a {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			for _, k := range names {
+				if this.filter == nil || this.filter.matches(a[k]) {
+					rv = append(rv, a[k])
+				}
+			}
+		}
+		for _, name := range this.names {
+			if v, ok := a[name]; ok {
+				rv = append(rv, v)
+			}
+		}
+	case []interface{}:
+		switch {
+		case this.all || this.filter != nil:
+			for _, v := range a {
+				if this.filter == nil || this.filter.matches(v) {
+					rv = append(rv, v)
+				}
+			}
+		case this.slice != nil:
+			start, end, step := sliceBounds(this.slice, len(a))
+			for i := start; (step > 0 && i < end) || (step < 0 && i > end); i += step {
+				rv = append(rv, a[i])
+			}
+		}
+		for _, i := range this.indexes {
+			if i < 0 {
+				i += len(a)
+			}
+			if i >= 0 && i < len(a) {
+				rv = append(rv, a[i])
+			}
+		}
+	}
+	return rv
+}
+
+func sliceBounds(slice []*int, n int) (int, int, int) {
+	step := 1
+	if slice[2] != nil {
+		step = *slice[2]
+	}
+	start, end := 0, n
+	if step < 0 {
+		start, end = n-1, -1
+	}
+	clamp := func(i, lo, hi int) int {
+		if i < 0 {
+			i += n
+		}
+		if i < lo {
+			return lo
+		} else if i > hi {
+			return hi
+		}
+		return i
+	}
+	if slice[0] != nil {
+		if step > 0 {
+			start = clamp(*slice[0], 0, n)
+		} else {
+			start = clamp(*slice[0], -1, n-1)
+		}
+	}
+	if slice[1] != nil {
+		if step > 0 {
+			end = clamp(*slice[1], 0, n)
+		} else {
+			end = clamp(*slice[1], -1, n-1)
+		}
+	}
+	return start, end, step
+}
@@ -142,6 +142,18 @@ var _FUNCTIONS = map[string]Function{
 	"weekday_millis":       &WeekdayMillis{},
 	"weekday_str":          &WeekdayStr{},
 
+	// Number and date formatting
+	"format_number": &FormatNumber{},
+	"parse_number":  &ParseNumber{},
+	"to_char":       &ToChar{},
+	"to_date":       &ToDate{},
+
+	// Date intervals
+	"age":         &Age{},
+	"date_bin":    &DateBin{},
+	"date_range":  &DateRange{},
+	"to_interval": &ToInterval{},
+
 	// String
 	"contains":  &Contains{},
 	"initcap":   &Title{},
@@ -167,6 +179,11 @@ var _FUNCTIONS = map[string]Function{
 	"trim":      &Trim{},
 	"upper":     &Upper{},
 
+	// Collation and Unicode normalization
+	"collation_key": &CollationKey{},
+	"normalize":     &Normalize{},
+	"unaccent":      &Unaccent{},
+
 	// Regular expressions
 	"contains_regex":   &RegexpContains{},
 	"contains_regexp":  &RegexpContains{},
@@ -301,6 +318,21 @@ var _FUNCTIONS = map[string]Function{
 	"pairs":        &Pairs{},
 	"poly_length":  &PolyLength{},
 
+	// JSON Pointer, JSONPath and JSON Patch
+	"json_diff":           &JSONDiff{},
+	"json_merge_patch":    &JSONMergePatch{},
+	"json_patch":          &JSONPatch{},
+	"json_pointer_get":    &JSONPointerGet{},
+	"json_pointer_remove": &JSONPointerRemove{},
+	"json_pointer_set":    &JSONPointerSet{},
+	"jsonpath":            &JSONPath{},
+
+	// Sketches
+	"hll_estimate":       &HLLEstimate{},
+	"hll_union":          &HLLUnion{},
+	"tdigest_percentile": &TDigestPercentile{},
+	"tdigest_union":      &TDigestUnion{},
+
 	// Base64
 	"base64":        &Base64Encode{},
 	"base64_decode": &Base64Decode{},
@@ -308,6 +340,30 @@ var _FUNCTIONS = map[string]Function{
 	"decode_base64": &Base64Decode{},
 	"encode_base64": &Base64Encode{},
 
+	// Geospatial
+	"geohash_bbox":     &GeohashBbox{},
+	"geohash_decode":   &GeohashDecode{},
+	"geohash_encode":   &GeohashEncode{},
+	"st_bbox":          &StBbox{},
+	"st_buffer":        &StBuffer{},
+	"st_contains":      &StContains{},
+	"st_distance":      &StDistance{},
+	"st_dwithin":       &StDWithin{},
+	"st_intersects":    &StIntersects{},
+	"st_make_envelope": &StMakeEnvelope{},
+	"st_within":        &StWithin{},
+
+	// Hash
+	"crc32":  &Crc32{},
+	"hash64": &Hash64{},
+	"hmac":   &Hmac{},
+	"md5":    &Md5{},
+	"sha1":   &Sha1{},
+	"sha224": &Sha224{},
+	"sha256": &Sha256{},
+	"sha384": &Sha384{},
+	"sha512": &Sha512{},
+
 	// Comparison
 	"greatest":  &Greatest{},
 	"least":     &Least{},
@@ -420,4 +476,9 @@ var _FUNCTIONS = map[string]Function{
 
 	// Index Advisor
 	"advisor": &Advisor{},
+
+	// Recurring tasks
+	"schedule_task": &ScheduleTask{},
+	"pause_task":    &PauseTask{},
+	"resume_task":   &ResumeTask{},
 }
@@ -0,0 +1,354 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/couchbase/query/util"
+	"github.com/couchbase/query/value"
+)
+
+///////////////////////////////////////////////////
+//
+// HLLEstimate
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the sketch function HLL_ESTIMATE(sketch). It returns
+the estimated number of distinct values in a HyperLogLog sketch, as
+returned by the HLL_SKETCH() and HLL_MERGE() aggregates.
+*/
+type HLLEstimate struct {
+	UnaryFunctionBase
+}
+
+func NewHLLEstimate(operand Expression) Function {
+	rv := &HLLEstimate{
+		*NewUnaryFunctionBase("hll_estimate", operand),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *HLLEstimate) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *HLLEstimate) Type() value.Type { return value.NUMBER }
+
+func (this *HLLEstimate) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.UnaryEval(this, item, context)
+}
+
+func (this *HLLEstimate) Apply(context Context, arg value.Value) (value.Value, error) {
+	if arg.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	} else if arg.Type() != value.STRING {
+		return value.NULL_VALUE, nil
+	}
+
+	hll, err := util.ParseHyperLogLog(arg.Actual().(string))
+	if err != nil {
+		return nil, fmt.Errorf("%s() %v", this.Name(), err)
+	}
+	return value.NewValue(int64(hll.Count())), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *HLLEstimate) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewHLLEstimate(operands[0])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// HLLUnion
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the sketch function HLL_UNION(sketch1, sketch2, ...).
+It returns the HyperLogLog sketch of the union of the sets of values
+the sketches were built from, skipping NULL and MISSING arguments.
+It returns NULL if there are no sketches, or if any argument is not
+a string.
+*/
+type HLLUnion struct {
+	FunctionBase
+}
+
+func NewHLLUnion(operands ...Expression) Function {
+	rv := &HLLUnion{
+		*NewFunctionBase("hll_union", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *HLLUnion) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *HLLUnion) Type() value.Type { return value.STRING }
+
+func (this *HLLUnion) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *HLLUnion) Apply(context Context, args ...value.Value) (value.Value, error) {
+	var rv *util.HyperLogLog
+	for _, arg := range args {
+		if arg.Type() <= value.NULL {
+			continue
+		} else if arg.Type() != value.STRING {
+			return value.NULL_VALUE, nil
+		}
+
+		hll, err := util.ParseHyperLogLog(arg.Actual().(string))
+		if err != nil {
+			return nil, fmt.Errorf("%s() %v", this.Name(), err)
+		}
+		if rv == nil {
+			rv = hll
+		} else {
+			rv.Merge(hll)
+		}
+	}
+
+	if rv == nil {
+		return value.NULL_VALUE, nil
+	}
+	return value.NewValue(rv.String()), nil
+}
+
+/*
+NULL and MISSING sketches are skipped.
+*/
+func (this *HLLUnion) PropagatesNull() bool {
+	return false
+}
+
+func (this *HLLUnion) PropagatesMissing() bool {
+	return false
+}
+
+/*
+Minimum input arguments required for the defined function
+HLL_UNION is 1.
+*/
+func (this *HLLUnion) MinArgs() int { return 1 }
+
+/*
+Maximum number of input arguments defined for the HLL_UNION
+function is MaxInt16  = 1<<15 - 1.
+*/
+func (this *HLLUnion) MaxArgs() int { return math.MaxInt16 }
+
+/*
+Factory method pattern.
+*/
+func (this *HLLUnion) Constructor() FunctionConstructor {
+	return NewHLLUnion
+}
+
+///////////////////////////////////////////////////
+//
+// TDigestPercentile
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the sketch function TDIGEST_PERCENTILE(sketch, fraction).
+It returns the estimated value at the given fraction of the numbers a
+t-digest sketch was built from, as returned by the TDIGEST_SKETCH() and
+TDIGEST_MERGE() aggregates. Fraction is a number between 0 and 1, or an
+array of them, in which case the result is the array of the corresponding
+percentiles.
+*/
+type TDigestPercentile struct {
+	BinaryFunctionBase
+}
+
+func NewTDigestPercentile(first, second Expression) Function {
+	rv := &TDigestPercentile{
+		*NewBinaryFunctionBase("tdigest_percentile", first, second),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *TDigestPercentile) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *TDigestPercentile) Type() value.Type { return value.JSON }
+
+func (this *TDigestPercentile) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.BinaryEval(this, item, context)
+}
+
+func (this *TDigestPercentile) Apply(context Context, first, second value.Value) (value.Value, error) {
+	if first.Type() == value.MISSING || second.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	} else if first.Type() != value.STRING {
+		return value.NULL_VALUE, nil
+	}
+
+	fractions := []interface{}{second}
+	array := second.Type() == value.ARRAY
+	if array {
+		fractions = second.Actual().([]interface{})
+	}
+
+	td, err := util.ParseTDigest(first.Actual().(string))
+	if err != nil {
+		return nil, fmt.Errorf("%s() %v", this.Name(), err)
+	}
+
+	rv := make([]interface{}, len(fractions))
+	for i, f := range fractions {
+		fv := value.NewValue(f)
+		if fv.Type() != value.NUMBER {
+			return value.NULL_VALUE, nil
+		}
+		q := fv.(value.NumberValue).Float64()
+		if q < 0.0 || q > 1.0 || td.Count() == 0.0 {
+			rv[i] = value.NULL_VALUE
+		} else {
+			rv[i] = value.NewValue(td.Quantile(q))
+		}
+	}
+
+	if array {
+		return value.NewValue(rv), nil
+	}
+	return rv[0].(value.Value), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *TDigestPercentile) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewTDigestPercentile(operands[0], operands[1])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// TDigestUnion
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the sketch function TDIGEST_UNION(sketch1, sketch2, ...).
+It returns the t-digest sketch of all the numbers the sketches were built
+from, skipping NULL and MISSING arguments. It returns NULL if there are
+no sketches, or if any argument is not a string.
+*/
+type TDigestUnion struct {
+	FunctionBase
+}
+
+func NewTDigestUnion(operands ...Expression) Function {
+	rv := &TDigestUnion{
+		*NewFunctionBase("tdigest_union", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *TDigestUnion) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *TDigestUnion) Type() value.Type { return value.STRING }
+
+func (this *TDigestUnion) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *TDigestUnion) Apply(context Context, args ...value.Value) (value.Value, error) {
+	var rv *util.TDigest
+	for _, arg := range args {
+		if arg.Type() <= value.NULL {
+			continue
+		} else if arg.Type() != value.STRING {
+			return value.NULL_VALUE, nil
+		}
+
+		td, err := util.ParseTDigest(arg.Actual().(string))
+		if err != nil {
+			return nil, fmt.Errorf("%s() %v", this.Name(), err)
+		}
+		if rv == nil {
+			rv = td
+		} else {
+			rv.Merge(td)
+		}
+	}
+
+	if rv == nil {
+		return value.NULL_VALUE, nil
+	}
+	return value.NewValue(rv.String()), nil
+}
+
+/*
+NULL and MISSING sketches are skipped.
+*/
+func (this *TDigestUnion) PropagatesNull() bool {
+	return false
+}
+
+func (this *TDigestUnion) PropagatesMissing() bool {
+	return false
+}
+
+/*
+Minimum input arguments required for the defined function
+TDIGEST_UNION is 1.
+*/
+func (this *TDigestUnion) MinArgs() int { return 1 }
+
+/*
+Maximum number of input arguments defined for the TDIGEST_UNION
+function is MaxInt16  = 1<<15 - 1.
+*/
+func (this *TDigestUnion) MaxArgs() int { return math.MaxInt16 }
+
+/*
+Factory method pattern.
+*/
+func (this *TDigestUnion) Constructor() FunctionConstructor {
+	return NewTDigestUnion
+}
@@ -0,0 +1,78 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/couchbase/query/util"
+	"github.com/couchbase/query/value"
+)
+
+func TestSketchFunctions(t *testing.T) {
+	hll := func(from, to int) Expression {
+		rv, _ := util.NewHyperLogLog(util.HLL_DEFAULT_PRECISION)
+		for i := from; i < to; i++ {
+			rv.AddBytes([]byte(strconv.Itoa(i)))
+		}
+		return NewConstant(rv.String())
+	}
+	tdigest := func(vals ...float64) Expression {
+		rv, _ := util.NewTDigest(util.TDIGEST_DEFAULT_COMPRESSION)
+		for _, v := range vals {
+			rv.Add(v)
+		}
+		return NewConstant(rv.String())
+	}
+
+	tests := []struct {
+		expr     Expression
+		expected interface{}
+	}{
+		{NewHLLEstimate(hll(0, 10)), 10},
+		{NewHLLEstimate(NewHLLUnion(hll(0, 10), NewConstant(nil), hll(5, 20))), 20},
+		{NewHLLEstimate(NewConstant(1)), nil},
+		{NewHLLUnion(NewConstant(nil)), nil},
+		{NewHLLUnion(hll(0, 10), NewConstant(1)), nil},
+		{NewTDigestPercentile(tdigest(4, 1, 3, 2), NewConstant(0.5)), 2.5},
+		{NewTDigestPercentile(tdigest(4, 1, 3, 2), NewConstant([]interface{}{0, 1.0 / 3.0, 1, 2})),
+			[]interface{}{1, 2, 4, nil}},
+		{NewTDigestPercentile(NewTDigestUnion(tdigest(1, 2), tdigest(3, 4)), NewConstant(0.25)), 1.75},
+		{NewTDigestPercentile(tdigest(), NewConstant(0.5)), nil},
+		{NewTDigestPercentile(tdigest(1), NewConstant("a")), nil},
+		{NewTDigestUnion(NewConstant(nil), NewConstant(value.MISSING_VALUE)), nil},
+	}
+
+	for _, test := range tests {
+		rv, err := test.expr.Evaluate(nil, nil)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", test.expr, err)
+			continue
+		}
+		if rv.Collate(value.NewValue(test.expected)) != 0 {
+			t.Errorf("%v: expected %v, got %v", test.expr, test.expected, rv)
+		}
+	}
+
+	failures := []Expression{
+		NewHLLEstimate(NewConstant("abc")),
+		NewHLLUnion(hll(0, 10), tdigest(1)),
+		NewTDigestPercentile(hll(0, 10), NewConstant(0.5)),
+		NewTDigestUnion(NewConstant("")),
+	}
+
+	for _, expr := range failures {
+		rv, err := expr.Evaluate(nil, nil)
+		if err == nil {
+			t.Errorf("%v: expected an error, got %v", expr, rv)
+		}
+	}
+}
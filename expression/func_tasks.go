@@ -0,0 +1,319 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/couchbase/query/auth"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/scheduler"
+	"github.com/couchbase/query/value"
+)
+
+// Recurring statements show in system:tasks_cache with this class and
+// subclass, and are dropped by deleting them from there.
+const (
+	_SCHEDULE_CLASS     = "schedule"
+	_SCHEDULE_STATEMENT = "statement"
+)
+
+///////////////////////////////////////////////////
+//
+// ScheduleTask
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function SCHEDULE_TASK(name, schedule, statement [, options]).
+It schedules statement to run on this node every time schedule fires, until
+the task is deleted from system:tasks_cache. Schedule is either a cron
+expression or @every followed by a duration. The options are "retries",
+the number of times a failed run is retried before waiting for the next
+scheduled time, "retry_delay", a duration string, and "query_context".
+*/
+type ScheduleTask struct {
+	FunctionBase
+}
+
+func NewScheduleTask(operands ...Expression) Function {
+	rv := &ScheduleTask{
+		*NewFunctionBase("schedule_task", operands...),
+	}
+
+	rv.setVolatile()
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *ScheduleTask) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *ScheduleTask) Type() value.Type { return value.BOOLEAN }
+
+func (this *ScheduleTask) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.Eval(this, item, context)
+}
+
+func (this *ScheduleTask) Indexable() bool {
+	return false
+}
+
+/*
+Scheduling tasks requires access to system:tasks_cache. The privileges
+of the statement itself are checked every time it runs.
+*/
+func (this *ScheduleTask) Privileges() *auth.Privileges {
+	privs := auth.NewPrivileges()
+	privs.Add("", auth.PRIV_SYSTEM_READ, auth.PRIV_PROPS_NONE)
+	for _, child := range this.Children() {
+		privs.AddAll(child.Privileges())
+	}
+	return privs
+}
+
+func (this *ScheduleTask) Apply(context Context, args ...value.Value) (value.Value, error) {
+	for _, arg := range args {
+		if arg.Type() == value.MISSING {
+			return value.MISSING_VALUE, nil
+		}
+	}
+	for _, arg := range args[:3] {
+		if arg.Type() != value.STRING {
+			return value.NULL_VALUE, nil
+		}
+	}
+
+	if context.Readonly() {
+		return nil, fmt.Errorf("%s() cannot be used in a read only request", this.Name())
+	}
+
+	name := args[0].Actual().(string)
+	schedule := args[1].Actual().(string)
+	statement := args[2].Actual().(string)
+
+	retries := 0
+	retryDelay := time.Duration(0)
+	queryContext := ""
+	if len(args) > 3 {
+		options, ok := args[3].Actual().(map[string]interface{})
+		if !ok {
+			return value.NULL_VALUE, nil
+		}
+		for k, v := range options {
+			val := value.NewValue(v)
+			switch k {
+			case "retries":
+				if val.Type() != value.NUMBER {
+					return nil, fmt.Errorf("%s() retries must be a non-negative integer", this.Name())
+				}
+				n := value.AsNumberValue(val).Float64()
+				if n < 0 || n != math.Trunc(n) {
+					return nil, fmt.Errorf("%s() retries must be a non-negative integer", this.Name())
+				}
+				retries = int(n)
+			case "retry_delay":
+				s, ok := val.Actual().(string)
+				if !ok {
+					return nil, fmt.Errorf("%s() retry_delay must be a duration string", this.Name())
+				}
+				d, err := time.ParseDuration(s)
+				if err != nil || d < 0 {
+					return nil, fmt.Errorf("%s() invalid retry_delay %v", this.Name(), s)
+				}
+				retryDelay = d
+			case "query_context":
+				s, ok := val.Actual().(string)
+				if !ok {
+					return nil, fmt.Errorf("%s() query_context must be a string", this.Name())
+				}
+				queryContext = s
+			default:
+				return nil, fmt.Errorf("%s() invalid option %v", this.Name(), k)
+			}
+		}
+	}
+
+	taskContext := context
+	if queryContext != "" {
+		taskContext = context.NewQueryContext(queryContext, false).(Context)
+	}
+
+	err := scheduler.ScheduleRecurringTask(name, _SCHEDULE_CLASS, _SCHEDULE_STATEMENT, schedule, retries, retryDelay,
+		func(context scheduler.Context, parms interface{}) (interface{}, []errors.Error) {
+			res, _, err := context.EvaluateStatement(statement, nil, nil, false, false)
+			if err != nil {
+				return nil, []errors.Error{errors.NewError(err, "")}
+			}
+			return res, nil
+		}, nil, nil, taskContext)
+	if err != nil {
+		return nil, err
+	}
+	return value.TRUE_VALUE, nil
+}
+
+func (this *ScheduleTask) MinArgs() int { return 3 }
+
+func (this *ScheduleTask) MaxArgs() int { return 4 }
+
+/*
+Factory method pattern.
+*/
+func (this *ScheduleTask) Constructor() FunctionConstructor {
+	return NewScheduleTask
+}
+
+///////////////////////////////////////////////////
+//
+// PauseTask
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function PAUSE_TASK(name). It stops a task scheduled
+with SCHEDULE_TASK from running until it is resumed. A task that is
+running when paused completes its current run.
+*/
+type PauseTask struct {
+	UnaryFunctionBase
+}
+
+func NewPauseTask(operand Expression) Function {
+	rv := &PauseTask{
+		*NewUnaryFunctionBase("pause_task", operand),
+	}
+
+	rv.setVolatile()
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *PauseTask) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *PauseTask) Type() value.Type { return value.BOOLEAN }
+
+func (this *PauseTask) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.UnaryEval(this, item, context)
+}
+
+func (this *PauseTask) Indexable() bool {
+	return false
+}
+
+func (this *PauseTask) Privileges() *auth.Privileges {
+	return taskPrivileges(this.Operand())
+}
+
+func (this *PauseTask) Apply(context Context, arg value.Value) (value.Value, error) {
+	return applyTask(arg, scheduler.PauseTask)
+}
+
+/*
+Factory method pattern.
+*/
+func (this *PauseTask) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewPauseTask(operands[0])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// ResumeTask
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function RESUME_TASK(name). It schedules a paused
+task for the next time its schedule fires.
+*/
+type ResumeTask struct {
+	UnaryFunctionBase
+}
+
+func NewResumeTask(operand Expression) Function {
+	rv := &ResumeTask{
+		*NewUnaryFunctionBase("resume_task", operand),
+	}
+
+	rv.setVolatile()
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *ResumeTask) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *ResumeTask) Type() value.Type { return value.BOOLEAN }
+
+func (this *ResumeTask) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return this.UnaryEval(this, item, context)
+}
+
+func (this *ResumeTask) Indexable() bool {
+	return false
+}
+
+func (this *ResumeTask) Privileges() *auth.Privileges {
+	return taskPrivileges(this.Operand())
+}
+
+func (this *ResumeTask) Apply(context Context, arg value.Value) (value.Value, error) {
+	return applyTask(arg, scheduler.ResumeTask)
+}
+
+/*
+Factory method pattern.
+*/
+func (this *ResumeTask) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewResumeTask(operands[0])
+	}
+}
+
+func taskPrivileges(operand Expression) *auth.Privileges {
+	privs := auth.NewPrivileges()
+	privs.Add("", auth.PRIV_SYSTEM_READ, auth.PRIV_PROPS_NONE)
+	privs.AddAll(operand.Privileges())
+	return privs
+}
+
+func applyTask(arg value.Value, op func(string) errors.Error) (value.Value, error) {
+	if arg.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	} else if arg.Type() != value.STRING {
+		return value.NULL_VALUE, nil
+	}
+
+	id, err := scheduler.TaskId(arg.Actual().(string), _SCHEDULE_CLASS, _SCHEDULE_STATEMENT)
+	if err == nil {
+		err = op(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value.TRUE_VALUE, nil
+}
@@ -412,6 +412,8 @@ func (this *ToString) Apply(context Context, arg value.Value) (value.Value, erro
 
 		s := string(raw)
 		return value.NewValue(s), nil
+	case value.INTERVAL:
+		return value.NewValue(arg.Actual().(value.Interval).String()), nil
 	default:
 		return value.NULL_VALUE, nil
 	}
@@ -410,6 +410,9 @@ func (this *Stringer) VisitConstant(expr *Constant) (interface{}, error) {
 	}
 
 	b, _ := expr.value.MarshalJSON()
+	if expr.value.Type() == value.INTERVAL {
+		return "to_interval(" + string(b) + ")", nil
+	}
 	return string(b), nil
 }
 
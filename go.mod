@@ -57,6 +57,7 @@ require (
 	github.com/sbinet/liner v0.0.0-20150202172121-d9335eee40a4
 	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a
 	golang.org/x/net v0.0.0-20190404232315-eb5bcb51f2a3
+	golang.org/x/text v0.3.0
 	gopkg.in/couchbase/gocb.v1 v1.6.7
 	gopkg.in/couchbaselabs/gocbconnstr.v1 v1.0.4 // indirect
 	gopkg.in/couchbaselabs/gojcbmock.v1 v1.0.4 // indirect
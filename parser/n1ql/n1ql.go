@@ -134,6 +134,22 @@ func (this *lexer) Lex(lval *yySymType) int {
 
 	rv := this.nex.Lex(lval)
 
+	// WITHIN GROUP introduces the ordering of ordered set aggregates,
+	// and would otherwise conflict with the WITHIN operator
+	if rv == WITHIN {
+		this.hasSaved = true
+		oldLval := *lval
+		this.saved = this.nex.Lex(lval)
+		this.lval = *lval
+		*lval = oldLval
+
+		if this.saved == GROUP {
+			this.hasSaved = false
+			return WITHIN_GROUP
+		}
+		return WITHIN
+	}
+
 	// we are going to treat identifiers specially to resolve
 	// shift reduce conflicts on namespaces
 	if rv != IDENT {
@@ -230,3 +246,110 @@ func (this *lexer) Namespace() string {
 func (this *lexer) QueryContext() string {
 	return this.queryContext
 }
+
+/*
+The arguments of an aggregate with a quantifier, an ORDER BY or an
+ON OVERFLOW clause, as in STRING_AGG(DISTINCT expr, sep ORDER BY ...).
+*/
+type aggregateArgs struct {
+	flags    uint32
+	exprs    expression.Expressions
+	order    *algebra.Order
+	overflow *algebra.Overflow
+}
+
+/*
+Apply the ORDER BY and ON OVERFLOW clauses to the aggregate.
+*/
+func (this *aggregateArgs) setClauses(agg algebra.Aggregate, order *algebra.Order) {
+	if order != nil {
+		agg.SetAggregateOrder(order.Terms())
+	}
+
+	if this.overflow != nil {
+		if a, ok := agg.(interface{ SetOverflow(*algebra.Overflow) }); ok {
+			a.SetOverflow(this.overflow)
+		}
+	}
+}
+
+/*
+A comparison with an operand written as expr COLLATE locale compares
+the collation keys of all its operands in the locale.
+*/
+func collateOperands(operands ...expression.Expression) expression.Expressions {
+	return collate(func(pos int, op, locale expression.Expression) expression.Expression {
+		return expression.NewCollationKey(op, locale)
+	}, operands...)
+}
+
+/*
+A LIKE with an operand written as expr COLLATE locale matches the
+operands folded for the locale, as their collation keys would lose
+the wildcards of the pattern.
+*/
+func collateLikeOperands(operands ...expression.Expression) expression.Expressions {
+	return collate(func(pos int, op, locale expression.Expression) expression.Expression {
+		return expression.NewCollationFold(op, locale)
+	}, operands...)
+}
+
+/*
+An IN with an operand written as expr COLLATE locale looks for the
+collation key of its first operand among those of the elements of
+the second.
+*/
+func collateInOperands(first, second expression.Expression) expression.Expressions {
+	return collate(func(pos int, op, locale expression.Expression) expression.Expression {
+		if pos > 0 {
+			if array, ok := op.(*expression.ArrayConstruct); ok {
+				keys := make(expression.Expressions, len(array.Operands()))
+				for i, elem := range array.Operands() {
+					keys[i] = expression.NewCollationKey(elem, locale.Copy())
+				}
+				return expression.NewArrayConstruct(keys...)
+			}
+
+			elem := expression.NewIdentifier("collated")
+			return expression.NewArray(expression.NewCollationKey(elem, locale),
+				expression.Bindings{expression.NewSimpleBinding(elem.Identifier(), op)}, nil)
+		}
+		return expression.NewCollationKey(op, locale)
+	}, first, second)
+}
+
+func collate(key func(pos int, op, locale expression.Expression) expression.Expression,
+	operands ...expression.Expression) expression.Expressions {
+
+	var locale expression.Expression
+	for _, op := range operands {
+		if c, ok := op.(*expression.Collate); ok {
+			locale = c.Second()
+			break
+		}
+	}
+
+	if locale == nil {
+		return operands
+	}
+
+	rv := make(expression.Expressions, len(operands))
+	for i, op := range operands {
+		if c, ok := op.(*expression.Collate); ok {
+			op = c.First()
+		}
+		rv[i] = key(i, op, locale.Copy())
+	}
+	return rv
+}
+
+/*
+An ORDER BY term written as expr COLLATE locale sorts by the collation
+key of expr.
+*/
+func collateSortTerm(expr expression.Expression) expression.Expression {
+	if c, ok := expr.(*expression.Collate); ok {
+		return expression.NewCollationKey(c.First(), c.Second())
+	}
+	return expr
+}
@@ -52,6 +52,9 @@ use              *algebra.Use
 joinHint         algebra.JoinHint
 indexRefs        algebra.IndexRefs
 indexRef         *algebra.IndexRef
+withClause       *algebra.WithClause
+recursiveWith    *algebra.RecursiveWith
+recursiveWiths   algebra.RecursiveWiths
 subqueryTerm     *algebra.SubqueryTerm
 path             expression.Path
 group            *algebra.Group
@@ -101,6 +104,9 @@ isolationLevel   datastore.IsolationLevel
 functionName	 functions.FunctionName
 functionBody     functions.FunctionBody
 
+aggArgs          *aggregateArgs
+overflow         *algebra.Overflow
+
 // token offset into the statement
 tokOffset	 int
 }
@@ -138,6 +144,7 @@ tokOffset	 int
 %token COVER
 %token CREATE
 %token CURRENT
+%token CYCLE
 %token DATABASE
 %token DATASET
 %token DATASTORE
@@ -254,10 +261,12 @@ tokOffset	 int
 %token RAW
 %token READ
 %token REALM
+%token RECURSIVE
 %token REDUCE
 %token RENAME
 %token REPLACE
 %token RESPECT
+%token RESTRICT
 %token RETURN
 %token RETURNING
 %token REVOKE
@@ -312,6 +321,7 @@ tokOffset	 int
 %token WINDOW
 %token WITH
 %token WITHIN
+%token WITHIN_GROUP
 %token WORK
 %token XOR
 
@@ -337,6 +347,7 @@ tokOffset	 int
 %left           CONCAT
 %left           PLUS MINUS
 %left           STAR DIV MOD
+%left           COLLATE
 
 /* Unary operators */
 %right          COVER
@@ -352,7 +363,7 @@ tokOffset	 int
 /* Types */
 %type <s>                STR
 %type <s>                IDENT IDENT_ICASE NAMESPACE_ID
-%type <s>                REPLACE
+%type <s>                REPLACE CYCLE RECURSIVE RESTRICT
 %type <s>                NAMED_PARAM
 %type <f>                NUM
 %type <n>                INT
@@ -379,7 +390,7 @@ tokOffset	 int
 %type <expr>             opt_when
 
 %type <expr>             function_expr function_meta_expr
-%type <s>                function_name
+%type <s>                function_name permitted_identifiers
 
 %type <functionName>	 func_name long_func_name short_func_name
 %type <ss>		 parm_list parameter_terms
@@ -406,7 +417,12 @@ tokOffset	 int
 %type <expr>             on_keys on_key
 %type <indexRefs>        index_refs
 %type <indexRef>         index_ref
-%type <bindings>         opt_let let opt_with
+%type <bindings>         opt_let let
+%type <withClause>       opt_with
+%type <recursiveWith>    recursive_with_term
+%type <recursiveWiths>   recursive_with_list
+%type <exprs>            opt_cycle_clause
+%type <val>              opt_with_options
 %type <expr>             opt_where where opt_filter
 %type <group>            opt_group group
 %type <bindings>         opt_letting letting
@@ -493,6 +509,10 @@ tokOffset	 int
 %type <windowFrameExtents>  window_frame_extents
 %type <windowFrameExtent>   window_frame_extent
 %type <u32>                 opt_nulls_treatment nulls_treatment opt_from_first_last agg_quantifier
+%type <aggArgs>             agg_args agg_order_overflow
+%type <overflow>            on_overflow
+%type <s>                   opt_overflow_filler
+%type <b>                   opt_overflow_count
 
 %type <isolationLevel>      opt_isolation_level isolation_level isolation_val
 %type <s>                   opt_savepoint savepoint_name
@@ -983,6 +1003,17 @@ AS alias
 
 alias:
 IDENT
+|
+permitted_identifiers
+;
+
+/* keywords that can still be used as identifiers */
+permitted_identifiers:
+CYCLE
+|
+RECURSIVE
+|
+RESTRICT
 ;
 
 
@@ -1199,6 +1230,8 @@ IDENT
 
 keyspace_name:
 IDENT
+|
+permitted_identifiers
 ;
 
 opt_use:
@@ -1410,7 +1443,12 @@ opt_with:
 |
 WITH with_list
 {
-    $$ = $2
+    $$ = algebra.NewWithClause($2)
+}
+|
+WITH RECURSIVE recursive_with_list
+{
+    $$ = algebra.NewRecursiveWithClause($3)
 }
 ;
 
@@ -1438,6 +1476,48 @@ alias AS paren_expr
 }
 ;
 
+recursive_with_list:
+recursive_with_term
+{
+    $$ = algebra.RecursiveWiths{$1}
+}
+|
+recursive_with_list COMMA recursive_with_term
+{
+    $$ = append($1, $3)
+}
+;
+
+recursive_with_term:
+with_term opt_cycle_clause opt_with_options
+{
+    $$ = algebra.NewRecursiveWith($1, $2, $3)
+}
+;
+
+opt_cycle_clause:
+/* empty */
+{ $$ = nil }
+|
+CYCLE exprs RESTRICT
+{
+    $$ = $2
+}
+;
+
+opt_with_options:
+/* empty */
+{ $$ = nil }
+|
+OPTIONS expr
+{
+    $$ = $2.Value()
+    if $$ == nil {
+	yylex.Error("OPTIONS value must be static.")
+    }
+}
+;
+
 
 /*************************************************
  *
@@ -1578,7 +1658,7 @@ sort_terms COMMA sort_term
 sort_term:
 expr opt_dir opt_order_nulls
 {
-    $$ = algebra.NewSortTerm($1, $2, algebra.NewOrderNullsPos($2,$3))
+    $$ = algebra.NewSortTerm(collateSortTerm($1), $2, algebra.NewOrderNullsPos($2,$3))
 }
 ;
 
@@ -2027,6 +2107,8 @@ variable COLON variable WITHIN expr
 
 variable:
 IDENT
+|
+permitted_identifiers
 ;
 
 opt_when:
@@ -2983,11 +3065,21 @@ IDENT
     $$ = expression.NewIdentifier($1)
 }
 |
+permitted_identifiers
+{
+    $$ = expression.NewIdentifier($1)
+}
+|
 path DOT IDENT
 {
     $$ = expression.NewField($1, expression.NewFieldName($3, false))
 }
 |
+path DOT permitted_identifiers
+{
+    $$ = expression.NewField($1, expression.NewFieldName($3, false))
+}
+|
 path DOT IDENT_ICASE
 {
     field := expression.NewField($1, expression.NewFieldName($3, true))
@@ -3029,6 +3121,11 @@ expr DOT IDENT
     $$ = expression.NewField($1, expression.NewFieldName($3, false))
 }
 |
+expr DOT permitted_identifiers
+{
+    $$ = expression.NewField($1, expression.NewFieldName($3, false))
+}
+|
 expr DOT IDENT_ICASE
 {
     field := expression.NewField($1, expression.NewFieldName($3, true))
@@ -3100,6 +3197,12 @@ expr CONCAT expr
     $$ = expression.NewConcat($1, $3)
 }
 |
+/* Collation */
+expr COLLATE STR
+{
+    $$ = expression.NewCollate($1, expression.NewConstant($3))
+}
+|
 /* Logical */
 expr AND expr
 {
@@ -3119,67 +3222,80 @@ NOT expr
 /* Comparison */
 expr EQ expr
 {
-    $$ = expression.NewEq($1, $3)
+    ops := collateOperands($1, $3)
+    $$ = expression.NewEq(ops[0], ops[1])
 }
 |
 expr DEQ expr
 {
-    $$ = expression.NewEq($1, $3)
+    ops := collateOperands($1, $3)
+    $$ = expression.NewEq(ops[0], ops[1])
 }
 |
 expr NE expr
 {
-    $$ = expression.NewNE($1, $3)
+    ops := collateOperands($1, $3)
+    $$ = expression.NewNE(ops[0], ops[1])
 }
 |
 expr LT expr
 {
-    $$ = expression.NewLT($1, $3)
+    ops := collateOperands($1, $3)
+    $$ = expression.NewLT(ops[0], ops[1])
 }
 |
 expr GT expr
 {
-    $$ = expression.NewGT($1, $3)
+    ops := collateOperands($1, $3)
+    $$ = expression.NewGT(ops[0], ops[1])
 }
 |
 expr LE expr
 {
-    $$ = expression.NewLE($1, $3)
+    ops := collateOperands($1, $3)
+    $$ = expression.NewLE(ops[0], ops[1])
 }
 |
 expr GE expr
 {
-    $$ = expression.NewGE($1, $3)
+    ops := collateOperands($1, $3)
+    $$ = expression.NewGE(ops[0], ops[1])
 }
 |
 expr BETWEEN b_expr AND b_expr
 {
-    $$ = expression.NewBetween($1, $3, $5)
+    ops := collateOperands($1, $3, $5)
+    $$ = expression.NewBetween(ops[0], ops[1], ops[2])
 }
 |
 expr NOT BETWEEN b_expr AND b_expr
 {
-    $$ = expression.NewNotBetween($1, $4, $6)
+    ops := collateOperands($1, $4, $6)
+    $$ = expression.NewNotBetween(ops[0], ops[1], ops[2])
 }
 |
 expr LIKE expr
 {
-    $$ = expression.NewLike($1, $3)
+    ops := collateLikeOperands($1, $3)
+    $$ = expression.NewLike(ops[0], ops[1])
 }
 |
 expr NOT LIKE expr
 {
-    $$ = expression.NewNotLike($1, $4)
+    ops := collateLikeOperands($1, $4)
+    $$ = expression.NewNotLike(ops[0], ops[1])
 }
 |
 expr IN expr
 {
-    $$ = expression.NewIn($1, $3)
+    ops := collateInOperands($1, $3)
+    $$ = expression.NewIn(ops[0], ops[1])
 }
 |
 expr NOT IN expr
 {
-    $$ = expression.NewNotIn($1, $4)
+    ops := collateInOperands($1, $4)
+    $$ = expression.NewNotIn(ops[0], ops[1])
 }
 |
 expr WITHIN expr
@@ -3248,6 +3364,12 @@ IDENT
 }
 |
 /* Identifier */
+permitted_identifiers
+{
+    $$ = expression.NewIdentifier($1)
+}
+|
+/* Identifier */
 IDENT_ICASE
 {
     ident := expression.NewIdentifier($1)
@@ -3304,6 +3426,11 @@ b_expr DOT IDENT
     $$ = expression.NewField($1, expression.NewFieldName($3, false))
 }
 |
+b_expr DOT permitted_identifiers
+{
+    $$ = expression.NewField($1, expression.NewFieldName($3, false))
+}
+|
 b_expr DOT IDENT_ICASE
 {
     field := expression.NewField($1, expression.NewFieldName($3, true))
@@ -3374,6 +3501,12 @@ b_expr CONCAT b_expr
 {
     $$ = expression.NewConcat($1, $3)
 }
+|
+/* Collation */
+b_expr COLLATE STR
+{
+    $$ = expression.NewCollate($1, expression.NewConstant($3))
+}
 ;
 
 
@@ -3418,6 +3551,18 @@ STR
 {
     $$ = expression.NewConstant(value.NewValue($1))
 }
+|
+IDENT STR
+{
+    if strings.ToLower($1) != "interval" {
+        yylex.Error(fmt.Sprintf("Unexpected string %q after %s.", $2, $1))
+    }
+    iv, err := value.ParseInterval($2)
+    if err != nil {
+        yylex.Error(err.Error())
+    }
+    $$ = expression.NewConstant(value.NewIntervalValue(iv))
+}
 ;
 
 
@@ -3680,16 +3825,81 @@ function_name LPAREN opt_exprs RPAREN opt_filter opt_nulls_treatment opt_window_
     }
 }
 |
-function_name LPAREN agg_quantifier expr RPAREN opt_filter opt_window_function
+function_name LPAREN opt_exprs RPAREN WITHIN_GROUP LPAREN order_by RPAREN opt_filter opt_window_function
 {
-    agg, ok := algebra.GetAggregate($1, $3 == algebra.AGGREGATE_DISTINCT, ($6 != nil), ($7 != nil))
-    if ok {
-        $$ = agg.Constructor()($4)
-        if a, ok := $$.(algebra.Aggregate); ok {
-             a.SetAggregateModifiers($3, $6, $7)
+    $$ = nil
+    agg, ok := algebra.GetAggregate($1, false, ($9 != nil), ($10 != nil))
+    if !ok || !algebra.AggregateHasProperty($1, algebra.AGGREGATE_ALLOWS_WITHIN_GROUP|algebra.AGGREGATE_ALLOWS_ORDER_BY) {
+        yylex.Error(fmt.Sprintf("WITHIN GROUP syntax is not valid for function %s.", $1))
+    } else if algebra.AggregateHasProperty($1, algebra.AGGREGATE_ALLOWS_ORDER_BY) {
+        if len($3) < agg.MinArgs() || len($3) > agg.MaxArgs() {
+            yylex.Error(fmt.Sprintf("Number of arguments to function %s must be between %d and %d.", $1, agg.MinArgs(), agg.MaxArgs()))
+        } else {
+            $$ = agg.Constructor()($3...)
+            if a, ok := $$.(algebra.Aggregate); ok {
+                 a.SetAggregateModifiers(uint32(0), $9, $10)
+                 a.SetAggregateOrder($7.Terms())
+            }
         }
+    } else if len($7.Terms()) != 1 {
+        yylex.Error(fmt.Sprintf("WITHIN GROUP ORDER BY of function %s must have exactly one term.", $1))
     } else {
+        term := $7.Terms()[0]
+        operands := append(expression.Expressions{term.Expression()}, $3...)
+        if len(operands) < agg.MinArgs() || len(operands) > agg.MaxArgs() {
+            yylex.Error(fmt.Sprintf("Number of arguments to function %s must be %d.", $1, agg.MaxArgs()-1))
+        } else {
+            flags := uint32(0)
+            if term.Descending() {
+                flags = algebra.AGGREGATE_DESCENDING
+            }
+            $$ = agg.Constructor()(operands...)
+            if a, ok := $$.(algebra.Aggregate); ok {
+                 a.SetAggregateModifiers(flags, $9, $10)
+            }
+        }
+    }
+}
+|
+function_name LPAREN agg_args RPAREN WITHIN_GROUP LPAREN order_by RPAREN opt_filter opt_window_function
+{
+    $$ = nil
+    agg, ok := algebra.GetAggregate($1, $3.flags == algebra.AGGREGATE_DISTINCT, ($9 != nil), ($10 != nil))
+    if !ok || !algebra.AggregateHasProperty($1, algebra.AGGREGATE_ALLOWS_ORDER_BY) {
+        yylex.Error(fmt.Sprintf("WITHIN GROUP syntax is not valid for function %s.", $1))
+    } else if $3.order != nil {
+        yylex.Error(fmt.Sprintf("ORDER BY and WITHIN GROUP cannot both be used for function %s.", $1))
+    } else if len($3.exprs) < agg.MinArgs() || len($3.exprs) > agg.MaxArgs() {
+        yylex.Error(fmt.Sprintf("Number of arguments to function %s must be between %d and %d.", $1, agg.MinArgs(), agg.MaxArgs()))
+    } else {
+        $$ = agg.Constructor()($3.exprs...)
+        if a, ok := $$.(algebra.Aggregate); ok {
+             a.SetAggregateModifiers($3.flags, $9, $10)
+             $3.setClauses(a, $7)
+        }
+    }
+}
+|
+function_name LPAREN agg_args RPAREN opt_filter opt_window_function
+{
+    $$ = nil
+    agg, ok := algebra.GetAggregate($1, $3.flags == algebra.AGGREGATE_DISTINCT, ($5 != nil), ($6 != nil))
+    if !ok {
         yylex.Error(fmt.Sprintf("Invalid aggregate function %s.", $1))
+    } else if ($3.order != nil || $3.overflow != nil) && !algebra.AggregateHasProperty($1, algebra.AGGREGATE_ALLOWS_ORDER_BY) {
+        yylex.Error(fmt.Sprintf("ORDER BY and ON OVERFLOW syntax is not valid for function %s.", $1))
+    } else if len($3.exprs) < agg.MinArgs() || len($3.exprs) > agg.MaxArgs() {
+        if agg.MinArgs() == agg.MaxArgs() {
+            yylex.Error(fmt.Sprintf("Number of arguments to function %s must be %d.", $1, agg.MaxArgs()))
+        } else {
+            yylex.Error(fmt.Sprintf("Number of arguments to function %s must be between %d and %d.", $1, agg.MinArgs(), agg.MaxArgs()))
+        }
+    } else {
+        $$ = agg.Constructor()($3.exprs...)
+        if a, ok := $$.(algebra.Aggregate); ok {
+             a.SetAggregateModifiers($3.flags, $5, $6)
+             $3.setClauses(a, $3.order)
+        }
     }
 }
 |
@@ -4108,6 +4318,94 @@ DISTINCT
 }
 ;
 
+agg_args:
+exprs agg_order_overflow
+{
+    $$ = $2
+    $$.exprs = $1
+}
+|
+agg_quantifier exprs
+{
+    $$ = &aggregateArgs{flags: $1, exprs: $2}
+}
+|
+agg_quantifier exprs agg_order_overflow
+{
+    $$ = $3
+    $$.flags = $1
+    $$.exprs = $2
+}
+;
+
+agg_order_overflow:
+order_by
+{
+    $$ = &aggregateArgs{order: $1}
+}
+|
+on_overflow
+{
+    $$ = &aggregateArgs{overflow: $1}
+}
+|
+order_by on_overflow
+{
+    $$ = &aggregateArgs{order: $1, overflow: $2}
+}
+;
+
+/* OVERFLOW, ERROR, COUNT and WITHOUT are not reserved */
+on_overflow:
+ON IDENT IDENT
+{
+    if strings.ToLower($2) != "overflow" || strings.ToLower($3) != "error" {
+        yylex.Error(fmt.Sprintf("Invalid ON OVERFLOW clause ON %s %s.", $2, $3))
+    }
+    $$ = algebra.NewOverflow(false, "", false)
+}
+|
+ON IDENT TRUNCATE opt_overflow_filler opt_overflow_count
+{
+    if strings.ToLower($2) != "overflow" {
+        yylex.Error(fmt.Sprintf("Invalid ON OVERFLOW clause ON %s TRUNCATE.", $2))
+    }
+    $$ = algebra.NewOverflow(true, $4, $5)
+}
+;
+
+opt_overflow_filler:
+/* empty */
+{
+    $$ = algebra.OVERFLOW_FILLER
+}
+|
+STR
+;
+
+opt_overflow_count:
+/* empty */
+{
+    $$ = true
+}
+|
+WITH IDENT
+{
+    if strings.ToLower($2) != "count" {
+        yylex.Error(fmt.Sprintf("Invalid ON OVERFLOW clause WITH %s.", $2))
+    }
+    $$ = true
+}
+|
+IDENT IDENT
+{
+    if strings.ToLower($1) != "without" || strings.ToLower($2) != "count" {
+        yylex.Error(fmt.Sprintf("Invalid ON OVERFLOW clause %s %s.", $1, $2))
+    }
+    $$ = false
+}
+;
+
 opt_filter:
 /* empty */
 { $$ = nil }
@@ -17,33 +17,42 @@ import (
 	"github.com/couchbase/query/expression/parser"
 )
 
+/*
+The number of partitions both sides of a hash join or nest are spilled
+to, should the build side outgrow memory.
+*/
+const HASH_SPILL_PARTITIONS = 32
+
 type HashJoin struct {
 	readonly
-	outer        bool
-	onclause     expression.Expression
-	child        Operator
-	buildExprs   expression.Expressions
-	probeExprs   expression.Expressions
-	buildAliases []string
-	hintError    string
-	filter       expression.Expression
-	cost         float64
-	cardinality  float64
+	outer           bool
+	onclause        expression.Expression
+	child           Operator
+	buildExprs      expression.Expressions
+	probeExprs      expression.Expressions
+	buildAliases    []string
+	hintError       string
+	filter          expression.Expression
+	cost            float64
+	cardinality     float64
+	spillPartitions int
 }
 
 func NewHashJoin(join *algebra.AnsiJoin, child Operator, buildExprs, probeExprs expression.Expressions,
-	buildAliases []string, filter expression.Expression, cost, cardinality float64) *HashJoin {
+	buildAliases []string, filter expression.Expression, cost, cardinality float64,
+	spillPartitions int) *HashJoin {
 	return &HashJoin{
-		outer:        join.Outer(),
-		onclause:     join.Onclause(),
-		child:        child,
-		buildExprs:   buildExprs,
-		probeExprs:   probeExprs,
-		buildAliases: buildAliases,
-		hintError:    join.HintError(),
-		filter:       filter,
-		cost:         cost,
-		cardinality:  cardinality,
+		outer:           join.Outer(),
+		onclause:        join.Onclause(),
+		child:           child,
+		buildExprs:      buildExprs,
+		probeExprs:      probeExprs,
+		buildAliases:    buildAliases,
+		hintError:       join.HintError(),
+		filter:          filter,
+		cost:            cost,
+		cardinality:     cardinality,
+		spillPartitions: spillPartitions,
 	}
 }
 
@@ -95,6 +104,10 @@ func (this *HashJoin) Cardinality() float64 {
 	return this.cardinality
 }
 
+func (this *HashJoin) SpillPartitions() int {
+	return this.spillPartitions
+}
+
 func (this *HashJoin) MarshalJSON() ([]byte, error) {
 	return json.Marshal(this.MarshalBase(nil))
 }
@@ -137,6 +150,8 @@ func (this *HashJoin) MarshalBase(f func(map[string]interface{})) map[string]int
 		r["cardinality"] = this.cardinality
 	}
 
+	r["spill_partitions"] = this.spillPartitions
+
 	if f != nil {
 		f(r)
 	} else {
@@ -147,17 +162,18 @@ func (this *HashJoin) MarshalBase(f func(map[string]interface{})) map[string]int
 
 func (this *HashJoin) UnmarshalJSON(body []byte) error {
 	var _unmarshalled struct {
-		_            string          `json:"#operator"`
-		Onclause     string          `json:"on_clause"`
-		Outer        bool            `json:"outer"`
-		BuildExprs   []string        `json:"build_exprs"`
-		ProbeExprs   []string        `json:"probe_exprs"`
-		BuildAliases []string        `json:"build_aliases"`
-		HintError    string          `json:"hint_not_followed"`
-		Filter       string          `json:"filter"`
-		Cost         float64         `json:"cost"`
-		Cardinality  float64         `json:"cardinality"`
-		Child        json.RawMessage `json:"~child"`
+		_               string          `json:"#operator"`
+		Onclause        string          `json:"on_clause"`
+		Outer           bool            `json:"outer"`
+		BuildExprs      []string        `json:"build_exprs"`
+		ProbeExprs      []string        `json:"probe_exprs"`
+		BuildAliases    []string        `json:"build_aliases"`
+		HintError       string          `json:"hint_not_followed"`
+		Filter          string          `json:"filter"`
+		Cost            float64         `json:"cost"`
+		Cardinality     float64         `json:"cardinality"`
+		SpillPartitions int             `json:"spill_partitions"`
+		Child           json.RawMessage `json:"~child"`
 	}
 
 	err := json.Unmarshal(body, &_unmarshalled)
@@ -205,6 +221,12 @@ func (this *HashJoin) UnmarshalJSON(body []byte) error {
 	this.cost = getCost(_unmarshalled.Cost)
 	this.cardinality = getCardinality(_unmarshalled.Cardinality)
 
+	// plans from before hash joins could spill
+	this.spillPartitions = _unmarshalled.SpillPartitions
+	if this.spillPartitions <= 0 {
+		this.spillPartitions = HASH_SPILL_PARTITIONS
+	}
+
 	raw_child := _unmarshalled.Child
 	var child_type struct {
 		Op_name string `json:"#operator"`
@@ -19,31 +19,34 @@ import (
 
 type HashNest struct {
 	readonly
-	outer       bool
-	onclause    expression.Expression
-	child       Operator
-	buildExprs  expression.Expressions
-	probeExprs  expression.Expressions
-	buildAlias  string
-	hintError   string
-	filter      expression.Expression
-	cost        float64
-	cardinality float64
+	outer           bool
+	onclause        expression.Expression
+	child           Operator
+	buildExprs      expression.Expressions
+	probeExprs      expression.Expressions
+	buildAlias      string
+	hintError       string
+	filter          expression.Expression
+	cost            float64
+	cardinality     float64
+	spillPartitions int
 }
 
 func NewHashNest(nest *algebra.AnsiNest, child Operator, buildExprs, probeExprs expression.Expressions,
-	buildAlias string, filter expression.Expression, cost, cardinality float64) *HashNest {
+	buildAlias string, filter expression.Expression, cost, cardinality float64,
+	spillPartitions int) *HashNest {
 	return &HashNest{
-		outer:       nest.Outer(),
-		onclause:    nest.Onclause(),
-		child:       child,
-		buildExprs:  buildExprs,
-		probeExprs:  probeExprs,
-		buildAlias:  buildAlias,
-		hintError:   nest.HintError(),
-		filter:      filter,
-		cost:        cost,
-		cardinality: cardinality,
+		outer:           nest.Outer(),
+		onclause:        nest.Onclause(),
+		child:           child,
+		buildExprs:      buildExprs,
+		probeExprs:      probeExprs,
+		buildAlias:      buildAlias,
+		hintError:       nest.HintError(),
+		filter:          filter,
+		cost:            cost,
+		cardinality:     cardinality,
+		spillPartitions: spillPartitions,
 	}
 }
 
@@ -95,6 +98,10 @@ func (this *HashNest) Cardinality() float64 {
 	return this.cardinality
 }
 
+func (this *HashNest) SpillPartitions() int {
+	return this.spillPartitions
+}
+
 func (this *HashNest) MarshalJSON() ([]byte, error) {
 	return json.Marshal(this.MarshalBase(nil))
 }
@@ -137,6 +144,8 @@ func (this *HashNest) MarshalBase(f func(map[string]interface{})) map[string]int
 		r["cardinality"] = this.cardinality
 	}
 
+	r["spill_partitions"] = this.spillPartitions
+
 	if f != nil {
 		f(r)
 	} else {
@@ -147,17 +156,18 @@ func (this *HashNest) MarshalBase(f func(map[string]interface{})) map[string]int
 
 func (this *HashNest) UnmarshalJSON(body []byte) error {
 	var _unmarshalled struct {
-		_           string          `json:"#operator"`
-		Onclause    string          `json:"on_clause"`
-		Outer       bool            `json:"outer"`
-		BuildExprs  []string        `json:"build_exprs"`
-		ProbeExprs  []string        `json:"probe_exprs"`
-		BuildAlias  string          `json:"build_alias"`
-		HintError   string          `json:"hint_not_followed"`
-		Filter      string          `json:"filter"`
-		Cost        float64         `json:"cost"`
-		Cardinality float64         `json:"cardinality"`
-		Child       json.RawMessage `json:"~child"`
+		_               string          `json:"#operator"`
+		Onclause        string          `json:"on_clause"`
+		Outer           bool            `json:"outer"`
+		BuildExprs      []string        `json:"build_exprs"`
+		ProbeExprs      []string        `json:"probe_exprs"`
+		BuildAlias      string          `json:"build_alias"`
+		HintError       string          `json:"hint_not_followed"`
+		Filter          string          `json:"filter"`
+		Cost            float64         `json:"cost"`
+		Cardinality     float64         `json:"cardinality"`
+		SpillPartitions int             `json:"spill_partitions"`
+		Child           json.RawMessage `json:"~child"`
 	}
 
 	err := json.Unmarshal(body, &_unmarshalled)
@@ -205,6 +215,12 @@ func (this *HashNest) UnmarshalJSON(body []byte) error {
 	this.cost = getCost(_unmarshalled.Cost)
 	this.cardinality = getCardinality(_unmarshalled.Cardinality)
 
+	// plans from before hash joins could spill
+	this.spillPartitions = _unmarshalled.SpillPartitions
+	if this.spillPartitions <= 0 {
+		this.spillPartitions = HASH_SPILL_PARTITIONS
+	}
+
 	raw_child := _unmarshalled.Child
 	var child_type struct {
 		Op_name string `json:"#operator"`
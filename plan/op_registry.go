@@ -77,6 +77,9 @@ var _OPERATORS = map[string]Operator{
 	// Let + Letting
 	"Let": &Let{},
 
+	// With
+	"With": &With{},
+
 	// Infer
 	"InferKeyspace": &InferKeyspace{},
 
@@ -11,22 +11,29 @@ package plan
 
 import (
 	"encoding/json"
+	"fmt"
 
+	"github.com/couchbase/query/algebra"
 	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/expression/parser"
 	"github.com/couchbase/query/expression/unmarshal"
+	"github.com/couchbase/query/value"
 )
 
 type With struct {
 	readonly
 	bindings    expression.Bindings
+	recursive   algebra.RecursiveWiths
 	child       Operator
 	cost        float64
 	cardinality float64
 }
 
-func NewWith(bindings expression.Bindings, child Operator, cost, cardinality float64) *With {
+func NewWith(bindings expression.Bindings, recursive algebra.RecursiveWiths, child Operator,
+	cost, cardinality float64) *With {
 	return &With{
 		bindings:    bindings,
+		recursive:   recursive,
 		child:       child,
 		cost:        cost,
 		cardinality: cardinality,
@@ -45,6 +52,10 @@ func (this *With) Bindings() expression.Bindings {
 	return this.bindings
 }
 
+func (this *With) RecursiveWiths() algebra.RecursiveWiths {
+	return this.recursive
+}
+
 func (this *With) Readonly() bool {
 	return this.child.Readonly()
 }
@@ -68,6 +79,9 @@ func (this *With) MarshalJSON() ([]byte, error) {
 func (this *With) MarshalBase(f func(map[string]interface{})) map[string]interface{} {
 	r := map[string]interface{}{"#operator": "With"}
 	r["bindings"] = this.bindings
+	if len(this.recursive) > 0 {
+		r["recursive"] = this.recursive
+	}
 	if this.cost > 0.0 {
 		r["cost"] = this.cost
 	}
@@ -84,8 +98,13 @@ func (this *With) MarshalBase(f func(map[string]interface{})) map[string]interfa
 
 func (this *With) UnmarshalJSON(body []byte) error {
 	var _unmarshalled struct {
-		_           string          `json:"#operator"`
-		Bindings    json.RawMessage `json:"bindings"`
+		_         string          `json:"#operator"`
+		Bindings  json.RawMessage `json:"bindings"`
+		Recursive []struct {
+			Alias   string          `json:"alias"`
+			Cycle   []string        `json:"cycle"`
+			Options json.RawMessage `json:"options"`
+		} `json:"recursive"`
 		Child       json.RawMessage `json:"~child"`
 		Cost        float64         `json:"cost"`
 		Cardinality float64         `json:"cardinality"`
@@ -101,6 +120,43 @@ func (this *With) UnmarshalJSON(body []byte) error {
 	}
 
 	this.bindings, err = unmarshal.UnmarshalBindings(_unmarshalled.Bindings)
+	if err != nil {
+		return err
+	}
+
+	this.recursive = nil
+	for _, r := range _unmarshalled.Recursive {
+		var binding *expression.Binding
+		for _, b := range this.bindings {
+			if b.Variable() == r.Alias {
+				binding = b
+				break
+			}
+		}
+		if binding == nil {
+			return fmt.Errorf("With: no binding for recursive term %s", r.Alias)
+		}
+
+		var cycle expression.Expressions
+		for _, s := range r.Cycle {
+			expr, err := parser.Parse(s)
+			if err != nil {
+				return err
+			}
+			cycle = append(cycle, expr)
+		}
+
+		var options value.Value
+		if len(r.Options) > 0 {
+			options = value.NewValue(r.Options)
+		}
+		term := algebra.NewRecursiveWith(binding, cycle, options)
+		err = term.FormalizeCycle()
+		if err != nil {
+			return err
+		}
+		this.recursive = append(this.recursive, term)
+	}
 
 	err = json.Unmarshal(_unmarshalled.Child, &child_type)
 	if err != nil {
@@ -656,7 +656,8 @@ func (this *builder) buildHashJoin(node *algebra.AnsiJoin, filter expression.Exp
 	if newOnclause != nil {
 		node.SetOnclause(newOnclause)
 	}
-	return plan.NewHashJoin(node, child, buildExprs, probeExprs, aliases, newFilter, cost, cardinality), nil
+	return plan.NewHashJoin(node, child, buildExprs, probeExprs, aliases, newFilter, cost, cardinality,
+		plan.HASH_SPILL_PARTITIONS), nil
 }
 
 func (this *builder) buildHashNest(node *algebra.AnsiNest, filter expression.Expression, selec float64) (hnest *plan.HashNest, err error) {
@@ -676,7 +677,8 @@ func (this *builder) buildHashNest(node *algebra.AnsiNest, filter expression.Exp
 	if newOnclause != nil {
 		node.SetOnclause(newOnclause)
 	}
-	return plan.NewHashNest(node, child, buildExprs, probeExprs, aliases[0], newFilter, cost, cardinality), nil
+	return plan.NewHashNest(node, child, buildExprs, probeExprs, aliases[0], newFilter, cost, cardinality,
+		plan.HASH_SPILL_PARTITIONS), nil
 }
 
 func (this *builder) buildHashJoinScan(right algebra.SimpleFromTerm, outer bool,
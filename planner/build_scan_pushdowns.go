@@ -216,14 +216,18 @@ nextagg:
 
 func (this *builder) indexGroupLeadingIndexKeysMatch(entry *indexEntry, indexKeys expression.Expressions) (bool, int) {
 
-	// generate unique group keys.
+	// generate unique group keys, false for time buckets of index keys.
 	groupkeys := make(map[string]bool, len(this.group.By())+1)
 	for _, gexpr := range this.group.By() {
 		// ignore constants
 		if gexpr.Value() != nil {
 			continue
 		}
-		groupkeys[gexpr.String()] = true
+		if key := timeBucketKey(entry, gexpr, indexKeys); key != nil {
+			groupkeys[key.String()] = groupkeys[key.String()]
+		} else {
+			groupkeys[gexpr.String()] = true
+		}
 	}
 
 	// For Partition index the partition keys needs to be in group keys to use DIstinct aggregates
@@ -236,8 +240,15 @@ func (this *builder) indexGroupLeadingIndexKeysMatch(entry *indexEntry, indexKey
 	// Check group keys matching leading keys. If equality predicate that index key can be skipped in group
 	nMatched := 0
 	nGroupMatched := 0
+	bucketed := false
 	for nMatched < len(indexKeys) {
-		if _, ok := groupkeys[indexKeys[nMatched].String()]; ok {
+		if exact, ok := groupkeys[indexKeys[nMatched].String()]; ok {
+			// the next index key is not sorted within a time bucket
+			if bucketed {
+				return false, 0
+			}
+			bucketed = !exact
+
 			// index key matched with group key, check duplicate index keys
 			duplicate := false
 			for k := 0; !duplicate && k <= nMatched-1; k++ {
@@ -258,10 +269,64 @@ func (this *builder) indexGroupLeadingIndexKeysMatch(entry *indexEntry, indexKey
 		}
 	}
 
+	// distinct aggregates are limited to the matched keys
+	if bucketed {
+		nMatched--
+	}
+
 	// Check all group keys matched with leading index keys
 	return (nGroupMatched == len(groupkeys)), nMatched
 }
 
+/*
+A time bucket of an index key, DATE_BIN(stride, key [, origin [, tz ]]),
+groups like the key itself, as long as the buckets follow the index order
+of the key. This holds for UNIX milliseconds, so the spans on the key must
+only let numbers through. Returns the index key, or nil if gexpr is not
+such a time bucket. Partitioned indexes are left out, as a bucket can
+span several partitions.
+*/
+func timeBucketKey(entry *indexEntry, gexpr expression.Expression, indexKeys expression.Expressions) expression.Expression {
+	bin, ok := gexpr.(*expression.DateBin)
+	if !ok || len(entry.partitionKeys) > 0 {
+		return nil
+	}
+
+	operands := bin.Operands()
+	for i, op := range operands {
+		if i != 1 && op.Value() == nil {
+			return nil
+		}
+	}
+
+	termSpans, ok := entry.spans.(*TermSpans)
+	if !ok {
+		return nil
+	}
+
+	for pos, key := range indexKeys {
+		if !operands[1].EquivalentTo(key) {
+			continue
+		}
+		for _, span := range termSpans.Spans() {
+			if pos >= len(span.Ranges) || !numericBound(span.Ranges[pos].Low) ||
+				!numericBound(span.Ranges[pos].High) {
+				return nil
+			}
+		}
+		return key
+	}
+	return nil
+}
+
+func numericBound(bound expression.Expression) bool {
+	if bound == nil {
+		return false
+	}
+	val := bound.Value()
+	return val != nil && val.Type() == value.NUMBER
+}
+
 func (this *builder) checkExactSpans(entry *indexEntry, pred expression.Expression, alias string,
 	unnestFiletrs expression.Expressions) bool {
 	// spans are not exact
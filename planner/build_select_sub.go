@@ -315,7 +315,7 @@ func (this *builder) VisitSubselect(node *algebra.Subselect) (interface{}, error
 		if this.useCBO {
 			cost, cardinality = getWithCost(rv, node.With())
 		}
-		rv = plan.NewWith(node.With(), rv, cost, cardinality)
+		rv = plan.NewWith(node.With(), node.RecursiveWiths(), rv, cost, cardinality)
 		this.children = make([]plan.Operator, 0, 1)
 		this.addChildren(rv)
 	}
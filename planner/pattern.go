@@ -17,6 +17,7 @@ import (
 	"github.com/couchbase/query/expression"
 	base "github.com/couchbase/query/plannerbase"
 	"github.com/couchbase/query/util"
+	"github.com/couchbase/query/value"
 )
 
 func (this *builder) PatternFor(baseKeyspace *base.BaseKeyspace, indexes []datastore.Index,
@@ -28,9 +29,10 @@ func (this *builder) PatternFor(baseKeyspace *base.BaseKeyspace, indexes []datas
 	defer _PATTERN_INDEX_POOL.Put(suffixes)
 	tokens := _PATTERN_INDEX_POOL.Get()
 	defer _PATTERN_INDEX_POOL.Put(tokens)
+	geohashes := make(map[string]expression.Expressions)
 
-	collectPatternIndexes(pred, indexes, formalizer, suffixes, tokens)
-	if len(suffixes) == 0 && len(tokens) == 0 {
+	collectPatternIndexes(pred, indexes, formalizer, suffixes, tokens, geohashes)
+	if len(suffixes) == 0 && len(tokens) == 0 && len(geohashes) == 0 {
 		return nil
 	}
 
@@ -42,7 +44,7 @@ func (this *builder) PatternFor(baseKeyspace *base.BaseKeyspace, indexes []datas
 		return err
 	}
 
-	pat := newPattern(suffixes, tokens)
+	pat := newPattern(suffixes, tokens, geohashes)
 	rv, err := pred.Accept(pat)
 	if err != nil {
 		return err
@@ -74,14 +76,16 @@ func (this *builder) PatternFor(baseKeyspace *base.BaseKeyspace, indexes []datas
 type pattern struct {
 	expression.MapperBase
 
-	suffixes map[string]string
-	tokens   map[string]string
+	suffixes  map[string]string
+	tokens    map[string]string
+	geohashes map[string]expression.Expressions
 }
 
-func newPattern(suffixes, tokens map[string]string) *pattern {
+func newPattern(suffixes, tokens map[string]string, geohashes map[string]expression.Expressions) *pattern {
 	rv := &pattern{
-		suffixes: suffixes,
-		tokens:   tokens,
+		suffixes:  suffixes,
+		tokens:    tokens,
+		geohashes: geohashes,
 	}
 
 	rv.SetMapper(rv)
@@ -117,6 +121,8 @@ func (this *pattern) VisitFunction(expr expression.Function) (interface{}, error
 		return this.visitRegexpContains(expr)
 	case *expression.RegexpLike:
 		return this.visitRegexpLike(expr)
+	case *expression.StWithin, *expression.StContains, *expression.StDWithin:
+		return this.visitGeo(expr)
 	default:
 		return expr, nil
 	}
@@ -230,8 +236,50 @@ func (this *pattern) visitRegexpLike(expr *expression.RegexpLike) (interface{},
 	return expression.NewAnd(expr, any), nil
 }
 
+/*
+Geospatial predicates against a constant region also restrict the
+geohash of their operand to the cells covering the region, so that
+indexes on the geohash can be scanned.
+*/
+func (this *pattern) visitGeo(expr expression.Function) (interface{}, error) {
+	source, bbox, ok := expression.GeoRegion(expr)
+	if !ok {
+		return expr, nil
+	}
+	precision, ok := this.geohashes[source.String()]
+	if !ok {
+		return expr, nil
+	}
+
+	p := expression.GEOHASH_PRECISION
+	if len(precision) > 0 {
+		v := precision[0].Value()
+		if v == nil || v.Type() != value.NUMBER {
+			return expr, nil
+		}
+		p = int(value.AsNumberValue(v).Int64())
+	}
+
+	cells := expression.GeohashCover(bbox, p)
+	if len(cells) == 0 {
+		return expr, nil
+	}
+
+	operands := append(expression.Expressions{source}, precision...)
+	geohash := expression.NewGeohashEncode(operands...)
+	terms := make(expression.Expressions, len(cells))
+	for i, cell := range cells {
+		terms[i] = expression.NewLike(geohash.Copy(), expression.NewConstant(cell+"%"))
+	}
+	if len(terms) == 1 {
+		return expression.NewAnd(expr, terms[0]), nil
+	}
+	return expression.NewAnd(expr, expression.NewOr(terms...)), nil
+}
+
 func collectPatternIndexes(pred expression.Expression, indexes []datastore.Index,
-	formalizer *expression.Formalizer, suffixes, tokens map[string]string) {
+	formalizer *expression.Formalizer, suffixes, tokens map[string]string,
+	geohashes map[string]expression.Expressions) {
 
 	var err error
 outer:
@@ -257,6 +305,18 @@ outer:
 		}
 
 		for _, key := range index.RangeKey() {
+			if geohash, ok := key.(*expression.GeohashEncode); ok {
+				operands := geohash.Operands()
+				op := operands[0].Copy()
+				op, err = formalizer.Map(op)
+				if err != nil {
+					continue outer
+				}
+
+				geohashes[op.String()] = operands[1:]
+				continue
+			}
+
 			if all, ok := key.(*expression.All); ok {
 				sufVar := _DEFAULT_SUFFIXES_VARIABLE
 				suf, _ := all.Array().(*expression.Suffixes)
@@ -0,0 +1,356 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package prepareds
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	json "github.com/couchbase/go_json"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/logging"
+	"github.com/couchbase/query/plan"
+)
+
+/*
+Plan baselines keep the plan of a prepared statement stable.
+Once a plan is pinned, any different plan produced for the same statement,
+be it by a reprepare following a metadata change, by a new PREPARE, or by a
+plan received from another node, is recorded as a candidate, and the pinned
+plan stays in use for as long as it can still run.
+Admins can compare candidates to the baseline, and accept or reject them.
+Baselines are local to the node, like the cache itself.
+*/
+type Baseline struct {
+	Prepared   *plan.Prepared
+	Pinned     time.Time
+	Candidates []*PlanCandidate
+	operator   []byte
+	lastId     int
+}
+
+type PlanCandidate struct {
+	Id       int
+	Prepared *plan.Prepared
+	Captured time.Time
+	operator []byte
+}
+
+// older candidates are discarded past this
+const _MAX_CANDIDATES = 8
+
+type planBaselines struct {
+	sync.Mutex
+	baselines map[string]*Baseline
+}
+
+var baselines = &planBaselines{baselines: make(map[string]*Baseline)}
+
+/*
+Pin the plan currently cached for a statement.
+Any existing baseline and its candidates are replaced.
+*/
+func PinPrepared(name string) errors.Error {
+	var prepared *plan.Prepared
+
+	PreparedDo(name, func(ce *CacheEntry) {
+		prepared = ce.Prepared
+	})
+	if prepared == nil {
+		return errors.NewNoSuchPreparedError(name)
+	}
+	operator, err := json.Marshal(prepared.Operator)
+	if err != nil {
+		return errors.NewPlanError(err, "")
+	}
+	baselines.pin(name, prepared, operator)
+	return nil
+}
+
+/*
+Drop the baseline of a statement, which will be replanned as usual.
+*/
+func UnpinPrepared(name string) errors.Error {
+	if !baselines.drop(name) {
+		return errors.NewNoSuchBaselineError(name)
+	}
+	return nil
+}
+
+/*
+Make a candidate the new baseline, and use it straight away.
+*/
+func AcceptPlanCandidate(name string, id string) errors.Error {
+	baselines.Lock()
+	baseline, candidate, err := baselines.candidate(name, id)
+	if err == nil {
+		baseline.removeCandidate(candidate)
+		baseline.Prepared = candidate.Prepared
+		baseline.operator = candidate.operator
+		baseline.Pinned = time.Now()
+	}
+	baselines.Unlock()
+	if err != nil {
+		return err
+	}
+
+	prepareds.add(candidate.Prepared, false, false, nil)
+	return nil
+}
+
+/*
+Discard a candidate.
+*/
+func RejectPlanCandidate(name string, id string) errors.Error {
+	baselines.Lock()
+	defer baselines.Unlock()
+
+	baseline, candidate, err := baselines.candidate(name, id)
+	if err != nil {
+		return err
+	}
+	baseline.removeCandidate(candidate)
+	return nil
+}
+
+/*
+A summary of the baseline of a statement, as shown in system:prepareds,
+or nil if it has none.
+With plans set, the plans themselves are included, and each candidate is
+compared to the baseline.
+*/
+func BaselineInfo(name string, plans bool) (map[string]interface{}, errors.Error) {
+	baselines.Lock()
+	defer baselines.Unlock()
+
+	baseline, ok := baselines.baselines[name]
+	if !ok {
+		return nil, nil
+	}
+
+	var pinnedPlan interface{}
+	rv := map[string]interface{}{
+		"pinned": baseline.Pinned.String(),
+	}
+	if plans {
+		err := json.Unmarshal(baseline.operator, &pinnedPlan)
+		if err != nil {
+			return nil, errors.NewPreparedDecodingError(err)
+		}
+		rv["plan"] = pinnedPlan
+	}
+	candidates := make([]interface{}, len(baseline.Candidates))
+	for i, candidate := range baseline.Candidates {
+		c := map[string]interface{}{
+			"id":       candidate.Id,
+			"captured": candidate.Captured.String(),
+		}
+		if plans {
+			var candidatePlan interface{}
+
+			err := json.Unmarshal(candidate.operator, &candidatePlan)
+			if err != nil {
+				return nil, errors.NewPreparedDecodingError(err)
+			}
+			c["plan"] = candidatePlan
+			c["diff"] = diffPlans("", pinnedPlan, candidatePlan, []interface{}{})
+		}
+		candidates[i] = c
+	}
+	rv["candidates"] = candidates
+	return rv, nil
+}
+
+func (this *planBaselines) pin(name string, prepared *plan.Prepared, operator []byte) {
+	this.Lock()
+	this.baselines[name] = &Baseline{Prepared: prepared, Pinned: time.Now(), operator: operator}
+	this.Unlock()
+	persistChanged()
+}
+
+func (this *planBaselines) drop(name string) bool {
+	this.Lock()
+	_, ok := this.baselines[name]
+	delete(this.baselines, name)
+	this.Unlock()
+	if ok {
+		persistChanged()
+	}
+	return ok
+}
+
+// the pinned plan, in the form kept by the persisted cache
+func (this *planBaselines) encodedPlan(name string) string {
+	this.Lock()
+	defer this.Unlock()
+
+	baseline, ok := this.baselines[name]
+	if !ok {
+		return ""
+	}
+	return baseline.Prepared.EncodedPlan()
+}
+
+// called with the lock held
+func (this *planBaselines) candidate(name string, id string) (*Baseline, *PlanCandidate, errors.Error) {
+	baseline, ok := this.baselines[name]
+	if !ok {
+		return nil, nil, errors.NewNoSuchBaselineError(name)
+	}
+	n, err := strconv.Atoi(id)
+	if err == nil {
+		for _, candidate := range baseline.Candidates {
+			if candidate.Id == n {
+				return baseline, candidate, nil
+			}
+		}
+	}
+	return nil, nil, errors.NewNoSuchPlanCandidateError(name, id)
+}
+
+/*
+The plan to be cached for a statement: for statements with a baseline,
+this is the pinned plan, unless it can no longer run, and plans that
+differ from it are recorded as candidates.
+*/
+func (this *planBaselines) check(prepared *plan.Prepared) *plan.Prepared {
+	name := encodeName(prepared.Name(), prepared.QueryContext())
+
+	this.Lock()
+	baseline, ok := this.baselines[name]
+	this.Unlock()
+	if !ok || baseline.Prepared == prepared {
+		return prepared
+	}
+
+	operator, err := json.Marshal(prepared.Operator)
+	if err != nil {
+		return prepared
+	}
+
+	this.Lock()
+	baseline, ok = this.baselines[name]
+	if !ok {
+		this.Unlock()
+		return prepared
+	}
+
+	// the statement has been dropped and prepared again with a different text
+	if baseline.Prepared.Text() != prepared.Text() {
+		delete(this.baselines, name)
+		this.Unlock()
+		persistChanged()
+		logging.Infof("Dropping plan baseline for prepared statement <ud>%v</ud>: statement has changed", name)
+		return prepared
+	}
+
+	// same plan, new metadata
+	if bytes.Equal(baseline.operator, operator) {
+		baseline.Prepared = prepared
+		this.Unlock()
+		return prepared
+	}
+	added := baseline.addCandidate(prepared, operator)
+	pinned := baseline.Prepared
+	this.Unlock()
+
+	if pinned.Verify() {
+		return pinned
+	}
+	if added {
+		logging.Infof("Pinned plan for prepared statement <ud>%v</ud> can no longer run: using candidate plan", name)
+	}
+	return prepared
+}
+
+// called with the lock held
+func (this *Baseline) addCandidate(prepared *plan.Prepared, operator []byte) bool {
+	for _, candidate := range this.Candidates {
+		if bytes.Equal(candidate.operator, operator) {
+			candidate.Prepared = prepared
+			return false
+		}
+	}
+	if len(this.Candidates) >= _MAX_CANDIDATES {
+		this.Candidates = this.Candidates[1:]
+	}
+	this.lastId++
+	this.Candidates = append(this.Candidates, &PlanCandidate{
+		Id:       this.lastId,
+		Prepared: prepared,
+		Captured: time.Now(),
+		operator: operator,
+	})
+	return true
+}
+
+// called with the lock held
+func (this *Baseline) removeCandidate(candidate *PlanCandidate) {
+	for i, c := range this.Candidates {
+		if c == candidate {
+			this.Candidates = append(this.Candidates[:i], this.Candidates[i+1:]...)
+			return
+		}
+	}
+}
+
+/*
+The differences between two plans, as a list of paths, each with the value
+found in the baseline and in the candidate.
+*/
+func diffPlans(path string, baseline, candidate interface{}, rv []interface{}) []interface{} {
+	switch b := baseline.(type) {
+	case map[string]interface{}:
+		if c, ok := candidate.(map[string]interface{}); ok {
+			names := make([]string, 0, len(b)+len(c))
+			for n := range b {
+				names = append(names, n)
+			}
+			for n := range c {
+				if _, ok := b[n]; !ok {
+					names = append(names, n)
+				}
+			}
+			sort.Strings(names)
+			for _, n := range names {
+				rv = diffPlans(path+"."+n, b[n], c[n], rv)
+			}
+			return rv
+		}
+	case []interface{}:
+		if c, ok := candidate.([]interface{}); ok {
+			for i := 0; i < len(b) || i < len(c); i++ {
+				var bi, ci interface{}
+
+				if i < len(b) {
+					bi = b[i]
+				}
+				if i < len(c) {
+					ci = c[i]
+				}
+				rv = diffPlans(fmt.Sprintf("%s[%d]", path, i), bi, ci, rv)
+			}
+			return rv
+		}
+	default:
+		if baseline == candidate {
+			return rv
+		}
+	}
+	return append(rv, map[string]interface{}{
+		"path":      path,
+		"baseline":  baseline,
+		"candidate": candidate,
+	})
+}
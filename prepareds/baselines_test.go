@@ -0,0 +1,218 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package prepareds
+
+import (
+	"reflect"
+	"testing"
+
+	json "github.com/couchbase/go_json"
+	"github.com/couchbase/query/algebra"
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/plan"
+)
+
+// a keyspace that can be dropped, to make plans that use it fail verification
+type testKeyspace struct {
+	datastore.Keyspace
+	namespace *testNamespace
+}
+
+func (this *testKeyspace) Id() string                     { return "ks" }
+func (this *testKeyspace) Uid() string                    { return "ks" }
+func (this *testKeyspace) Scope() datastore.Scope         { return nil }
+func (this *testKeyspace) Namespace() datastore.Namespace { return this.namespace }
+
+type testNamespace struct {
+	datastore.Namespace
+	keyspace *testKeyspace
+}
+
+func (this *testNamespace) KeyspaceById(id string) (datastore.Keyspace, errors.Error) {
+	if this.keyspace == nil {
+		return nil, nil
+	}
+	return this.keyspace, nil
+}
+
+func (this *testNamespace) MetadataVersion() uint64 { return 0 }
+func (this *testNamespace) MetadataId() string      { return "default" }
+
+func newTestKeyspace() *testKeyspace {
+	namespace := &testNamespace{}
+	namespace.keyspace = &testKeyspace{namespace: namespace}
+	return namespace.keyspace
+}
+
+func newTestPrepared(text string, operator plan.Operator) *plan.Prepared {
+	prepared := plan.NewPrepared(operator, nil, nil)
+	prepared.SetName("p1")
+	prepared.SetText(text)
+	return prepared
+}
+
+func pinTestPrepared(t *testing.T, prepared *plan.Prepared) *Baseline {
+	baselines.baselines = make(map[string]*Baseline)
+	operator, err := json.Marshal(prepared.Operator)
+	if err != nil {
+		t.Fatalf("cannot marshal plan: %v", err)
+	}
+	baselines.pin(prepared.Name(), prepared, operator)
+	return baselines.baselines[prepared.Name()]
+}
+
+func TestBaselineCheck(t *testing.T) {
+	pinned := newTestPrepared("SELECT 1", plan.NewDummyScan(1, 1))
+	baseline := pinTestPrepared(t, pinned)
+
+	// same plan, new metadata
+	same := newTestPrepared("SELECT 1", plan.NewDummyScan(1, 1))
+	if rv := baselines.check(same); rv != same || baseline.Prepared != same {
+		t.Errorf("expected the same plan to replace the pinned one")
+	}
+	if len(baseline.Candidates) != 0 {
+		t.Errorf("expected no candidates, got %v", len(baseline.Candidates))
+	}
+
+	// a different plan is a candidate, and the pinned plan stays while it verifies
+	different := newTestPrepared("SELECT 1", plan.NewDummyScan(2, 1))
+	if rv := baselines.check(different); rv != same {
+		t.Errorf("expected the pinned plan to be kept")
+	}
+	again := newTestPrepared("SELECT 1", plan.NewDummyScan(2, 1))
+	baselines.check(again)
+	if len(baseline.Candidates) != 1 || baseline.Candidates[0].Id != 1 ||
+		baseline.Candidates[0].Prepared != again {
+		t.Errorf("expected one candidate for the different plan, got %v", baseline.Candidates)
+	}
+
+	// the statement has changed
+	changed := newTestPrepared("SELECT 2", plan.NewDummyScan(1, 1))
+	if rv := baselines.check(changed); rv != changed {
+		t.Errorf("expected the plan of the changed statement")
+	}
+	if _, ok := baselines.baselines["p1"]; ok {
+		t.Errorf("expected the baseline to be dropped")
+	}
+}
+
+func TestBaselineCheckStale(t *testing.T) {
+	keyspace := newTestKeyspace()
+	term := algebra.NewKeyspaceTermFromPath(algebra.NewPathShortOrLong("default", "", "", "ks"), "", nil, nil)
+	pinned := newTestPrepared("SELECT 1", plan.NewFetch(keyspace, term, nil, 0, 0))
+	baseline := pinTestPrepared(t, pinned)
+
+	different := newTestPrepared("SELECT 1", plan.NewDummyScan(1, 1))
+	if rv := baselines.check(different); rv != pinned {
+		t.Errorf("expected the pinned plan to be kept")
+	}
+
+	// the pinned plan can no longer run
+	keyspace.namespace.keyspace = nil
+	different = newTestPrepared("SELECT 1", plan.NewDummyScan(1, 1))
+	if rv := baselines.check(different); rv != different {
+		t.Errorf("expected the candidate plan to be used")
+	}
+	if baseline.Prepared != pinned || len(baseline.Candidates) != 1 {
+		t.Errorf("expected the pinned plan to stay the baseline")
+	}
+}
+
+func TestAcceptPlanCandidate(t *testing.T) {
+	PreparedsInit(16)
+
+	pinned := newTestPrepared("SELECT 1", plan.NewDummyScan(1, 1))
+	baseline := pinTestPrepared(t, pinned)
+	candidate := newTestPrepared("SELECT 1", plan.NewDummyScan(2, 1))
+	baselines.check(candidate)
+
+	if err := AcceptPlanCandidate("p1", "2"); err == nil {
+		t.Errorf("expected an error for an unknown candidate")
+	}
+	if err := AcceptPlanCandidate("p2", "1"); err == nil {
+		t.Errorf("expected an error for an unknown baseline")
+	}
+	if err := AcceptPlanCandidate("p1", "1"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if baseline.Prepared != candidate || len(baseline.Candidates) != 0 {
+		t.Errorf("expected the candidate to become the baseline")
+	}
+
+	var cached *plan.Prepared
+	PreparedDo("p1", func(ce *CacheEntry) {
+		cached = ce.Prepared
+	})
+	if cached != candidate {
+		t.Errorf("expected the candidate to be cached")
+	}
+}
+
+func TestBaselineInfo(t *testing.T) {
+	pinned := newTestPrepared("SELECT 1", plan.NewDummyScan(1, 1))
+	baseline := pinTestPrepared(t, pinned)
+	baselines.check(newTestPrepared("SELECT 1", plan.NewDummyScan(2, 1)))
+
+	info, err := BaselineInfo("p1", true)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	candidates := info["candidates"].([]interface{})
+	if len(candidates) != 1 {
+		t.Fatalf("expected one candidate, got %v", candidates)
+	}
+	diff := candidates[0].(map[string]interface{})["diff"].([]interface{})
+	if len(diff) != 1 || diff[0].(map[string]interface{})["path"] != ".cost" {
+		t.Errorf("expected the cost to differ, got %v", diff)
+	}
+
+	baseline.operator = []byte("{")
+	if _, err = BaselineInfo("p1", true); err == nil {
+		t.Errorf("expected an error for a corrupted plan")
+	}
+	if _, err = BaselineInfo("p1", false); err != nil {
+		t.Errorf("unexpected error %v without plans", err)
+	}
+	if info, _ = BaselineInfo("p2", true); info != nil {
+		t.Errorf("expected no baseline, got %v", info)
+	}
+}
+
+func TestDiffPlans(t *testing.T) {
+	baseline := map[string]interface{}{
+		"#operator": "Sequence",
+		"~children": []interface{}{
+			map[string]interface{}{"#operator": "PrimaryScan3", "index": "#primary"},
+			map[string]interface{}{"#operator": "Fetch"},
+		},
+	}
+	candidate := map[string]interface{}{
+		"#operator": "Sequence",
+		"~children": []interface{}{
+			map[string]interface{}{"#operator": "IndexScan3", "index": "ix1"},
+			map[string]interface{}{"#operator": "Fetch"},
+			map[string]interface{}{"#operator": "Filter"},
+		},
+	}
+
+	expected := []interface{}{
+		map[string]interface{}{"path": ".~children[0].#operator", "baseline": "PrimaryScan3", "candidate": "IndexScan3"},
+		map[string]interface{}{"path": ".~children[0].index", "baseline": "#primary", "candidate": "ix1"},
+		map[string]interface{}{"path": ".~children[2]", "baseline": nil,
+			"candidate": map[string]interface{}{"#operator": "Filter"}},
+	}
+	if diff := diffPlans("", baseline, candidate, []interface{}{}); !reflect.DeepEqual(diff, expected) {
+		t.Errorf("expected %v, got %v", expected, diff)
+	}
+	if diff := diffPlans("", baseline, baseline, []interface{}{}); len(diff) != 0 {
+		t.Errorf("expected no differences, got %v", diff)
+	}
+}
@@ -0,0 +1,314 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package prepareds
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	atomic "github.com/couchbase/go-couchbase/platform"
+	json "github.com/couchbase/go_json"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/logging"
+	"github.com/couchbase/query/plan"
+	"github.com/couchbase/query/util"
+)
+
+/*
+A snapshot of the prepared statement cache, as persisted across restarts
+and as exported and imported through the admin API.
+Encoded plans are only trusted if they were produced by the same engine
+version: otherwise statements are prepared again from their text.
+Either way, plans are verified against the current metadata and
+reprepared if indexes or keyspaces have changed.
+*/
+type Snapshot struct {
+	Version   string          `json:"version"`
+	Prepareds []SnapshotEntry `json:"prepareds"`
+}
+
+type SnapshotEntry struct {
+	Name            string `json:"name"`
+	QueryContext    string `json:"queryContext,omitempty"`
+	Namespace       string `json:"namespace,omitempty"`
+	Text            string `json:"statement"`
+	Type            string `json:"type,omitempty"`
+	IndexApiVersion int    `json:"indexApiVersion"`
+	FeatureControls uint64 `json:"featureControls"`
+	UseFts          bool   `json:"useFts,omitempty"`
+	UseCBO          bool   `json:"useCBO,omitempty"`
+	EncodedPlan     string `json:"encoded_plan,omitempty"`
+	PinnedPlan      string `json:"pinned_plan,omitempty"`
+}
+
+const _PERSIST_FILE = "prepareds.json"
+const _PERSIST_INTERVAL = 10 * time.Second
+
+var persistDir string
+var persistDirty uint32
+var persistStop chan bool
+var persistDone chan bool
+
+/*
+Take a snapshot of the cache, predefined statements excluded.
+*/
+func ExportPrepareds() *Snapshot {
+	rv := &Snapshot{Version: util.VERSION, Prepareds: make([]SnapshotEntry, 0, CountPrepareds())}
+	PreparedsForeach(func(name string, ce *CacheEntry) bool {
+		p := ce.Prepared
+		if !prepareds.IsPredefinedPrepareName(p.Name()) {
+			rv.Prepareds = append(rv.Prepareds, SnapshotEntry{
+				Name:            p.Name(),
+				QueryContext:    p.QueryContext(),
+				Namespace:       p.Namespace(),
+				Text:            p.Text(),
+				Type:            p.Type(),
+				IndexApiVersion: p.IndexApiVersion(),
+				FeatureControls: p.FeatureControls(),
+				UseFts:          p.UseFts(),
+				UseCBO:          p.UseCBO(),
+				EncodedPlan:     p.EncodedPlan(),
+				PinnedPlan:      baselines.encodedPlan(name),
+			})
+		}
+		return true
+	}, nil)
+	return rv
+}
+
+/*
+Load the statements in a snapshot into the cache.
+Statements that already exist with a different text are not replaced.
+Returns the number of statements loaded, and the errors for those that weren't.
+*/
+func ImportPrepareds(snapshot *Snapshot) (int, []errors.Error) {
+	var errs []errors.Error
+
+	count := 0
+	for i := range snapshot.Prepareds {
+		var err errors.Error
+
+		entry := &snapshot.Prepareds[i]
+
+		if prepareds.IsPredefinedPrepareName(entry.Name) {
+			continue
+		}
+
+		// pinned plans only survive if they can be decoded
+		if snapshot.Version == util.VERSION && entry.PinnedPlan != "" {
+			err = importPinned(entry)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		// plans from the same engine can be decoded, verified, and reprepared if stale
+		if snapshot.Version == util.VERSION && entry.EncodedPlan != "" {
+			_, err = DecodePreparedWithContext(entry.Name, entry.QueryContext, entry.EncodedPlan, false, nil)
+			if err == nil {
+				count++
+				continue
+			}
+		}
+
+		// everything else is prepared again
+		err = importFromText(entry)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			count++
+		}
+	}
+	return count, errs
+}
+
+func importFromText(entry *SnapshotEntry) errors.Error {
+	prepared := plan.NewPrepared(nil, nil, nil)
+	prepared.SetName(entry.Name)
+	prepared.SetText(entry.Text)
+	prepared.SetType(entry.Type)
+	prepared.SetNamespace(entry.Namespace)
+	prepared.SetQueryContext(entry.QueryContext)
+	prepared.SetIndexApiVersion(entry.IndexApiVersion)
+	prepared.SetFeatureControls(entry.FeatureControls)
+	prepared.SetUseFts(entry.UseFts)
+	prepared.SetUseCBO(entry.UseCBO)
+
+	prepared, err := reprepare(prepared, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	added := true
+	prepareds.add(prepared, false, false, func(ce *CacheEntry) bool {
+		added = ce.Prepared.Text() == prepared.Text()
+		return added
+	})
+	if !added {
+		return errors.NewPreparedNameError(
+			fmt.Sprintf("duplicate name: %s", encodeName(entry.Name, entry.QueryContext)))
+	}
+	return nil
+}
+
+// the baseline is set before the statement is cached, so that the pinned plan is used
+func importPinned(entry *SnapshotEntry) errors.Error {
+	pinned, err := decodePrepared(entry.PinnedPlan, nil)
+	if err != nil {
+		return err
+	}
+	fullName := encodeName(entry.Name, entry.QueryContext)
+	if encodeName(pinned.Name(), pinned.QueryContext()) != fullName || pinned.Text() != entry.Text {
+		return errors.NewEncodingNameMismatchError(fullName, encodeName(pinned.Name(), pinned.QueryContext()))
+	}
+	operator, err1 := json.Marshal(pinned.Operator)
+	if err1 != nil {
+		return errors.NewPreparedDecodingError(err1)
+	}
+	baselines.pin(fullName, pinned, operator)
+	return nil
+}
+
+/*
+Persist the cache to a local directory, so that it survives restarts.
+The statements already persisted there are loaded, and the cache is
+saved in the background whenever it changes.
+*/
+func PreparedsPersistInit(dir string) {
+	if dir == "" {
+		return
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		logging.Errorp("Cannot create prepared statements directory", logging.Pair{"dir", dir},
+			logging.Pair{"error", err})
+		return
+	}
+	persistDir = dir
+
+	fileName := filepath.Join(dir, _PERSIST_FILE)
+	bytes, err := ioutil.ReadFile(fileName)
+	if err == nil {
+		var snapshot Snapshot
+
+		err = json.Unmarshal(bytes, &snapshot)
+		if err != nil {
+			logging.Errorp("Cannot decode persisted prepared statements", logging.Pair{"file", fileName},
+				logging.Pair{"error", err})
+		} else {
+			count, errs := ImportPrepareds(&snapshot)
+			logging.Infop("Loaded persisted prepared statements", logging.Pair{"loaded", count},
+				logging.Pair{"failed", len(errs)})
+			for _, err := range errs {
+				logging.Infof("Cannot load prepared statement: %v", err)
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		logging.Errorp("Cannot read persisted prepared statements", logging.Pair{"file", fileName},
+			logging.Pair{"error", err})
+	}
+
+	persistStop = make(chan bool)
+	persistDone = make(chan bool)
+	go persister(persistStop, persistDone)
+}
+
+func persister(stop, done chan bool) {
+	ticker := time.NewTicker(_PERSIST_INTERVAL)
+	defer func() {
+		ticker.Stop()
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-ticker.C:
+			if atomic.CompareAndSwapUint32(&persistDirty, 1, 0) {
+				PreparedsPersist()
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+/*
+Stop saving the cache in the background, and save it one last time,
+on shutdown.
+*/
+func PreparedsPersistStop() {
+	if persistStop == nil {
+		return
+	}
+	close(persistStop)
+	<-persistDone
+	persistStop = nil
+	PreparedsPersist()
+}
+
+/*
+Save the cache, if persistence is enabled.
+*/
+func PreparedsPersist() {
+	if persistDir == "" {
+		return
+	}
+
+	bytes, err := json.Marshal(ExportPrepareds())
+	if err == nil {
+		var file *os.File
+
+		// write a new file and move it into place, so that a crash never leaves a partial cache
+		file, err = ioutil.TempFile(persistDir, _PERSIST_FILE+"-")
+		if err == nil {
+			_, err = file.Write(bytes)
+			if err == nil {
+				err = file.Sync()
+			}
+			if err1 := file.Close(); err == nil {
+				err = err1
+			}
+			if err == nil {
+				err = os.Rename(file.Name(), filepath.Join(persistDir, _PERSIST_FILE))
+			}
+			if err != nil {
+				os.Remove(file.Name())
+			} else {
+
+				// the rename itself only survives a crash once the directory is synced
+				err = syncDir(persistDir)
+			}
+		}
+	}
+	if err != nil {
+		logging.Errorp("Cannot persist prepared statements", logging.Pair{"dir", persistDir},
+			logging.Pair{"error", err})
+	}
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if err1 := d.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+func persistChanged() {
+	if persistDir != "" {
+		atomic.StoreUint32(&persistDirty, 1)
+	}
+}
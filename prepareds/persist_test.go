@@ -0,0 +1,96 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package prepareds
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	json "github.com/couchbase/go_json"
+	"github.com/couchbase/query/plan"
+)
+
+func resetPrepareds() {
+	PreparedsInit(16)
+	baselines.baselines = make(map[string]*Baseline)
+}
+
+func TestPersistRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prepareds")
+	if err != nil {
+		t.Fatalf("cannot create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		persistDir = ""
+	}()
+
+	resetPrepareds()
+	prepared := newTestPrepared("SELECT 1", plan.NewDummyScan(1, 1))
+	bytes, err := json.Marshal(prepared)
+	if err != nil {
+		t.Fatalf("cannot marshal prepared: %v", err)
+	}
+	prepared.BuildEncodedPlan(bytes)
+	prepareds.add(prepared, false, false, nil)
+	if err := PinPrepared("p1"); err != nil {
+		t.Fatalf("cannot pin prepared: %v", err)
+	}
+
+	// persist
+	PreparedsPersistInit(dir)
+	PreparedsPersistStop()
+	if _, err := os.Stat(filepath.Join(dir, _PERSIST_FILE)); err != nil {
+		t.Fatalf("expected the cache to be persisted: %v", err)
+	}
+
+	// restore
+	resetPrepareds()
+	PreparedsPersistInit(dir)
+	PreparedsPersistStop()
+	checkRestored(t, "restore")
+
+	// export and import
+	snapshot := ExportPrepareds()
+	if len(snapshot.Prepareds) != 1 {
+		t.Fatalf("expected one exported statement, got %v", snapshot.Prepareds)
+	}
+	entry := snapshot.Prepareds[0]
+	if entry.Name != "p1" || entry.Text != "SELECT 1" || entry.EncodedPlan != prepared.EncodedPlan() ||
+		entry.PinnedPlan == "" {
+		t.Errorf("unexpected exported statement %v", entry)
+	}
+
+	resetPrepareds()
+	count, errs := ImportPrepareds(snapshot)
+	if count != 1 || len(errs) != 0 {
+		t.Fatalf("expected one imported statement, got %v, errors %v", count, errs)
+	}
+	checkRestored(t, "import")
+}
+
+func checkRestored(t *testing.T, what string) {
+	var restored *plan.Prepared
+
+	PreparedDo("p1", func(ce *CacheEntry) {
+		restored = ce.Prepared
+	})
+	if restored == nil || restored.Text() != "SELECT 1" {
+		t.Fatalf("%s: expected the statement to be cached, got %v", what, restored)
+	}
+	if _, ok := restored.Operator.(*plan.DummyScan); !ok {
+		t.Errorf("%s: unexpected plan %v", what, restored.Operator)
+	}
+	if baseline, _ := BaselineInfo("p1", false); baseline == nil {
+		t.Errorf("%s: expected the pinned plan to be restored", what)
+	}
+}
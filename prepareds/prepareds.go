@@ -273,7 +273,11 @@ func (this *preparedCache) get(fullName string, track bool) *CacheEntry {
 	return nil
 }
 
-func (this *preparedCache) add(prepared *plan.Prepared, populated bool, track bool, process func(*CacheEntry) bool) {
+func (this *preparedCache) add(prepared *plan.Prepared, populated bool, track bool,
+	process func(*CacheEntry) bool) *plan.Prepared {
+
+	// statements with a baseline stick to the pinned plan
+	prepared = baselines.check(prepared)
 
 	// prepare a new entry, if statement does not exist
 	ce := &CacheEntry{
@@ -310,6 +314,8 @@ func (this *preparedCache) add(prepared *plan.Prepared, populated bool, track bo
 		}
 		return op
 	})
+	persistChanged()
+	return prepared
 }
 
 // Auto Prepare
@@ -430,9 +436,15 @@ func PreparedDo(name string, f func(*CacheEntry)) {
 }
 
 func AddPrepared(prepared *plan.Prepared) errors.Error {
+	_, err := addPrepared(prepared)
+	return err
+}
+
+// returns the plan actually cached, which might be a pinned one
+func addPrepared(prepared *plan.Prepared) (*plan.Prepared, errors.Error) {
 	added := true
 
-	prepareds.add(prepared, false, false, func(ce *CacheEntry) bool {
+	prepared = prepareds.add(prepared, false, false, func(ce *CacheEntry) bool {
 		if ce.Prepared.Text() != prepared.Text() {
 			added = false
 		}
@@ -440,16 +452,18 @@ func AddPrepared(prepared *plan.Prepared) errors.Error {
 	})
 	fullName := encodeName(prepared.Name(), prepared.QueryContext())
 	if !added {
-		return errors.NewPreparedNameError(
+		return nil, errors.NewPreparedNameError(
 			fmt.Sprintf("duplicate name: %s", fullName))
 	} else {
 		distributePrepared(fullName, prepared.EncodedPlan())
-		return nil
+		return prepared, nil
 	}
 }
 
 func DeletePrepared(name string) errors.Error {
 	if prepareds.cache.Delete(name, nil) {
+		baselines.drop(name)
+		persistChanged()
 		return nil
 	}
 	return errors.NewNoSuchPreparedError(name)
@@ -562,7 +576,7 @@ func (prepareds *preparedCache) getPrepared(preparedName string, queryContext st
 		if !good && !metaCheck {
 			prepared, err = reprepare(prepared, nil, phaseTime)
 			if err == nil {
-				err = AddPrepared(prepared)
+				prepared, err = addPrepared(prepared)
 			}
 		}
 	}
@@ -608,27 +622,11 @@ func DecodePrepared(prepared_name string, prepared_stmt string) (*plan.Prepared,
 func DecodePreparedWithContext(prepared_name string, queryContext string, prepared_stmt string, track bool, phaseTime *time.Duration) (*plan.Prepared, errors.Error) {
 	added := true
 
-	decoded, err := base64.StdEncoding.DecodeString(prepared_stmt)
-	if err != nil {
-		return nil, errors.NewPreparedDecodingError(err)
-	}
-	var buf bytes.Buffer
-	buf.Write(decoded)
-	reader, err := gzip.NewReader(&buf)
+	prepared, err := decodePrepared(prepared_stmt, phaseTime)
 	if err != nil {
-		return nil, errors.NewPreparedDecodingError(err)
-	}
-	prepared_bytes, err := ioutil.ReadAll(reader)
-	if err != nil {
-		return nil, errors.NewPreparedDecodingError(err)
-	}
-	prepared, err := unmarshalPrepared(prepared_bytes, phaseTime)
-	if err != nil {
-		return nil, errors.NewPreparedDecodingError(err)
+		return nil, err
 	}
 
-	prepared.SetEncodedPlan(prepared_stmt)
-
 	// MB-19509 we now have to check that the encoded plan matches
 	// the prepared statement named in the rest API
 	_, prepared_key := distributed.RemoteAccess().SplitKey(prepared_name)
@@ -662,7 +660,7 @@ func DecodePreparedWithContext(prepared_name string, queryContext string, prepar
 		}
 	}
 
-	prepareds.add(prepared, good, track,
+	prepared = prepareds.add(prepared, good, track,
 		func(oldEntry *CacheEntry) bool {
 
 			// MB-19509: if the entry exists already, the new plan must
@@ -687,6 +685,30 @@ func DecodePreparedWithContext(prepared_name string, queryContext string, prepar
 	}
 }
 
+func decodePrepared(prepared_stmt string, phaseTime *time.Duration) (*plan.Prepared, errors.Error) {
+	decoded, err := base64.StdEncoding.DecodeString(prepared_stmt)
+	if err != nil {
+		return nil, errors.NewPreparedDecodingError(err)
+	}
+	var buf bytes.Buffer
+	buf.Write(decoded)
+	reader, err := gzip.NewReader(&buf)
+	if err != nil {
+		return nil, errors.NewPreparedDecodingError(err)
+	}
+	prepared_bytes, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, errors.NewPreparedDecodingError(err)
+	}
+	prepared, err := unmarshalPrepared(prepared_bytes, phaseTime)
+	if err != nil {
+		return nil, errors.NewPreparedDecodingError(err)
+	}
+
+	prepared.SetEncodedPlan(prepared_stmt)
+	return prepared, nil
+}
+
 func unmarshalPrepared(bytes []byte, phaseTime *time.Duration) (*plan.Prepared, errors.Error) {
 	prepared := plan.NewPrepared(nil, nil, nil)
 	err := prepared.UnmarshalJSON(bytes)
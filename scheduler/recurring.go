@@ -0,0 +1,282 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package scheduler
+
+// Recurring tasks stay in the scheduled cache between runs: each run is
+// recorded in the task history, and the task is then rescheduled for the
+// next time its schedule fires, or, if the run failed and retries are left,
+// after the retry delay.
+
+import (
+	"time"
+
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/util"
+)
+
+// runs kept in the history of a recurring task
+const _HISTORY = 10
+
+type TaskRun struct {
+	StartTime time.Time
+	EndTime   time.Time
+	Attempt   int
+	Results   interface{}
+	Errors    []errors.Error
+}
+
+func (this *TaskRun) Object() map[string]interface{} {
+	rv := map[string]interface{}{
+		"startTime": this.StartTime.String(),
+		"stopTime":  this.EndTime.String(),
+		"attempt":   this.Attempt,
+	}
+	if this.Results != nil {
+		rv["results"] = this.Results
+	}
+	if len(this.Errors) > 0 {
+		errs := make([]interface{}, 0, len(this.Errors))
+		for _, err := range this.Errors {
+			if err != nil {
+				errs = append(errs, err.Object())
+			}
+		}
+		rv["errors"] = errs
+	}
+	return rv
+}
+
+func (this *TaskEntry) Recurring() bool {
+	return this.schedule != nil
+}
+
+func ScheduleRecurringTask(name, class, subClass, schedule string, retries int, retryDelay time.Duration,
+	exec, stop TaskFunc, parms interface{}, context Context) errors.Error {
+
+	sched, err := ParseSchedule(schedule)
+	if err != nil {
+		return errors.NewInvalidScheduleError(schedule, err)
+	}
+	now := time.Now()
+	next := sched.Next(now)
+	if next.IsZero() {
+		return errors.NewInvalidScheduleError(schedule, nil)
+	}
+
+	id, rerr := TaskId(name, class, subClass)
+	if rerr != nil {
+		return rerr
+	}
+
+	task := &TaskEntry{
+		Name:       name,
+		Class:      class,
+		SubClass:   subClass,
+		Delay:      next.Sub(now),
+		PostTime:   now,
+		Exec:       exec,
+		Stop:       stop,
+		State:      SCHEDULED,
+		Id:         id,
+		Schedule:   sched.String(),
+		NextRun:    next,
+		Retries:    retries,
+		RetryDelay: retryDelay,
+		parameters: parms,
+		context:    context,
+		schedule:   sched,
+	}
+
+	// lose any old completed run, so to preserve key uniqueness
+	scheduler.completed.Delete(id, nil)
+
+	added := true
+	scheduler.scheduled.Add(task, id, func(ce interface{}) util.Operation {
+		added = false
+		return util.IGNORE
+	})
+	if !added {
+		return errors.NewDuplicateTaskError(task.Id)
+	}
+
+	scheduler.scheduled.Use(id, func(ce interface{}) {
+		task.arm(now)
+	})
+	return nil
+}
+
+// the id of the task of a given name and class, as used in system:tasks_cache
+func TaskId(name, class, subClass string) (string, errors.Error) {
+	id, err := util.UUIDV5(class+subClass, name)
+	if err != nil {
+		return "", errors.NewSchedulerError("uuid", err)
+	}
+	return id, nil
+}
+
+func PauseTask(id string) errors.Error {
+	var err errors.Error
+
+	task := scheduler.scheduled.Use(id, func(ce interface{}) {
+		task := ce.(*TaskEntry)
+		if task.schedule == nil {
+			err = errors.NewTaskNotRecurringError(id)
+			return
+		}
+		switch task.State {
+		case SCHEDULED:
+			task.timer.Stop()
+			task.State = PAUSED
+		case RUNNING:
+			task.pause = true
+		}
+	})
+	if task == nil {
+		return errors.NewTaskNotFoundError(id)
+	}
+	return err
+}
+
+func ResumeTask(id string) errors.Error {
+	var err errors.Error
+
+	task := scheduler.scheduled.Use(id, func(ce interface{}) {
+		task := ce.(*TaskEntry)
+		if task.schedule == nil {
+			err = errors.NewTaskNotRecurringError(id)
+			return
+		}
+		switch task.State {
+		case PAUSED:
+			task.attempt = 0
+			task.NextRun = task.schedule.Next(time.Now())
+			task.State = SCHEDULED
+			task.arm(time.Now())
+		case RUNNING:
+			task.pause = false
+		}
+	})
+	if task == nil {
+		return errors.NewTaskNotFoundError(id)
+	}
+	return err
+}
+
+// set the timer for the next run, with the task locked
+func (this *TaskEntry) arm(now time.Time) {
+	this.armed++
+	armed := this.armed
+	this.timer = time.AfterFunc(this.NextRun.Sub(now), func() {
+		this.run(armed)
+	})
+}
+
+func (this *TaskEntry) run(armed int64) {
+
+	// first, lock, check and mark as running
+	// a timer that was stopped and replaced while firing must not run the task
+	bailOut := false
+	scheduler.scheduled.Use(this.Id, func(ce interface{}) {
+		if this.State != SCHEDULED || this.armed != armed {
+			bailOut = true
+			return
+		}
+		this.State = RUNNING
+		this.StartTime = time.Now()
+	})
+	if bailOut {
+		return
+	}
+
+	run := &TaskRun{
+		StartTime: this.StartTime,
+		Attempt:   this.attempt,
+	}
+	run.Results, run.Errors = this.Exec(this.context, this.parameters)
+	run.EndTime = time.Now()
+
+	done := false
+	scheduler.scheduled.Use(this.Id, func(ce interface{}) {
+		this.Results = run.Results
+		this.Errors = run.Errors
+		this.EndTime = run.EndTime
+		this.Runs++
+		this.History = append(this.History, run)
+		if len(this.History) > _HISTORY {
+			this.History = this.History[len(this.History)-_HISTORY:]
+		}
+
+		if len(run.Errors) > 0 {
+			this.Failures++
+			if this.attempt < this.Retries {
+				this.attempt++
+				this.NextRun = run.EndTime.Add(this.RetryDelay)
+			} else {
+				this.attempt = 0
+				this.NextRun = this.schedule.Next(run.EndTime)
+			}
+		} else {
+			this.attempt = 0
+			this.NextRun = this.schedule.Next(run.EndTime)
+		}
+
+		switch {
+		case this.drop || this.NextRun.IsZero():
+			this.State = COMPLETED
+			done = true
+		case this.pause:
+			this.pause = false
+			this.State = PAUSED
+		default:
+			this.State = SCHEDULED
+			this.arm(time.Now())
+		}
+	})
+	if !done {
+		return
+	}
+
+	// dropped while running, or the schedule does not fire any more
+	drop := this.drop
+	scheduler.scheduled.Delete(this.Id, func(ce interface{}) {
+		this.Exec = nil
+		this.Stop = nil
+		this.context = nil
+		this.parameters = nil
+		this.timer = nil
+	})
+	if !drop {
+		scheduler.completed.Add(this, this.Id, func(ce interface{}) util.Operation {
+			return util.REPLACE
+		})
+	}
+}
+
+// add the schedule, run count and history of a recurring task to its system:tasks_cache entry
+func (this *TaskEntry) AddRecurringInfo(item map[string]interface{}) {
+	if this.schedule == nil {
+		return
+	}
+	item["schedule"] = this.Schedule
+	item["runs"] = this.Runs
+	item["failures"] = this.Failures
+	item["retries"] = this.Retries
+	item["retryDelay"] = this.RetryDelay.String()
+	if this.State == SCHEDULED {
+		item["nextRun"] = this.NextRun.String()
+	}
+	if len(this.History) > 0 {
+		history := make([]interface{}, len(this.History))
+		for i, run := range this.History {
+			history[i] = run.Object()
+		}
+		item["history"] = history
+	}
+}
@@ -0,0 +1,241 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package scheduler
+
+// This module parses the schedules of recurring tasks, which are either
+// fixed intervals:
+//
+//	@every 1h30m
+//
+// or standard five field cron expressions, in local time:
+//
+//	minute hour day-of-month month day-of-week
+//
+// each field being *, a value, a range a-b, or either of the latter two
+// followed by a /step, or a comma separated list of any of these.
+// Months and days of the week can also be given by their three letter names.
+// As in cron, if both the day of the month and the day of the week are
+// restricted, a day matching either runs the task.
+// The @yearly, @monthly, @weekly, @daily and @hourly shorthands are accepted.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Schedule interface {
+	Next(from time.Time) time.Time
+	String() string
+}
+
+var shorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil {
+			return nil, err
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("interval %v is less than a second", d)
+		}
+		return &interval{spec: spec, every: d}, nil
+	}
+
+	expr := spec
+	if strings.HasPrefix(spec, "@") {
+		var ok bool
+		expr, ok = shorthands[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("unknown schedule %v", spec)
+		}
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %v does not have 5 fields", spec)
+	}
+
+	rv := &cron{spec: spec}
+	var err error
+	for i, f := range cronFields {
+		rv.bits[i], err = f.parse(fields[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	rv.anyDom = fields[_DOM] == "*"
+	rv.anyDow = fields[_DOW] == "*"
+
+	// fold sunday as 7 onto sunday as 0
+	if rv.bits[_DOW]&(1<<7) != 0 {
+		rv.bits[_DOW] |= 1
+	}
+	return rv, nil
+}
+
+type interval struct {
+	spec  string
+	every time.Duration
+}
+
+func (this *interval) Next(from time.Time) time.Time {
+	return from.Add(this.every)
+}
+
+func (this *interval) String() string {
+	return this.spec
+}
+
+const (
+	_MINUTE = iota
+	_HOUR
+	_DOM
+	_MONTH
+	_DOW
+)
+
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names []string
+}
+
+var cronFields = [5]cronField{
+	{"minute", 0, 59, nil},
+	{"hour", 0, 23, nil},
+	{"day of month", 1, 31, nil},
+	{"month", 1, 12, []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{"day of week", 0, 7, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+func (this *cronField) parse(field string) (uint64, error) {
+	var rv uint64
+
+	for _, term := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(term, '/'); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(term[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %v field %v", this.name, term)
+			}
+			term = term[:i]
+		}
+
+		lo, hi := this.min, this.max
+		if term != "*" {
+			var err error
+			bounds := strings.SplitN(term, "-", 2)
+			lo, err = this.value(bounds[0])
+			if err != nil {
+				return 0, err
+			}
+			if len(bounds) == 2 {
+				hi, err = this.value(bounds[1])
+				if err != nil {
+					return 0, err
+				}
+			} else if step == 1 {
+				hi = lo
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range in %v field %v", this.name, term)
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			rv |= 1 << uint(v)
+		}
+	}
+	return rv, nil
+}
+
+func (this *cronField) value(s string) (int, error) {
+	for i, n := range this.names {
+		if strings.ToLower(s) == n {
+			return this.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < this.min || v > this.max {
+		return 0, fmt.Errorf("invalid %v %v", this.name, s)
+	}
+	return v, nil
+}
+
+type cron struct {
+	spec   string
+	bits   [5]uint64
+	anyDom bool
+	anyDow bool
+}
+
+// give up on schedules that never fire, such as February 30th
+const _MAX_YEARS = 5
+
+func (this *cron) Next(from time.Time) time.Time {
+	t := from.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(_MAX_YEARS, 0, 0)
+
+	for t.Before(limit) {
+		if this.bits[_MONTH]&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !this.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if this.bits[_HOUR]&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if this.bits[_MINUTE]&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (this *cron) matchDay(t time.Time) bool {
+	dom := this.bits[_DOM]&(1<<uint(t.Day())) != 0
+	dow := this.bits[_DOW]&(1<<uint(t.Weekday())) != 0
+
+	switch {
+	case this.anyDom && this.anyDow:
+		return true
+	case this.anyDom:
+		return dow
+	case this.anyDow:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+func (this *cron) String() string {
+	return this.spec
+}
@@ -0,0 +1,68 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	from := time.Date(2020, time.March, 14, 10, 27, 30, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"@every 90m", from.Add(90 * time.Minute)},
+		{"* * * * *", time.Date(2020, time.March, 14, 10, 28, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, time.March, 14, 10, 30, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2020, time.March, 15, 2, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2020, time.March, 15, 0, 0, 0, 0, time.UTC)},
+		{"30 9 * * mon-fri", time.Date(2020, time.March, 16, 9, 30, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1,20 * *", time.Date(2020, time.March, 20, 12, 0, 0, 0, time.UTC)},
+
+		// day of month or day of week
+		{"0 0 31 * 7", time.Date(2020, time.March, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, test := range tests {
+		s, err := ParseSchedule(test.spec)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", test.spec, err)
+			continue
+		}
+		if next := s.Next(from); !next.Equal(test.next) {
+			t.Errorf("%v: expected %v, got %v", test.spec, test.next, next)
+		}
+	}
+}
+
+func TestScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * foo *",
+		"10-5 * * * *",
+		"*/0 * * * *",
+		"@fortnightly",
+		"@every 10ms",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
@@ -26,6 +26,7 @@ const (
 	RUNNING   State = "running"
 	COMPLETED State = "completed"
 	CANCELLED State = "cancelled"
+	PAUSED    State = "paused"
 )
 
 type TaskFunc func(Context, interface{}) (interface{}, []errors.Error)
@@ -45,9 +46,23 @@ type TaskEntry struct {
 	Results   interface{}
 	Errors    []errors.Error
 
+	// recurring tasks only
+	Schedule   string
+	NextRun    time.Time
+	Runs       int64
+	Failures   int64
+	Retries    int
+	RetryDelay time.Duration
+	History    []*TaskRun
+
 	timer      *time.Timer
 	parameters interface{}
 	context    Context
+	schedule   Schedule
+	attempt    int
+	armed      int64
+	pause      bool
+	drop       bool
 }
 
 type schedulerCache struct {
@@ -196,11 +211,17 @@ func DeleteTask(id string) errors.Error {
 	bailOut := false
 	deleted := false
 
-	_ = scheduler.scheduled.Get(id, func(ce interface{}) {
+	_ = scheduler.scheduled.Use(id, func(ce interface{}) {
 		task = ce.(*TaskEntry)
-		if task.State == SCHEDULED {
+		if task.State == SCHEDULED || task.State == PAUSED {
 			task.State = DELETING
 			task.timer.Stop()
+		} else if task.schedule != nil {
+
+			// a recurring task goes once the current run completes
+			task.drop = true
+			task = nil
+			deleted = true
 		} else {
 			bailOut = true
 		}
@@ -210,6 +231,9 @@ func DeleteTask(id string) errors.Error {
 	if bailOut {
 		return errors.NewTaskRunningError(id)
 	}
+	if deleted {
+		return nil
+	}
 
 	// cleanup and remove
 	if task != nil {
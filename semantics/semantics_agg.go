@@ -51,6 +51,26 @@ func (this *SemChecker) visitAggregateFunction(agg algebra.Aggregate) (err error
 			"semantics.visit_aggregate_function.filter")
 	}
 
+	// Ordered set aggregate fraction must not depend on the input
+	if algebra.AggregateHasProperty(agg.Name(), algebra.AGGREGATE_ALLOWS_WITHIN_GROUP) && len(agg.Operands()) > 1 {
+		if op := agg.Operands()[1]; op == nil || op.Static() == nil {
+			return errors.NewSemanticsError(nil, aggName+" fraction must be a constant or a parameter")
+		}
+	}
+
+	// String aggregate separator must not depend on the input
+	if algebra.AggregateHasProperty(agg.Name(), algebra.AGGREGATE_ALLOWS_ORDER_BY) && len(agg.Operands()) > 1 {
+		if op := agg.Operands()[1]; op == nil || op.Static() == nil {
+			return errors.NewSemanticsError(nil, aggName+" separator must be a constant or a parameter")
+		}
+	}
+
+	// ORDER BY within the arguments, but aggregate doesn't support it
+	if len(agg.AggregateOrder()) > 0 && !algebra.AggregateHasProperty(aggName, algebra.AGGREGATE_ALLOWS_ORDER_BY) {
+		return errors.NewWindowSemanticError(aggName, "ORDER BY clause ", "is not allowed.",
+			"semantics.visit_aggregate_function.order")
+	}
+
 	wTerm := agg.WindowTerm()
 	if wTerm == nil {
 		if algebra.AggregateHasProperty(aggName, algebra.AGGREGATE_ALLOWS_REGULAR) {
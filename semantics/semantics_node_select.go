@@ -27,6 +27,11 @@ func (this *SemChecker) VisitSubselect(node *algebra.Subselect) (r interface{},
 		if err = node.With().MapExpressions(this); err != nil {
 			return nil, err
 		}
+		for _, term := range node.RecursiveWiths() {
+			if err = term.Validate(); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	if node.From() != nil {
@@ -30,6 +30,7 @@ import (
 	datastore_package "github.com/couchbase/query/datastore"
 	"github.com/couchbase/query/datastore/resolver"
 	"github.com/couchbase/query/datastore/system"
+	"github.com/couchbase/query/execution"
 	"github.com/couchbase/query/functions"
 	"github.com/couchbase/query/functions/constructor"
 	"github.com/couchbase/query/logging"
@@ -38,6 +39,7 @@ import (
 	"github.com/couchbase/query/scheduler"
 	server_package "github.com/couchbase/query/server"
 	"github.com/couchbase/query/server/http"
+	"github.com/couchbase/query/tracing"
 	"github.com/couchbase/query/util"
 )
 
@@ -92,6 +94,15 @@ var ENTERPRISE = flag.Bool("enterprise", true, "Enterprise mode")
 var MAX_INDEX_API = flag.Int("max-index-api", datastore_package.INDEX_API_MAX, "Max Index API")
 var N1QL_FEAT_CTRL = flag.Uint64("n1ql-feat-ctrl", util.DEF_N1QL_FEAT_CTRL, "N1QL Feature Controls")
 var MEMORY_QUOTA = flag.Uint64("memory-quota", _DEF_MEMORY_QUOTA, "Maximum amount of document memory allowed per request, in MB")
+var SPILL_THRESHOLD = flag.Uint64("spill-threshold", 0, "Amount of memory an operator can buffer before spilling to disk, in MB")
+var RECURSION_LEVELS = flag.Int64("recursion-levels", execution.DEF_RECURSION_LEVELS, "Maximum number of levels a recursive WITH without options can evaluate, 0 for no limit")
+var RECURSION_DOCUMENTS = flag.Int64("recursion-documents", execution.DEF_RECURSION_DOCUMENTS, "Maximum number of documents a recursive WITH without options can return, 0 for no limit")
+var SPILL_DIR = flag.String("spill-dir", "", "Directory for temporary spill files")
+var PREPAREDS_DIR = flag.String("prepareds-dir", "", "Directory in which to persist prepared statements across restarts")
+var TRACE_EXPORTER = flag.String("trace-exporter", "", "Exporter for request traces: stdout or otlp")
+var TRACE_ENDPOINT = flag.String("trace-endpoint", tracing.DEF_OTLP_ENDPOINT, "OTLP/HTTP endpoint to export request traces to")
+var TRACE_SAMPLE = flag.Float64("trace-sample", 1.0, "Fraction of requests traced, unless the caller decides")
+var TRACE_STATEMENTS = flag.Bool("trace-statements", false, "Export the text of statements in request traces")
 
 //cpu and memory profiling flags
 var CPU_PROFILE = flag.String("cpuprofile", "", "write cpu profile to file")
@@ -260,6 +271,7 @@ func main() {
 
 	datastore_package.SetSystemstore(server.Systemstore())
 	prepareds.PreparedsReprepareInit(datastore, sys)
+	prepareds.PreparedsPersistInit(*PREPAREDS_DIR)
 
 	server.SetCpuProfile(*CPU_PROFILE)
 	server.SetKeepAlive(*KEEP_ALIVE_LENGTH)
@@ -280,9 +292,18 @@ func main() {
 		util.SetUseCBO(util.CE_USE_CBO)
 	}
 	server.SetMemoryQuota(*MEMORY_QUOTA)
+	server.SetSpillThreshold(*SPILL_THRESHOLD)
+	server.SetSpillDirectory(*SPILL_DIR)
+	server.SetRecursionLevels(*RECURSION_LEVELS)
+	server.SetRecursionDocuments(*RECURSION_DOCUMENTS)
 
 	audit.StartAuditService(*DATASTORE, *SERVICERS+*PLUS_SERVICERS)
 
+	if err := tracing.Init(*TRACE_EXPORTER, *TRACE_ENDPOINT, *TRACE_SAMPLE, *TRACE_STATEMENTS); err != nil {
+		logging.Errorp("Cannot start tracing", logging.Pair{"error", err})
+		os.Exit(1)
+	}
+
 	logging.Infop("cbq-engine started",
 		logging.Pair{"version", util.VERSION},
 		logging.Pair{"datastore", *DATASTORE},
@@ -357,6 +378,8 @@ func signalCatcher(server *server_package.Server, endpoint *http.HttpEndpoint) {
 		os.Exit(0)
 	}
 	logging.Infop("Attempting graceful exit")
+	prepareds.PreparedsPersistStop()
+
 	// Stop accepting new requests
 	err := endpoint.Close()
 	if err != nil {
@@ -49,6 +49,11 @@ const (
 	USECBO          = "use-cbo"
 	TXTIMEOUT       = "txtimeout"
 	ATRCOLLECTION   = "atrcollection"
+	SPILLTHRESHOLD  = "spill-threshold"
+	WORKLOADGROUPS  = "workload-groups"
+	REQUESTLIMITS   = "request-limits"
+	RECURSIONLEVELS = "recursion-levels"
+	RECURSIONDOCS   = "recursion-documents"
 )
 
 type Checker func(interface{}) (bool, errors.Error)
@@ -85,6 +90,11 @@ var CHECKERS = map[string]Checker{
 	USECBO:          checkBool,
 	TXTIMEOUT:       checkDuration,
 	ATRCOLLECTION:   checkPath,
+	SPILLTHRESHOLD:  checkNonNegativeInteger,
+	WORKLOADGROUPS:  checkWorkloadGroups,
+	REQUESTLIMITS:   checkRequestLimits,
+	RECURSIONLEVELS: checkNonNegativeInteger,
+	RECURSIONDOCS:   checkNonNegativeInteger,
 }
 
 func checkBool(val interface{}) (bool, errors.Error) {
@@ -208,3 +218,60 @@ func checkPath(val interface{}) (bool, errors.Error) {
 
 	return ok, nil
 }
+
+func checkWorkloadGroups(val interface{}) (bool, errors.Error) {
+	_, err := getWorkloadGroups(val)
+	return err == nil, err
+}
+
+func getWorkloadGroups(val interface{}) ([]*WorkloadGroup, errors.Error) {
+	defs, ok := val.([]interface{})
+	if !ok {
+		return nil, errors.NewAdminSettingTypeError(WORKLOADGROUPS, val)
+	}
+
+	names := make(map[string]bool, len(defs))
+	groups := make([]*WorkloadGroup, 0, len(defs))
+	for _, d := range defs {
+		def, ok := d.(map[string]interface{})
+		if !ok {
+			return nil, errors.NewAdminSettingTypeError(WORKLOADGROUPS, d)
+		}
+		group, err := NewWorkloadGroup(def)
+		if err != nil {
+			return nil, err
+		}
+		if names[group.Name] {
+			return nil, errors.NewAdminWorkloadGroupExists(group.Name)
+		}
+		names[group.Name] = true
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+func checkRequestLimits(val interface{}) (bool, errors.Error) {
+	_, err := getRequestLimits(val)
+	return err == nil, err
+}
+
+func getRequestLimits(val interface{}) ([]*RequestLimit, errors.Error) {
+	defs, ok := val.([]interface{})
+	if !ok {
+		return nil, errors.NewAdminSettingTypeError(REQUESTLIMITS, val)
+	}
+
+	limits := make([]*RequestLimit, 0, len(defs))
+	for _, d := range defs {
+		def, ok := d.(map[string]interface{})
+		if !ok {
+			return nil, errors.NewAdminSettingTypeError(REQUESTLIMITS, d)
+		}
+		limit, err := NewRequestLimit(def)
+		if err != nil {
+			return nil, err
+		}
+		limits = append(limits, limit)
+	}
+	return limits, nil
+}
@@ -38,6 +38,7 @@ const (
 	accountingPrefix   = adminPrefix + "/stats"
 	vitalsPrefix       = adminPrefix + "/vitals"
 	preparedsPrefix    = adminPrefix + "/prepareds"
+	baselinePrefix     = preparedsPrefix + "/{name}/baseline"
 	requestsPrefix     = adminPrefix + "/active_requests"
 	completedsPrefix   = adminPrefix + "/completed_requests"
 	functionsPrefix    = adminPrefix + "/functions_cache"
@@ -75,6 +76,9 @@ func (this *HttpEndpoint) registerAccountingHandlers() {
 	preparedsHandler := func(w http.ResponseWriter, req *http.Request) {
 		this.wrapAPI(w, req, doPrepareds)
 	}
+	baselineHandler := func(w http.ResponseWriter, req *http.Request) {
+		this.wrapAPI(w, req, doBaseline)
+	}
 	requestsHandler := func(w http.ResponseWriter, req *http.Request) {
 		this.wrapAPI(w, req, doActiveRequests)
 	}
@@ -147,8 +151,10 @@ func (this *HttpEndpoint) registerAccountingHandlers() {
 		accountingPrefix:                      {handler: statsHandler, methods: []string{"GET"}},
 		accountingPrefix + "/{stat}":          {handler: statHandler, methods: []string{"GET", "DELETE"}},
 		vitalsPrefix:                          {handler: vitalsHandler, methods: []string{"GET"}},
-		preparedsPrefix:                       {handler: preparedsHandler, methods: []string{"GET"}},
+		preparedsPrefix:                       {handler: preparedsHandler, methods: []string{"GET", "POST"}},
 		preparedsPrefix + "/{name}":           {handler: preparedHandler, methods: []string{"GET", "POST", "DELETE", "PUT"}},
+		baselinePrefix:                        {handler: baselineHandler, methods: []string{"GET", "PUT", "DELETE"}},
+		baselinePrefix + "/{candidate}":       {handler: baselineHandler, methods: []string{"PUT", "DELETE"}},
 		requestsPrefix:                        {handler: requestsHandler, methods: []string{"GET"}},
 		requestsPrefix + "/{request}":         {handler: requestHandler, methods: []string{"GET", "POST", "DELETE"}},
 		completedsPrefix:                      {handler: completedsHandler, methods: []string{"GET"}},
@@ -437,6 +443,9 @@ func doPrepared(endpoint *HttpEndpoint, w http.ResponseWriter, req *http.Request
 			if len(txPrepards) > 0 {
 				itemMap["txPrepards"] = txPrepards
 			}
+			if baseline, _ := prepareds.BaselineInfo(name, false); baseline != nil {
+				itemMap["baseline"] = baseline
+			}
 			if req.Method == "POST" {
 				itemMap["plan"] = entry.Prepared.Operator
 				if len(txPlans) > 0 {
@@ -463,6 +472,61 @@ func doPrepared(endpoint *HttpEndpoint, w http.ResponseWriter, req *http.Request
 	}
 }
 
+/*
+Plan baselines: GET shows the pinned plan and how candidates differ from it,
+PUT pins the current plan, or accepts a candidate, DELETE unpins the plan,
+or rejects a candidate.
+*/
+func doBaseline(endpoint *HttpEndpoint, w http.ResponseWriter, req *http.Request, af *audit.ApiAuditFields) (interface{}, errors.Error) {
+	vars := mux.Vars(req)
+	name := vars["name"]
+	candidate, isCandidate := vars["candidate"]
+
+	af.EventTypeId = audit.API_ADMIN_PREPAREDS
+	af.Name = name
+
+	var err errors.Error
+	if req.Method == "GET" {
+		err, _ = endpoint.verifyCredentialsFromRequest("system:prepareds", auth.PRIV_SYSTEM_READ, req, af)
+		if err != nil {
+			return nil, err
+		}
+		baseline, err := prepareds.BaselineInfo(name, true)
+		if err != nil {
+			return nil, err
+		}
+		if baseline == nil {
+			return nil, errors.NewNoSuchBaselineError(name)
+		}
+		return baseline, nil
+	}
+
+	err, _ = endpoint.verifyCredentialsFromRequest("system:prepareds", auth.PRIV_CLUSTER_SETTINGS_WRITE, req, af)
+	if err != nil {
+		return nil, err
+	}
+	switch req.Method {
+	case "PUT":
+		if isCandidate {
+			err = prepareds.AcceptPlanCandidate(name, candidate)
+		} else {
+			err = prepareds.PinPrepared(name)
+		}
+	case "DELETE":
+		if isCandidate {
+			err = prepareds.RejectPlanCandidate(name, candidate)
+		} else {
+			err = prepareds.UnpinPrepared(name)
+		}
+	default:
+		return nil, errors.NewServiceErrorHttpMethod(req.Method)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
 func doPrepareds(endpoint *HttpEndpoint, w http.ResponseWriter, req *http.Request, af *audit.ApiAuditFields) (interface{}, errors.Error) {
 	af.EventTypeId = audit.API_ADMIN_PREPAREDS
 	switch req.Method {
@@ -472,6 +536,11 @@ func doPrepareds(endpoint *HttpEndpoint, w http.ResponseWriter, req *http.Reques
 			return nil, err
 		}
 
+		// the whole cache, in the form accepted by POST
+		if req.FormValue("export") != "" {
+			return prepareds.ExportPrepareds(), nil
+		}
+
 		numPrepareds := prepareds.CountPrepareds()
 		data := make([]map[string]interface{}, numPrepareds)
 		i := 0
@@ -510,6 +579,33 @@ func doPrepareds(endpoint *HttpEndpoint, w http.ResponseWriter, req *http.Reques
 		prepareds.PreparedsForeach(snapshot, nil)
 		return data, nil
 
+	case "POST":
+		body, err1 := ioutil.ReadAll(req.Body)
+		defer req.Body.Close()
+
+		// http.BasicAuth eats the body, so verify credentials after getting the body.
+		err, _ := endpoint.verifyCredentialsFromRequest("system:prepareds", auth.PRIV_SYSTEM_READ, req, af)
+		if err != nil {
+			return nil, err
+		}
+
+		if err1 != nil {
+			return nil, errors.NewAdminBodyError(err1)
+		}
+
+		// import a cache previously exported, possibly from another node
+		var snapshot prepareds.Snapshot
+		err1 = json.Unmarshal(body, &snapshot)
+		if err1 != nil {
+			return nil, errors.NewAdminBodyError(err1)
+		}
+		count, errs := prepareds.ImportPrepareds(&snapshot)
+		rv := map[string]interface{}{"imported": count}
+		if len(errs) > 0 {
+			rv["errors"] = errs
+		}
+		return rv, nil
+
 	default:
 		return nil, errors.NewServiceErrorHttpMethod(req.Method)
 	}
@@ -729,6 +825,7 @@ func doTask(endpoint *HttpEndpoint, w http.ResponseWriter, req *http.Request, af
 			if !entry.EndTime.IsZero() {
 				itemMap["stopTime"] = entry.EndTime.String()
 			}
+			entry.AddRecurringInfo(itemMap)
 		})
 		return itemMap, nil
 	} else {
@@ -775,6 +872,7 @@ func doTasks(endpoint *HttpEndpoint, w http.ResponseWriter, req *http.Request, a
 			if !d.EndTime.IsZero() {
 				data[i]["stopTime"] = d.EndTime.String()
 			}
+			d.AddRecurringInfo(data[i])
 			i++
 			return true
 		}
@@ -470,6 +470,11 @@ func fillSettings(settings map[string]interface{}, srvr *server.Server) map[stri
 	settings[server.MEMORYQUOTA] = srvr.MemoryQuota()
 	settings[server.USECBO] = srvr.UseCBO()
 	settings[server.ATRCOLLECTION] = srvr.AtrCollection()
+	settings[server.SPILLTHRESHOLD] = srvr.SpillThreshold()
+	settings[server.RECURSIONLEVELS] = srvr.RecursionLevels()
+	settings[server.RECURSIONDOCS] = srvr.RecursionDocuments()
+	settings[server.WORKLOADGROUPS] = server.WorkloadGroupsSetting()
+	settings[server.REQUESTLIMITS] = server.RequestLimitsSetting()
 	return settings
 }
 
@@ -11,6 +11,10 @@ package http
 
 import (
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"sync"
 
 	"github.com/couchbase/query/util"
 )
@@ -61,3 +65,58 @@ func (bp *syncPoolBufPool) PutBuffer(b *bytes.Buffer) {
 func (bp *syncPoolBufPool) BufferCapacity() int {
 	return bp.buf_size
 }
+
+// compressWriter is the common API of the response compressors
+type compressWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var gzipPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+var deflatePool = sync.Pool{
+	New: func() interface{} {
+		return zlib.NewWriter(nil)
+	},
+}
+
+func getCompressWriter(c Compression, w io.Writer) compressWriter {
+	var rv compressWriter
+
+	switch c.contentEncoding() {
+	case "gzip":
+		rv = gzipPool.Get().(*gzip.Writer)
+	case "deflate":
+		rv = deflatePool.Get().(*zlib.Writer)
+	default:
+		return nil
+	}
+	rv.Reset(w)
+	return rv
+}
+
+func putCompressWriter(cw compressWriter) {
+	switch cw := cw.(type) {
+	case *gzip.Writer:
+		gzipPool.Put(cw)
+	case *zlib.Writer:
+		deflatePool.Put(cw)
+	}
+}
+
+// countingWriter tracks how many bytes have been sent
+type countingWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (this *countingWriter) Write(p []byte) (int, error) {
+	n, err := this.w.Write(p)
+	this.count += n
+	return n, err
+}
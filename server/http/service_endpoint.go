@@ -236,6 +236,7 @@ func (this *HttpEndpoint) ServeHTTP(resp http.ResponseWriter, req *http.Request)
 	defer this.actives.Delete(request.Id().String(), false)
 
 	defer this.doStats(request, this.server)
+	defer request.endSpan()
 
 	if request.State() == server.FATAL {
 
@@ -252,6 +253,9 @@ func (this *HttpEndpoint) ServeHTTP(resp http.ResponseWriter, req *http.Request)
 	}
 
 	if !res {
+
+		// no body, so nothing to decompress
+		resp.Header().Del("Content-Encoding")
 		resp.WriteHeader(http.StatusServiceUnavailable)
 	}
 }
@@ -34,6 +34,7 @@ import (
 	"github.com/couchbase/query/prepareds"
 	"github.com/couchbase/query/server"
 	"github.com/couchbase/query/timestamp"
+	"github.com/couchbase/query/tracing"
 	"github.com/couchbase/query/util"
 	"github.com/couchbase/query/value"
 )
@@ -47,6 +48,9 @@ type httpRequest struct {
 	httpCloseNotify <-chan bool
 	writer          bufferedWriter
 	httpRespCode    int
+	format          Format
+	columns         []string
+	compression     Compression
 	resultCount     int
 	resultSize      int
 	errorCount      int
@@ -109,6 +113,13 @@ func newHttpRequest(rv *httpRequest, resp http.ResponseWriter, req *http.Request
 	server.NewBaseRequest(&rv.BaseRequest)
 	rv.SetRequestTime(reqTime)
 
+	// join the caller's trace, and let the caller know about ours
+	span := tracing.StartRequest("query.request", req.Header.Get("traceparent"), reqTime)
+	if span != nil {
+		rv.SetSpan(span)
+		resp.Header().Set("traceparent", span.Traceparent())
+	}
+
 	// for GET method, only readonly access
 	if req.Method == "GET" {
 		rv.SetReadonly(value.TRUE)
@@ -122,6 +133,9 @@ func newHttpRequest(rv *httpRequest, resp http.ResponseWriter, req *http.Request
 	rv.SetUserAgent(userAgent)
 	rv.SetRemoteAddr(req.RemoteAddr)
 
+	// the compression parameter, if present, overrides content negotiation
+	rv.compression = negotiateCompression(req)
+
 	if err == nil {
 		err = httpArgs.processParameters(rv)
 	}
@@ -354,8 +368,11 @@ func handleFormat(rv *httpRequest, httpArgs httpRequestArgs, parm string, val in
 		format := newFormat(format_field)
 		if format == UNDEFINED_FORMAT {
 			err = errors.NewServiceErrorUnrecognizedValue(FORMAT, format_field)
-		} else if format != JSON {
-			err = errors.NewServiceErrorNotImplemented(FORMAT, format_field)
+		} else {
+			rv.format = format
+			if format != JSON {
+				rv.resp.Header().Set("Content-Type", format.ContentType())
+			}
 		}
 	}
 	return err
@@ -375,8 +392,10 @@ func handleCompression(rv *httpRequest, httpArgs httpRequestArgs, parm string, v
 		compression := newCompression(compression_field)
 		if compression == UNDEFINED_COMPRESSION {
 			err = errors.NewServiceErrorUnrecognizedValue(COMPRESSION, compression_field)
-		} else if compression != NONE {
+		} else if compression.contentEncoding() == "" && compression != NONE {
 			err = errors.NewServiceErrorNotImplemented(COMPRESSION, compression_field)
+		} else {
+			rv.compression = compression
 		}
 	}
 	return err
@@ -1056,6 +1075,14 @@ func contentNegotiation(resp http.ResponseWriter, req *http.Request) errors.Erro
 		return nil
 	}
 	desiredContent := accept[0]
+
+	// alternative result formats are selected by the format parameter
+	for _, f := range []Format{XML, CSV, TSV} {
+		if strings.HasPrefix(desiredContent, f.MediaType()) {
+			return nil
+		}
+	}
+
 	// media type must be application/json at least
 	if !strings.HasPrefix(desiredContent, acceptType) {
 		return errors.NewServiceErrorMediaType(desiredContent)
@@ -1743,6 +1770,28 @@ func (f Format) String() string {
 	return s
 }
 
+func (f Format) MediaType() string {
+	var s string
+	switch f {
+	case XML:
+		s = "application/xml"
+	case CSV:
+		s = "text/csv"
+	case TSV:
+		s = "text/tab-separated-values"
+	default:
+		s = acceptType
+	}
+	return s
+}
+
+func (f Format) ContentType() string {
+	if f == JSON {
+		return version
+	}
+	return f.MediaType() + "; charset=utf-8"
+}
+
 type Compression int
 
 const (
@@ -1751,6 +1800,8 @@ const (
 	RLE
 	LZMA
 	LZO
+	GZIP
+	DEFLATE
 	UNDEFINED_COMPRESSION
 )
 
@@ -1766,6 +1817,10 @@ func newCompression(s string) Compression {
 		return LZMA
 	case "LZO":
 		return LZO
+	case "GZIP":
+		return GZIP
+	case "DEFLATE":
+		return DEFLATE
 	default:
 		return UNDEFINED_COMPRESSION
 	}
@@ -1784,12 +1839,71 @@ func (c Compression) String() string {
 		s = "LZMA"
 	case LZO:
 		s = "LZO"
+	case GZIP:
+		s = "GZIP"
+	case DEFLATE:
+		s = "DEFLATE"
 	default:
 		s = "UNDEFINED_COMPRESSION"
 	}
 	return s
 }
 
+// HTTP content coding for the supported compressions, empty if not supported
+// ZIP is served as gzip
+func (c Compression) contentEncoding() string {
+	var s string
+	switch c {
+	case ZIP, GZIP:
+		s = "gzip"
+	case DEFLATE:
+		s = "deflate"
+	}
+	return s
+}
+
+// choose the preferred supported coding in the Accept-Encoding header
+// ties are resolved in favour of gzip, and "*" only stands for codings
+// not explicitly listed
+func negotiateCompression(req *http.Request) Compression {
+	qs := make(map[string]float64, 3)
+	for _, header := range req.Header["Accept-Encoding"] {
+		for _, coding := range strings.Split(header, ",") {
+			q := 1.0
+			params := strings.Split(coding, ";")
+			for _, p := range params[1:] {
+				p = strings.TrimSpace(p)
+				if strings.HasPrefix(p, "q=") {
+					f, err := strconv.ParseFloat(p[2:], 64)
+					if err != nil {
+						f = 0.0
+					}
+					q = f
+				}
+			}
+			name := strings.ToLower(strings.TrimSpace(params[0]))
+			if name == "x-gzip" {
+				name = "gzip"
+			}
+			qs[name] = q
+		}
+	}
+
+	rv := NONE
+	bestQ := 0.0
+	for _, c := range []Compression{GZIP, DEFLATE} {
+		q, ok := qs[c.contentEncoding()]
+		if !ok {
+			q = qs["*"]
+		}
+		if q > bestQ {
+			rv = c
+			bestQ = q
+		}
+	}
+	return rv
+}
+
 // scanVectorEntry implements timestamp.Entry
 type scanVectorEntry struct {
 	position uint32
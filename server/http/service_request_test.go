@@ -11,11 +11,14 @@ package http
 
 import (
 	"bytes"
+	"compress/gzip"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -484,3 +487,63 @@ func doJsonEncodedPost(payload map[string]interface{}) (*http.Response, error) {
 
 	return res, nil
 }
+
+func TestNegotiateCompression(t *testing.T) {
+	cases := map[string]Compression{
+		"":                       NONE,
+		"gzip":                   GZIP,
+		"deflate":                DEFLATE,
+		"deflate, gzip":          GZIP,
+		"gzip;q=0.5, deflate":    DEFLATE,
+		"gzip;q=0, *":            DEFLATE,
+		"gzip;q=0, deflate;q=0":  NONE,
+		"br, identity":           NONE,
+		"x-gzip;q=0.8, br;q=1.0": GZIP,
+		"*;q=0.1":                GZIP,
+	}
+
+	for header, expected := range cases {
+		req, _ := http.NewRequest("GET", "http://localhost/query/service", nil)
+		if header != "" {
+			req.Header.Set("Accept-Encoding", header)
+		}
+		if c := negotiateCompression(req); c != expected {
+			t.Errorf("Accept-Encoding %q: expected %v, got %v", header, expected, c)
+		}
+	}
+}
+
+func TestCompressedSizes(t *testing.T) {
+	req, err := http.NewRequest("POST", test_server.URL()+"/", bytes.NewBufferString("statement=select+1"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error in HTTP request: %v", err)
+	}
+	defer res.Body.Close()
+
+	compressed, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	uncompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// trailers are only available once the body has been read
+	if size := res.Trailer.Get(_TRAILER_COMPRESSED_SIZE); size != strconv.Itoa(len(compressed)) {
+		t.Errorf("Expected compressed size %v, got %v", len(compressed), size)
+	}
+	if size := res.Trailer.Get(_TRAILER_UNCOMPRESSED_SIZE); size != strconv.Itoa(len(uncompressed)) {
+		t.Errorf("Expected uncompressed size %v, got %v", len(uncompressed), size)
+	}
+}
@@ -11,12 +11,16 @@ package http
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/couchbase/query/datastore"
 	"github.com/couchbase/query/distributed"
@@ -24,6 +28,7 @@ import (
 	"github.com/couchbase/query/execution"
 	"github.com/couchbase/query/logging"
 	"github.com/couchbase/query/server"
+	"github.com/couchbase/query/tracing"
 	"github.com/couchbase/query/util"
 	"github.com/couchbase/query/value"
 )
@@ -46,6 +51,13 @@ func (this *httpRequest) Fail(err errors.Error) {
 	// Determine the appropriate http response code based on the error
 	httpRespCode := mapErrorToHttpResponse(err, http.StatusInternalServerError)
 	this.setHttpCode(httpRespCode)
+	if httpRespCode == http.StatusTooManyRequests {
+		if cause, ok := err.Cause().(map[string]interface{}); ok {
+			if retryAfter, ok := cause["retry_after"].(int); ok {
+				this.resp.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			}
+		}
+	}
 	// Add error to the request
 	this.Error(err)
 }
@@ -86,6 +98,8 @@ func mapErrorToHttpResponse(err errors.Error, def int) int {
 		return http.StatusUnprocessableEntity
 	case 10000:
 		return http.StatusUnauthorized
+	case errors.SERVICE_RATE_LIMITED, errors.SERVICE_CONCURRENCY_LIMITED:
+		return http.StatusTooManyRequests
 	default:
 		return def
 	}
@@ -105,6 +119,12 @@ func (this *httpRequest) setHttpCode(httpRespCode int) {
 }
 
 func (this *httpRequest) Failed(srvr *server.Server) {
+
+	// errors at this stage are always reported as JSON
+	if this.format != JSON {
+		this.format = JSON
+		this.resp.Header().Set("Content-Type", version)
+	}
 	prefix, indent := this.prettyStrings(srvr.Pretty(), false)
 	this.writeString("{\n")
 	this.writeRequestID(prefix)
@@ -124,6 +144,34 @@ func (this *httpRequest) Failed(srvr *server.Server) {
 	this.Stop(server.FATAL)
 }
 
+// the request span ends once the response has been written
+// statements are user data, and only exported, tagged as such, if configured
+func (this *httpRequest) endSpan() {
+	span := this.Span()
+	if span == nil {
+		return
+	}
+	span.SetAttribute("db.system", "couchbase")
+	if this.Statement() != "" && tracing.Statements() {
+		span.SetAttribute("db.statement", "<ud>"+this.Statement()+"</ud>")
+	}
+	if this.Prepared() != nil {
+		span.SetAttribute("preparedName", this.Prepared().Name())
+	}
+	span.SetAttribute("requestID", this.Id().String())
+	if this.ClientID().IsValid() {
+		span.SetAttribute("clientContextID", this.ClientID().String())
+	}
+	span.SetAttribute("state", this.State().StateName())
+	span.SetAttribute("resultCount", this.resultCount)
+	span.SetAttribute("http.status_code", this.httpCode())
+	if errs := this.Errors(); len(errs) > 0 {
+		span.SetAttribute("errorCount", len(errs))
+		span.SetError(errs[0].Error())
+	}
+	span.End()
+}
+
 func (this *httpRequest) markTimeOfCompletion(now time.Time) {
 	this.executionTime = now.Sub(this.ServiceTime())
 	this.elapsedTime = now.Sub(this.RequestTime())
@@ -133,7 +181,14 @@ func (this *httpRequest) Execute(srvr *server.Server, context *execution.Context
 	this.prefix, this.indent = this.prettyStrings(srvr.Pretty(), false)
 
 	this.setHttpCode(http.StatusOK)
-	this.writePrefix(srvr, signature, this.prefix, this.indent)
+	switch this.format {
+	case CSV, TSV:
+		this.writeDelimitedPrefix(signature)
+	case XML:
+		this.writeXMLPrefix(srvr, signature, this.prefix, this.indent)
+	default:
+		this.writePrefix(srvr, signature, this.prefix, this.indent)
+	}
 
 	// release writer
 	this.Done()
@@ -163,8 +218,17 @@ func (this *httpRequest) Execute(srvr *server.Server, context *execution.Context
 	this.markTimeOfCompletion(now)
 
 	state := this.State()
-	this.writeSuffix(srvr, state, this.prefix, this.indent)
-	this.writer.noMoreData()
+	switch this.format {
+	case CSV, TSV:
+		this.writer.noMoreData()
+		this.writeDelimitedTrailers(state)
+	case XML:
+		this.writeXMLSuffix(srvr, state, this.prefix, this.indent)
+		this.writer.noMoreData()
+	default:
+		this.writeSuffix(srvr, state, this.prefix, this.indent)
+		this.writer.noMoreData()
+	}
 }
 
 func (this *httpRequest) Expire(state server.State, timeout time.Duration) {
@@ -241,6 +305,13 @@ func (this *httpRequest) Result(item value.AnnotatedValue) bool {
 		return true
 	}
 
+	switch this.format {
+	case CSV, TSV:
+		return this.delimitedResult(item)
+	case XML:
+		return this.xmlResult(item)
+	}
+
 	this.writer.timeFlush()
 	beforeWrites := this.writer.mark()
 
@@ -434,6 +505,10 @@ func (this *httpRequest) writeMetrics(metrics bool, prefix, indent string) bool
 		newPrefix = "\n" + prefix + indent
 	}
 
+	// the sizes of a compressed response are not part of the metrics:
+	// they would have to include the metrics themselves, and the compressed
+	// size is only known once the compressor has been closed, after the last
+	// byte of the body; they are sent as trailers instead, see noMoreData()
 	var b [64]byte
 	beforeMetrics := this.writer.mark()
 	if !(this.writeString(",\n") &&
@@ -726,10 +801,16 @@ func (this *httpRequest) writeProfile(profile server.Profile, prefix, indent str
 // note that the access to the buffered writer is not controlled,
 // and the executor and stream have to coordinate in between them
 // not to mess up the output
+// if the response is compressed, chunks go through the compressor,
+// which is flushed together with the response, and the full uncompressed
+// and compressed sizes are sent as trailers
 type bufferedWriter struct {
-	req         *httpRequest  // the request for the response we are writing
-	buffer      *bytes.Buffer // buffer for writing response data to
-	buffer_pool BufferPool    // buffer manager for our buffers
+	req         *httpRequest   // the request for the response we are writing
+	buffer      *bytes.Buffer  // buffer for writing response data to
+	buffer_pool BufferPool     // buffer manager for our buffers
+	compressor  compressWriter // response compressor, if any
+	sink        countingWriter // compressed bytes sent
+	written     int            // uncompressed bytes sent
 	closed      bool
 	header      bool // headers required
 	lastFlush   util.Time
@@ -737,6 +818,11 @@ type bufferedWriter struct {
 
 const _PRINTF_THRESHOLD = 128
 
+const (
+	_TRAILER_UNCOMPRESSED_SIZE = "X-Query-Uncompressed-Size"
+	_TRAILER_COMPRESSED_SIZE   = "X-Query-Compressed-Size"
+)
+
 func NewBufferedWriter(w *bufferedWriter, r *httpRequest, bp BufferPool) {
 	w.req = r
 	w.buffer = bp.GetBuffer()
@@ -744,6 +830,18 @@ func NewBufferedWriter(w *bufferedWriter, r *httpRequest, bp BufferPool) {
 	w.closed = false
 	w.header = true
 	w.lastFlush = util.Now()
+	w.written = 0
+	w.compressor = nil
+	if r.compression != NONE {
+		w.sink = countingWriter{w: r.resp}
+		w.compressor = getCompressWriter(r.compression, &w.sink)
+		if w.compressor != nil {
+			header := r.resp.Header()
+			header.Set("Content-Encoding", r.compression.contentEncoding())
+			header.Add("Vary", "Accept-Encoding")
+			header.Add("Trailer", _TRAILER_UNCOMPRESSED_SIZE+", "+_TRAILER_COMPRESSED_SIZE)
+		}
+	}
 }
 
 func (this *bufferedWriter) writeBytes(s []byte) bool {
@@ -756,21 +854,7 @@ func (this *bufferedWriter) writeBytes(s []byte) bool {
 
 	// threshold exceeded
 	if len(s)+this.buffer.Len() > this.buffer_pool.BufferCapacity() {
-		w := this.req.resp // our request's response writer
-
-		// write response header and data buffered so far using request's response writer:
-		if this.header {
-			w.WriteHeader(this.req.httpCode())
-			this.header = false
-		}
-
-		// write out and empty the buffer
-		io.Copy(w, this.buffer)
-		this.buffer.Reset()
-
-		// do the flushing
-		this.lastFlush = util.Now()
-		w.(http.Flusher).Flush()
+		this.flush()
 	}
 
 	// under threshold - write the string to our buffer
@@ -785,21 +869,7 @@ func (this *bufferedWriter) printf(f string, args ...interface{}) bool {
 
 	// threshold exceeded
 	if _PRINTF_THRESHOLD+this.buffer.Len() > this.buffer_pool.BufferCapacity() {
-		w := this.req.resp // our request's response writer
-
-		// write response header and data buffered so far using request's response writer:
-		if this.header {
-			w.WriteHeader(this.req.httpCode())
-			this.header = false
-		}
-
-		// write out and empty the buffer
-		io.Copy(w, this.buffer)
-		this.buffer.Reset()
-
-		// do the flushing
-		this.lastFlush = util.Now()
-		w.(http.Flusher).Flush()
+		this.flush()
 	}
 
 	// under threshold - write the string to our buffer
@@ -824,21 +894,7 @@ func (this *bufferedWriter) timeFlush() {
 
 	// flush only if time has exceeded
 	if util.Since(this.lastFlush) > 100*time.Millisecond {
-		w := this.req.resp // our request's response writer
-
-		// write response header and data buffered so far using request's response writer:
-		if this.header {
-			w.WriteHeader(this.req.httpCode())
-			this.header = false
-		}
-
-		// write out and empty the buffer
-		io.Copy(w, this.buffer)
-		this.buffer.Reset()
-
-		// do the flushing
-		this.lastFlush = util.Now()
-		w.(http.Flusher).Flush()
+		this.flush()
 	}
 }
 
@@ -850,22 +906,32 @@ func (this *bufferedWriter) sizeFlush() {
 
 	// beyond capacity
 	if this.buffer.Len() > this.buffer_pool.BufferCapacity() {
-		w := this.req.resp // our request's response writer
+		this.flush()
+	}
+}
 
-		// write response header and data buffered so far using request's response writer:
-		if this.header {
-			w.WriteHeader(this.req.httpCode())
-			this.header = false
-		}
+// write response header and data buffered so far using request's response writer
+func (this *bufferedWriter) flush() {
+	w := this.req.resp // our request's response writer
 
-		// write out and empty the buffer
-		io.Copy(w, this.buffer)
-		this.buffer.Reset()
+	if this.header {
+		w.WriteHeader(this.req.httpCode())
+		this.header = false
+	}
 
-		// do the flushing
-		this.lastFlush = util.Now()
-		w.(http.Flusher).Flush()
+	// write out and empty the buffer
+	this.written += this.buffer.Len()
+	if this.compressor != nil {
+		io.Copy(this.compressor, this.buffer)
+		this.compressor.Flush()
+	} else {
+		io.Copy(w, this.buffer)
 	}
+	this.buffer.Reset()
+
+	// do the flushing
+	this.lastFlush = util.Now()
+	w.(http.Flusher).Flush()
 }
 
 // mark the current write position
@@ -892,16 +958,475 @@ func (this *bufferedWriter) noMoreData() {
 
 	if this.header {
 		// calculate and set the Content-Length header:
-		content_len := strconv.Itoa(len(this.buffer.Bytes()))
-		w.Header().Set("Content-Length", content_len)
+		// delimited formats send trailers, which require a chunked response,
+		// and the compressed length is not known in advance
+		if this.req.format != CSV && this.req.format != TSV && this.compressor == nil {
+			content_len := strconv.Itoa(len(this.buffer.Bytes()))
+			w.Header().Set("Content-Length", content_len)
+		}
 		// write response header and data buffered so far:
 		w.WriteHeader(this.req.httpCode())
 		this.header = false
 	}
 
-	io.Copy(w, this.buffer)
+	this.written += this.buffer.Len()
+	if this.compressor != nil {
+		io.Copy(this.compressor, this.buffer)
+		this.compressor.Close()
+		putCompressWriter(this.compressor)
+		this.compressor = nil
+		w.Header().Set(_TRAILER_UNCOMPRESSED_SIZE, strconv.Itoa(this.written))
+		w.Header().Set(_TRAILER_COMPRESSED_SIZE, strconv.Itoa(this.sink.count))
+	} else {
+		io.Copy(w, this.buffer)
+	}
 	// no more data in the response => return buffer to pool:
 	this.buffer_pool.PutBuffer(this.buffer)
 	r.Body.Close()
 	this.closed = true
 }
+
+// Delimited (CSV and TSV) result formats
+//
+// The response body only contains the results, preceded by a header row.
+// The columns are the projection aliases found in the signature, in name order,
+// or, if the signature does not determine them (SELECT *, RAW projections), the
+// field names of the first result.
+// Non object RAW results produce a single column named "$1".
+// Values are flattened as follows:
+//   - strings are written as they are
+//   - numbers and booleans are written in their JSON representation
+//   - null and missing values produce an empty cell
+//   - objects and arrays are written as compact JSON text
+//   - binary values are written base64 encoded
+// Since results are streamed before the request completes, status, result and
+// error counts, and the errors themselves are sent as HTTP trailers.
+
+const (
+	_TRAILER_STATUS       = "X-Query-Status"
+	_TRAILER_RESULT_COUNT = "X-Query-Result-Count"
+	_TRAILER_ERROR_COUNT  = "X-Query-Error-Count"
+	_TRAILER_ERRORS       = "X-Query-Errors"
+)
+
+const _RAW_COLUMN = "$1"
+
+func (this *httpRequest) writeDelimitedPrefix(signature value.Value) bool {
+	this.resp.Header().Add("Trailer", _TRAILER_STATUS+", "+_TRAILER_RESULT_COUNT+", "+
+		_TRAILER_ERROR_COUNT+", "+_TRAILER_ERRORS)
+	this.columns = signatureColumns(signature)
+	if this.columns == nil {
+		return true
+	}
+	return this.writeDelimitedHeader()
+}
+
+func (this *httpRequest) writeDelimitedHeader() bool {
+	var buf bytes.Buffer
+
+	for i, c := range this.columns {
+		if i > 0 {
+			buf.WriteByte(this.format.separator())
+		}
+		this.format.writeCell(&buf, []byte(c))
+	}
+	buf.WriteString("\r\n")
+	return this.writer.writeBytes(buf.Bytes())
+}
+
+// columns are only known in advance for projections with no stars
+func signatureColumns(signature value.Value) []string {
+	if signature == nil || signature.Type() != value.OBJECT {
+		return nil
+	}
+	if _, ok := signature.Field("*"); ok {
+		return nil
+	}
+	return signature.FieldNames(nil)
+}
+
+func resultColumns(item value.Value) []string {
+	if item.Type() != value.OBJECT {
+		return []string{_RAW_COLUMN}
+	}
+	return item.FieldNames(nil)
+}
+
+func (this *httpRequest) delimitedResult(item value.AnnotatedValue) bool {
+	var val value.Value
+
+	if this.columns == nil {
+		this.columns = resultColumns(item)
+		if !this.writeDelimitedHeader() {
+			this.SetState(server.CLOSED)
+			return false
+		}
+	}
+
+	this.writer.timeFlush()
+	beforeResult := this.writer.mark()
+	buf := this.writer.buf()
+	sep := this.format.separator()
+	cell := make([]byte, 0, 64)
+	rawColumn := len(this.columns) == 1 && this.columns[0] == _RAW_COLUMN && item.Type() != value.OBJECT
+	for i, c := range this.columns {
+		if i > 0 {
+			buf.Write([]byte{sep})
+		}
+		if rawColumn {
+			val = item
+		} else {
+			val, _ = item.Field(c)
+		}
+		cell = flattenCell(cell[:0], val)
+		this.format.writeCell(buf, cell)
+	}
+	if !this.writer.write("\r\n") {
+		this.writer.truncate(beforeResult)
+		this.SetState(server.CLOSED)
+		return false
+	}
+	this.resultSize += (this.writer.mark() - beforeResult)
+	this.resultCount++
+	this.writer.sizeFlush()
+	return true
+}
+
+func flattenCell(dst []byte, val value.Value) []byte {
+	if val == nil {
+		return dst
+	}
+	switch val.Type() {
+	case value.MISSING, value.NULL:
+		return dst
+	case value.STRING:
+		return append(dst, val.Actual().(string)...)
+	case value.BINARY:
+		b := val.Actual().([]byte)
+		l := len(dst)
+		dst = append(dst, make([]byte, base64.StdEncoding.EncodedLen(len(b)))...)
+		base64.StdEncoding.Encode(dst[l:], b)
+		return dst
+	case value.INTERVAL:
+		return append(dst, val.Actual().(value.Interval).String()...)
+	default:
+		var buf bytes.Buffer
+
+		err := val.WriteJSON(&buf, "", "", true)
+		if err != nil {
+			return dst
+		}
+		return append(dst, buf.Bytes()...)
+	}
+}
+
+func (f Format) separator() byte {
+	if f == TSV {
+		return '\t'
+	}
+	return ','
+}
+
+// CSV cells follow RFC 4180: cells containing separators, quotes or line breaks
+// are quoted, with quotes doubled.
+// TSV cells cannot be quoted: tabs, line breaks and backslashes are escaped
+// with a backslash instead.
+func (f Format) writeCell(w io.Writer, cell []byte) {
+	if f == TSV {
+		start := 0
+		for i, c := range cell {
+			var esc string
+			switch c {
+			case '\t':
+				esc = "\\t"
+			case '\n':
+				esc = "\\n"
+			case '\r':
+				esc = "\\r"
+			case '\\':
+				esc = "\\\\"
+			default:
+				continue
+			}
+			w.Write(cell[start:i])
+			io.WriteString(w, esc)
+			start = i + 1
+		}
+		w.Write(cell[start:])
+		return
+	}
+
+	if bytes.IndexAny(cell, ",\"\r\n") < 0 && (len(cell) == 0 || (cell[0] != ' ' && cell[0] != '\t')) {
+		w.Write(cell)
+		return
+	}
+	io.WriteString(w, "\"")
+	w.Write(bytes.Replace(cell, []byte{'"'}, []byte{'"', '"'}, -1))
+	io.WriteString(w, "\"")
+}
+
+func (this *httpRequest) writeDelimitedTrailers(state server.State) {
+	errs := this.Errors()
+	if state == server.COMPLETED {
+		if len(errs) == 0 {
+			state = server.SUCCESS
+		} else {
+			state = server.ERRORS
+		}
+	}
+	header := this.resp.Header()
+	header.Set(_TRAILER_STATUS, state.StateName())
+	header.Set(_TRAILER_RESULT_COUNT, strconv.Itoa(this.resultCount))
+	header.Set(_TRAILER_ERROR_COUNT, strconv.Itoa(len(errs)))
+	if len(errs) > 0 {
+		m := make([]map[string]interface{}, len(errs))
+		for i, err := range errs {
+			m[i] = map[string]interface{}{
+				"code": err.Code(),
+				"msg":  err.Error(),
+			}
+		}
+		bytes, err := json.Marshal(m)
+		if err == nil {
+			header.Set(_TRAILER_ERRORS, string(bytes))
+		}
+	}
+}
+
+// XML result format
+//
+// The response mirrors the JSON document: a <response> root element holding
+// requestID, clientContextID, signature, results, errors, warnings, status and metrics.
+// Each result is a <result> element.
+// Values are mapped as follows:
+//   - object fields become child elements named after the field, in name order;
+//     field names that are not valid XML names become <field name="..."> elements
+//   - array elements become <item> child elements
+//   - scalars are written as escaped text
+//   - null values become empty elements with a null="true" attribute
+//   - missing values are omitted
+//   - binary values are written base64 encoded, with an encoding="base64" attribute
+
+const _XML_HEADER = "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n"
+
+func (this *httpRequest) writeXMLPrefix(srvr *server.Server, signature value.Value, prefix, indent string) bool {
+	if !(this.writeString(_XML_HEADER) &&
+		this.writeString("<response>") &&
+		this.writeXMLElement(prefix, "requestID", this.Id().String())) {
+		return false
+	}
+	if this.ClientID().IsValid() && !this.writeXMLElement(prefix, "clientContextID", this.ClientID().String()) {
+		return false
+	}
+	s := this.Signature()
+	if s == value.TRUE || (s == value.NONE && srvr.Signature()) {
+		buf := this.writer.buf()
+		writeXMLValue(buf, "signature", signature, xmlNewline(prefix), indent)
+	}
+	return this.writeXMLNewline(prefix) && this.writeString("<results>")
+}
+
+func (this *httpRequest) xmlResult(item value.AnnotatedValue) bool {
+	this.writer.timeFlush()
+	beforeResult := this.writer.mark()
+	err := writeXMLValue(this.writer.buf(), "result", item, xmlNewline(this.prefix+this.indent), this.indent)
+	if err != nil {
+		this.writer.truncate(beforeResult)
+		this.Error(errors.NewServiceErrorInvalidJSON(err))
+		this.SetState(server.FATAL)
+		return false
+	}
+	this.resultSize += (this.writer.mark() - beforeResult)
+	this.resultCount++
+	this.writer.sizeFlush()
+	return true
+}
+
+func (this *httpRequest) writeXMLSuffix(srvr *server.Server, state server.State, prefix, indent string) bool {
+	if !(this.writeXMLNewline(prefix) && this.writeString("</results>")) {
+		return false
+	}
+
+	errs := this.Errors()
+	if len(errs) > 0 && state != server.FATAL {
+		this.setHttpCode(mapErrorToHttpResponse(errs[0], http.StatusOK))
+	}
+	this.errorCount = len(errs)
+	if !this.writeXMLErrors(prefix, indent, "errors", "error", errs) {
+		return false
+	}
+	warnings := this.Warnings()
+	this.warningCount = len(warnings)
+	if !this.writeXMLErrors(prefix, indent, "warnings", "warning", warnings) {
+		return false
+	}
+
+	if state == server.COMPLETED {
+		if this.errorCount == 0 {
+			state = server.SUCCESS
+		} else {
+			state = server.ERRORS
+		}
+	}
+	if !this.writeXMLElement(prefix, "status", state.StateName()) {
+		return false
+	}
+
+	m := this.Metrics()
+	if m == value.TRUE || (m == value.NONE && srvr.Metrics()) {
+		newPrefix := prefix + indent
+		if !(this.writeXMLNewline(prefix) && this.writeString("<metrics>") &&
+			this.writeXMLElement(newPrefix, "elapsedTime", this.elapsedTime.String()) &&
+			this.writeXMLElement(newPrefix, "executionTime", this.executionTime.String()) &&
+			this.writeXMLElement(newPrefix, "resultCount", strconv.Itoa(this.resultCount)) &&
+			this.writeXMLElement(newPrefix, "resultSize", strconv.Itoa(this.resultSize)) &&
+			this.writeXMLElement(newPrefix, "serviceLoad", strconv.Itoa(server.ActiveRequestsLoad()))) {
+			return false
+		}
+		if this.MutationCount() > 0 &&
+			!this.writeXMLElement(newPrefix, "mutationCount", strconv.FormatUint(this.MutationCount(), 10)) {
+			return false
+		}
+		if this.errorCount > 0 && !this.writeXMLElement(newPrefix, "errorCount", strconv.Itoa(this.errorCount)) {
+			return false
+		}
+		if this.warningCount > 0 && !this.writeXMLElement(newPrefix, "warningCount", strconv.Itoa(this.warningCount)) {
+			return false
+		}
+		if !(this.writeXMLNewline(prefix) && this.writeString("</metrics>")) {
+			return false
+		}
+	}
+	return this.writeString("\n</response>\n")
+}
+
+func (this *httpRequest) writeXMLErrors(prefix, indent, list, elem string, errs []errors.Error) bool {
+	if len(errs) == 0 {
+		return true
+	}
+	if !(this.writeXMLNewline(prefix) && this.writeString("<"+list+">")) {
+		return false
+	}
+	newPrefix := xmlNewline(prefix + indent)
+	for _, err := range errs {
+		var buf bytes.Buffer
+
+		buf.WriteString(newPrefix)
+		buf.WriteString("<" + elem + " code=\"")
+		buf.WriteString(strconv.Itoa(int(err.Code())))
+		buf.WriteString("\">")
+		xml.EscapeText(&buf, []byte(err.Error()))
+		buf.WriteString("</" + elem + ">")
+		if !this.writer.writeBytes(buf.Bytes()) {
+			return false
+		}
+	}
+	return this.writeXMLNewline(prefix) && this.writeString("</"+list+">")
+}
+
+func (this *httpRequest) writeXMLElement(prefix, name, text string) bool {
+	var buf bytes.Buffer
+
+	buf.WriteString(xmlNewline(prefix))
+	buf.WriteString("<" + name + ">")
+	xml.EscapeText(&buf, []byte(text))
+	buf.WriteString("</" + name + ">")
+	return this.writer.writeBytes(buf.Bytes())
+}
+
+func (this *httpRequest) writeXMLNewline(prefix string) bool {
+	return this.writeString(xmlNewline(prefix))
+}
+
+// elements always start on a new line, indented only when pretty printing
+func xmlNewline(prefix string) string {
+	return "\n" + prefix
+}
+
+func writeXMLValue(w io.Writer, name string, val value.Value, newline, indent string) error {
+	if val == nil {
+		val = value.NULL_VALUE
+	}
+
+	tag, attr := xmlTag(name)
+	switch val.Type() {
+	case value.MISSING:
+		return nil
+	case value.NULL:
+		_, err := io.WriteString(w, newline+"<"+tag+attr+" null=\"true\"/>")
+		return err
+	case value.OBJECT:
+		if _, err := io.WriteString(w, newline+"<"+tag+attr+">"); err != nil {
+			return err
+		}
+		childNewline := newline + indent
+		for _, n := range val.FieldNames(nil) {
+			f, _ := val.Field(n)
+			if err := writeXMLValue(w, n, f, childNewline, indent); err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(w, newline+"</"+tag+">")
+		return err
+	case value.ARRAY:
+		if _, err := io.WriteString(w, newline+"<"+tag+attr+">"); err != nil {
+			return err
+		}
+		childNewline := newline + indent
+		for i := 0; ; i++ {
+			e, ok := val.Index(i)
+			if !ok {
+				break
+			}
+			if err := writeXMLValue(w, "item", e, childNewline, indent); err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(w, newline+"</"+tag+">")
+		return err
+	case value.BINARY:
+		_, err := io.WriteString(w, newline+"<"+tag+attr+" encoding=\"base64\">"+
+			base64.StdEncoding.EncodeToString(val.Actual().([]byte))+"</"+tag+">")
+		return err
+	default:
+		var text []byte
+
+		if _, err := io.WriteString(w, newline+"<"+tag+attr+">"); err != nil {
+			return err
+		}
+		text = flattenCell(text, val)
+		if err := xml.EscapeText(w, text); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "</"+tag+">")
+		return err
+	}
+}
+
+// field names that are not valid XML names are carried in an attribute
+func xmlTag(name string) (string, string) {
+	if isXMLName(name) {
+		return name, ""
+	}
+	var buf bytes.Buffer
+
+	buf.WriteString(" name=\"")
+	xml.EscapeText(&buf, []byte(name))
+	buf.WriteString("\"")
+	return "field", buf.String()
+}
+
+func isXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_' || unicode.IsLetter(r):
+		case i > 0 && (r == '-' || r == '.' || unicode.IsDigit(r)):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/couchbase/query/value"
+)
+
+func TestDelimitedCells(t *testing.T) {
+	cells := []struct {
+		val value.Value
+		csv string
+		tsv string
+	}{
+		{value.NewValue("plain"), "plain", "plain"},
+		{value.NewValue("a,b"), "\"a,b\"", "a,b"},
+		{value.NewValue("say \"hi\""), "\"say \"\"hi\"\"\"", "say \"hi\""},
+		{value.NewValue("tab\there\nline"), "\"tab\there\nline\"", "tab\\there\\nline"},
+		{value.NewValue(12.5), "12.5", "12.5"},
+		{value.NewValue(true), "true", "true"},
+		{value.NULL_VALUE, "", ""},
+		{value.MISSING_VALUE, "", ""},
+		{value.NewValue([]interface{}{1, "x"}), "\"[1,\"\"x\"\"]\"", "[1,\"x\"]"},
+		{value.NewValue(map[string]interface{}{"a": 1}), "\"{\"\"a\"\":1}\"", "{\"a\":1}"},
+	}
+
+	for _, c := range cells {
+		var csv, tsv bytes.Buffer
+
+		cell := flattenCell(nil, c.val)
+		CSV.writeCell(&csv, cell)
+		TSV.writeCell(&tsv, cell)
+		if csv.String() != c.csv {
+			t.Errorf("CSV cell for %v: expected %q, got %q", c.val, c.csv, csv.String())
+		}
+		if tsv.String() != c.tsv {
+			t.Errorf("TSV cell for %v: expected %q, got %q", c.val, c.tsv, tsv.String())
+		}
+	}
+}
+
+func TestSignatureColumns(t *testing.T) {
+	sig := value.NewValue(map[string]interface{}{"b": "json", "a": "number"})
+	cols := signatureColumns(sig)
+	if len(cols) != 2 || cols[0] != "a" || cols[1] != "b" {
+		t.Errorf("Unexpected columns %v", cols)
+	}
+
+	sig = value.NewValue(map[string]interface{}{"*": "*", "a": "number"})
+	if cols = signatureColumns(sig); cols != nil {
+		t.Errorf("Expected no columns for star projection, got %v", cols)
+	}
+
+	if cols = signatureColumns(value.NewValue("json")); cols != nil {
+		t.Errorf("Expected no columns for raw projection, got %v", cols)
+	}
+
+	cols = resultColumns(value.NewValue(10))
+	if len(cols) != 1 || cols[0] != _RAW_COLUMN {
+		t.Errorf("Unexpected raw columns %v", cols)
+	}
+}
+
+func TestXMLValue(t *testing.T) {
+	var buf bytes.Buffer
+
+	val := value.NewValue(map[string]interface{}{
+		"name":   "a<b",
+		"list":   []interface{}{1, nil},
+		"1st":    true,
+		"nested": map[string]interface{}{"x": 1.5},
+	})
+	err := writeXMLValue(&buf, "result", val, "", "")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := "<result><field name=\"1st\">true</field><list><item>1</item><item null=\"true\"/></list>" +
+		"<name>a&lt;b</name><nested><x>1.5</x></nested></result>"
+	if buf.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, buf.String())
+	}
+
+	for n, ok := range map[string]bool{"a": true, "_a-1.b": true, "1a": false, "xmlfoo": false, "a b": false, "": false} {
+		if isXMLName(n) != ok {
+			t.Errorf("Name %q: expected %v", n, ok)
+		}
+	}
+}
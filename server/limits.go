@@ -0,0 +1,322 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/couchbase/query/accounting"
+	"github.com/couchbase/query/auth"
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/errors"
+)
+
+/*
+Request limits cap the rate (as a token bucket) and the number of concurrent
+requests of each authenticated user.
+A limit applies either to a named user, or to every user holding a role, in
+which case each of those users gets their own allowance.
+User limits take precedence over role limits, and role limits apply in the
+order in which they are defined.
+*/
+type RequestLimit struct {
+	User          string
+	Role          string
+	Rate          float64
+	Burst         int
+	MaxConcurrent int
+}
+
+const (
+	_RL_USER           = "user"
+	_RL_ROLE           = "role"
+	_RL_RATE           = "rate"
+	_RL_BURST          = "burst"
+	_RL_MAX_CONCURRENT = "max_concurrent"
+)
+
+// how often idle user allowances are discarded
+const _RL_SWEEP_INTERVAL = time.Minute
+
+// how long throttled clients are asked to wait for a concurrent request to complete
+const _RL_CONCURRENCY_RETRY = time.Second
+
+/*
+Create a request limit from its definition, as found in the
+request-limits setting.
+*/
+func NewRequestLimit(def map[string]interface{}) (*RequestLimit, errors.Error) {
+	rv := &RequestLimit{}
+	for n, v := range def {
+		var ok bool
+
+		switch n {
+		case _RL_USER:
+			rv.User, ok = v.(string)
+			ok = ok && rv.User != ""
+		case _RL_ROLE:
+			rv.Role, ok = v.(string)
+			ok = ok && rv.Role != ""
+		case _RL_RATE:
+			switch r := v.(type) {
+			case int64:
+				rv.Rate = float64(r)
+			case float64:
+				rv.Rate = r
+			}
+			ok = rv.Rate > 0
+		case _RL_BURST:
+			rv.Burst, ok = workloadInteger(v, 1)
+		case _RL_MAX_CONCURRENT:
+			rv.MaxConcurrent, ok = workloadInteger(v, 1)
+		}
+		if !ok {
+			return nil, errors.NewAdminSettingTypeError(REQUESTLIMITS+"."+n, v)
+		}
+	}
+	if (rv.User == "") == (rv.Role == "") {
+		return nil, errors.NewAdminSettingTypeError(REQUESTLIMITS, def)
+	}
+	if rv.Rate == 0 && rv.MaxConcurrent == 0 {
+		return nil, errors.NewAdminSettingTypeError(REQUESTLIMITS, def)
+	}
+	if rv.Burst > 0 && rv.Rate == 0 {
+		return nil, errors.NewAdminSettingTypeError(REQUESTLIMITS+"."+_RL_BURST, def[_RL_BURST])
+	}
+	if rv.Rate > 0 && rv.Burst == 0 {
+		rv.Burst = int(math.Ceil(rv.Rate))
+	}
+	return rv, nil
+}
+
+/*
+The limit definition, in the same form accepted by NewRequestLimit.
+*/
+func (this *RequestLimit) Object() map[string]interface{} {
+	rv := make(map[string]interface{}, 4)
+	if this.User != "" {
+		rv[_RL_USER] = this.User
+	} else {
+		rv[_RL_ROLE] = this.Role
+	}
+	if this.Rate > 0 {
+		rv[_RL_RATE] = this.Rate
+		rv[_RL_BURST] = this.Burst
+	}
+	if this.MaxConcurrent > 0 {
+		rv[_RL_MAX_CONCURRENT] = this.MaxConcurrent
+	}
+	return rv
+}
+
+// the allowance of a single user
+type userLimiter struct {
+	limit  *RequestLimit
+	tokens float64
+	last   time.Time
+	active int
+}
+
+// called with the lock held
+func (this *userLimiter) refill(now time.Time) {
+	if this.limit.Rate > 0 {
+		this.tokens += now.Sub(this.last).Seconds() * this.limit.Rate
+		if this.tokens > float64(this.limit.Burst) {
+			this.tokens = float64(this.limit.Burst)
+		}
+	}
+	this.last = now
+}
+
+// called with the lock held
+func (this *userLimiter) take(user string, now time.Time) errors.Error {
+	this.refill(now)
+	if this.limit.MaxConcurrent > 0 && this.active >= this.limit.MaxConcurrent {
+		accounting.UpdateCounter(accounting.CONCURRENCY_LIMITED_REQUESTS)
+		return errors.NewServiceErrorConcurrencyLimited(user, this.limit.MaxConcurrent, _RL_CONCURRENCY_RETRY)
+	}
+	if this.limit.Rate > 0 {
+		if this.tokens < 1 {
+			accounting.UpdateCounter(accounting.RATE_LIMITED_REQUESTS)
+			wait := time.Duration((1 - this.tokens) / this.limit.Rate * float64(time.Second))
+			return errors.NewServiceErrorRateLimited(user, wait)
+		}
+		this.tokens--
+	}
+	this.active++
+	return nil
+}
+
+// an allowance that is full and unused is the same as a new one
+// called with the lock held
+func (this *userLimiter) idle() bool {
+	return this.active == 0 && (this.limit.Rate == 0 || this.tokens >= float64(this.limit.Burst))
+}
+
+type requestLimits struct {
+	sync.Mutex
+	limits   []*RequestLimit
+	users    map[string]*RequestLimit
+	roles    []*RequestLimit
+	limiters map[string]*userLimiter
+	sweep    time.Time
+}
+
+var limits = &requestLimits{
+	users:    make(map[string]*RequestLimit),
+	limiters: make(map[string]*userLimiter),
+}
+
+/*
+Replace all the request limits, as from /admin/settings.
+Allowances start afresh.
+*/
+func SetRequestLimits(l []*RequestLimit) {
+	limits.Lock()
+	defer limits.Unlock()
+
+	limits.set(l)
+}
+
+// called with the lock held
+func (this *requestLimits) set(l []*RequestLimit) {
+	this.limits = l
+	this.users = make(map[string]*RequestLimit, len(l))
+	this.roles = nil
+	for _, limit := range l {
+		if limit.User != "" {
+			this.users[limit.User] = limit
+		} else {
+			this.roles = append(this.roles, limit)
+		}
+	}
+	this.limiters = make(map[string]*userLimiter)
+}
+
+/*
+All the limit definitions, as returned by /admin/settings.
+*/
+func RequestLimitsSetting() []interface{} {
+	limits.Lock()
+	defer limits.Unlock()
+
+	rv := make([]interface{}, len(limits.limits))
+	for i, limit := range limits.limits {
+		rv[i] = limit.Object()
+	}
+	return rv
+}
+
+/*
+Charge a request to the allowances of its users.
+The allowances returned must be released once the request completes.
+Only authenticated users are charged, so that no one can use up the
+allowance of others: requests that fail authentication are left to fail
+when they execute.
+*/
+func (this *requestLimits) admit(request Request, store datastore.Datastore) ([]*userLimiter, errors.Error) {
+	this.Lock()
+	none := len(this.limits) == 0
+	hasRoles := len(this.roles) > 0
+	this.Unlock()
+	if none || request.Credentials() == nil {
+		return nil, nil
+	}
+
+	users, err := store.Authorize(auth.NewPrivileges(), request.Credentials())
+	if err != nil {
+		return nil, nil
+	}
+	if len(users) == 0 {
+		users = requestUsers(request)
+	}
+
+	rv := make([]*userLimiter, 0, len(users))
+	for _, user := range users {
+		name := userName(user)
+
+		var roles map[string]bool
+		if hasRoles {
+			roles = userRoles.get([]string{user}, store)
+		}
+
+		this.Lock()
+		limiter := this.limiter(name, roles)
+		if limiter != nil {
+			err = limiter.take(name, time.Now())
+		}
+		this.Unlock()
+
+		if err != nil {
+			this.release(rv)
+			return nil, err
+		}
+		if limiter != nil {
+			rv = append(rv, limiter)
+		}
+	}
+	return rv, nil
+}
+
+func (this *requestLimits) release(limiters []*userLimiter) {
+	if len(limiters) == 0 {
+		return
+	}
+
+	this.Lock()
+	for _, limiter := range limiters {
+		limiter.active--
+	}
+	this.Unlock()
+}
+
+// the allowance of a user, if any limit applies to them
+// called with the lock held
+func (this *requestLimits) limiter(user string, roles map[string]bool) *userLimiter {
+	now := time.Now()
+	if now.Sub(this.sweep) > _RL_SWEEP_INTERVAL {
+		for n, limiter := range this.limiters {
+			limiter.refill(now)
+			if limiter.idle() {
+				delete(this.limiters, n)
+			}
+		}
+		this.sweep = now
+	}
+
+	limit := this.users[user]
+	if limit == nil {
+		for _, l := range this.roles {
+			if roles[l.Role] {
+				limit = l
+				break
+			}
+		}
+	}
+	if limit == nil {
+		return nil
+	}
+
+	limiter := this.limiters[user]
+	if limiter == nil {
+		limiter = &userLimiter{limit: limit, tokens: float64(limit.Burst), last: now}
+		this.limiters[user] = limiter
+	} else if limiter.limit != limit {
+
+		// the user roles have changed
+		limiter.limit = limit
+		if limiter.tokens > float64(limit.Burst) {
+			limiter.tokens = float64(limit.Burst)
+		}
+	}
+	return limiter
+}
@@ -0,0 +1,109 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/query/errors"
+)
+
+func TestRequestLimits(t *testing.T) {
+	setting := []interface{}{
+		map[string]interface{}{"user": "etl", "rate": float64(2), "max_concurrent": float64(1)},
+		map[string]interface{}{"role": "query_select[reports]", "rate": 0.5},
+		map[string]interface{}{"role": "analytics_reader", "max_concurrent": int64(3)},
+	}
+
+	l, err := getRequestLimits(setting)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if l[0].Burst != 2 || l[1].Burst != 1 || l[2].Burst != 0 {
+		t.Errorf("Unexpected bursts %v %v %v", l[0].Burst, l[1].Burst, l[2].Burst)
+	}
+
+	rl := &requestLimits{sweep: time.Now()}
+	rl.set(l)
+
+	// user limits win over role limits, role limits apply in order
+	roles := map[string]bool{"query_select": true, "query_select[reports]": true, "analytics_reader": true}
+	if limiter := rl.limiter("etl", roles); limiter == nil || limiter.limit != l[0] {
+		t.Errorf("Expected the etl user limit")
+	}
+	if limiter := rl.limiter("analyst", roles); limiter == nil || limiter.limit != l[1] {
+		t.Errorf("Expected the reports role limit")
+	}
+	if limiter := rl.limiter("admin", map[string]bool{"admin": true}); limiter != nil {
+		t.Errorf("Expected no limit, got %v", limiter.limit)
+	}
+
+	now := time.Now()
+	etl := rl.limiter("etl", nil)
+	if err := etl.take("etl", now); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if err := etl.take("etl", now); err == nil || err.Code() != errors.SERVICE_CONCURRENCY_LIMITED {
+		t.Errorf("Expected the concurrency limit, got %v", err)
+	}
+	etl.active--
+	if err := etl.take("etl", now); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	etl.active--
+	err = etl.take("etl", now)
+	if err == nil || err.Code() != errors.SERVICE_RATE_LIMITED {
+		t.Fatalf("Expected the rate limit, got %v", err)
+	}
+	if cause := err.Cause().(map[string]interface{}); cause["retry_after"] != 1 {
+		t.Errorf("Unexpected retry after %v", cause["retry_after"])
+	}
+
+	// half a second refills a token
+	if err := etl.take("etl", now.Add(500*time.Millisecond)); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	etl.active--
+
+	analyst := rl.limiter("analyst", roles)
+	analyst.take("analyst", now)
+	err = analyst.take("analyst", now.Add(time.Second))
+	if err == nil {
+		t.Fatalf("Expected the rate limit")
+	}
+	if cause := err.Cause().(map[string]interface{}); cause["retry_after"] != 1 {
+		t.Errorf("Unexpected retry after %v", cause["retry_after"])
+	}
+
+	// idle allowances are swept
+	analyst.active = 0
+	rl.sweep = time.Time{}
+	etl.last = now.Add(-time.Minute)
+	rl.limiter("admin", nil)
+	if len(rl.limiters) != 1 || rl.limiters["analyst"] != analyst {
+		t.Errorf("Expected only the analyst allowance to be left, got %v", rl.limiters)
+	}
+
+	invalid := []interface{}{
+		map[string]interface{}{"user": "etl"},
+		map[string]interface{}{"user": "etl", "role": "admin", "rate": float64(1)},
+		map[string]interface{}{"rate": float64(1)},
+		map[string]interface{}{"user": "etl", "rate": float64(-1)},
+		map[string]interface{}{"user": "etl", "max_concurrent": float64(2), "burst": float64(2)},
+		map[string]interface{}{"user": "etl", "max_concurrent": float64(0.5)},
+		map[string]interface{}{"user": "etl", "rate": float64(1), "queue": float64(1)},
+	}
+	for _, def := range invalid {
+		if _, err := getRequestLimits([]interface{}{def}); err == nil {
+			t.Errorf("%v: expected an error", def)
+		}
+	}
+}
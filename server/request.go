@@ -22,6 +22,7 @@ import (
 	"github.com/couchbase/query/execution"
 	"github.com/couchbase/query/plan"
 	"github.com/couchbase/query/timestamp"
+	"github.com/couchbase/query/tracing"
 	"github.com/couchbase/query/util"
 	"github.com/couchbase/query/value"
 )
@@ -160,6 +161,8 @@ type Request interface {
 	SetUserAgent(userAgent string)
 	SetTimings(o execution.Operator)
 	GetTimings() execution.Operator
+	Span() *tracing.Span
+	SetSpan(span *tracing.Span)
 	IsAdHoc() bool
 
 	setSleep() // internal methods for load control
@@ -312,6 +315,7 @@ type BaseRequest struct {
 	atrCollection     string
 	numAtrs           int
 	executionContext  *execution.Context
+	span              *tracing.Span
 }
 
 type requestIDImpl struct {
@@ -733,6 +737,12 @@ func (this *BaseRequest) FmtPhaseOperators() map[string]interface{} {
 
 func (this *BaseRequest) AddPhaseTime(phase execution.Phases, duration time.Duration) {
 	atomic.AddUint64(&(this.phaseStats[phase].duration), uint64(duration))
+
+	// server phases are only accrued once, as they complete
+	// operator phases are traced by the operators themselves
+	if phase >= execution.INSTANTIATE {
+		this.span.Record(phase.String(), duration)
+	}
 }
 
 func (this *BaseRequest) FmtPhaseTimes() map[string]interface{} {
@@ -788,6 +798,14 @@ func (this *BaseRequest) GetTimings() execution.Operator {
 	return this.timings
 }
 
+func (this *BaseRequest) SetSpan(span *tracing.Span) {
+	this.span = span
+}
+
+func (this *BaseRequest) Span() *tracing.Span {
+	return this.span
+}
+
 func (this *BaseRequest) SetControls(c value.Tristate) {
 	this.controls = c
 }
@@ -0,0 +1,85 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/logging"
+)
+
+// how often user roles are refreshed for role based workload groups and limits
+const _ROLES_REFRESH = 30 * time.Second
+
+type rolesCache struct {
+	sync.Mutex
+	roles   map[string][]datastore.Role
+	refresh time.Time
+}
+
+var userRoles = &rolesCache{}
+
+/*
+The roles of the given users, both as "role" and "role[target]".
+User roles are cached and refreshed at most every _ROLES_REFRESH.
+*/
+func (this *rolesCache) get(users []string, store datastore.Datastore) map[string]bool {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.roles == nil || time.Since(this.refresh) > _ROLES_REFRESH {
+		all, err := store.GetUserInfoAll()
+		if err != nil {
+			logging.Infof("cannot load user roles: %v", err)
+		} else {
+			this.roles = make(map[string][]datastore.Role, len(all))
+			for _, u := range all {
+				this.roles[u.Id] = u.Roles
+			}
+		}
+
+		// don't hammer the datastore on failure either
+		this.refresh = time.Now()
+	}
+
+	rv := make(map[string]bool)
+	for _, user := range users {
+		for _, r := range this.roles[userName(user)] {
+			rv[r.Name] = true
+			if r.Target != "" {
+				rv[r.Name+"["+r.Target+"]"] = true
+			}
+		}
+	}
+	return rv
+}
+
+// the users whose credentials were presented with the request
+func requestUsers(request Request) []string {
+	var users []string
+
+	if creds := request.Credentials(); creds != nil {
+		for user := range creds.Users {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// strip the domain from a "domain:user" credential
+func userName(user string) string {
+	if i := strings.IndexByte(user, ':'); i >= 0 {
+		return user[i+1:]
+	}
+	return user
+}
@@ -97,6 +97,7 @@ type runQueue struct {
 	tail      int32
 	queue     []waitEntry
 	mutex     sync.RWMutex
+	retired   int32
 }
 
 type txRunQueues struct {
@@ -364,6 +365,40 @@ func (this *Server) SetPipelineCap(pipeline_cap int64) {
 	execution.SetPipelineCap(pipeline_cap)
 }
 
+// spill threshold in MB, 0 to only spill when the memory quota is reached
+func (this *Server) SpillThreshold() uint64 {
+	return uint64(execution.GetSpillThreshold()) / (1024 * 1024)
+}
+
+func (this *Server) SetSpillThreshold(threshold uint64) {
+	execution.SetSpillThreshold(int64(threshold * 1024 * 1024))
+}
+
+// recursive WITH limits for terms that set none, 0 for no limit
+func (this *Server) RecursionLevels() int64 {
+	return execution.GetRecursionLevels()
+}
+
+func (this *Server) SetRecursionLevels(levels int64) {
+	execution.SetRecursionLevels(levels)
+}
+
+func (this *Server) RecursionDocuments() int64 {
+	return execution.GetRecursionDocuments()
+}
+
+func (this *Server) SetRecursionDocuments(documents int64) {
+	execution.SetRecursionDocuments(documents)
+}
+
+func (this *Server) SpillDirectory() string {
+	return execution.GetSpillDirectory()
+}
+
+func (this *Server) SetSpillDirectory(dir string) {
+	execution.SetSpillDirectory(dir)
+}
+
 func (this *Server) PipelineBatch() int {
 	return execution.PipelineBatchSize()
 }
@@ -523,21 +558,51 @@ func (this *Server) SetUseCBO(useCBO bool) {
 }
 
 func (this *Server) ServiceRequest(request Request) bool {
+	limiters, err := limits.admit(request, this.datastore)
+	if err != nil {
+		request.Fail(err)
+		request.Failed(this)
+		return true // so that StatusServiceUnavailable will not return
+	}
+	defer limits.release(limiters)
+
+	group := workloads.classify(request, this.datastore)
+	if group != nil {
+		group.apply(request)
+	}
 	if !this.setupRequestContext(request) {
 		request.Failed(this)
 		return true // so that StatusServiceUnavailable will not return
 	}
 
+	if group != nil {
+		return this.handlePlusRequest(request, group.queue, &this.unboundQueue, &this.transactionQueues)
+	}
 	return this.handleRequest(request, &this.unboundQueue)
 }
 
 func (this *Server) PlusServiceRequest(request Request) bool {
+	limiters, err := limits.admit(request, this.datastore)
+	if err != nil {
+		request.Fail(err)
+		request.Failed(this)
+		return true // so that StatusServiceUnavailable will not return
+	}
+	defer limits.release(limiters)
+
+	group := workloads.classify(request, this.datastore)
+	if group != nil {
+		group.apply(request)
+	}
 	if !this.setupRequestContext(request) {
 		request.Failed(this)
 		return true // so that StatusServiceUnavailable will not return
 	}
 
-	return this.handlePlusRequest(request, &this.plusQueue, &this.transactionQueues)
+	if group != nil {
+		return this.handlePlusRequest(request, group.queue, &this.plusQueue, &this.transactionQueues)
+	}
+	return this.handlePlusRequest(request, &this.plusQueue, nil, &this.transactionQueues)
 }
 
 func (this *Server) setupRequestContext(request Request) bool {
@@ -594,9 +659,17 @@ func (this *Server) handleRequest(request Request, queue *runQueue) bool {
 	return true
 }
 
-func (this *Server) handlePlusRequest(request Request, queue *runQueue, transactionQueues *txRunQueues) bool {
+// the workload group of a request may have been dropped since the request was classified:
+// if its queue has been retired, the request goes to the fallback queue
+func (this *Server) handlePlusRequest(request Request, queue, fallback *runQueue, transactionQueues *txRunQueues) bool {
 	if !queue.enqueue(request) {
-		return false
+		if fallback == nil || atomic.LoadInt32(&queue.retired) == 0 {
+			return false
+		}
+		queue = fallback
+		if !queue.enqueue(request) {
+			return false
+		}
 	}
 
 	dequeue := true
@@ -692,14 +765,24 @@ func newTxRunQueues(q *txRunQueues, nqueues, num int) {
 	q.txQueues = make(map[string]*runQueue, nqueues)
 }
 
+// requests are not added to a retired queue
 func (this *runQueue) enqueue(request Request) bool {
 	runCnt := int(atomic.AddInt32(&this.runCnt, 1))
 
+	// retire() happened before the increment, and the queue may have no waiter checker
+	if atomic.LoadInt32(&this.retired) != 0 {
+		atomic.AddInt32(&this.runCnt, -1)
+		return false
+	}
+
 	// if servicers exceeded, reserve a spot in the queue
+	// the spot is reserved before the run slot is given back, so that the two counters
+	// are never both seen as zero, and the waiter checker of a retired queue stays
+	// until the request is released
 	if runCnt > this.servicers {
 
-		atomic.AddInt32(&this.runCnt, -1)
 		queueCnt := atomic.AddInt32(&this.queueCnt, 1)
+		atomic.AddInt32(&this.runCnt, -1)
 
 		// rats! queue full, can't handle this request
 		if queueCnt >= this.size {
@@ -823,6 +906,11 @@ func (this *runQueue) checkWaiters() {
 		time.Sleep(100 * time.Millisecond)
 		runCnt := atomic.LoadInt32(&this.runCnt)
 		queueCnt := atomic.LoadInt32(&this.queueCnt)
+
+		// queues of dropped workload groups go once they have drained
+		if atomic.LoadInt32(&this.retired) != 0 && runCnt == 0 && queueCnt == 0 {
+			return
+		}
 		for {
 
 			// no left behind requests
@@ -848,12 +936,18 @@ func (this *runQueue) checkWaiters() {
 	}
 }
 
+// no new requests are added to a retired queue: enqueue() checks after taking a run slot,
+// so requests that get in before keep the waiter checker running until they are done
+func (this *runQueue) retire() {
+	atomic.StoreInt32(&this.retired, 1)
+}
+
 func (this *runQueue) load(txqueueCnt int) int {
 	return 100 * (int(this.runCnt) + int(this.queueCnt) + txqueueCnt) / this.servicers
 }
 
 func (this *Server) Load() int {
-	return this.plusQueue.load(this.txQueueCount()) + this.unboundQueue.load(0)
+	return this.plusQueue.load(this.txQueueCount()) + this.unboundQueue.load(0) + workloads.load()
 }
 
 func (this *Server) txQueueCount() int {
@@ -877,6 +971,7 @@ func (this *Server) serviceRequest(request Request) {
 	request.Servicing()
 
 	context := request.ExecutionContext()
+	context.SetSpan(request.Span())
 	if request.TxId() != "" {
 		atrCollection := this.AtrCollection()
 		if request.AtrCollection() != "" {
@@ -172,6 +172,35 @@ var _SETTERS = map[string]Setter{
 		}
 		return nil
 	},
+	SPILLTHRESHOLD: func(s *Server, o interface{}) errors.Error {
+		value := getNumber(o)
+		s.SetSpillThreshold(uint64(value))
+		return nil
+	},
+	RECURSIONLEVELS: func(s *Server, o interface{}) errors.Error {
+		value := getNumber(o)
+		s.SetRecursionLevels(int64(value))
+		return nil
+	},
+	RECURSIONDOCS: func(s *Server, o interface{}) errors.Error {
+		value := getNumber(o)
+		s.SetRecursionDocuments(int64(value))
+		return nil
+	},
+	WORKLOADGROUPS: func(s *Server, o interface{}) errors.Error {
+		groups, err := getWorkloadGroups(o)
+		if err == nil {
+			SetWorkloadGroups(groups)
+		}
+		return err
+	},
+	REQUESTLIMITS: func(s *Server, o interface{}) errors.Error {
+		limits, err := getRequestLimits(o)
+		if err == nil {
+			SetRequestLimits(limits)
+		}
+		return err
+	},
 }
 
 func getNumber(o interface{}) float64 {
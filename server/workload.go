@@ -0,0 +1,459 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package server
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	atomic "github.com/couchbase/go-couchbase/platform"
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/errors"
+)
+
+/*
+Workload groups give a set of users, roles, applications (by client context id
+prefix) or query contexts their own run queue, so that one workload cannot
+starve the others of servicers, and their own defaults and limits for
+timeout, memory quota and parallelism.
+Requests not mapped to any group use the server wide queues.
+*/
+type WorkloadGroup struct {
+	Name             string
+	Servicers        int
+	QueueDepth       int
+	Timeout          time.Duration
+	MemoryQuota      uint64
+	MaxParallelism   int
+	Users            []string
+	Roles            []string
+	ClientIdPrefixes []string
+	QueryContexts    []string
+	queue            *runQueue
+}
+
+const (
+	_WG_NAME            = "name"
+	_WG_SERVICERS       = "servicers"
+	_WG_QUEUE_DEPTH     = "queue_depth"
+	_WG_TIMEOUT         = "timeout"
+	_WG_MEMORY_QUOTA    = "memory_quota"
+	_WG_MAX_PARALLELISM = "max_parallelism"
+	_WG_USERS           = "users"
+	_WG_ROLES           = "roles"
+	_WG_CLIENT_IDS      = "client_context_id_prefixes"
+	_WG_QUERY_CONTEXTS  = "query_contexts"
+)
+
+const _WG_DEF_QUEUE_DEPTH = 256
+
+/*
+Create a workload group from its definition, as found in the
+workload-groups setting or system:workload_groups.
+*/
+func NewWorkloadGroup(def map[string]interface{}) (*WorkloadGroup, errors.Error) {
+	name, ok := def[_WG_NAME].(string)
+	if !ok || name == "" {
+		return nil, errors.NewAdminWorkloadGroupInvalid("", _WG_NAME, def[_WG_NAME])
+	}
+
+	rv := &WorkloadGroup{Name: name, QueueDepth: _WG_DEF_QUEUE_DEPTH}
+	for n, v := range def {
+		var err errors.Error
+
+		switch n {
+		case _WG_NAME:
+		case _WG_SERVICERS:
+			rv.Servicers, ok = workloadInteger(v, 1)
+		case _WG_QUEUE_DEPTH:
+			rv.QueueDepth, ok = workloadInteger(v, 1)
+		case _WG_MAX_PARALLELISM:
+			rv.MaxParallelism, ok = workloadInteger(v, 0)
+		case _WG_MEMORY_QUOTA:
+			var quota int
+			quota, ok = workloadInteger(v, 0)
+			rv.MemoryQuota = uint64(quota)
+		case _WG_TIMEOUT:
+			rv.Timeout, ok = workloadDuration(v)
+		case _WG_USERS:
+			rv.Users, ok = workloadStrings(v)
+		case _WG_ROLES:
+			rv.Roles, ok = workloadStrings(v)
+		case _WG_CLIENT_IDS:
+			rv.ClientIdPrefixes, ok = workloadStrings(v)
+		case _WG_QUERY_CONTEXTS:
+			rv.QueryContexts, ok = workloadStrings(v)
+		default:
+			ok = false
+		}
+		if !ok {
+			err = errors.NewAdminWorkloadGroupInvalid(name, n, v)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rv.Servicers == 0 {
+		return nil, errors.NewAdminWorkloadGroupInvalid(name, _WG_SERVICERS, def[_WG_SERVICERS])
+	}
+	return rv, nil
+}
+
+func workloadInteger(v interface{}, min int) (int, bool) {
+	var n int
+
+	switch v := v.(type) {
+	case int64:
+		n = int(v)
+	case float64:
+		if v != float64(int64(v)) {
+			return 0, false
+		}
+		n = int(v)
+	default:
+		return 0, false
+	}
+	return n, n >= min
+}
+
+// duration strings as for txtimeout, or nanoseconds as for timeout
+func workloadDuration(v interface{}) (time.Duration, bool) {
+	switch v := v.(type) {
+	case string:
+		d, e := time.ParseDuration(v)
+		return d, e == nil && d >= 0
+	case int64:
+		return time.Duration(v), v >= 0
+	case float64:
+		return time.Duration(v), v >= 0
+	}
+	return 0, false
+}
+
+func workloadStrings(v interface{}) ([]string, bool) {
+	a, ok := v.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	rv := make([]string, 0, len(a))
+	for _, e := range a {
+		s, ok := e.(string)
+		if !ok || s == "" {
+			return nil, false
+		}
+		rv = append(rv, s)
+	}
+	return rv, true
+}
+
+/*
+The group definition, in the same form accepted by NewWorkloadGroup.
+*/
+func (this *WorkloadGroup) Object() map[string]interface{} {
+	rv := map[string]interface{}{
+		_WG_NAME:        this.Name,
+		_WG_SERVICERS:   this.Servicers,
+		_WG_QUEUE_DEPTH: this.QueueDepth,
+	}
+	if this.Timeout > 0 {
+		rv[_WG_TIMEOUT] = this.Timeout.String()
+	}
+	if this.MemoryQuota > 0 {
+		rv[_WG_MEMORY_QUOTA] = this.MemoryQuota
+	}
+	if this.MaxParallelism > 0 {
+		rv[_WG_MAX_PARALLELISM] = this.MaxParallelism
+	}
+	addStrings := func(n string, a []string) {
+		if len(a) > 0 {
+			l := make([]interface{}, len(a))
+			for i, s := range a {
+				l[i] = s
+			}
+			rv[n] = l
+		}
+	}
+	addStrings(_WG_USERS, this.Users)
+	addStrings(_WG_ROLES, this.Roles)
+	addStrings(_WG_CLIENT_IDS, this.ClientIdPrefixes)
+	addStrings(_WG_QUERY_CONTEXTS, this.QueryContexts)
+	return rv
+}
+
+/*
+Number of requests currently running in the group.
+*/
+func (this *WorkloadGroup) Active() int {
+	if this.queue == nil {
+		return 0
+	}
+	return int(atomic.LoadInt32(&this.queue.runCnt))
+}
+
+/*
+Number of requests currently waiting for a group servicer.
+*/
+func (this *WorkloadGroup) Queued() int {
+	if this.queue == nil {
+		return 0
+	}
+	return int(atomic.LoadInt32(&this.queue.queueCnt))
+}
+
+/*
+Apply the group defaults and limits to a request.
+The timeout is only a default: the server wide timeout still caps it.
+*/
+func (this *WorkloadGroup) apply(request Request) {
+	if this.MaxParallelism > 0 {
+		maxParallelism := request.MaxParallelism()
+		if maxParallelism <= 0 || maxParallelism > this.MaxParallelism {
+			request.SetMaxParallelism(this.MaxParallelism)
+		}
+	}
+	if this.Timeout > 0 && request.Timeout() <= 0 {
+		request.SetTimeout(this.Timeout)
+	}
+	if this.MemoryQuota > 0 {
+		memoryQuota := request.MemoryQuota()
+		if memoryQuota == 0 || memoryQuota > this.MemoryQuota {
+			request.SetMemoryQuota(this.MemoryQuota)
+		}
+	}
+}
+
+type workloadGroups struct {
+	sync.RWMutex
+	groups   map[string]*WorkloadGroup
+	names    []string
+	hasRoles bool
+}
+
+var workloads = &workloadGroups{groups: make(map[string]*WorkloadGroup)}
+
+/*
+Add a workload group, or replace an existing one if replace is set.
+A replaced group keeps its run queue, and the requests in it, unless the
+queue depth changes.
+*/
+func AddWorkloadGroup(group *WorkloadGroup, replace bool) errors.Error {
+	workloads.Lock()
+	defer workloads.Unlock()
+
+	old, ok := workloads.groups[group.Name]
+	if ok && !replace {
+		return errors.NewAdminWorkloadGroupExists(group.Name)
+	}
+	workloads.add(group, old)
+	workloads.refresh()
+	return nil
+}
+
+/*
+Drop a workload group: requests already in its queue are still serviced.
+*/
+func DropWorkloadGroup(name string) bool {
+	workloads.Lock()
+	defer workloads.Unlock()
+
+	group, ok := workloads.groups[name]
+	if ok {
+		group.queue.retire()
+		delete(workloads.groups, name)
+		workloads.refresh()
+	}
+	return ok
+}
+
+/*
+Replace all the workload groups at once, as from /admin/settings.
+*/
+func SetWorkloadGroups(groups []*WorkloadGroup) {
+	workloads.Lock()
+	defer workloads.Unlock()
+
+	oldGroups := workloads.groups
+	workloads.groups = make(map[string]*WorkloadGroup, len(groups))
+	for _, group := range groups {
+		workloads.add(group, oldGroups[group.Name])
+		delete(oldGroups, group.Name)
+	}
+	for _, group := range oldGroups {
+		group.queue.retire()
+	}
+	workloads.refresh()
+}
+
+func WorkloadGroupNames() []string {
+	workloads.RLock()
+	defer workloads.RUnlock()
+
+	return append([]string{}, workloads.names...)
+}
+
+func CountWorkloadGroups() int {
+	workloads.RLock()
+	defer workloads.RUnlock()
+
+	return len(workloads.groups)
+}
+
+/*
+Operate on a workload group, if it exists.
+*/
+func WorkloadGroupDo(name string, f func(*WorkloadGroup)) bool {
+	workloads.RLock()
+	defer workloads.RUnlock()
+
+	group, ok := workloads.groups[name]
+	if ok {
+		f(group)
+	}
+	return ok
+}
+
+/*
+All the group definitions, as returned by /admin/settings.
+*/
+func WorkloadGroupsSetting() []interface{} {
+	workloads.RLock()
+	defer workloads.RUnlock()
+
+	rv := make([]interface{}, len(workloads.names))
+	for i, name := range workloads.names {
+		rv[i] = workloads.groups[name].Object()
+	}
+	return rv
+}
+
+func (this *workloadGroups) load() int {
+	this.RLock()
+	defer this.RUnlock()
+
+	rv := 0
+	for _, group := range this.groups {
+		rv += group.queue.load(0)
+	}
+	return rv
+}
+
+// called with the lock held
+func (this *workloadGroups) add(group *WorkloadGroup, old *WorkloadGroup) {
+	if old != nil && old.QueueDepth == group.QueueDepth {
+		group.queue = old.queue
+		group.queue.servicers = group.Servicers
+	} else {
+		if old != nil {
+			old.queue.retire()
+		}
+		group.queue = &runQueue{servicers: group.Servicers}
+		newRunQueue(group.queue, group.QueueDepth, false)
+	}
+	this.groups[group.Name] = group
+}
+
+// called with the lock held
+func (this *workloadGroups) refresh() {
+	this.names = make([]string, 0, len(this.groups))
+	this.hasRoles = false
+	for name, group := range this.groups {
+		this.names = append(this.names, name)
+		if len(group.Roles) > 0 {
+			this.hasRoles = true
+		}
+	}
+	sort.Strings(this.names)
+}
+
+/*
+Find the group a request belongs to.
+A match by user takes precedence over a match by client context id prefix,
+where the longest prefix wins, then by query context and lastly by role.
+Ties go to the first group in name order.
+*/
+func (this *workloadGroups) classify(request Request, store datastore.Datastore) *WorkloadGroup {
+	users := requestUsers(request)
+
+	this.RLock()
+	if len(this.groups) == 0 {
+		this.RUnlock()
+		return nil
+	}
+	group := this.match(users, request.ClientID().String(), request.QueryContext())
+	hasRoles := this.hasRoles
+	this.RUnlock()
+
+	if group != nil || !hasRoles || len(users) == 0 {
+		return group
+	}
+
+	roles := userRoles.get(users, store)
+	if len(roles) == 0 {
+		return nil
+	}
+
+	this.RLock()
+	defer this.RUnlock()
+	for _, name := range this.names {
+		group := this.groups[name]
+		for _, r := range group.Roles {
+			if roles[r] {
+				return group
+			}
+		}
+	}
+	return nil
+}
+
+// called with the read lock held
+func (this *workloadGroups) match(users []string, clientId, queryContext string) *WorkloadGroup {
+	for _, name := range this.names {
+		group := this.groups[name]
+		for _, u := range group.Users {
+			for _, user := range users {
+				if u == user || u == userName(user) {
+					return group
+				}
+			}
+		}
+	}
+
+	if clientId != "" {
+		var rv *WorkloadGroup
+		longest := 0
+
+		for _, name := range this.names {
+			group := this.groups[name]
+			for _, p := range group.ClientIdPrefixes {
+				if len(p) > longest && strings.HasPrefix(clientId, p) {
+					rv = group
+					longest = len(p)
+				}
+			}
+		}
+		if rv != nil {
+			return rv
+		}
+	}
+
+	if queryContext != "" {
+		for _, name := range this.names {
+			group := this.groups[name]
+			for _, qc := range group.QueryContexts {
+				if qc == queryContext {
+					return group
+				}
+			}
+		}
+	}
+	return nil
+}
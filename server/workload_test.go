@@ -0,0 +1,134 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWorkloadGroups(t *testing.T) {
+	setting := []interface{}{
+		map[string]interface{}{
+			"name":                       "api",
+			"servicers":                  int64(8),
+			"users":                      []interface{}{"api"},
+			"client_context_id_prefixes": []interface{}{"api-"},
+		},
+		map[string]interface{}{
+			"name":                       "batch",
+			"servicers":                  float64(2),
+			"queue_depth":                float64(16),
+			"timeout":                    "10s",
+			"max_parallelism":            float64(4),
+			"client_context_id_prefixes": []interface{}{"api-batch-"},
+		},
+		map[string]interface{}{
+			"name":           "reports",
+			"servicers":      float64(1),
+			"memory_quota":   float64(512),
+			"query_contexts": []interface{}{"default:reports"},
+			"roles":          []interface{}{"query_select[reports]"},
+		},
+	}
+
+	groups, err := getWorkloadGroups(setting)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if groups[1].Timeout != 10*time.Second || groups[1].QueueDepth != 16 || groups[2].MemoryQuota != 512 {
+		t.Errorf("Unexpected groups %+v %+v", groups[1], groups[2])
+	}
+	if groups[0].QueueDepth != _WG_DEF_QUEUE_DEPTH {
+		t.Errorf("Expected default queue depth, got %v", groups[0].QueueDepth)
+	}
+
+	wg := &workloadGroups{groups: make(map[string]*WorkloadGroup)}
+	for _, group := range groups {
+		wg.add(group, nil)
+	}
+	wg.refresh()
+	if !wg.hasRoles || len(wg.names) != 3 {
+		t.Errorf("Unexpected names %v", wg.names)
+	}
+
+	tests := []struct {
+		users        []string
+		clientId     string
+		queryContext string
+		expected     string
+	}{
+		{[]string{"local:api"}, "api-batch-1", "", "api"},
+		{nil, "api-batch-1", "default:reports", "batch"},
+		{nil, "api-1", "", "api"},
+		{[]string{"analyst"}, "", "default:reports", "reports"},
+		{[]string{"analyst"}, "adhoc", "default:travel", ""},
+	}
+
+	for _, test := range tests {
+		name := ""
+		if group := wg.match(test.users, test.clientId, test.queryContext); group != nil {
+			name = group.Name
+		}
+		if name != test.expected {
+			t.Errorf("%v %q %q: expected group %q, got %q", test.users, test.clientId, test.queryContext,
+				test.expected, name)
+		}
+	}
+
+	// replacing a group keeps its queue unless the queue depth changes
+	queue := groups[0].queue
+	api, _ := NewWorkloadGroup(map[string]interface{}{"name": "api", "servicers": float64(4)})
+	wg.add(api, groups[0])
+	if api.queue != queue || queue.servicers != 4 {
+		t.Errorf("Expected the api queue to be kept")
+	}
+	batch, _ := NewWorkloadGroup(map[string]interface{}{"name": "batch", "servicers": float64(2)})
+	wg.add(batch, groups[1])
+	if batch.queue == groups[1].queue || groups[1].queue.retired == 0 {
+		t.Errorf("Expected the batch queue to be retired")
+	}
+
+	invalid := []interface{}{
+		map[string]interface{}{"name": "a"},
+		map[string]interface{}{"name": "a", "servicers": float64(0)},
+		map[string]interface{}{"name": "a", "servicers": float64(1.5)},
+		map[string]interface{}{"name": "a", "servicers": float64(1), "timeout": "soon"},
+		map[string]interface{}{"name": "a", "servicers": float64(1), "users": "api"},
+		map[string]interface{}{"name": "a", "servicers": float64(1), "priority": "high"},
+		map[string]interface{}{"servicers": float64(1)},
+	}
+	for _, def := range invalid {
+		if _, err := getWorkloadGroups([]interface{}{def}); err == nil {
+			t.Errorf("%v: expected an error", def)
+		}
+	}
+	if _, err := getWorkloadGroups([]interface{}{setting[0], setting[0]}); err == nil {
+		t.Errorf("Expected an error for duplicate groups")
+	}
+}
+
+func TestRetiredQueue(t *testing.T) {
+	queue := &runQueue{servicers: 1}
+	newRunQueue(queue, 4, false)
+
+	if !queue.enqueue(nil) {
+		t.Fatalf("Expected the request to run")
+	}
+	queue.dequeue()
+
+	queue.retire()
+	if queue.enqueue(nil) {
+		t.Errorf("Expected a retired queue to refuse requests")
+	}
+	if queue.runCnt != 0 || queue.queueCnt != 0 {
+		t.Errorf("Unexpected counters %v %v", queue.runCnt, queue.queueCnt)
+	}
+}
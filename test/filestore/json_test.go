@@ -10,10 +10,14 @@
 package test
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/plan"
+	"github.com/couchbase/query/prepareds"
 	"io/ioutil"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -198,6 +202,45 @@ func TestSimpleSelect(t *testing.T) {
 	}
 }
 
+func TestRecursivePrepared(t *testing.T) {
+	qc := start()
+
+	stmt := "WITH RECURSIVE g AS (SELECT \"a\" AS id UNION ALL SELECT e.dst AS id FROM g " +
+		"UNNEST [{\"src\": \"a\", \"dst\": \"b\"}, {\"src\": \"b\", \"dst\": \"a\"}] AS e " +
+		"WHERE e.src = g.id) CYCLE id RESTRICT OPTIONS {\"levels\": 5} SELECT g.id FROM g ORDER BY g.id"
+	expected, _, err := Run(qc, true, stmt, nil, nil, _NAMESPACE)
+	if err != nil {
+		t.Fatalf("did not expect err %s", err.Error())
+	}
+	if len(expected) != 2 {
+		t.Fatalf("expected 2 results, got %v", expected)
+	}
+
+	r, _, err := Run(qc, true, "PREPARE recursive_cycle FROM "+stmt, nil, nil, _NAMESPACE)
+	if err != nil || len(r) != 1 {
+		t.Fatalf("unable to prepare: %v", err)
+	}
+
+	// execute the plan as decoded, as it is when distributed
+	bytes, _ := json.Marshal(r[0])
+	prepared := plan.NewPrepared(nil, nil, nil)
+	if e := prepared.UnmarshalJSON(bytes); e != nil {
+		t.Fatalf("unable to unmarshal prepared: %v", e)
+	}
+	err = prepareds.AddPrepared(prepared)
+	if err != nil {
+		t.Fatalf("unable to add prepared: %s", err.Error())
+	}
+
+	actual, _, err := Run(qc, true, "EXECUTE recursive_cycle", nil, nil, _NAMESPACE)
+	if err != nil {
+		t.Fatalf("did not expect err %s", err.Error())
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
 func TestAllCaseFiles(t *testing.T) {
 	qc := start()
 	matches, err := filepath.Glob("json/default/cases/case_*.json")
@@ -0,0 +1,193 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/couchbase/query/util"
+)
+
+const _SERVICE_NAME = "cbq-engine"
+const _SCOPE_NAME = "github.com/couchbase/query"
+
+// OTLP status codes
+const _STATUS_ERROR = 2
+
+// the OTLP JSON encoding of an export request
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func newOtlpAttribute(key string, val interface{}) otlpAttribute {
+	var v map[string]interface{}
+
+	// 64 bit integers are encoded as strings in OTLP JSON
+	switch val := val.(type) {
+	case bool:
+		v = map[string]interface{}{"boolValue": val}
+	case int:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(int64(val), 10)}
+	case int64:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(val, 10)}
+	case uint64:
+		v = map[string]interface{}{"intValue": strconv.FormatUint(val, 10)}
+	case float64:
+		v = map[string]interface{}{"doubleValue": val}
+	case string:
+		v = map[string]interface{}{"stringValue": val}
+	case time.Duration:
+		v = map[string]interface{}{"stringValue": val.String()}
+	default:
+		v = map[string]interface{}{"stringValue": fmt.Sprint(val)}
+	}
+	return otlpAttribute{Key: key, Value: v}
+}
+
+func encodeSpans(spans []*Span) ([]byte, error) {
+	hostName, _ := os.Hostname()
+	encoded := make([]otlpSpan, len(spans))
+	for i, span := range spans {
+		span.Lock()
+		s := &encoded[i]
+		s.TraceId = hex.EncodeToString(span.traceId[:])
+		s.SpanId = hex.EncodeToString(span.spanId[:])
+		if span.parentId != [8]byte{} {
+			s.ParentSpanId = hex.EncodeToString(span.parentId[:])
+		}
+		s.Name = span.name
+		s.Kind = span.kind
+		s.StartTimeUnixNano = strconv.FormatInt(span.start.UnixNano(), 10)
+		s.EndTimeUnixNano = strconv.FormatInt(span.end.UnixNano(), 10)
+		for k, v := range span.attributes {
+			s.Attributes = append(s.Attributes, newOtlpAttribute(k, v))
+		}
+		if span.err != "" {
+			s.Status = &otlpStatus{Code: _STATUS_ERROR, Message: span.err}
+		}
+		span.Unlock()
+	}
+
+	return json.Marshal(&otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: []otlpAttribute{
+				newOtlpAttribute("service.name", _SERVICE_NAME),
+				newOtlpAttribute("service.version", util.VERSION),
+				newOtlpAttribute("host.name", hostName),
+			}},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: _SCOPE_NAME, Version: util.VERSION},
+				Spans: encoded,
+			}},
+		}},
+	})
+}
+
+// one export request per line
+type stdoutExporter struct {
+	sync.Mutex
+	out io.Writer
+}
+
+func newStdoutExporter() *stdoutExporter {
+	return &stdoutExporter{out: os.Stdout}
+}
+
+func (this *stdoutExporter) export(spans []*Span) error {
+	b, err := encodeSpans(spans)
+	if err != nil {
+		return err
+	}
+	this.Lock()
+	defer this.Unlock()
+	_, err = this.out.Write(append(b, '\n'))
+	return err
+}
+
+// OTLP over HTTP, as accepted by collectors on port 4318
+type otlpExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newOtlpExporter(endpoint string) *otlpExporter {
+	return &otlpExporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (this *otlpExporter) export(spans []*Span) error {
+	b, err := encodeSpans(spans)
+	if err != nil {
+		return err
+	}
+	resp, err := this.client.Post(this.endpoint, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%v returned %v", this.endpoint, resp.Status)
+	}
+	return nil
+}
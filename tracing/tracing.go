@@ -0,0 +1,326 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+/*
+Package tracing emits OpenTelemetry spans for the request lifecycle and
+for execution operators.
+
+Incoming W3C traceparent headers are honored, so that requests join the
+distributed trace of the caller, and spans are exported in batches, in
+the OTLP JSON encoding, either to stdout or to a collector over HTTP.
+When no exporter is configured, no span is ever created: all Span
+methods accept a nil receiver, so that callers need no checks.
+*/
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	atomic "github.com/couchbase/go-couchbase/platform"
+	"github.com/couchbase/query/logging"
+)
+
+const (
+	EXPORTER_NONE   = ""
+	EXPORTER_STDOUT = "stdout"
+	EXPORTER_OTLP   = "otlp"
+)
+
+const DEF_OTLP_ENDPOINT = "http://localhost:4318/v1/traces"
+
+// span kinds, as defined by OTLP
+const (
+	_KIND_INTERNAL = 1
+	_KIND_SERVER   = 2
+)
+
+// operators in correlated subqueries run once per row:
+// past this, operators are not traced
+const _MAX_CHILDREN = 1024
+
+const (
+	_QUEUE_SIZE     = 4096
+	_BATCH_SIZE     = 512
+	_BATCH_INTERVAL = 5 * time.Second
+)
+
+type Span struct {
+	sync.Mutex
+	name       string
+	kind       int
+	traceId    [16]byte
+	spanId     [8]byte
+	parentId   [8]byte
+	start      time.Time
+	end        time.Time
+	attributes map[string]interface{}
+	err        string
+	children   uint32
+}
+
+type exporter interface {
+	export(spans []*Span) error
+}
+
+type tracer struct {
+	exporter   exporter
+	threshold  uint64
+	statements bool
+	spans      chan *Span
+	dropped    atomic.AlignedUint64
+}
+
+var tracing *tracer
+
+/*
+Start exporting spans.
+The sample ratio applies to requests that are not already part of a trace:
+for the others, the sampling decision of the caller is honored.
+Statements contain user data, and are only exported if asked for.
+*/
+func Init(exporterName, endpoint string, ratio float64, statements bool) error {
+	var e exporter
+
+	switch strings.ToLower(exporterName) {
+	case EXPORTER_NONE:
+		return nil
+	case EXPORTER_STDOUT:
+		e = newStdoutExporter()
+	case EXPORTER_OTLP:
+		if endpoint == "" {
+			endpoint = DEF_OTLP_ENDPOINT
+		}
+		e = newOtlpExporter(endpoint)
+	default:
+		return fmt.Errorf("Invalid trace exporter %v", exporterName)
+	}
+	if ratio < 0 || ratio > 1 {
+		return fmt.Errorf("Invalid trace sample ratio %v", ratio)
+	}
+
+	t := &tracer{
+		exporter:   e,
+		threshold:  uint64(ratio * math.MaxUint64),
+		statements: statements,
+		spans:      make(chan *Span, _QUEUE_SIZE),
+	}
+	if ratio == 1 {
+		t.threshold = math.MaxUint64
+	}
+	go t.run()
+	tracing = t
+	logging.Infop("Tracing enabled", logging.Pair{"exporter", exporterName}, logging.Pair{"sample", ratio},
+		logging.Pair{"statements", statements})
+	return nil
+}
+
+func Enabled() bool {
+	return tracing != nil
+}
+
+func Statements() bool {
+	return tracing != nil && tracing.statements
+}
+
+/*
+Start the span of a request, received at the time given.
+The request joins the trace in the traceparent header, if valid.
+Returns nil if tracing is disabled, or the request is not sampled.
+*/
+func StartRequest(name string, traceparent string, start time.Time) *Span {
+	if tracing == nil {
+		return nil
+	}
+
+	rv := &Span{name: name, kind: _KIND_SERVER, start: start}
+	traceId, parentId, flags, ok := ParseTraceparent(traceparent)
+	if ok {
+		if flags&0x01 == 0 {
+			return nil
+		}
+		rv.traceId = traceId
+		rv.parentId = parentId
+	} else {
+		rand.Read(rv.traceId[:])
+
+		// the last eight bytes of the trace id are random, and can be used for sampling
+		sample := uint64(0)
+		for _, b := range rv.traceId[8:] {
+			sample = sample<<8 | uint64(b)
+		}
+		if sample > tracing.threshold {
+			return nil
+		}
+	}
+	rand.Read(rv.spanId[:])
+	return rv
+}
+
+/*
+Parse a W3C traceparent header, of the form
+version-traceid-parentid-flags, with all fields in lower case hex.
+*/
+func ParseTraceparent(header string) (traceId [16]byte, parentId [8]byte, flags byte, ok bool) {
+	fields := strings.Split(strings.TrimSpace(header), "-")
+	if len(fields) < 4 || len(fields[0]) != 2 || fields[0] == "ff" {
+		return
+	}
+
+	// version 00 has exactly four fields, later versions can add more
+	if fields[0] == "00" && len(fields) != 4 {
+		return
+	}
+	if !decodeHex(traceId[:], fields[1]) || !decodeHex(parentId[:], fields[2]) {
+		return
+	}
+	var f [1]byte
+	if !decodeHex(f[:], fields[3]) {
+		return
+	}
+	if traceId == [16]byte{} || parentId == [8]byte{} {
+		return
+	}
+	return traceId, parentId, f[0], true
+}
+
+func decodeHex(dest []byte, s string) bool {
+	if len(s) != 2*len(dest) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dest, []byte(s))
+	return err == nil
+}
+
+/*
+Start a child span of this one.
+*/
+func (this *Span) Child(name string) *Span {
+	return this.ChildAt(name, time.Now())
+}
+
+func (this *Span) ChildAt(name string, start time.Time) *Span {
+	if this == nil || atomic.AddUint32(&this.children, 1) > _MAX_CHILDREN {
+		return nil
+	}
+	rv := &Span{name: name, kind: _KIND_INTERNAL, start: start, traceId: this.traceId, parentId: this.spanId}
+	rand.Read(rv.spanId[:])
+	return rv
+}
+
+/*
+Add a child span for a phase that has just completed.
+*/
+func (this *Span) Record(name string, duration time.Duration) {
+	if this == nil {
+		return
+	}
+	now := time.Now()
+	child := this.ChildAt(name, now.Add(-duration))
+	if child != nil {
+		child.endAt(now)
+	}
+}
+
+func (this *Span) SetAttribute(key string, val interface{}) {
+	if this == nil {
+		return
+	}
+	this.Lock()
+	if this.attributes == nil {
+		this.attributes = make(map[string]interface{}, 8)
+	}
+	this.attributes[key] = val
+	this.Unlock()
+}
+
+func (this *Span) SetError(msg string) {
+	if this == nil {
+		return
+	}
+	this.Lock()
+	this.err = msg
+	this.Unlock()
+}
+
+/*
+The traceparent header identifying this span to downstream services.
+*/
+func (this *Span) Traceparent() string {
+	if this == nil {
+		return ""
+	}
+	return "00-" + hex.EncodeToString(this.traceId[:]) + "-" + hex.EncodeToString(this.spanId[:]) + "-01"
+}
+
+func (this *Span) TraceId() string {
+	if this == nil {
+		return ""
+	}
+	return hex.EncodeToString(this.traceId[:])
+}
+
+/*
+End the span, and queue it for export.
+Spans are dropped, rather than slowing down requests, if the exporter
+can't keep up.
+*/
+func (this *Span) End() {
+	if this == nil {
+		return
+	}
+	this.endAt(time.Now())
+}
+
+func (this *Span) endAt(end time.Time) {
+	t := tracing
+	if t == nil {
+		return
+	}
+	this.Lock()
+	this.end = end
+	this.Unlock()
+	select {
+	case t.spans <- this:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+func (this *tracer) run() {
+	ticker := time.NewTicker(_BATCH_INTERVAL)
+	batch := make([]*Span, 0, _BATCH_SIZE)
+	for {
+		select {
+		case span := <-this.spans:
+			batch = append(batch, span)
+			if len(batch) < _BATCH_SIZE {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		err := this.exporter.export(batch)
+		if err != nil {
+			logging.Errorp("Cannot export spans", logging.Pair{"spans", len(batch)}, logging.Pair{"error", err})
+		}
+		if dropped := atomic.LoadUint64(&this.dropped); dropped > 0 {
+			atomic.AddUint64(&this.dropped, ^(dropped - 1))
+			logging.Warnp("Spans dropped", logging.Pair{"spans", dropped})
+		}
+		batch = make([]*Span, 0, _BATCH_SIZE)
+	}
+}
@@ -0,0 +1,117 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package tracing
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraceparent(t *testing.T) {
+	valid := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	traceId, parentId, flags, ok := ParseTraceparent(valid)
+	if !ok || flags != 1 || traceId[0] != 0x4b || parentId[7] != 0xb7 {
+		t.Errorf("Unexpected parse of %v: %v %v %v %v", valid, traceId, parentId, flags, ok)
+	}
+
+	// later versions can add fields
+	if _, _, _, ok := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); !ok {
+		t.Errorf("Expected future versions to be accepted")
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-x1",
+	}
+	for _, header := range invalid {
+		if _, _, _, ok := ParseTraceparent(header); ok {
+			t.Errorf("Expected %q to be rejected", header)
+		}
+	}
+}
+
+func TestSpans(t *testing.T) {
+	defer func() { tracing = nil }()
+
+	if span := StartRequest("request", "", time.Now()); span != nil {
+		t.Errorf("Expected no span with tracing disabled")
+	}
+
+	tracing = &tracer{threshold: math.MaxUint64, spans: make(chan *Span, 8)}
+	if Statements() {
+		t.Errorf("Expected statements not to be exported unless asked for")
+	}
+
+	// the caller's trace is joined, and its sampling decision honored
+	request := StartRequest("request", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", time.Now())
+	if request.TraceId() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("Unexpected trace id %v", request.TraceId())
+	}
+	if StartRequest("request", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", time.Now()) != nil {
+		t.Errorf("Expected unsampled requests not to be traced")
+	}
+	if !strings.HasPrefix(request.Traceparent(), "00-4bf92f3577b34da6a3ce929d0e0e4736-") ||
+		strings.Contains(request.Traceparent(), "00f067aa0ba902b7") {
+		t.Errorf("Unexpected traceparent %v", request.Traceparent())
+	}
+
+	scan := request.Child("indexScan")
+	scan.SetAttribute("items_out", int64(10))
+	scan.End()
+	request.Record("parse", time.Millisecond)
+	request.SetError("timeout")
+	request.End()
+
+	spans := []*Span{<-tracing.spans, <-tracing.spans, <-tracing.spans}
+	if spans[0] != scan || spans[2] != request || spans[1].parentId != request.spanId {
+		t.Fatalf("Unexpected spans %v", spans)
+	}
+	if spans[1].end.Sub(spans[1].start) != time.Millisecond {
+		t.Errorf("Unexpected parse duration %v", spans[1].end.Sub(spans[1].start))
+	}
+
+	b, err := encodeSpans(spans)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	var encoded otlpRequest
+	if err = json.Unmarshal(b, &encoded); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	s := encoded.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(s) != 3 || s[0].ParentSpanId != s[2].SpanId || s[2].ParentSpanId != "00f067aa0ba902b7" {
+		t.Errorf("Unexpected encoding %s", b)
+	}
+	if s[0].Attributes[0].Value["intValue"] != "10" || s[2].Status == nil || s[2].Kind != _KIND_SERVER {
+		t.Errorf("Unexpected encoding %s", b)
+	}
+
+	request.children = _MAX_CHILDREN
+	if request.Child("fetch") != nil {
+		t.Errorf("Expected no more children")
+	}
+	request.Record("run", time.Millisecond)
+
+	// nothing is sampled at a zero ratio
+	tracing.threshold = 0
+	if StartRequest("request", "", time.Now()) != nil {
+		t.Errorf("Expected the request not to be sampled")
+	}
+}
@@ -172,6 +172,10 @@ func (this *HashTable) Get(hashVal interface{}, marshal func(interface{}) ([]byt
 
 	this.mode = HASH_TABLE_GET
 
+	// forget any position left by a previous Get() or Iterate()
+	this.bucket = -1
+	this.vector = -1
+
 	hashKey, err := this.getHashKey(hashVal, marshal)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,282 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+/*
+HyperLogLog estimates the number of distinct items in a stream in a fixed
+amount of memory. Each item is hashed to 64 bits: the first p bits select
+one of 2^p registers, which keeps the largest position of the leftmost one
+bit in the remaining bits seen so far. The standard error is 1.04/sqrt(2^p).
+
+Sketches are mergeable by taking the register wise maximum, so that partial
+results from different pipelines or nodes can be combined in any order.
+Sketches of different precisions are merged at the lower one.
+
+Small sketches keep the registers that are set in a map, and switch to the
+full array of registers once that would take less memory.
+*/
+package util
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+const (
+	HLL_MIN_PRECISION     = 4
+	HLL_MAX_PRECISION     = 18
+	HLL_DEFAULT_PRECISION = 14
+)
+
+const (
+	_HLL_MAGIC  = 'H'
+	_HLL_DENSE  = 1
+	_HLL_SPARSE = 2
+)
+
+type HyperLogLog struct {
+	precision uint8
+	registers []uint8
+	sparse    map[uint32]uint8
+}
+
+func NewHyperLogLog(precision int) (*HyperLogLog, error) {
+	if precision < HLL_MIN_PRECISION || precision > HLL_MAX_PRECISION {
+		return nil, fmt.Errorf("Invalid HyperLogLog precision %d, it must be between %d and %d.",
+			precision, HLL_MIN_PRECISION, HLL_MAX_PRECISION)
+	}
+	return &HyperLogLog{
+		precision: uint8(precision),
+		sparse:    make(map[uint32]uint8),
+	}, nil
+}
+
+func (this *HyperLogLog) Precision() int {
+	return int(this.precision)
+}
+
+func (this *HyperLogLog) size() uint32 {
+	return 1 << this.precision
+}
+
+// Add an item, given its 64 bit hash
+func (this *HyperLogLog) Add(hash uint64) {
+	p := this.precision
+	index := uint32(hash >> (64 - p))
+	rank := uint8(bits.LeadingZeros64(hash<<p|1<<(p-1))) + 1
+	this.set(index, rank)
+}
+
+// AddBytes hashes the given bytes and adds them
+func (this *HyperLogLog) AddBytes(b []byte) {
+	this.Add(SeaHashSum64(b))
+}
+
+func (this *HyperLogLog) set(index uint32, rank uint8) {
+	if this.registers != nil {
+		if rank > this.registers[index] {
+			this.registers[index] = rank
+		}
+		return
+	}
+
+	if rank > this.sparse[index] {
+		this.sparse[index] = rank
+
+		// a map entry takes more than 8 bytes, a register one
+		if uint32(len(this.sparse)) > this.size()/8 {
+			this.densify()
+		}
+	}
+}
+
+func (this *HyperLogLog) densify() {
+	this.registers = make([]uint8, this.size())
+	for index, rank := range this.sparse {
+		this.registers[index] = rank
+	}
+	this.sparse = nil
+}
+
+func (this *HyperLogLog) forEach(f func(index uint32, rank uint8)) {
+	if this.registers != nil {
+		for index, rank := range this.registers {
+			if rank != 0 {
+				f(uint32(index), rank)
+			}
+		}
+	} else {
+		for index, rank := range this.sparse {
+			f(index, rank)
+		}
+	}
+}
+
+// Merge another sketch into this one
+func (this *HyperLogLog) Merge(other *HyperLogLog) {
+	if other.precision < this.precision {
+		this.reduce(other.precision)
+	}
+
+	shift := other.precision - this.precision
+	other.forEach(func(index uint32, rank uint8) {
+		this.set(reducedRegister(index, rank, shift))
+	})
+}
+
+// Lower the precision of the sketch in place
+func (this *HyperLogLog) reduce(precision uint8) {
+	shift := this.precision - precision
+	old := *this
+	this.precision = precision
+	this.registers = nil
+	this.sparse = make(map[uint32]uint8)
+	old.forEach(func(index uint32, rank uint8) {
+		this.set(reducedRegister(index, rank, shift))
+	})
+}
+
+/*
+The register and rank an item would have had at a precision lower by shift
+bits: the low bits of the index become the leading bits of the remainder.
+*/
+func reducedRegister(index uint32, rank uint8, shift uint8) (uint32, uint8) {
+	if shift == 0 {
+		return index, rank
+	}
+	dropped := index & (1<<shift - 1)
+	if dropped != 0 {
+		return index >> shift, shift - uint8(bits.Len32(dropped)) + 1
+	}
+	return index >> shift, shift + rank
+}
+
+// Count returns the estimated number of distinct items added
+func (this *HyperLogLog) Count() uint64 {
+	m := float64(this.size())
+	sum := 0.0
+	zeros := this.size()
+	this.forEach(func(index uint32, rank uint8) {
+		sum += math.Ldexp(1.0, -int(rank))
+		zeros--
+	})
+	sum += float64(zeros)
+
+	var alpha float64
+	switch this.size() {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1.0 + 1.079/m)
+	}
+
+	estimate := alpha * m * m / sum
+
+	// linear counting is more accurate for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+/*
+Binary format: magic, encoding and precision, then either all the registers,
+or the index and rank of each register set, as kept in memory.
+*/
+func (this *HyperLogLog) MarshalBinary() ([]byte, error) {
+	if this.registers != nil {
+		rv := make([]byte, 3, 3+this.size())
+		rv[0], rv[1], rv[2] = _HLL_MAGIC, _HLL_DENSE, this.precision
+		return append(rv, this.registers...), nil
+	}
+
+	entries := make([]uint32, 0, len(this.sparse))
+	for index, rank := range this.sparse {
+		entries = append(entries, index<<8|uint32(rank))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i] < entries[j] })
+
+	rv := make([]byte, 3+4*len(entries))
+	rv[0], rv[1], rv[2] = _HLL_MAGIC, _HLL_SPARSE, this.precision
+	for i, entry := range entries {
+		binary.BigEndian.PutUint32(rv[3+4*i:], entry)
+	}
+	return rv, nil
+}
+
+func (this *HyperLogLog) UnmarshalBinary(data []byte) error {
+	if len(data) < 3 || data[0] != _HLL_MAGIC {
+		return fmt.Errorf("Invalid HyperLogLog sketch.")
+	}
+
+	precision := int(data[2])
+	hll, err := NewHyperLogLog(precision)
+	if err != nil {
+		return err
+	}
+
+	encoding := data[1]
+	data = data[3:]
+	maxRank := uint8(64 - precision + 1)
+	switch {
+	case encoding == _HLL_DENSE && len(data) == int(hll.size()):
+		hll.densify()
+		for index, rank := range data {
+			if rank > maxRank {
+				return fmt.Errorf("Invalid HyperLogLog sketch.")
+			}
+			hll.registers[index] = rank
+		}
+	case encoding == _HLL_SPARSE && len(data)%4 == 0:
+		for ; len(data) > 0; data = data[4:] {
+			entry := binary.BigEndian.Uint32(data)
+			index, rank := entry>>8, uint8(entry)
+			if index >= hll.size() || rank == 0 || rank > maxRank {
+				return fmt.Errorf("Invalid HyperLogLog sketch.")
+			}
+			hll.set(index, rank)
+		}
+	default:
+		return fmt.Errorf("Invalid HyperLogLog sketch.")
+	}
+
+	*this = *hll
+	return nil
+}
+
+// String returns the sketch serialized and encoded in base64
+func (this *HyperLogLog) String() string {
+	b, _ := this.MarshalBinary()
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func (this *HyperLogLog) MarshalJSON() ([]byte, error) {
+	return []byte("\"" + this.String() + "\""), nil
+}
+
+// ParseHyperLogLog decodes a sketch returned by String
+func ParseHyperLogLog(s string) (*HyperLogLog, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid HyperLogLog sketch.")
+	}
+	rv := &HyperLogLog{}
+	err = rv.UnmarshalBinary(b)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
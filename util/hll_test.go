@@ -0,0 +1,78 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package util
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func hllOf(t *testing.T, precision, from, to int) *HyperLogLog {
+	hll, err := NewHyperLogLog(precision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := from; i < to; i++ {
+		hll.AddBytes([]byte(strconv.Itoa(i)))
+	}
+	return hll
+}
+
+func checkCount(t *testing.T, what string, hll *HyperLogLog, expected int) {
+	count := float64(hll.Count())
+	if math.Abs(count-float64(expected)) > 0.03*float64(expected) {
+		t.Errorf("%s: expected about %d, got %v", what, expected, count)
+	}
+}
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{10, 1000, 100000} {
+		hll := hllOf(t, HLL_DEFAULT_PRECISION, 0, n)
+		checkCount(t, "count "+strconv.Itoa(n), hll, n)
+
+		// duplicates do not count
+		hll.Merge(hllOf(t, HLL_DEFAULT_PRECISION, 0, n))
+		checkCount(t, "self merge "+strconv.Itoa(n), hll, n)
+
+		// round trip, sparse and dense
+		rt, err := ParseHyperLogLog(hll.String())
+		if err != nil || rt.Count() != hll.Count() || rt.String() != hll.String() {
+			t.Errorf("round trip %d: expected %v, got %v, %v", n, hll.Count(), rt, err)
+		}
+	}
+
+	// merging overlapping sketches
+	hll := hllOf(t, HLL_DEFAULT_PRECISION, 0, 60000)
+	hll.Merge(hllOf(t, HLL_DEFAULT_PRECISION, 40000, 100000))
+	checkCount(t, "merge", hll, 100000)
+
+	// merging at different precisions gives the lower precision sketch
+	low := hllOf(t, 10, 0, 50000)
+	high := hllOf(t, 12, 0, 50000)
+	high.Merge(low)
+	if high.Precision() != 10 || high.String() != low.String() {
+		t.Errorf("merge at precision 10 differs from direct sketch")
+	}
+	low = hllOf(t, 10, 0, 50000)
+	low.Merge(hllOf(t, 12, 0, 50000))
+	if low.String() != high.String() {
+		t.Errorf("merge of precision 12 differs from direct sketch")
+	}
+
+	if _, err := NewHyperLogLog(HLL_MAX_PRECISION + 1); err == nil {
+		t.Errorf("expected an invalid precision error")
+	}
+	for _, s := range []string{"", "SA4=", "not base64", hll.String()[:100]} {
+		if _, err := ParseHyperLogLog(s); err == nil {
+			t.Errorf("expected an invalid sketch error for %s", s)
+		}
+	}
+}
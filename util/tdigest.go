@@ -0,0 +1,267 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+/*
+TDigest estimates quantiles of a stream of numbers in a bounded amount of
+memory. It is a merging t-digest: values are buffered, and every so often
+sorted together with the existing centroids, adjacent centroids being
+combined as long as the result stays within the size allowed at its
+quantile by the arcsine scale function. This keeps centroids small near the
+tails, so that extreme quantiles are the most accurate. The number of
+centroids is bounded by about the compression.
+
+Digests are mergeable by compressing the centroids of both together, so
+that partial results from different pipelines or nodes can be combined in
+any order.
+*/
+package util
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	TDIGEST_MIN_COMPRESSION     = 10
+	TDIGEST_MAX_COMPRESSION     = 10000
+	TDIGEST_DEFAULT_COMPRESSION = 100
+)
+
+const (
+	_TDIGEST_MAGIC   = 'T'
+	_TDIGEST_VERSION = 1
+)
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+type TDigest struct {
+	compression float64
+	centroids   []centroid
+	buffer      []centroid
+	count       float64
+	min         float64
+	max         float64
+}
+
+func NewTDigest(compression int) (*TDigest, error) {
+	if compression < TDIGEST_MIN_COMPRESSION || compression > TDIGEST_MAX_COMPRESSION {
+		return nil, fmt.Errorf("Invalid t-digest compression %d, it must be between %d and %d.",
+			compression, TDIGEST_MIN_COMPRESSION, TDIGEST_MAX_COMPRESSION)
+	}
+	return &TDigest{
+		compression: float64(compression),
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}, nil
+}
+
+func (this *TDigest) Compression() int {
+	return int(this.compression)
+}
+
+// Count returns the total weight of the values added
+func (this *TDigest) Count() float64 {
+	return this.count
+}
+
+// Add a value, NaN and infinities are ignored
+func (this *TDigest) Add(x float64) {
+	this.add(x, 1.0)
+}
+
+func (this *TDigest) add(mean, weight float64) {
+	if math.IsNaN(mean) || math.IsInf(mean, 0) || weight <= 0.0 {
+		return
+	}
+	this.buffer = append(this.buffer, centroid{mean, weight})
+	this.count += weight
+	this.min = math.Min(this.min, mean)
+	this.max = math.Max(this.max, mean)
+	if len(this.buffer) >= 5*int(this.compression) {
+		this.compress()
+	}
+}
+
+// Merge another digest into this one
+func (this *TDigest) Merge(other *TDigest) {
+	if other.count == 0.0 {
+		return
+	}
+	this.buffer = append(this.buffer, other.centroids...)
+	this.buffer = append(this.buffer, other.buffer...)
+	this.count += other.count
+	this.min = math.Min(this.min, other.min)
+	this.max = math.Max(this.max, other.max)
+	this.compress()
+}
+
+// the arcsine scale function, in units of centroid size
+func (this *TDigest) scale(q float64) float64 {
+	q = math.Min(math.Max(q, 0.0), 1.0)
+	return this.compression / (2.0 * math.Pi) * math.Asin(2.0*q-1.0)
+}
+
+func (this *TDigest) compress() {
+	if len(this.buffer) == 0 {
+		return
+	}
+
+	all := append(this.centroids, this.buffer...)
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+
+	rv := all[:1]
+	seen := 0.0
+	limit := this.scale(0.0) + 1.0
+	for _, c := range all[1:] {
+		last := &rv[len(rv)-1]
+		if this.scale((seen+last.weight+c.weight)/this.count) <= limit {
+			last.weight += c.weight
+			last.mean += (c.mean - last.mean) * c.weight / last.weight
+		} else {
+			seen += last.weight
+			limit = this.scale(seen/this.count) + 1.0
+			rv = append(rv, c)
+		}
+	}
+
+	this.centroids = append(make([]centroid, 0, len(rv)), rv...)
+	this.buffer = this.buffer[:0]
+}
+
+/*
+Quantile returns the estimated value at quantile q, between 0 and 1, or NaN
+if no values were added. Each centroid stands for its weight at the middle
+of its position, and values in between are interpolated linearly. Like
+PERCENTILE_CONT, the value at q is taken at position q * (count - 1), so
+that the result is exact as long as no centroids have been combined.
+*/
+func (this *TDigest) Quantile(q float64) float64 {
+	this.compress()
+	if len(this.centroids) == 0 {
+		return math.NaN()
+	}
+
+	pos := q*(this.count-1.0) + 0.5
+	first := this.centroids[0]
+	if pos <= first.weight/2.0 {
+		return interpolate(pos, 0.5, this.min, first.weight/2.0, first.mean)
+	}
+
+	seen := 0.0
+	for i := 0; i < len(this.centroids)-1; i++ {
+		c, next := this.centroids[i], this.centroids[i+1]
+		mid := seen + c.weight/2.0
+		nextMid := seen + c.weight + next.weight/2.0
+		if pos <= nextMid {
+			return interpolate(pos, mid, c.mean, nextMid, next.mean)
+		}
+		seen += c.weight
+	}
+
+	last := this.centroids[len(this.centroids)-1]
+	return interpolate(pos, this.count-last.weight/2.0, last.mean, this.count-0.5, this.max)
+}
+
+func interpolate(x, x0, y0, x1, y1 float64) float64 {
+	if x <= x0 || x1 <= x0 {
+		return y0
+	} else if x >= x1 {
+		return y1
+	}
+	return y0 + (x-x0)*(y1-y0)/(x1-x0)
+}
+
+/*
+Binary format: magic, version, compression, count of centroids, min, max,
+then the mean and weight of each centroid, all numbers big endian.
+*/
+func (this *TDigest) MarshalBinary() ([]byte, error) {
+	this.compress()
+	rv := make([]byte, 2+4+4+16+16*len(this.centroids))
+	rv[0], rv[1] = _TDIGEST_MAGIC, _TDIGEST_VERSION
+	binary.BigEndian.PutUint32(rv[2:], uint32(this.compression))
+	binary.BigEndian.PutUint32(rv[6:], uint32(len(this.centroids)))
+	binary.BigEndian.PutUint64(rv[10:], math.Float64bits(this.min))
+	binary.BigEndian.PutUint64(rv[18:], math.Float64bits(this.max))
+	for i, c := range this.centroids {
+		binary.BigEndian.PutUint64(rv[26+16*i:], math.Float64bits(c.mean))
+		binary.BigEndian.PutUint64(rv[34+16*i:], math.Float64bits(c.weight))
+	}
+	return rv, nil
+}
+
+func (this *TDigest) UnmarshalBinary(data []byte) error {
+	if len(data) < 26 || data[0] != _TDIGEST_MAGIC || data[1] != _TDIGEST_VERSION {
+		return fmt.Errorf("Invalid t-digest sketch.")
+	}
+
+	td, err := NewTDigest(int(binary.BigEndian.Uint32(data[2:])))
+	if err != nil {
+		return err
+	}
+
+	n := int(binary.BigEndian.Uint32(data[6:]))
+	if n < 0 || len(data) != 26+16*n {
+		return fmt.Errorf("Invalid t-digest sketch.")
+	}
+
+	min := math.Float64frombits(binary.BigEndian.Uint64(data[10:]))
+	max := math.Float64frombits(binary.BigEndian.Uint64(data[18:]))
+	if n > 0 && !(min <= max) {
+		return fmt.Errorf("Invalid t-digest sketch.")
+	}
+	td.centroids = make([]centroid, n)
+	for i := range td.centroids {
+		c := centroid{
+			mean:   math.Float64frombits(binary.BigEndian.Uint64(data[26+16*i:])),
+			weight: math.Float64frombits(binary.BigEndian.Uint64(data[34+16*i:])),
+		}
+		if !(c.weight > 0.0) || math.IsInf(c.weight, 0) || math.IsNaN(c.mean) || math.IsInf(c.mean, 0) {
+			return fmt.Errorf("Invalid t-digest sketch.")
+		}
+		td.centroids[i] = c
+		td.count += c.weight
+		td.min = math.Min(td.min, math.Min(min, c.mean))
+		td.max = math.Max(td.max, math.Max(max, c.mean))
+	}
+	sort.SliceStable(td.centroids, func(i, j int) bool { return td.centroids[i].mean < td.centroids[j].mean })
+
+	*this = *td
+	return nil
+}
+
+// String returns the digest serialized and encoded in base64
+func (this *TDigest) String() string {
+	b, _ := this.MarshalBinary()
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func (this *TDigest) MarshalJSON() ([]byte, error) {
+	return []byte("\"" + this.String() + "\""), nil
+}
+
+// ParseTDigest decodes a digest returned by String
+func ParseTDigest(s string) (*TDigest, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid t-digest sketch.")
+	}
+	rv := &TDigest{}
+	err = rv.UnmarshalBinary(b)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
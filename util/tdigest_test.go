@@ -0,0 +1,75 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package util
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestTDigest(t *testing.T) {
+	td, err := NewTDigest(TDIGEST_DEFAULT_COMPRESSION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := td.Quantile(0.5); !math.IsNaN(q) {
+		t.Errorf("empty digest: expected NaN, got %v", q)
+	}
+
+	// exact while no centroids are combined
+	for _, x := range []float64{3, 1, 4, 2} {
+		td.Add(x)
+	}
+	for q, expected := range map[float64]float64{0.0: 1, 0.5: 2.5, 1.0 / 3.0: 2, 0.9: 3.7, 1.0: 4} {
+		if v := td.Quantile(q); math.Abs(v-expected) > 1e-9 {
+			t.Errorf("quantile %v: expected %v, got %v", q, expected, v)
+		}
+	}
+
+	// a shuffled uniform distribution, split across digests that are merged
+	n := 100000
+	perm := rand.New(rand.NewSource(1)).Perm(n)
+	parts := make([]*TDigest, 4)
+	for i := range parts {
+		parts[i], _ = NewTDigest(TDIGEST_DEFAULT_COMPRESSION)
+	}
+	for i, x := range perm {
+		parts[i%len(parts)].Add(float64(x))
+	}
+	td = parts[0]
+	for _, part := range parts[1:] {
+		rt, err := ParseTDigest(part.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		td.Merge(rt)
+	}
+
+	if td.Count() != float64(n) || len(td.centroids) > 2*TDIGEST_DEFAULT_COMPRESSION {
+		t.Errorf("expected %d values in at most %d centroids, got %v in %d",
+			n, 2*TDIGEST_DEFAULT_COMPRESSION, td.Count(), len(td.centroids))
+	}
+	for _, q := range []float64{0.0, 0.001, 0.01, 0.25, 0.5, 0.75, 0.99, 0.999, 1.0} {
+		expected := q * float64(n-1)
+		if v := td.Quantile(q); math.Abs(v-expected) > 0.005*float64(n) {
+			t.Errorf("quantile %v: expected about %v, got %v", q, expected, v)
+		}
+	}
+
+	if _, err := NewTDigest(TDIGEST_MIN_COMPRESSION - 1); err == nil {
+		t.Errorf("expected an invalid compression error")
+	}
+	for _, s := range []string{"", "VAE=", "not base64", td.String()[:100]} {
+		if _, err := ParseTDigest(s); err == nil {
+			t.Errorf("expected an invalid sketch error for %s", s)
+		}
+	}
+}
@@ -10,7 +10,6 @@
 package value
 
 import (
-	"encoding/base64"
 	"fmt"
 
 	"github.com/couchbase/query/util"
@@ -156,8 +155,8 @@ func (this *Bag) Put(key, item Value) {
 		}
 
 		entry.Count++
-	case BINARY:
-		akey := base64.StdEncoding.EncodeToString(key.Actual().([]byte))
+	case BINARY, INTERVAL:
+		akey := binaryKey(key)
 		entry := this.binaries[akey]
 		if entry == nil {
 			entry = &BagEntry{Value: item}
@@ -203,8 +202,8 @@ func (this *Bag) Entry(key Value) *BagEntry {
 		return this.arrays[key.String()]
 	case OBJECT:
 		return this.objects[key.String()]
-	case BINARY:
-		str := base64.StdEncoding.EncodeToString(key.Actual().([]byte))
+	case BINARY, INTERVAL:
+		str := binaryKey(key)
 		return this.binaries[str]
 	default:
 		panic(fmt.Sprintf("Unsupported value type %T.", key))
@@ -0,0 +1,558 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package value
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+/*
+Encoding of values for operators that move them out of memory and
+back, such as spilling joins and groups. Unlike plain JSON, it
+preserves MISSING, the meta data, covers and attachments of annotated
+values, including the sets and lists aggregates cumulate into, the
+annotations of the documents nested under each alias, and projections.
+
+Scopes are cut at the parent passed in, and reattached to the parent
+passed on decoding.
+*/
+type encodedValue struct {
+	Value       json.RawMessage               `json:"v,omitempty"`
+	Annotated   bool                          `json:"n,omitempty"`
+	Scope       bool                          `json:"s,omitempty"`
+	Meta        json.RawMessage               `json:"m,omitempty"`
+	Covers      json.RawMessage               `json:"c,omitempty"`
+	Attachments map[string]*encodedAttachment `json:"a,omitempty"`
+	Fields      map[string]*encodedValue      `json:"f,omitempty"`
+	Original    *encodedValue                 `json:"o,omitempty"`
+	Id          json.RawMessage               `json:"i,omitempty"`
+	Bit         uint8                         `json:"b,omitempty"`
+	Self        bool                          `json:"x,omitempty"`
+}
+
+const (
+	_ATTACHMENT_VALUE  = "value"
+	_ATTACHMENT_SET    = "set"
+	_ATTACHMENT_LIST   = "list"
+	_ATTACHMENT_VALUES = "values"
+	_ATTACHMENT_INT    = "int"
+	_ATTACHMENT_JSON   = "json"
+)
+
+type encodedAttachment struct {
+	Kind    string                   `json:"k"`
+	Value   *encodedValue            `json:"v,omitempty"`
+	Values  []*encodedValue          `json:"l,omitempty"`
+	Map     map[string]*encodedValue `json:"m,omitempty"`
+	Numeric bool                     `json:"num,omitempty"`
+	Cap     int                      `json:"cap,omitempty"`
+	Raw     json.RawMessage          `json:"r,omitempty"`
+}
+
+/*
+Encode val, which need not be annotated, for a later
+UnmarshalAnnotated.
+*/
+func MarshalAnnotated(val Value, parent Value) ([]byte, error) {
+	enc, err := encodeValue(val, parent)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(enc)
+}
+
+/*
+Decode the output of MarshalAnnotated, reattaching scopes to parent.
+*/
+func UnmarshalAnnotated(data []byte, parent Value) (AnnotatedValue, error) {
+	var enc encodedValue
+
+	err := json.Unmarshal(data, &enc)
+	if err != nil {
+		return nil, err
+	}
+	val, err := enc.decode(parent)
+	if err != nil {
+		return nil, err
+	}
+	return NewAnnotatedValue(val), nil
+}
+
+func encodeValue(val Value, parent Value) (*encodedValue, error) {
+	var err error
+
+	rv := &encodedValue{}
+	if av, ok := val.(*annotatedValue); ok {
+		rv.Annotated = true
+		err = rv.encodeAnnotations(av)
+		if err != nil {
+			return nil, err
+		}
+		if av.original != nil {
+			rv.Original, err = encodeValue(av.Original(), parent)
+			if err != nil {
+				return nil, err
+			}
+		}
+		val = av.Value
+	}
+
+	if scope, ok := val.(*ScopeValue); ok {
+		rv.Scope = true
+		if scope.parent == nil || sameValue(scope.parent, parent) {
+			val = scope.Value
+		} else {
+			val = objectValue(scope.Fields())
+		}
+	}
+
+	rv.Value, err = EncodeRaw(val)
+	if err != nil {
+		return nil, err
+	}
+
+	// annotations of nested documents, such as those of each keyspace alias
+	if val.Type() == OBJECT {
+		for name, field := range val.Fields() {
+			if fav, ok := field.(*annotatedValue); ok {
+				enc := &encodedValue{Annotated: true}
+				err = enc.encodeAnnotations(fav)
+				if err != nil {
+					return nil, err
+				}
+				if rv.Fields == nil {
+					rv.Fields = make(map[string]*encodedValue)
+				}
+				rv.Fields[name] = enc
+			}
+		}
+	}
+	return rv, nil
+}
+
+/*
+Whether two values are the same instance. Values can be backed by maps,
+which cannot be compared with ==, so only pointers and maps are
+compared, by address.
+*/
+func sameValue(val1, val2 Value) bool {
+	if val1 == nil || val2 == nil {
+		return val1 == val2
+	}
+	v1 := reflect.ValueOf(val1)
+	v2 := reflect.ValueOf(val2)
+	if v1.Type() != v2.Type() {
+		return false
+	}
+	switch v1.Kind() {
+	case reflect.Ptr, reflect.Map:
+		return v1.Pointer() == v2.Pointer()
+	}
+	return false
+}
+
+func (this *encodedValue) encodeAnnotations(av *annotatedValue) error {
+	var err error
+
+	this.Bit = av.bit
+	this.Self = av.self
+	if av.id != nil {
+		this.Id, err = json.Marshal(av.id)
+		if err != nil {
+			return err
+		}
+	}
+	if len(av.meta) > 0 {
+		this.Meta, err = json.Marshal(av.meta)
+		if err != nil {
+			return err
+		}
+	}
+	if av.covers != nil {
+		this.Covers, err = EncodeRaw(av.covers)
+		if err != nil {
+			return err
+		}
+	}
+	for key, attachment := range av.attachments {
+		enc, err := encodeAttachment(attachment)
+		if err != nil {
+			return fmt.Errorf("Cannot encode attachment %s: %v", key, err)
+		}
+		if this.Attachments == nil {
+			this.Attachments = make(map[string]*encodedAttachment, len(av.attachments))
+		}
+		this.Attachments[key] = enc
+	}
+	return nil
+}
+
+func encodeAttachment(attachment interface{}) (*encodedAttachment, error) {
+	var err error
+
+	switch attachment := attachment.(type) {
+	case Value:
+		rv := &encodedAttachment{Kind: _ATTACHMENT_VALUE}
+		rv.Value, err = encodeValue(attachment, nil)
+		return rv, err
+	case *Set:
+		if !attachment.collect {
+			return nil, fmt.Errorf("set does not collect its values")
+		}
+		rv := &encodedAttachment{Kind: _ATTACHMENT_SET, Numeric: attachment.numeric, Cap: attachment.objectCap}
+		rv.Values, err = encodeValues(attachment.Values())
+		return rv, err
+	case *List:
+		rv := &encodedAttachment{Kind: _ATTACHMENT_LIST}
+		rv.Values, err = encodeValues(attachment.Values())
+		return rv, err
+	case map[string]Value:
+		rv := &encodedAttachment{Kind: _ATTACHMENT_VALUES, Map: make(map[string]*encodedValue, len(attachment))}
+		for key, val := range attachment {
+			if val != nil {
+				rv.Map[key], err = encodeValue(val, nil)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		return rv, nil
+	case int:
+		rv := &encodedAttachment{Kind: _ATTACHMENT_INT}
+		rv.Raw, err = json.Marshal(attachment)
+		return rv, err
+	default:
+		rv := &encodedAttachment{Kind: _ATTACHMENT_JSON}
+		rv.Raw, err = json.Marshal(attachment)
+		return rv, err
+	}
+}
+
+// nil entries stand for the nil items of sets and lists
+func encodeValues(vals []Value) ([]*encodedValue, error) {
+	var err error
+
+	rv := make([]*encodedValue, len(vals))
+	for i, val := range vals {
+		if val != nil {
+			rv[i], err = encodeValue(val, nil)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rv, nil
+}
+
+/*
+Encode a plain value, without its annotations, for DecodeRaw.
+Values are wrapped in an array so that MISSING survives. Intervals
+have no JSON type of their own: values that hold any are encoded as
+an object, with the paths to their intervals.
+*/
+func EncodeRaw(val Value) (json.RawMessage, error) {
+	if val.Type() == MISSING {
+		return json.RawMessage("[]"), nil
+	}
+	data, err := val.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	if paths := intervalPaths(val, nil, nil); len(paths) > 0 {
+		return json.Marshal(&encodedIntervals{Value: data, Intervals: paths})
+	}
+	rv := make(json.RawMessage, 0, len(data)+2)
+	rv = append(rv, '[')
+	rv = append(rv, data...)
+	return append(rv, ']'), nil
+}
+
+/*
+Decode the output of EncodeRaw.
+*/
+func DecodeRaw(raw json.RawMessage) Value {
+	if len(raw) > 0 && raw[0] == '{' {
+		return decodeIntervals(raw)
+	}
+	if len(raw) <= 2 {
+		return MISSING_VALUE
+	}
+	return NewValue([]byte(raw[1 : len(raw)-1]))
+}
+
+type encodedIntervals struct {
+	Value     json.RawMessage `json:"v"`
+	Intervals [][]interface{} `json:"i"`
+}
+
+// field names and array positions leading to each interval in val,
+// documents read from disk cannot hold any
+func intervalPaths(val interface{}, path []interface{}, paths [][]interface{}) [][]interface{} {
+	switch val := val.(type) {
+	case intervalValue:
+		return append(paths, append([]interface{}{}, path...))
+	case *annotatedValue:
+		return intervalPaths(val.Value, path, paths)
+	case *ScopeValue:
+		return intervalPaths(val.Value, path, paths)
+	case *parsedValue:
+		if val.raw != nil {
+			return paths
+		}
+		return intervalPaths(val.parsed, path, paths)
+	case objectValue:
+		return intervalPaths(map[string]interface{}(val), path, paths)
+	case copiedObjectValue:
+		return intervalPaths(map[string]interface{}(val.objectValue), path, paths)
+	case sliceValue:
+		return intervalPaths([]interface{}(val), path, paths)
+	case copiedSliceValue:
+		return intervalPaths([]interface{}(val.sliceValue), path, paths)
+	case *listValue:
+		return intervalPaths([]interface{}(val.slice), path, paths)
+	case map[string]interface{}:
+		for name, field := range val {
+			paths = intervalPaths(field, append(path, name), paths)
+		}
+	case []interface{}:
+		for i, elem := range val {
+			paths = intervalPaths(elem, append(path, i), paths)
+		}
+	}
+	return paths
+}
+
+func decodeIntervals(raw json.RawMessage) Value {
+	var enc encodedIntervals
+
+	err := json.Unmarshal(raw, &enc)
+	if err != nil {
+		return NewValue([]byte(raw))
+	}
+	val, err := decodeJSON(enc.Value)
+	if err != nil {
+		return NewValue([]byte(enc.Value))
+	}
+
+	for _, path := range enc.Intervals {
+		val = setInterval(val, path)
+	}
+	return NewValue(val)
+}
+
+// replace the ISO 8601 duration at path with its interval
+func setInterval(val interface{}, path []interface{}) interface{} {
+	if len(path) == 0 {
+		s, ok := val.(string)
+		if !ok {
+			return val
+		}
+		interval, err := ParseInterval(s)
+		if err != nil {
+			return val
+		}
+		return NewIntervalValue(interval)
+	}
+
+	switch val := val.(type) {
+	case map[string]interface{}:
+		if name, ok := path[0].(string); ok {
+			if field, ok := val[name]; ok {
+				val[name] = setInterval(field, path[1:])
+			}
+		}
+	case []interface{}:
+		if pos, ok := path[0].(float64); ok && int(pos) >= 0 && int(pos) < len(val) {
+			val[int(pos)] = setInterval(val[int(pos)], path[1:])
+		}
+	}
+	return val
+}
+
+func (this *encodedValue) decode(parent Value) (Value, error) {
+	val := DecodeRaw(this.Value)
+	if this.Scope || len(this.Fields) > 0 {
+		fields, ok := val.Actual().(map[string]interface{})
+		if !ok {
+			fields = make(map[string]interface{})
+		}
+		if this.Scope {
+			val = NewScopeValue(fields, parent)
+		} else {
+			val = objectValue(fields)
+		}
+	}
+
+	for name, enc := range this.Fields {
+		field, ok := val.Field(name)
+		if ok {
+			fav := NewAnnotatedValue(field).(*annotatedValue)
+			err := enc.decodeAnnotations(fav)
+			if err != nil {
+				return nil, err
+			}
+			val.SetField(name, fav)
+		}
+	}
+
+	if !this.Annotated {
+		return val, nil
+	}
+
+	av := NewAnnotatedValue(val).(*annotatedValue)
+	err := this.decodeAnnotations(av)
+	if err != nil {
+		return nil, err
+	}
+	if this.Original != nil {
+		orig, err := this.Original.decode(parent)
+		if err != nil {
+			return nil, err
+		}
+		if oav, ok := orig.(*annotatedValue); ok {
+			orig = oav.Value
+		}
+		av.original = orig
+	}
+	return av, nil
+}
+
+func (this *encodedValue) decodeAnnotations(av *annotatedValue) error {
+	av.bit = this.Bit
+	av.self = this.Self
+	if len(this.Meta) > 0 {
+		meta, err := decodeJSON(this.Meta)
+		if err != nil {
+			return err
+		}
+		if fields, ok := meta.(map[string]interface{}); ok {
+			av.meta = fields
+		}
+	}
+	if len(this.Id) > 0 {
+		id, err := decodeJSON(this.Id)
+		if err != nil {
+			return err
+		}
+		av.SetId(id)
+	}
+	if len(this.Covers) > 0 {
+		covers := DecodeRaw(this.Covers)
+		for key, cover := range covers.Fields() {
+			av.SetCover(key, NewValue(cover))
+		}
+	}
+	for key, enc := range this.Attachments {
+		attachment, err := enc.decode()
+		if err != nil {
+			return err
+		}
+		av.SetAttachment(key, attachment)
+	}
+	return nil
+}
+
+func (this *encodedAttachment) decode() (interface{}, error) {
+	switch this.Kind {
+	case _ATTACHMENT_VALUE:
+		return this.Value.decode(nil)
+	case _ATTACHMENT_SET:
+		vals, err := decodeValues(this.Values)
+		if err != nil {
+			return nil, err
+		}
+		set := NewSet(this.Cap, true, this.Numeric)
+		for _, val := range vals {
+			set.Add(val)
+		}
+		return set, nil
+	case _ATTACHMENT_LIST:
+		vals, err := decodeValues(this.Values)
+		if err != nil {
+			return nil, err
+		}
+		list := NewList(len(vals))
+		list.AddAll(vals)
+		return list, nil
+	case _ATTACHMENT_VALUES:
+		rv := make(map[string]Value, len(this.Map))
+		for key, enc := range this.Map {
+			val, err := enc.decode(nil)
+			if err != nil {
+				return nil, err
+			}
+			rv[key] = val
+		}
+		return rv, nil
+	case _ATTACHMENT_INT:
+		var i int
+		err := json.Unmarshal(this.Raw, &i)
+		return i, err
+	case _ATTACHMENT_JSON:
+		return decodeJSON(this.Raw)
+	default:
+		return nil, fmt.Errorf("Unknown attachment kind %s", this.Kind)
+	}
+}
+
+func decodeValues(encs []*encodedValue) (Values, error) {
+	rv := make(Values, len(encs))
+	for i, enc := range encs {
+		if enc != nil {
+			val, err := enc.decode(nil)
+			if err != nil {
+				return nil, err
+			}
+			rv[i] = val
+		}
+	}
+	return rv, nil
+}
+
+// integers, such as CAS values, may not fit a float64
+func decodeJSON(data []byte) (interface{}, error) {
+	var rv interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&rv)
+	if err != nil {
+		return nil, err
+	}
+	return convertNumbers(rv), nil
+}
+
+func convertNumbers(val interface{}) interface{} {
+	switch val := val.(type) {
+	case json.Number:
+		s := string(val)
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return u
+		}
+		f, _ := val.Float64()
+		return f
+	case map[string]interface{}:
+		for key, field := range val {
+			val[key] = convertNumbers(field)
+		}
+	case []interface{}:
+		for i, elem := range val {
+			val[i] = convertNumbers(elem)
+		}
+	}
+	return val
+}
@@ -0,0 +1,126 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package value
+
+import (
+	"testing"
+)
+
+func TestMarshalAnnotated(t *testing.T) {
+	parent := NewValue(map[string]interface{}{"outer": 1})
+	doc := NewAnnotatedValue(map[string]interface{}{"name": "x"})
+	doc.SetId("k1")
+	doc.NewMeta()["cas"] = uint64(1<<63 + 1)
+	item := NewAnnotatedValue(NewScopeValue(map[string]interface{}{}, parent))
+	item.SetField("d", doc)
+	item.SetCover("cover", NewValue(5))
+
+	set := NewSet(4, true, false)
+	set.Add(NewValue("a"))
+	set.Add(NULL_VALUE)
+	agg := NewAnnotatedValue(NULL_VALUE)
+	agg.SetAttachment("set", set)
+	item.SetAttachment("aggregates", map[string]Value{"count": NewValue(2), "distinct": agg})
+	item.SetAttachment("unnest_position", 3)
+
+	data, err := MarshalAnnotated(item, parent)
+	if err != nil {
+		t.Fatalf("Cannot encode item: %v", err)
+	}
+	rv, err := UnmarshalAnnotated(data, parent)
+	if err != nil {
+		t.Fatalf("Cannot decode item: %v", err)
+	}
+
+	if v, _ := rv.Field("outer"); !v.Equals(NewValue(1)).Truth() {
+		t.Errorf("Parent scope not restored, got %v", v)
+	}
+	d, _ := rv.Field("d")
+	av, ok := d.(AnnotatedValue)
+	if !ok {
+		t.Fatalf("Expected annotated field, got %v", d)
+	}
+	if av.GetId() != "k1" || av.GetMeta()["cas"] != uint64(1<<63+1) {
+		t.Errorf("Unexpected meta %v", av.GetMeta())
+	}
+	if name, _ := av.Field("name"); name.Actual() != "x" {
+		t.Errorf("Unexpected document %v", av)
+	}
+	if c := rv.GetCover("cover"); c == nil || !c.Equals(NewValue(5)).Truth() {
+		t.Errorf("Unexpected cover %v", c)
+	}
+	if pos, ok := rv.GetAttachment("unnest_position").(int); !ok || pos != 3 {
+		t.Errorf("Unexpected position %v", rv.GetAttachment("unnest_position"))
+	}
+
+	aggs, ok := rv.GetAttachment("aggregates").(map[string]Value)
+	if !ok || !aggs["count"].Equals(NewValue(2)).Truth() {
+		t.Fatalf("Unexpected aggregates %v", rv.GetAttachment("aggregates"))
+	}
+	distinct, ok := aggs["distinct"].(AnnotatedValue)
+	if !ok {
+		t.Fatalf("Expected annotated aggregate, got %v", aggs["distinct"])
+	}
+	dset, ok := distinct.GetAttachment("set").(*Set)
+	if !ok || dset.Len() != 2 || !dset.Has(NewValue("a")) || !dset.Has(NULL_VALUE) {
+		t.Errorf("Unexpected set %v", distinct.GetAttachment("set"))
+	}
+}
+
+func TestMarshalOtherScope(t *testing.T) {
+	parent := NewValue(map[string]interface{}{"outer": 1})
+	other := NewValue(map[string]interface{}{"other": 2})
+	item := NewAnnotatedValue(NewScopeValue(map[string]interface{}{"inner": 3}, other))
+
+	// scopes under a different parent are kept whole
+	data, err := MarshalAnnotated(item, parent)
+	if err != nil {
+		t.Fatalf("Cannot encode item: %v", err)
+	}
+	rv, err := UnmarshalAnnotated(data, parent)
+	if err != nil {
+		t.Fatalf("Cannot decode item: %v", err)
+	}
+	for name, expected := range map[string]int{"outer": 1, "other": 2, "inner": 3} {
+		if v, _ := rv.Field(name); !v.Equals(NewValue(expected)).Truth() {
+			t.Errorf("Field %v: expected %v, got %v", name, expected, v)
+		}
+	}
+}
+
+func TestMarshalProjection(t *testing.T) {
+	doc := NewAnnotatedValue(map[string]interface{}{"a": 1, "b": 2})
+	doc.SetId("k2")
+	item := NewAnnotatedValue(map[string]interface{}{"t": doc})
+	pv := NewAnnotatedValue(NewScopeValue(map[string]interface{}{}, item))
+	pv.ShareAnnotations(item)
+	pv.SetProjection(NewValue(map[string]interface{}{"a": 1}))
+
+	data, err := MarshalAnnotated(pv, nil)
+	if err != nil {
+		t.Fatalf("Cannot encode item: %v", err)
+	}
+	rv, err := UnmarshalAnnotated(data, nil)
+	if err != nil {
+		t.Fatalf("Cannot decode item: %v", err)
+	}
+
+	if _, ok := rv.Field("b"); ok {
+		t.Errorf("Projection not restored, got %v", rv)
+	}
+	orig := rv.Original()
+	t1, _ := orig.Field("t")
+	if b, _ := t1.Field("b"); !b.Equals(NewValue(2)).Truth() {
+		t.Errorf("Original not restored, got %v", orig)
+	}
+	if tav, ok := t1.(AnnotatedValue); !ok || tav.GetId() != "k2" {
+		t.Errorf("Original annotations not restored, got %v", t1)
+	}
+}
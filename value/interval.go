@@ -0,0 +1,547 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package value
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/couchbase/query/util"
+)
+
+/*
+Interval is a date and time interval. Months, days and milliseconds
+are kept apart, because months have 28 to 31 days, and days have 23 to
+25 hours when daylight saving time starts or ends.
+*/
+type Interval struct {
+	Months int64
+	Days   int64
+	Millis int64
+}
+
+/*
+Add the interval to t. Months and days are calendar months and days in
+the location of t, and the milliseconds are elapsed time. Adding months
+to the end of a month stays within the month, so that January 31st
+plus one month is the last day of February.
+*/
+func (this Interval) AddTo(t time.Time) time.Time {
+	if this.Months != 0 {
+		y, m, d := t.Date()
+		first := time.Date(y, m+time.Month(this.Months), 1, 0, 0, 0, 0, t.Location())
+		if last := first.AddDate(0, 1, -1).Day(); d > last {
+			d = last
+		}
+		t = time.Date(first.Year(), first.Month(), d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	}
+	return t.AddDate(0, 0, int(this.Days)).Add(time.Duration(this.Millis) * time.Millisecond)
+}
+
+func (this Interval) Add(other Interval) Interval {
+	return Interval{this.Months + other.Months, this.Days + other.Days, this.Millis + other.Millis}
+}
+
+func (this Interval) Scale(n int64) Interval {
+	return Interval{this.Months * n, this.Days * n, this.Millis * n}
+}
+
+func (this Interval) Neg() Interval {
+	return Interval{-this.Months, -this.Days, -this.Millis}
+}
+
+func (this Interval) IsZero() bool {
+	return this.Months == 0 && this.Days == 0 && this.Millis == 0
+}
+
+/*
+Approximate length in milliseconds, with 30 day months and 24 hour
+days, for ordering intervals.
+*/
+func (this Interval) Length() float64 {
+	return (float64(this.Months)*30+float64(this.Days))*24*60*60*1000 + float64(this.Millis)
+}
+
+/*
+The ISO 8601 duration, such as P1Y2M3DT4H5M6.789S. Negative parts have
+a minus sign, as in P-1MT-2H.
+*/
+func (this Interval) String() string {
+	if this.IsZero() {
+		return "PT0S"
+	}
+
+	var buf strings.Builder
+	buf.WriteByte('P')
+	part := func(n int64, unit byte) {
+		if n != 0 {
+			buf.WriteString(strconv.FormatInt(n, 10))
+			buf.WriteByte(unit)
+		}
+	}
+
+	part(this.Months/12, 'Y')
+	part(this.Months%12, 'M')
+	part(this.Days, 'D')
+
+	if this.Millis != 0 {
+		buf.WriteByte('T')
+		hours := this.Millis / (60 * 60 * 1000)
+		minutes := this.Millis / (60 * 1000) % 60
+		seconds := this.Millis % (60 * 1000)
+		part(hours, 'H')
+		part(minutes, 'M')
+		if seconds != 0 {
+			buf.WriteString(strconv.FormatFloat(float64(seconds)/1000, 'f', -1, 64))
+			buf.WriteByte('S')
+		}
+	}
+	return buf.String()
+}
+
+func (this Interval) MarshalJSON() ([]byte, error) {
+	return json.Marshal(this.String())
+}
+
+func (this *Interval) UnmarshalJSON(data []byte) error {
+	var s string
+
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	*this, err = ParseInterval(s)
+	return err
+}
+
+var _INTERVAL_UNITS = map[string]Interval{
+	"year":         Interval{Months: 12},
+	"years":        Interval{Months: 12},
+	"yr":           Interval{Months: 12},
+	"yrs":          Interval{Months: 12},
+	"month":        Interval{Months: 1},
+	"months":       Interval{Months: 1},
+	"mon":          Interval{Months: 1},
+	"mons":         Interval{Months: 1},
+	"week":         Interval{Days: 7},
+	"weeks":        Interval{Days: 7},
+	"day":          Interval{Days: 1},
+	"days":         Interval{Days: 1},
+	"hour":         Interval{Millis: 60 * 60 * 1000},
+	"hours":        Interval{Millis: 60 * 60 * 1000},
+	"hr":           Interval{Millis: 60 * 60 * 1000},
+	"hrs":          Interval{Millis: 60 * 60 * 1000},
+	"minute":       Interval{Millis: 60 * 1000},
+	"minutes":      Interval{Millis: 60 * 1000},
+	"min":          Interval{Millis: 60 * 1000},
+	"mins":         Interval{Millis: 60 * 1000},
+	"second":       Interval{Millis: 1000},
+	"seconds":      Interval{Millis: 1000},
+	"sec":          Interval{Millis: 1000},
+	"secs":         Interval{Millis: 1000},
+	"millisecond":  Interval{Millis: 1},
+	"milliseconds": Interval{Millis: 1},
+	"ms":           Interval{Millis: 1},
+
+	// short units, as in 3d or 90m
+	"y":  Interval{Months: 12},
+	"mo": Interval{Months: 1},
+	"w":  Interval{Days: 7},
+	"d":  Interval{Days: 1},
+	"h":  Interval{Millis: 60 * 60 * 1000},
+	"m":  Interval{Millis: 60 * 1000},
+	"s":  Interval{Millis: 1000},
+}
+
+/*
+Parses an ISO 8601 duration, such as "P1Y2M3DT4H" or "P2W", or a list
+of quantities and units, such as "1 year 2 months", "-3 days 04:05:06"
+or "90 minutes ago". Fractions are allowed for weeks and smaller units.
+*/
+func ParseInterval(s string) (Interval, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "P") || strings.HasPrefix(s, "-P") {
+		return parseISOInterval(s)
+	}
+
+	var rv Interval
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return rv, fmt.Errorf("Invalid interval %q.", s)
+	}
+
+	ago := false
+	if strings.EqualFold(fields[len(fields)-1], "ago") {
+		ago = true
+		fields = fields[:len(fields)-1]
+	}
+
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if strings.Contains(f, ":") {
+			ms, err := parseClock(f)
+			if err != nil {
+				return rv, fmt.Errorf("Invalid interval %q: %v", s, err)
+			}
+			rv.Millis += ms
+			continue
+		}
+
+		// the unit may follow the quantity without a space, as in 3d
+		num, unit := f, ""
+		if n := strings.IndexFunc(f, unicode.IsLetter); n > 0 {
+			num, unit = f[:n], f[n:]
+		} else if i+1 < len(fields) {
+			i++
+			unit = fields[i]
+		}
+
+		q, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return rv, fmt.Errorf("Invalid interval %q: invalid quantity %s.", s, num)
+		}
+
+		u, ok := _INTERVAL_UNITS[strings.ToLower(unit)]
+		if !ok {
+			u, ok = _INTERVAL_UNITS[strings.ToLower(strings.TrimSuffix(unit, "s"))]
+		}
+		if !ok {
+			return rv, fmt.Errorf("Invalid interval %q: invalid unit %s.", s, unit)
+		}
+
+		part, err := scaleInterval(u, q)
+		if err != nil {
+			return rv, fmt.Errorf("Invalid interval %q: %v", s, err)
+		}
+		rv = rv.Add(part)
+	}
+
+	if ago {
+		rv = rv.Neg()
+	}
+	return rv, nil
+}
+
+/*
+Scales a unit by q. Months must be whole, and fractions of days are
+kept as milliseconds.
+*/
+func scaleInterval(unit Interval, q float64) (Interval, error) {
+	if unit.Millis == 0 && unit.Months == 0 && q != float64(int64(q)) {
+		// the fraction of a day is kept as milliseconds
+		days := q * float64(unit.Days)
+		whole := int64(days)
+		return Interval{Days: whole, Millis: int64((days-float64(whole))*24*60*60*1000 + 0.5*sign(q))}, nil
+	} else if unit.Millis == 0 && q != float64(int64(q)) {
+		return Interval{}, fmt.Errorf("fractional months are not allowed.")
+	}
+
+	return Interval{
+		Months: unit.Months * int64(q),
+		Days:   unit.Days * int64(q),
+		Millis: int64(float64(unit.Millis)*q + 0.5*sign(q)),
+	}, nil
+}
+
+func sign(f float64) float64 {
+	if f < 0 {
+		return -1
+	}
+	return 1
+}
+
+/*
+Parses [-]hh:mm[:ss[.fff]] into milliseconds.
+*/
+func parseClock(s string) (int64, error) {
+	neg := strings.HasPrefix(s, "-")
+	parts := strings.Split(strings.TrimPrefix(s, "-"), ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %s.", s)
+	}
+
+	var rv float64
+	scale := []float64{60 * 60 * 1000, 60 * 1000, 1000}
+	for i, p := range parts {
+		n, err := strconv.ParseFloat(p, 64)
+		if err != nil || n < 0 || (i < 2 && strings.Contains(p, ".")) {
+			return 0, fmt.Errorf("invalid time %s.", s)
+		}
+		rv += n * scale[i]
+	}
+
+	if neg {
+		rv = -rv
+	}
+	return int64(rv + 0.5*sign(rv)), nil
+}
+
+/*
+Parses an ISO 8601 duration, whose designators must be in order.
+*/
+func parseISOInterval(s string) (Interval, error) {
+	var rv Interval
+	neg := strings.HasPrefix(s, "-")
+	rest := strings.TrimPrefix(strings.TrimPrefix(s, "-"), "P")
+	if rest == "" || rest == "T" {
+		return rv, fmt.Errorf("Invalid interval %q.", s)
+	}
+
+	inTime := false
+	last := -1
+	for rest != "" {
+		if rest[0] == 'T' {
+			if inTime {
+				return rv, fmt.Errorf("Invalid interval %q.", s)
+			}
+			inTime = true
+			last = -1
+			rest = rest[1:]
+			continue
+		}
+
+		n := strings.IndexAny(rest, "YMWDHS")
+		if n <= 0 {
+			return rv, fmt.Errorf("Invalid interval %q.", s)
+		}
+		q, err := strconv.ParseFloat(rest[:n], 64)
+		if err != nil {
+			return rv, fmt.Errorf("Invalid interval %q.", s)
+		}
+
+		designators := "YMWD"
+		if inTime {
+			designators = "HMS"
+		}
+		pos := strings.IndexByte(designators, rest[n])
+		if pos <= last {
+			return rv, fmt.Errorf("Invalid interval %q.", s)
+		}
+		last = pos
+
+		unit := ""
+		switch designators[pos] {
+		case 'Y':
+			unit = "year"
+		case 'M':
+			unit = "month"
+			if inTime {
+				unit = "minute"
+			}
+		case 'W':
+			unit = "week"
+		case 'D':
+			unit = "day"
+		case 'H':
+			unit = "hour"
+		case 'S':
+			unit = "second"
+		}
+
+		part, err := scaleInterval(_INTERVAL_UNITS[unit], q)
+		if err != nil {
+			return rv, fmt.Errorf("Invalid interval %q: %v", s, err)
+		}
+		rv = rv.Add(part)
+		rest = rest[n+1:]
+	}
+
+	if neg {
+		rv = rv.Neg()
+	}
+	return rv, nil
+}
+
+type intervalValue Interval
+
+func NewIntervalValue(interval Interval) Value {
+	return intervalValue(interval)
+}
+
+func (this intervalValue) String() string {
+	return strconv.Quote(Interval(this).String())
+}
+
+func (this intervalValue) MarshalJSON() ([]byte, error) {
+	return []byte(this.String()), nil
+}
+
+func (this intervalValue) WriteJSON(w io.Writer, prefix, indent string, fast bool) error {
+	_, err := w.Write([]byte(this.String()))
+	return err
+}
+
+func (this intervalValue) Type() Type {
+	return INTERVAL
+}
+
+func (this intervalValue) Actual() interface{} {
+	return Interval(this)
+}
+
+func (this intervalValue) ActualForIndex() interface{} {
+	return Interval(this).String()
+}
+
+func (this intervalValue) Equals(other Value) Value {
+	other = other.unwrap()
+	switch other := other.(type) {
+	case missingValue:
+		return other
+	case *nullValue:
+		return other
+	case intervalValue:
+		if this == other {
+			return TRUE_VALUE
+		}
+	}
+
+	return FALSE_VALUE
+}
+
+func (this intervalValue) EquivalentTo(other Value) bool {
+	other = other.unwrap()
+	switch other := other.(type) {
+	case intervalValue:
+		return this == other
+	default:
+		return false
+	}
+}
+
+/*
+Intervals are ordered by their approximate length, and then by their
+months and days, so that only equal intervals collate equal.
+*/
+func (this intervalValue) Collate(other Value) int {
+	other = other.unwrap()
+	switch other := other.(type) {
+	case intervalValue:
+		l1, l2 := Interval(this).Length(), Interval(other).Length()
+		switch {
+		case l1 < l2:
+			return -1
+		case l1 > l2:
+			return 1
+		case this.Months != other.Months:
+			return int(this.Months - other.Months)
+		case this.Days != other.Days:
+			return int(this.Days - other.Days)
+		default:
+			return 0
+		}
+	default:
+		return int(INTERVAL - other.Type())
+	}
+}
+
+func (this intervalValue) Compare(other Value) Value {
+	other = other.unwrap()
+	switch other := other.(type) {
+	case missingValue:
+		return other
+	case *nullValue:
+		return other
+	default:
+		return intValue(this.Collate(other))
+	}
+}
+
+func (this intervalValue) Truth() bool {
+	return !Interval(this).IsZero()
+}
+
+func (this intervalValue) Copy() Value {
+	return this
+}
+
+func (this intervalValue) CopyForUpdate() Value {
+	return this
+}
+
+func (this intervalValue) Field(field string) (Value, bool) {
+	return missingField(field), false
+}
+
+func (this intervalValue) SetField(field string, val interface{}) error {
+	return Unsettable(field)
+}
+
+func (this intervalValue) UnsetField(field string) error {
+	return Unsettable(field)
+}
+
+func (this intervalValue) Index(index int) (Value, bool) {
+	return missingIndex(index), false
+}
+
+func (this intervalValue) SetIndex(index int, val interface{}) error {
+	return Unsettable(index)
+}
+
+func (this intervalValue) Slice(start, end int) (Value, bool) {
+	return NULL_VALUE, false
+}
+
+func (this intervalValue) SliceTail(start int) (Value, bool) {
+	return NULL_VALUE, false
+}
+
+func (this intervalValue) Descendants(buffer []interface{}) []interface{} {
+	return buffer
+}
+
+func (this intervalValue) Fields() map[string]interface{} {
+	return nil
+}
+
+func (this intervalValue) FieldNames(buffer []string) []string {
+	return nil
+}
+
+func (this intervalValue) DescendantPairs(buffer []util.IPair) []util.IPair {
+	return buffer
+}
+
+func (this intervalValue) Successor() Value {
+	return intervalValue{this.Months, this.Days, this.Millis + 1}
+}
+
+func (this intervalValue) Track() {
+}
+
+func (this intervalValue) Recycle() {
+}
+
+func (this intervalValue) Tokens(set *Set, options Value) *Set {
+	set.Add(this)
+	return set
+}
+
+func (this intervalValue) ContainsToken(token, options Value) bool {
+	return this.EquivalentTo(token)
+}
+
+func (this intervalValue) ContainsMatchingToken(matcher MatchFunc, options Value) bool {
+	return matcher(Interval(this).String())
+}
+
+func (this intervalValue) Size() uint64 {
+	return 24
+}
+
+func (this intervalValue) unwrap() Value {
+	return this
+}
@@ -0,0 +1,135 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package value
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseInterval(t *testing.T) {
+	tests := map[string]Interval{
+		"P1Y2M3DT4H5M6.789S": {14, 3, 4*3600000 + 5*60000 + 6789},
+		"P2W":                {0, 14, 0},
+		"-P1D":               {0, -1, 0},
+		"P-1MT-2H":           {-1, 0, -2 * 3600000},
+		"1 year 2 months":    {14, 0, 0},
+		"-3 days 04:05:06":   {0, -3, 4*3600000 + 5*60000 + 6000},
+		"90 minutes ago":     {0, 0, -90 * 60000},
+		"1.5 weeks":          {0, 10, 12 * 3600000},
+		"500 ms":             {0, 0, 500},
+	}
+
+	for s, expected := range tests {
+		iv, err := ParseInterval(s)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", s, err)
+			continue
+		}
+		if iv != expected {
+			t.Errorf("%s: expected %+v, got %+v", s, expected, iv)
+		}
+		back, err := ParseInterval(iv.String())
+		if err != nil || back != iv {
+			t.Errorf("%s: %s does not round trip, got %+v %v", s, iv, back, err)
+		}
+	}
+
+	for _, s := range []string{"", "PT", "P1H", "P1M2Y", "1.5 months", "2 fortnights", "banana"} {
+		if _, err := ParseInterval(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestIntervalAddTo(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("No time zone database")
+	}
+
+	jan31 := time.Date(2020, time.January, 31, 10, 0, 0, 0, time.UTC)
+	if rv := (Interval{Months: 1}).AddTo(jan31); !rv.Equal(time.Date(2020, time.February, 29, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected end of February, got %v", rv)
+	}
+
+	dst := time.Date(2020, time.March, 7, 12, 0, 0, 0, ny)
+	if rv := (Interval{Days: 1}).AddTo(dst); rv.Hour() != 12 {
+		t.Errorf("Expected calendar day, got %v", rv)
+	}
+	if rv := (Interval{Millis: 24 * 3600000}).AddTo(dst); rv.Hour() != 13 {
+		t.Errorf("Expected elapsed time, got %v", rv)
+	}
+}
+
+func TestIntervalValue(t *testing.T) {
+	day := NewIntervalValue(Interval{Days: 1})
+	hours := NewIntervalValue(Interval{Millis: 25 * 3600000})
+
+	if day.Type() != INTERVAL || day.String() != `"P1D"` {
+		t.Errorf("Unexpected interval %v of type %v", day, day.Type())
+	}
+	if day.Collate(hours) >= 0 || hours.Collate(day) <= 0 {
+		t.Errorf("Expected %v before %v", day, hours)
+	}
+	if day.Equals(NewIntervalValue(Interval{Millis: 24 * 3600000})).Truth() {
+		t.Errorf("Expected a day to differ from 24 hours")
+	}
+
+	set := NewSet(4, true, false)
+	set.Add(day)
+	set.Add(NewIntervalValue(Interval{Days: 1}))
+	set.Add(NewValue("P1D"))
+	if set.Len() != 2 {
+		t.Errorf("Expected 2 distinct values, got %v", set.Actuals())
+	}
+}
+
+func TestIntervalEncoding(t *testing.T) {
+	day := NewIntervalValue(Interval{Days: 1})
+	vals := []Value{
+		day,
+		NewValue("P1D"),
+		NewValue(map[string]interface{}{"d": day, "s": "P1D", "l": []interface{}{1, day}}),
+		NewValue([]interface{}{map[string]interface{}{"d": day}, day}),
+	}
+
+	for _, val := range vals {
+		raw, err := EncodeRaw(val)
+		if err != nil {
+			t.Fatalf("Cannot encode %v: %v", val, err)
+		}
+		rv := DecodeRaw(raw)
+		if !rv.EquivalentTo(val) || !val.EquivalentTo(rv) {
+			t.Errorf("Expected %v, got %v", val, rv)
+		}
+	}
+
+	av := NewAnnotatedValue(map[string]interface{}{"d": day})
+	av.SetId("k")
+	data, err := MarshalAnnotated(av, nil)
+	if err != nil {
+		t.Fatalf("Cannot encode %v: %v", av, err)
+	}
+	rv, err := UnmarshalAnnotated(data, nil)
+	if err != nil {
+		t.Fatalf("Cannot decode %s: %v", data, err)
+	}
+	if d, _ := rv.Field("d"); d.Type() != INTERVAL || rv.GetId() != "k" {
+		t.Errorf("Expected an interval, got %v", rv)
+	}
+
+	var interval Interval
+	data, _ = json.Marshal(Interval{Months: 14, Millis: 1500})
+	if err = json.Unmarshal(data, &interval); err != nil || interval != (Interval{Months: 14, Millis: 1500}) {
+		t.Errorf("Unexpected interval %+v from %s: %v", interval, data, err)
+	}
+}
@@ -10,7 +10,6 @@
 package value
 
 import (
-	"encoding/base64"
 	"fmt"
 
 	"github.com/couchbase/query/util"
@@ -191,8 +190,8 @@ func (this *MultiSet) Put(key, item Value, cnt int64) {
 		} else {
 			this.objects[key.String()] = vc
 		}
-	case BINARY:
-		str := base64.StdEncoding.EncodeToString(key.Actual().([]byte))
+	case BINARY, INTERVAL:
+		str := binaryKey(key)
 		vc := addValueCnt(this.binaries[str], mapItem, cnt)
 		if vc == nil {
 			delete(this.binaries, str)
@@ -204,7 +203,7 @@ func (this *MultiSet) Put(key, item Value, cnt int64) {
 	}
 }
 
-//Removes a single occurrence of the specified element from this multiset, if present.
+// Removes a single occurrence of the specified element from this multiset, if present.
 func (this *MultiSet) Remove(key Value) {
 	this.Put(key, key, -1)
 }
@@ -248,8 +247,8 @@ func (this *MultiSet) Has(key Value) bool {
 		_, ok = this.arrays[key.String()]
 	case OBJECT:
 		_, ok = this.objects[key.String()]
-	case BINARY:
-		str := base64.StdEncoding.EncodeToString(key.Actual().([]byte))
+	case BINARY, INTERVAL:
+		str := binaryKey(key)
 		_, ok = this.binaries[str]
 	default:
 		panic(fmt.Sprintf("Unsupported value type %T.", key))
@@ -299,8 +298,8 @@ func (this *MultiSet) Count(key Value) int64 {
 		vc, ok = this.arrays[key.String()]
 	case OBJECT:
 		vc, ok = this.objects[key.String()]
-	case BINARY:
-		str := base64.StdEncoding.EncodeToString(key.Actual().([]byte))
+	case BINARY, INTERVAL:
+		str := binaryKey(key)
 		vc, ok = this.binaries[str]
 	default:
 		panic(fmt.Sprintf("Unsupported value type %T.", key))
@@ -111,14 +111,25 @@ func (this *Set) Put(key, item Value) {
 		this.arrays[key.String()] = mapItem
 	case OBJECT:
 		this.objects[key.String()] = mapItem
-	case BINARY:
-		str := base64.StdEncoding.EncodeToString(key.Actual().([]byte))
+	case BINARY, INTERVAL:
+		str := binaryKey(key)
 		this.binaries[str] = mapItem
 	default:
 		panic(fmt.Sprintf("Unsupported value type %T.", key))
 	}
 }
 
+/*
+Binaries and intervals share a map. Binary keys are base64 encoded and
+interval keys are quoted, so they do not clash.
+*/
+func binaryKey(key Value) string {
+	if key.Type() == INTERVAL {
+		return key.String()
+	}
+	return base64.StdEncoding.EncodeToString(key.Actual().([]byte))
+}
+
 func (this *Set) Remove(key Value) {
 	if key == nil {
 		this.nills = false
@@ -158,8 +169,8 @@ func (this *Set) Remove(key Value) {
 		delete(this.arrays, key.String())
 	case OBJECT:
 		delete(this.objects, key.String())
-	case BINARY:
-		str := base64.StdEncoding.EncodeToString(key.Actual().([]byte))
+	case BINARY, INTERVAL:
+		str := binaryKey(key)
 		delete(this.binaries, str)
 	default:
 		panic(fmt.Sprintf("Unsupported value type %T.", key))
@@ -205,8 +216,8 @@ func (this *Set) Has(key Value) bool {
 		_, ok = this.arrays[key.String()]
 	case OBJECT:
 		_, ok = this.objects[key.String()]
-	case BINARY:
-		str := base64.StdEncoding.EncodeToString(key.Actual().([]byte))
+	case BINARY, INTERVAL:
+		str := binaryKey(key)
 		_, ok = this.binaries[str]
 	default:
 		panic(fmt.Sprintf("Unsupported value type %T.", key))
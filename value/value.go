@@ -63,19 +63,21 @@ type Type int
 /*
 List of valid N1QL types. Missing is specific to N1QL and Binary
 refers to unparsed JSON bytes, represented by a bytes array. It is a
-non-JSON value. The value type JSON is all-encompassing and covers all
-N1ql values.
+non-JSON value. Interval is a date and time interval, which is output
+as an ISO 8601 duration string. The value type JSON is all-encompassing
+and covers all N1ql values.
 */
 const (
-	MISSING = Type(iota) // Missing field
-	NULL                 // Explicit null
-	BOOLEAN              // JSON boolean
-	NUMBER               // JSON number
-	STRING               // JSON string
-	ARRAY                // JSON array
-	OBJECT               // JSON object
-	JSON                 // Non-specific JSON; used in result sets
-	BINARY               // non-JSON
+	MISSING  = Type(iota) // Missing field
+	NULL                  // Explicit null
+	BOOLEAN               // JSON boolean
+	NUMBER                // JSON number
+	STRING                // JSON string
+	ARRAY                 // JSON array
+	OBJECT                // JSON object
+	JSON                  // Non-specific JSON; used in result sets
+	BINARY                // non-JSON
+	INTERVAL              // Date and time interval
 )
 
 /*
@@ -92,15 +94,16 @@ The _TYPE_NAMES variable is a slice of strings that contains the Type
 and its corresponding string representation.
 */
 var _TYPE_NAMES = []string{
-	MISSING: "missing",
-	NULL:    "null",
-	BOOLEAN: "boolean",
-	NUMBER:  "number",
-	STRING:  "string",
-	ARRAY:   "array",
-	OBJECT:  "object",
-	JSON:    "json",
-	BINARY:  "binary",
+	MISSING:  "missing",
+	NULL:     "null",
+	BOOLEAN:  "boolean",
+	NUMBER:   "number",
+	STRING:   "string",
+	ARRAY:    "array",
+	OBJECT:   "object",
+	JSON:     "json",
+	BINARY:   "binary",
+	INTERVAL: "interval",
 }
 
 func (this Type) Successor() Type {
@@ -108,15 +111,16 @@ func (this Type) Successor() Type {
 }
 
 var _TYPE_SUCCESSORS = []Type{
-	MISSING: NULL,
-	NULL:    BOOLEAN,
-	BOOLEAN: JSON,
-	NUMBER:  NUMBER,
-	STRING:  STRING,
-	ARRAY:   ARRAY,
-	OBJECT:  OBJECT,
-	JSON:    JSON,
-	BINARY:  BINARY,
+	MISSING:  NULL,
+	NULL:     BOOLEAN,
+	BOOLEAN:  JSON,
+	NUMBER:   NUMBER,
+	STRING:   STRING,
+	ARRAY:    ARRAY,
+	OBJECT:   OBJECT,
+	JSON:     JSON,
+	BINARY:   BINARY,
+	INTERVAL: INTERVAL,
 }
 
 /*
@@ -409,6 +413,8 @@ func NewValue(val interface{}) Value {
 		return objectValue(val)
 	case *parsedValue:
 		return val
+	case Interval:
+		return intervalValue(val)
 	case int:
 		return intValue(val)
 	case Values: